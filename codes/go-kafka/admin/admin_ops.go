@@ -3,6 +3,7 @@ package admin
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
@@ -12,6 +13,7 @@ import (
 // AdminClient Kafka管理客户端
 type AdminClient struct {
 	brokers []string
+	config  *config.KafkaConfig
 	logger  *utils.Logger
 }
 
@@ -19,10 +21,23 @@ type AdminClient struct {
 func NewAdminClient(cfg *config.KafkaConfig) *AdminClient {
 	return &AdminClient{
 		brokers: cfg.Brokers,
+		config:  cfg,
 		logger:  utils.NewLogger("[AdminClient]"),
 	}
 }
 
+// dial 连接broker，设置了 admin.timeout 时作为连接上所有操作的截止时间
+func (a *AdminClient) dial(addr string) (*kafka.Conn, error) {
+	conn, err := a.config.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if timeout := a.config.Admin.Timeout; timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout.Std()))
+	}
+	return conn, nil
+}
+
 // ClusterInfo 集群信息
 type ClusterInfo struct {
 	Brokers      []BrokerInfo
@@ -39,7 +54,7 @@ type BrokerInfo struct {
 // GetClusterInfo 获取集群信息
 func (a *AdminClient) GetClusterInfo() (*ClusterInfo, error) {
 	// 连接到任意一个broker获取信息
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return nil, fmt.Errorf("连接Kafka失败: %w", err)
 	}
@@ -113,7 +128,7 @@ type PartitionAssignment struct {
 
 // ListConsumerGroups 列出所有消费者组
 func (a *AdminClient) ListConsumerGroups() ([]string, error) {
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return nil, fmt.Errorf("连接Kafka失败: %w", err)
 	}
@@ -135,7 +150,7 @@ func (a *AdminClient) ListConsumerGroups() ([]string, error) {
 // DescribeConsumerGroup 获取消费者组详情
 func (a *AdminClient) DescribeConsumerGroup(groupID string) (*ConsumerGroupInfo, error) {
 	// 连接到消费者组协调器
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +170,7 @@ func (a *AdminClient) DescribeConsumerGroup(groupID string) (*ConsumerGroupInfo,
 
 	// 连接协调器获取详细信息
 	coordinatorAddr := fmt.Sprintf("%s:%d", resp.Host, resp.Port)
-	coordConn, err := kafka.Dial("tcp", coordinatorAddr)
+	coordConn, err := a.dial(coordinatorAddr)
 	if err != nil {
 		return nil, fmt.Errorf("连接协调器失败: %w", err)
 	}
@@ -172,7 +187,7 @@ func (a *AdminClient) DescribeConsumerGroup(groupID string) (*ConsumerGroupInfo,
 
 // DeleteConsumerGroup 删除消费者组
 func (a *AdminClient) DeleteConsumerGroup(groupID string) error {
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return fmt.Errorf("连接Kafka失败: %w", err)
 	}
@@ -210,7 +225,7 @@ type PartitionInfo struct {
 
 // GetPartitionDetails 获取分区详细信息
 func (a *AdminClient) GetPartitionDetails(topic string) ([]PartitionInfo, error) {
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return nil, err
 	}
@@ -260,7 +275,7 @@ type Metrics struct {
 
 // GetMetrics 获取集群指标
 func (a *AdminClient) GetMetrics() (*Metrics, error) {
-	conn, err := kafka.Dial("tcp", a.brokers[0])
+	conn, err := a.dial(a.brokers[0])
 	if err != nil {
 		return nil, err
 	}
@@ -298,12 +313,18 @@ func (a *AdminClient) ResetConsumerGroupOffset(
 	partition int,
 	offset int64,
 ) error {
+	dialer, err := a.config.Dialer()
+	if err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+
 	// 创建临时消费者来提交偏移量
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:   a.brokers,
 		Topic:     topic,
 		GroupID:   groupID,
 		Partition: partition,
+		Dialer:    dialer,
 	})
 	defer reader.Close()
 
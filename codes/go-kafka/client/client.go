@@ -3,27 +3,157 @@ package client
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
 	"go-kafka/consumer"
+	"go-kafka/metrics"
 	"go-kafka/middleware"
 	"go-kafka/producer"
 	"go-kafka/serializer"
+	"go-kafka/tracer"
+	"go-kafka/utils"
 )
 
 // KafkaClient 高级Kafka客户端
+// 订阅 config.Watcher 后，配置变化实时应用到客户端构建的生产者、消费者和指标收集器，
+// 之后构建的生产者和消费者使用新的配置
 type KafkaClient struct {
+	mu         sync.Mutex
 	config     *config.KafkaConfig
 	serializer serializer.Serializer
+	metrics    *metrics.Metrics
+	tracer     *tracer.Tracer
+	reloadable []config.Reloadable
 }
 
-// NewClient 创建客户端
+var _ config.Reloadable = (*KafkaClient)(nil)
+
+// NewClient 创建客户端，按 observability 配置设置日志级别，启用指标和追踪
 func NewClient(cfg *config.KafkaConfig) *KafkaClient {
-	return &KafkaClient{
+	c := &KafkaClient{
 		config:     cfg,
 		serializer: &serializer.JSONSerializer{},
 	}
+
+	obs := cfg.Observability
+	if obs.LogLevel != "" {
+		utils.SetLevel(obs.LogLevel)
+	}
+	if obs.Metrics.Enabled {
+		c.metrics = metrics.NewMetrics()
+		c.metrics.RegisterHandler(metrics.NewLoggerHandler(utils.DefaultLogger.Logger))
+		go c.metrics.Start(obs.Metrics.Interval.Std())
+		c.reloadable = append(c.reloadable, c.metrics)
+	}
+	if obs.Tracing.Enabled {
+		name := obs.Tracing.ServiceName
+		if name == "" {
+			name = cfg.ClientID
+		}
+		c.tracer = tracer.NewTracer(name)
+	}
+	return c
+}
+
+// Config 返回客户端当前的配置
+func (c *KafkaClient) Config() *config.KafkaConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// Reload 将配置变化应用到客户端及其构建的组件，返回需要重启才能生效的配置项
+func (c *KafkaClient) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	c.mu.Lock()
+	c.config = cfg
+	reloadable := append([]config.Reloadable(nil), c.reloadable...)
+	c.mu.Unlock()
+
+	restart := changes.Fields("observability.tracing")
+	if c.metrics == nil {
+		restart = append(restart, changes.Fields("observability.metrics.enabled")...)
+	}
+	for _, r := range reloadable {
+		restart = append(restart, r.Reload(cfg, changes)...)
+	}
+	return restart
+}
+
+// subscription 构建的组件对配置变化的订阅，取消后不再调用组件的 Reload
+type subscription struct {
+	mu       sync.Mutex
+	r        config.Reloadable
+	canceled bool
+}
+
+func (s *subscription) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.canceled {
+		return nil
+	}
+	return s.r.Reload(cfg, changes)
+}
+
+// cancel 取消订阅，返回时不再有进行中的 Reload
+func (s *subscription) cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.canceled = true
+}
+
+// subscribe 记录需要接收配置变化的组件，组件关闭时调用 unsubscribe
+func (c *KafkaClient) subscribe(r config.Reloadable) *subscription {
+	sub := &subscription{r: r}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reloadable = append(c.reloadable, sub)
+	return sub
+}
+
+// unsubscribe 取消订阅并从客户端移除，重复调用无影响
+func (c *KafkaClient) unsubscribe(sub *subscription) {
+	sub.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.reloadable {
+		if r == config.Reloadable(sub) {
+			c.reloadable = append(c.reloadable[:i:i], c.reloadable[i+1:]...)
+			return
+		}
+	}
+}
+
+// Metrics 返回客户端的指标收集器，未启用 observability.metrics 时返回nil
+func (c *KafkaClient) Metrics() *metrics.Metrics {
+	return c.metrics
+}
+
+// Tracer 返回客户端的追踪器，未启用 observability.tracing 时返回nil
+func (c *KafkaClient) Tracer() *tracer.Tracer {
+	return c.tracer
+}
+
+// Close 停止指标报告并停止向已构建的组件应用配置变化，不关闭已构建的生产者和消费者
+func (c *KafkaClient) Close() error {
+	if c.metrics != nil {
+		c.metrics.Stop()
+	}
+
+	c.mu.Lock()
+	reloadable := c.reloadable
+	c.reloadable = nil
+	c.mu.Unlock()
+
+	for _, r := range reloadable {
+		if sub, ok := r.(*subscription); ok {
+			sub.cancel()
+		}
+	}
+	return nil
 }
 
 // SetSerializer 设置序列化器
@@ -33,18 +163,19 @@ func (c *KafkaClient) SetSerializer(s serializer.Serializer) {
 
 // ProducerBuilder 生产者构建器
 type ProducerBuilder struct {
-	client    *KafkaClient
-	batchSize int
-	async     bool
-	compress  kafka.Compression
+	client      *KafkaClient
+	batchSize   int
+	async       bool
+	partitioner kafka.Balancer
+	custom      producer.Producer
+	decorators  []func(producer.Producer) producer.Producer
 }
 
 // Producer 开始构建生产者
 func (c *KafkaClient) Producer() *ProducerBuilder {
 	return &ProducerBuilder{
-		client:   c,
-		async:    false,
-		compress: kafka.Lz4,
+		client: c,
+		async:  false,
 	}
 }
 
@@ -54,91 +185,197 @@ func (pb *ProducerBuilder) WithBatchSize(size int) *ProducerBuilder {
 	return pb
 }
 
+// WithPartitioner 设置分区器，例如 &partitioner.Sticky{}，优先于 producer.partitioner
+func (pb *ProducerBuilder) WithPartitioner(b kafka.Balancer) *ProducerBuilder {
+	pb.partitioner = b
+	return pb
+}
+
 // Async 设置异步模式
 func (pb *ProducerBuilder) Async() *ProducerBuilder {
 	pb.async = true
 	return pb
 }
 
+// WithProducer 使用自定义的生产者实现（需已完成连接），忽略内置生产者的配置
+func (pb *ProducerBuilder) WithProducer(p producer.Producer) *ProducerBuilder {
+	pb.custom = p
+	return pb
+}
+
+// Wrap 添加生产者装饰器，例如 producer.NewRateLimitedProducer，按添加顺序由内向外包装
+// 启用 observability.metrics 时生产者已由 metrics.InstrumentedProducer 包装，位于最内层；
+// 设置了 producer.rate_limit 时再由 producer.RateLimitedProducer 包装
+func (pb *ProducerBuilder) Wrap(decorators ...func(producer.Producer) producer.Producer) *ProducerBuilder {
+	pb.decorators = append(pb.decorators, decorators...)
+	return pb
+}
+
 // Build 构建生产者
 func (pb *ProducerBuilder) Build() (*ProducerWrapper, error) {
-	var p interface{}
+	cfg := pb.client.Config()
+	p, err := pb.build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	reloader := producerReloader(p, pb.custom != nil)
+
+	if m := pb.client.metrics; m != nil {
+		if source, ok := p.(metrics.SpoolSource); ok {
+			m.ObserveSpool(source)
+		}
+		p = metrics.NewInstrumentedProducer(p, m)
+	}
+	var limited *producer.RateLimitedProducer
+	if rc := cfg.Producer.RateLimit; rc.Rate > 0 {
+		limited = producer.NewRateLimitedProducer(p, newRateLimiter(rc))
+		p = limited
+	}
+	sub := pb.client.subscribe(config.ReloadFunc(func(cfg *config.KafkaConfig, changes config.Changes) []string {
+		restart := reloader(cfg, changes)
+		if limited != nil {
+			return append(restart, limited.Reload(cfg, changes)...)
+		}
+		return append(restart, changes.Fields("producer.rate_limit")...)
+	}))
+
+	for _, decorate := range pb.decorators {
+		p = decorate(p)
+	}
+
+	return &ProducerWrapper{
+		producer:   p,
+		serializer: pb.client.serializer,
+		client:     pb.client,
+		sub:        sub,
+	}, nil
+}
+
+// build 创建并连接内置生产者
+func (pb *ProducerBuilder) build(cfg *config.KafkaConfig) (producer.Producer, error) {
+	if pb.custom != nil {
+		return pb.custom, nil
+	}
 
 	if pb.async {
-		ap := producer.NewAsyncProducer(pb.client.config, nil)
+		ap := producer.NewAsyncProducer(cfg, nil)
+		ap.SetPartitioner(pb.partitioner)
 		if err := ap.Connect(); err != nil {
 			return nil, err
 		}
-		p = ap
-	} else if pb.batchSize > 0 {
-		bp := producer.NewBatchProducer(
-			pb.client.config,
-			producer.WithBatchSize(pb.batchSize),
-			producer.WithCompression(pb.compress),
-		)
+		return ap, nil
+	}
+
+	if pb.batchSize > 0 {
+		bp := producer.NewBatchProducer(cfg, producer.WithBatchSize(pb.batchSize), producer.WithPartitioner(pb.partitioner))
 		if err := bp.Connect(); err != nil {
 			return nil, err
 		}
-		p = bp
-	} else {
-		sp := producer.NewSimpleProducer(pb.client.config)
-		if err := sp.Connect(); err != nil {
-			return nil, err
+		return bp, nil
+	}
+
+	sp := producer.NewSimpleProducer(cfg)
+	sp.SetPartitioner(pb.partitioner)
+	if err := sp.Connect(); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// producerFields 生产者使用的配置段
+var producerFields = append([]string{"topic", "producer"}, config.ConnectionFields...)
+
+// producerReloader 生产者自身支持热更新时交给它处理，否则除限流外的变化都需要重新创建生产者
+// 自定义生产者不使用客户端的配置，只在实现了 config.Reloadable 时接收变化
+func producerReloader(p producer.Producer, custom bool) config.ReloadFunc {
+	return func(cfg *config.KafkaConfig, changes config.Changes) []string {
+		if r, ok := p.(config.Reloadable); ok {
+			return r.Reload(cfg, changes)
+		}
+		if custom {
+			return nil
 		}
-		p = sp
+		return changes.Except("producer.rate_limit").Fields(producerFields...)
 	}
+}
 
-	return &ProducerWrapper{
-		producer:   p,
-		serializer: pb.client.serializer,
-	}, nil
+// newRateLimiter 按限流配置创建限流器
+func newRateLimiter(rc config.RateLimitConfig) *middleware.RateLimiter {
+	return middleware.NewRateLimiter(rc.Rate, rc.Per.Std(), middleware.WithBurst(rc.Burst))
 }
 
 // ProducerWrapper 生产者包装器
 type ProducerWrapper struct {
-	producer   interface{}
+	producer   producer.Producer
 	serializer serializer.Serializer
+	client     *KafkaClient
+	sub        *subscription
 }
 
 // Send 发送消息
 func (pw *ProducerWrapper) Send(ctx context.Context, key string, data interface{}) error {
-	value, err := pw.serializer.Serialize(data)
+	return pw.SendWithHeaders(ctx, key, data, nil)
+}
+
+// SendWithHeaders 发送带消息头的消息
+func (pw *ProducerWrapper) SendWithHeaders(
+	ctx context.Context,
+	key string,
+	data interface{},
+	headers map[string]string,
+) error {
+	value, headers, err := serializer.SerializeWithHeaders(pw.serializer, data, headers)
 	if err != nil {
 		return err
 	}
 
-	switch p := pw.producer.(type) {
-	case *producer.SimpleProducer:
-		return p.SendMessage(ctx, key, string(value))
-	case *producer.AsyncProducer:
-		return p.SendAsync(key, string(value))
-	case *producer.BatchProducer:
-		return p.Send(key, string(value))
-	default:
-		return fmt.Errorf("unknown producer type")
+	if len(headers) == 0 {
+		return pw.producer.SendMessage(ctx, key, string(value))
 	}
+	return pw.producer.SendMessageWithHeaders(ctx, key, string(value), headers)
+}
+
+// Flush 刷新缓冲区
+func (pw *ProducerWrapper) Flush() error {
+	return pw.producer.Flush()
+}
+
+// Producer 返回底层生产者
+func (pw *ProducerWrapper) Producer() producer.Producer {
+	return pw.producer
 }
 
-// Close 关闭生产者
+// Close 取消配置订阅并关闭生产者
 func (pw *ProducerWrapper) Close() error {
-	switch p := pw.producer.(type) {
-	case *producer.SimpleProducer:
-		return p.Close()
-	case *producer.AsyncProducer:
-		return p.Close()
-	case *producer.BatchProducer:
-		return p.Close()
-	default:
-		return nil
-	}
+	pw.client.unsubscribe(pw.sub)
+	return pw.producer.Close()
+}
+
+// NewTypedProducer 使用生产者包装器的序列化器创建强类型生产者
+func NewTypedProducer[T any](pw *ProducerWrapper) *producer.TypedProducer[T] {
+	return producer.NewTypedProducer[T](pw.producer, pw.serializer)
 }
 
+// consumerMode 消费模式
+type consumerMode int
+
+const (
+	modeSimple       consumerMode = iota // 单实例消费者组
+	modeGroup                            // 多实例消费者组
+	modePartition                        // 指定分区
+	modeManualCommit                     // 手动提交
+)
+
 // ConsumerBuilder 消费者构建器
 type ConsumerBuilder struct {
-	client       *KafkaClient
-	groupID      string
-	manualCommit bool
-	middlewares  []middleware.Middleware
+	client      *KafkaClient
+	groupID     string
+	mode        consumerMode
+	instances   int
+	partition   int
+	custom      consumer.Consumer
+	dispatcher  *consumer.Dispatcher
+	middlewares []middleware.Middleware
 }
 
 // Consumer 开始构建消费者
@@ -151,7 +388,41 @@ func (c *KafkaClient) Consumer(groupID string) *ConsumerBuilder {
 
 // ManualCommit 设置手动提交
 func (cb *ConsumerBuilder) ManualCommit() *ConsumerBuilder {
-	cb.manualCommit = true
+	cb.mode = modeManualCommit
+	return cb
+}
+
+// Group 设置消费者组模式，启动 instances 个实例共同消费
+func (cb *ConsumerBuilder) Group(instances int) *ConsumerBuilder {
+	cb.mode = modeGroup
+	cb.instances = instances
+	return cb
+}
+
+// Partition 只消费指定分区，不加入消费者组
+func (cb *ConsumerBuilder) Partition(partition int) *ConsumerBuilder {
+	cb.mode = modePartition
+	cb.partition = partition
+	return cb
+}
+
+// Concurrency 使用 workers 个工作协程并行处理消息，ordering 指定顺序保证级别
+func (cb *ConsumerBuilder) Concurrency(workers int, ordering consumer.Ordering) *ConsumerBuilder {
+	return cb.WithDispatcher(consumer.NewDispatcher(
+		consumer.WithWorkers(workers),
+		consumer.WithOrdering(ordering),
+	))
+}
+
+// WithDispatcher 使用自定义的并发分发器
+func (cb *ConsumerBuilder) WithDispatcher(d *consumer.Dispatcher) *ConsumerBuilder {
+	cb.dispatcher = d
+	return cb
+}
+
+// WithConsumer 使用自定义的消费者实现（需已完成连接）
+func (cb *ConsumerBuilder) WithConsumer(c consumer.Consumer) *ConsumerBuilder {
+	cb.custom = c
 	return cb
 }
 
@@ -163,48 +434,120 @@ func (cb *ConsumerBuilder) Use(mws ...middleware.Middleware) *ConsumerBuilder {
 
 // Build 构建消费者
 func (cb *ConsumerBuilder) Build() (*ConsumerWrapper, error) {
-	cfg := &config.KafkaConfig{
-		Brokers: cb.client.config.Brokers,
-		Topic:   cb.client.config.Topic,
-		GroupID: cb.groupID,
+	cfg := *cb.client.Config()
+	cfg.GroupID = cb.groupID
+	c, err := cb.build(&cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	var c interface{}
+	// 追踪和指标中间件位于最外层，覆盖用户中间件的处理时间和错误，限流在用户中间件之前
+	var middlewares []middleware.Middleware
+	if t := cb.client.tracer; t != nil {
+		middlewares = append(middlewares, t.Middleware(nil))
+	}
+	if m := cb.client.metrics; m != nil {
+		middlewares = append(middlewares, m.Middleware())
+	}
+	var limiter *middleware.RateLimiter
+	if rc := cfg.Consumer.RateLimit; rc.Rate > 0 {
+		limiter = newRateLimiter(rc)
+		middlewares = append(middlewares, limiter.Middleware())
+	}
+	sub := cb.client.subscribe(consumerReloader(c, cb.custom != nil, limiter))
 
-	if cb.manualCommit {
+	return &ConsumerWrapper{
+		consumer:    c,
+		middlewares: append(middlewares, cb.middlewares...),
+		serializer:  cb.client.serializer,
+		client:      cb.client,
+		sub:         sub,
+	}, nil
+}
+
+// build 按消费模式创建消费者
+func (cb *ConsumerBuilder) build(cfg *config.KafkaConfig) (consumer.Consumer, error) {
+	if cb.custom != nil {
+		return cb.custom, nil
+	}
+
+	switch cb.mode {
+	case modeGroup:
+		// 消费者组管理器在 Start 时创建并连接实例
+		m := consumer.NewConsumerGroupManager(cfg)
+		m.SetInstances(cb.instances)
+		if cb.dispatcher != nil {
+			m.UseDispatcher(cb.dispatcher)
+		}
+		return m, nil
+
+	case modePartition:
+		sc := consumer.NewSimpleConsumer(cfg, cb.partition)
+		if err := sc.Connect(); err != nil {
+			return nil, err
+		}
+		if cb.dispatcher != nil {
+			sc.UseDispatcher(cb.dispatcher)
+		}
+		return sc, nil
+
+	case modeManualCommit:
 		mc := consumer.NewManualCommitConsumer(cfg, 100)
 		if err := mc.Connect(); err != nil {
 			return nil, err
 		}
-		c = mc
-	} else {
+		if cb.dispatcher != nil {
+			mc.UseDispatcher(cb.dispatcher)
+		}
+		return mc, nil
+
+	default:
 		sc := consumer.NewSimpleConsumer(cfg, -1)
 		if err := sc.Connect(); err != nil {
 			return nil, err
 		}
-		c = sc
+		if cb.dispatcher != nil {
+			sc.UseDispatcher(cb.dispatcher)
+		}
+		return sc, nil
 	}
+}
 
-	return &ConsumerWrapper{
-		consumer:    c,
-		middlewares: cb.middlewares,
-		serializer:  cb.client.serializer,
-	}, nil
+// consumerFields 消费者使用的配置段，消费者组由 Consumer 的参数指定
+var consumerFields = append([]string{"topic", "consumer"}, config.ConnectionFields...)
+
+// consumerReloader 限流实时生效，其他变化需要重新构建消费者；自定义消费者只在实现了 config.Reloadable 时接收变化
+func consumerReloader(c consumer.Consumer, custom bool, limiter *middleware.RateLimiter) config.ReloadFunc {
+	return func(cfg *config.KafkaConfig, changes config.Changes) []string {
+		var restart []string
+		if r, ok := c.(config.Reloadable); ok {
+			restart = r.Reload(cfg, changes)
+		} else if !custom {
+			restart = changes.Except("consumer.rate_limit").Fields(consumerFields...)
+		}
+
+		if limiter != nil {
+			return append(restart, limiter.Reload(cfg, changes)...)
+		}
+		return append(restart, changes.Fields("consumer.rate_limit")...)
+	}
 }
 
 // ConsumerWrapper 消费者包装器
 type ConsumerWrapper struct {
-	consumer    interface{}
+	consumer    consumer.Consumer
 	middlewares []middleware.Middleware
 	serializer  serializer.Serializer
 	handler     consumer.MessageHandler
+	client      *KafkaClient
+	sub         *subscription
 }
 
 // Handle 设置处理器
 func (cw *ConsumerWrapper) Handle(handler interface{}) *ConsumerWrapper {
 	// 包装中间件
-	var final middleware.HandlerFunc = func(msg kafka.Message) error {
-		return cw.handleMessage(msg, handler)
+	var final middleware.HandlerFunc = func(ctx context.Context, msg kafka.Message) error {
+		return cw.handleMessage(ctx, msg, handler)
 	}
 
 	// 应用中间件链
@@ -212,18 +555,32 @@ func (cw *ConsumerWrapper) Handle(handler interface{}) *ConsumerWrapper {
 		final = cw.middlewares[i](final)
 	}
 
-	cw.handler = final
+	cw.handler = consumer.MessageHandler(final)
 	return cw
 }
 
+// HandleTyped 设置强类型处理器，使用客户端的序列化器将消息反序列化为T后调用handler
+// 反序列化失败的消息交给 consumer.WithDecodeErrorHandler 或 consumer.WithDecodeDLQ 处理，默认跳过
+func HandleTyped[T any](cw *ConsumerWrapper, handler consumer.TypedHandler[T], options ...consumer.TypedOption) *ConsumerWrapper {
+	return cw.Handle(consumer.Decode(cw.serializer, handler, options...))
+}
+
 // handleMessage 处理消息
-func (cw *ConsumerWrapper) handleMessage(msg kafka.Message, handler interface{}) error {
-	if h, ok := handler.(func(kafka.Message) error); ok {
+// 支持的处理器: func(kafka.Message) error、func(context.Context, kafka.Message) error、func(string, interface{}) error
+func (cw *ConsumerWrapper) handleMessage(ctx context.Context, msg kafka.Message, handler interface{}) error {
+	switch h := handler.(type) {
+	case func(kafka.Message) error:
 		return h(msg)
+	case func(context.Context, kafka.Message) error:
+		return h(ctx, msg)
+	case consumer.MessageHandler:
+		return h(ctx, msg)
+	case middleware.HandlerFunc:
+		return h(ctx, msg)
 	}
 	if h, ok := handler.(func(string, interface{}) error); ok {
 		var data interface{}
-		if err := cw.serializer.Deserialize(msg.Value, &data); err != nil {
+		if err := serializer.DeserializeWithHeaders(cw.serializer, msg.Value, serializer.HeadersOf(msg), &data); err != nil {
 			return err
 		}
 		return h(string(msg.Key), data)
@@ -233,26 +590,31 @@ func (cw *ConsumerWrapper) handleMessage(msg kafka.Message, handler interface{})
 
 // Start 开始消费
 func (cw *ConsumerWrapper) Start(ctx context.Context) error {
-	switch c := cw.consumer.(type) {
-	case *consumer.ManualCommitConsumer:
-		return c.Start(ctx, cw.handler)
-	case *consumer.SimpleConsumer:
-		return c.Start(ctx, cw.handler)
-	default:
-		return fmt.Errorf("unknown consumer type")
+	if cw.handler == nil {
+		return fmt.Errorf("handler is not set")
 	}
+	return cw.consumer.Start(ctx, cw.handler)
 }
 
-// Close 关闭消费者
+// Stats 获取消费统计
+func (cw *ConsumerWrapper) Stats() kafka.ReaderStats {
+	return cw.consumer.Stats()
+}
+
+// Lag 获取消费延迟
+func (cw *ConsumerWrapper) Lag(ctx context.Context) (int64, error) {
+	return cw.consumer.Lag(ctx)
+}
+
+// Consumer 返回底层消费者
+func (cw *ConsumerWrapper) Consumer() consumer.Consumer {
+	return cw.consumer
+}
+
+// Close 取消配置订阅并关闭消费者
 func (cw *ConsumerWrapper) Close() error {
-	switch c := cw.consumer.(type) {
-	case *consumer.ManualCommitConsumer:
-		return c.Close()
-	case *consumer.SimpleConsumer:
-		return c.Close()
-	default:
-		return nil
-	}
+	cw.client.unsubscribe(cw.sub)
+	return cw.consumer.Close()
 }
 
 // ClientExample 使用示例
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/partitioner"
+)
+
+// partitioners producer.partitioner 可选的分区器，每个写入器创建新的实例
+var partitioners = map[string]func(p ProducerConfig) kafka.Balancer{
+	"hash":            func(ProducerConfig) kafka.Balancer { return &kafka.Hash{} },
+	"crc32":           func(ProducerConfig) kafka.Balancer { return &kafka.CRC32Balancer{} },
+	"murmur2":         func(ProducerConfig) kafka.Balancer { return &partitioner.Murmur2{} },
+	"round_robin":     func(ProducerConfig) kafka.Balancer { return &kafka.RoundRobin{} },
+	"least_bytes":     func(ProducerConfig) kafka.Balancer { return &kafka.LeastBytes{} },
+	"sticky":          func(ProducerConfig) kafka.Balancer { return &partitioner.Sticky{} },
+	"consistent_hash": func(ProducerConfig) kafka.Balancer { return &partitioner.ConsistentHash{} },
+	"explicit":        func(ProducerConfig) kafka.Balancer { return &partitioner.Explicit{} },
+	"header":          func(p ProducerConfig) kafka.Balancer { return &partitioner.ByHeader{Header: p.PartitionHeader} },
+}
+
+func partitionerNames() []string {
+	names := make([]string, 0, len(partitioners))
+	for name := range partitioners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupBalancers consumer.balancers 可选的分区分配策略
+var groupBalancers = map[string]kafka.GroupBalancer{
+	"range":       kafka.RangeGroupBalancer{},
+	"round_robin": kafka.RoundRobinGroupBalancer{},
+}
+
+// dialTimeout 未配置时与 kafka.DefaultDialer 一致
+func (c *KafkaConfig) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout.Std()
+	}
+	return 10 * time.Second
+}
+
+// secured 是否需要自定义连接设置，不需要时沿用kafka-go的默认连接
+func (c *KafkaConfig) secured() bool {
+	return c.ClientID != "" || c.DialTimeout > 0 || c.Security.TLS.Enabled || c.Security.SASL.Mechanism != ""
+}
+
+// Dialer 按配置创建建立连接使用的 kafka.Dialer，包含TLS和SASL设置
+// 所有直接连接broker的组件（管理客户端、Topic管理、连接池、健康检查）都通过它建立连接
+func (c *KafkaConfig) Dialer() (*kafka.Dialer, error) {
+	tlsConfig, err := c.Security.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := c.Security.SASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		ClientID:      c.ClientID,
+		Timeout:       c.dialTimeout(),
+		DualStack:     true,
+		TLS:           tlsConfig,
+		SASLMechanism: mechanism,
+	}, nil
+}
+
+// WriterTransport 按配置创建 kafka.Writer 使用的传输，与 Dialer 使用相同的TLS和SASL设置
+func (c *KafkaConfig) WriterTransport() (*kafka.Transport, error) {
+	tlsConfig, err := c.Security.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := c.Security.SASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		ClientID:    c.ClientID,
+		DialTimeout: c.dialTimeout(),
+		TLS:         tlsConfig,
+		SASL:        mechanism,
+	}, nil
+}
+
+// ApplyWriter 用 producer 段中设置了的配置项覆盖w的默认值，未设置的保持不变
+// 证书文件无法加载时返回错误
+func (c *KafkaConfig) ApplyWriter(w *kafka.Writer) error {
+	p := c.Producer
+
+	if p.Acks != "" {
+		var acks kafka.RequiredAcks
+		if acks.UnmarshalText([]byte(p.Acks)) == nil {
+			w.RequiredAcks = acks
+		}
+	}
+	if p.Compression != "" {
+		var compression kafka.Compression
+		if compression.UnmarshalText([]byte(p.Compression)) == nil {
+			w.Compression = compression
+		}
+	}
+	if newBalancer, ok := partitioners[p.Partitioner]; ok {
+		w.Balancer = newBalancer(p)
+	}
+	if p.BatchSize > 0 {
+		w.BatchSize = p.BatchSize
+	}
+	if p.BatchBytes > 0 {
+		w.BatchBytes = p.BatchBytes
+	}
+	if p.BatchTimeout > 0 {
+		w.BatchTimeout = p.BatchTimeout.Std()
+	}
+	if p.WriteTimeout > 0 {
+		w.WriteTimeout = p.WriteTimeout.Std()
+	}
+	if p.ReadTimeout > 0 {
+		w.ReadTimeout = p.ReadTimeout.Std()
+	}
+	if p.MaxAttempts > 0 {
+		w.MaxAttempts = p.MaxAttempts
+	}
+
+	if c.secured() {
+		t, err := c.WriterTransport()
+		if err != nil {
+			return err
+		}
+		w.Transport = t
+	}
+	return nil
+}
+
+// ApplyReader 用 consumer 段中设置了的配置项覆盖r的默认值，未设置的保持不变
+// 证书文件无法加载时返回错误
+func (c *KafkaConfig) ApplyReader(r *kafka.ReaderConfig) error {
+	cc := c.Consumer
+
+	switch cc.StartOffset {
+	case "earliest":
+		r.StartOffset = kafka.FirstOffset
+	case "latest":
+		r.StartOffset = kafka.LastOffset
+	}
+	if cc.MinBytes > 0 {
+		r.MinBytes = cc.MinBytes
+	}
+	if cc.MaxBytes > 0 {
+		r.MaxBytes = cc.MaxBytes
+	}
+	if cc.MaxWait > 0 {
+		r.MaxWait = cc.MaxWait.Std()
+	}
+	if cc.CommitInterval > 0 {
+		r.CommitInterval = cc.CommitInterval.Std()
+	}
+	if cc.HeartbeatInterval > 0 {
+		r.HeartbeatInterval = cc.HeartbeatInterval.Std()
+	}
+	if cc.SessionTimeout > 0 {
+		r.SessionTimeout = cc.SessionTimeout.Std()
+	}
+	if cc.RebalanceTimeout > 0 {
+		r.RebalanceTimeout = cc.RebalanceTimeout.Std()
+	}
+	switch cc.IsolationLevel {
+	case "read_committed":
+		r.IsolationLevel = kafka.ReadCommitted
+	case "read_uncommitted":
+		r.IsolationLevel = kafka.ReadUncommitted
+	}
+	if len(cc.Balancers) > 0 {
+		balancers := make([]kafka.GroupBalancer, 0, len(cc.Balancers))
+		for _, name := range cc.Balancers {
+			if b, ok := groupBalancers[name]; ok {
+				balancers = append(balancers, b)
+			}
+		}
+		r.GroupBalancers = balancers
+	}
+
+	if c.secured() {
+		d, err := c.Dialer()
+		if err != nil {
+			return err
+		}
+		r.Dialer = d
+	}
+	return nil
+}
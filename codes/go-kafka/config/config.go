@@ -1,15 +1,54 @@
 package config
 
 import (
-	"os"
-	"strings"
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/transport"
 )
 
 // KafkaConfig 保存Kafka连接配置
+// 各配置段的零值表示使用组件自身的默认值，例如 Producer.BatchSize 为0时
+// SimpleProducer 使用100，BatchProducer 使用500
 type KafkaConfig struct {
-	Brokers []string // Kafka集群地址列表
-	Topic   string   // 默认Topic
-	GroupID string   // 消费者组ID
+	Brokers  []string `json:"brokers"`   // Kafka集群地址列表
+	Topic    string   `json:"topic"`     // 默认Topic
+	GroupID  string   `json:"group_id"`  // 消费者组ID
+	ClientID string   `json:"client_id"` // 客户端标识，出现在broker日志和配额中
+
+	// DialTimeout 建立连接的超时，默认10秒
+	DialTimeout Duration `json:"dial_timeout"`
+
+	Producer      ProducerConfig      `json:"producer"`
+	Consumer      ConsumerConfig      `json:"consumer"`
+	Admin         AdminConfig         `json:"admin"`
+	Security      SecurityConfig      `json:"security"`
+	Observability ObservabilityConfig `json:"observability"`
+
+	// Transport 传输层，为nil时使用真实Kafka连接
+	// 测试中可设置为 transport.NewBroker() 在进程内运行
+	Transport transport.Transport `json:"-"`
+}
+
+// GetTransport 返回配置的传输层，未配置时返回默认的Kafka传输层，连接使用 Dialer 的设置
+func (c *KafkaConfig) GetTransport() transport.Transport {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	if kt, ok := transport.Default.(transport.KafkaTransport); ok {
+		kt.Dial = c.DialContext
+		return kt
+	}
+	return transport.Default
+}
+
+// DialContext 使用 Dialer 连接broker
+func (c *KafkaConfig) DialContext(ctx context.Context, network, address string) (*kafka.Conn, error) {
+	d, err := c.Dialer()
+	if err != nil {
+		return nil, err
+	}
+	return d.DialContext(ctx, network, address)
 }
 
 // DefaultConfig 返回默认配置
@@ -22,20 +61,10 @@ func DefaultConfig() *KafkaConfig {
 }
 
 // LoadFromEnv 从环境变量加载配置
+// 除 KAFKA_BROKERS、KAFKA_TOPIC、KAFKA_GROUP_ID 外，每个配置项都可以用环境变量覆盖，
+// 见 Load。无法解析的环境变量被忽略，需要报错时使用 Load("", "")
 func LoadFromEnv() *KafkaConfig {
 	config := DefaultConfig()
-
-	if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
-		config.Brokers = strings.Split(brokers, ",")
-	}
-
-	if topic := os.Getenv("KAFKA_TOPIC"); topic != "" {
-		config.Topic = topic
-	}
-
-	if groupID := os.Getenv("KAFKA_GROUP_ID"); groupID != "" {
-		config.GroupID = groupID
-	}
-
+	applyEnv(config, func(string, error) {})
 	return config
 }
@@ -0,0 +1,320 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Load 加载配置，优先级从低到高为：DefaultConfig、配置文件、profile、环境变量，加载后调用 Validate
+//
+// path 按扩展名识别 .yaml/.yml、.json、.toml 格式，为空时只使用默认值和环境变量。
+// profile 为空时使用环境变量 KAFKA_PROFILE，配置文件的 profiles 段中每个profile覆盖部分配置：
+//
+//	brokers: [localhost:9092]
+//	producer:
+//	  acks: one
+//	profiles:
+//	  prod:
+//	    brokers: [kafka-1:9092, kafka-2:9092]
+//	    producer:
+//	      acks: all
+//
+// 环境变量名为 KAFKA_ 加上配置路径的大写形式，例如 KAFKA_PRODUCER_BATCH_SIZE，列表用逗号分隔
+func Load(path, profile string) (*KafkaConfig, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+	}
+	return load(path, data, profile)
+}
+
+// load 使用已读取的配置文件内容加载配置
+func load(path string, data []byte, profile string) (*KafkaConfig, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		if profile == "" {
+			profile = os.Getenv("KAFKA_PROFILE")
+		}
+		if err := loadFile(cfg, path, data, profile); err != nil {
+			return nil, err
+		}
+	}
+
+	var envErr error
+	applyEnv(cfg, func(name string, err error) {
+		if envErr == nil {
+			envErr = fmt.Errorf("环境变量 %s: %w", name, err)
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile 解析配置文件，合并profile后写入cfg
+func loadFile(cfg *KafkaConfig, path string, data []byte, profile string) error {
+	raw, err := parseFile(path, data)
+	if err != nil {
+		return err
+	}
+
+	profiles := make(map[string]interface{})
+	if p, ok := raw["profiles"]; ok {
+		if profiles, ok = p.(map[string]interface{}); !ok {
+			return fmt.Errorf("配置文件 %s: profiles: 应为对象", path)
+		}
+		delete(raw, "profiles")
+	}
+
+	configType := reflect.TypeOf(KafkaConfig{})
+	if err := checkValue(raw, configType, ""); err != nil {
+		return fmt.Errorf("配置文件 %s: %w", path, err)
+	}
+	// 未使用的profile也检查，拼写错误在启动时就能发现
+	for name, p := range profiles {
+		if err := checkValue(p, configType, "profiles."+name); err != nil {
+			return fmt.Errorf("配置文件 %s: %w", path, err)
+		}
+	}
+
+	if profile != "" {
+		p, ok := profiles[profile]
+		if !ok {
+			return fmt.Errorf("配置文件 %s 中没有profile %s", path, profile)
+		}
+		mergeMaps(raw, p.(map[string]interface{}))
+	}
+
+	// 检查过的map重新编码为JSON，由encoding/json写入结构体
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("配置文件 %s: %w", path, err)
+	}
+	if err := json.Unmarshal(encoded, cfg); err != nil {
+		return fmt.Errorf("配置文件 %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseFile 按扩展名解析为通用的map
+func parseFile(path string, data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("不支持的配置文件格式: %s，应为 .yaml、.yml、.json 或 .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+
+	if raw == nil {
+		raw = make(map[string]interface{})
+	}
+	return raw, nil
+}
+
+// mergeMaps 将src深度合并到dst，对象逐项合并，其他值直接覆盖
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if target, ok := dst[k].(map[string]interface{}); ok {
+				mergeMaps(target, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// checkValue 按结构体的json标签检查配置值，返回带配置路径的错误
+// 三种格式解析出的数字类型不同（YAML为int，TOML为int64，JSON为float64），这里统一检查
+func checkValue(value interface{}, t reflect.Type, path string) error {
+	switch {
+	case t == durationType:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: 应为时长字符串，例如 \"10s\"", path)
+		}
+		var d Duration
+		if err := d.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+
+	case t.Kind() == reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: 应为对象", displayPath(path))
+		}
+		fields := jsonFields(t)
+		for key, v := range m {
+			field, ok := fields[key]
+			if !ok {
+				return fmt.Errorf("%s: 未知的配置项", joinPath(path, key))
+			}
+			if err := checkValue(v, field.Type, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case t.Kind() == reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: 应为列表", path)
+		}
+		for i, item := range items {
+			if err := checkValue(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case t.Kind() == reflect.String:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: 应为字符串", path)
+		}
+		return nil
+
+	case t.Kind() == reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: 应为true或false", path)
+		}
+		return nil
+
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		switch n := value.(type) {
+		case int, int64:
+			return nil
+		case float64:
+			if n == math.Trunc(n) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: 应为整数", path)
+	}
+	return nil
+}
+
+// jsonFields 结构体可配置的字段，按json标签索引
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name := jsonName(f); name != "" {
+			fields[name] = f
+		}
+	}
+	return fields
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	return name
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "配置文件根节点"
+	}
+	return path
+}
+
+// applyEnv 用环境变量覆盖配置，无法解析的值交给onError
+func applyEnv(cfg *KafkaConfig, onError func(name string, err error)) {
+	walkEnv(reflect.ValueOf(cfg).Elem(), "KAFKA", func(name string, v reflect.Value) {
+		s, ok := os.LookupEnv(name)
+		if !ok || s == "" {
+			return
+		}
+		if err := setFromString(v, s); err != nil {
+			onError(name, err)
+		}
+	})
+}
+
+// walkEnv 遍历可配置的字段，环境变量名为前缀加上json标签的大写形式
+func walkEnv(v reflect.Value, prefix string, fn func(name string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		envName := prefix + "_" + strings.ToUpper(name)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			walkEnv(field, envName, fn)
+			continue
+		}
+		fn(envName, field)
+	}
+}
+
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		return v.Addr().Interface().(*Duration).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("应为true或false，实际为 %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("应为整数，实际为 %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		parts := strings.Split(s, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		v.Set(reflect.ValueOf(parts))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration 配置文件中的时长，格式为 "500ms"、"10s"、"1m30s"
+type Duration time.Duration
+
+// Std 转换为 time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("无效的时长 %q，应为 500ms、10s、1m 等格式", text)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ProducerConfig 生产者配置
+type ProducerConfig struct {
+	Acks            string          `json:"acks"`             // all、one、none
+	Compression     string          `json:"compression"`      // none、gzip、snappy、lz4、zstd
+	Partitioner     string          `json:"partitioner"`      // hash、crc32、murmur2、round_robin、least_bytes、sticky、consistent_hash、explicit、header
+	PartitionHeader string          `json:"partition_header"` // partitioner 为 header 时按该消息头的值选择分区
+	BatchSize       int             `json:"batch_size"`       // 批量消息数
+	BatchBytes      int64           `json:"batch_bytes"`      // 批量最大字节数
+	BatchTimeout    Duration        `json:"batch_timeout"`    // 批量未满时的最长等待
+	WriteTimeout    Duration        `json:"write_timeout"`
+	ReadTimeout     Duration        `json:"read_timeout"`
+	MaxAttempts     int             `json:"max_attempts"`     // 发送失败的最大尝试次数
+	QueueSize       int             `json:"queue_size"`       // AsyncProducer 的发送队列长度
+	Backpressure    string          `json:"backpressure"`     // AsyncProducer 队列满时的处理方式：block（默认）、fail、drop_oldest
+	FlushInterval   Duration        `json:"flush_interval"`   // BatchProducer 定时刷新缓冲区的间隔，默认1秒
+	RateLimit       RateLimitConfig `json:"rate_limit"`       // client.KafkaClient 构建的生产者的发送限流
+	Idempotent      bool            `json:"idempotent"`       // BatchProducer 和 AsyncProducer 在消息头中写入生产者ID和分区序列号
+	TransactionalID string          `json:"transactional_id"` // TransactionalProducer 的ID，同一ID的新实例会隔离旧实例
+	Spool           SpoolConfig     `json:"spool"`            // BatchProducer 和 AsyncProducer 发送失败时的本地磁盘缓存
+}
+
+// SpoolConfig 本地磁盘缓存配置，Dir 为空时不启用；每个生产者需要使用单独的目录
+type SpoolConfig struct {
+	Dir           string   `json:"dir"`
+	SegmentBytes  int64    `json:"segment_bytes"`  // 单个段文件的大小上限，默认64MB
+	MaxBytes      int64    `json:"max_bytes"`      // 缓存总大小上限，超过时新的批次无法写入，默认1GB
+	MaxAge        Duration `json:"max_age"`        // 段文件的最长保留时间，过期的段文件删除不再发送，默认不限制
+	RetryInterval Duration `json:"retry_interval"` // 重放缓存的间隔，默认5秒
+}
+
+// RateLimitConfig 限流配置，每 per 时间补充 rate 个令牌，最多积累 burst 个，rate 为0时不限流
+type RateLimitConfig struct {
+	Rate  int      `json:"rate"`
+	Per   Duration `json:"per"`   // 默认1秒
+	Burst int      `json:"burst"` // 默认等于 rate
+}
+
+// ConsumerConfig 消费者配置
+type ConsumerConfig struct {
+	StartOffset       string          `json:"start_offset"` // earliest、latest，消费者组没有提交过偏移量时的起点
+	MinBytes          int             `json:"min_bytes"`
+	MaxBytes          int             `json:"max_bytes"`
+	MaxWait           Duration        `json:"max_wait"`
+	CommitInterval    Duration        `json:"commit_interval"` // 自动提交间隔，0为每条消息同步提交
+	HeartbeatInterval Duration        `json:"heartbeat_interval"`
+	SessionTimeout    Duration        `json:"session_timeout"`
+	RebalanceTimeout  Duration        `json:"rebalance_timeout"`
+	Balancers         []string        `json:"balancers"`       // 分区分配策略，range、round_robin，按优先级排列
+	RateLimit         RateLimitConfig `json:"rate_limit"`      // client.KafkaClient 构建的消费者的处理限流
+	IsolationLevel    string          `json:"isolation_level"` // read_uncommitted、read_committed，后者只读取已提交事务的消息
+}
+
+// AdminConfig 管理客户端配置
+type AdminConfig struct {
+	Timeout           Duration `json:"timeout"`            // 单个管理操作的超时
+	Partitions        int      `json:"partitions"`         // 创建Topic的默认分区数
+	ReplicationFactor int      `json:"replication_factor"` // 创建Topic的默认副本因子
+}
+
+// SecurityConfig TLS和SASL配置
+type SecurityConfig struct {
+	TLS  TLSConfig  `json:"tls"`
+	SASL SASLConfig `json:"sasl"`
+}
+
+// TLSConfig TLS配置，CertFile 和 KeyFile 用于双向认证
+type TLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// SASLConfig SASL认证配置
+type SASLConfig struct {
+	Mechanism string `json:"mechanism"` // PLAIN、SCRAM-SHA-256、SCRAM-SHA-512
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// ObservabilityConfig 日志、指标、追踪和健康检查配置
+type ObservabilityConfig struct {
+	LogLevel            string        `json:"log_level"` // info、error
+	Metrics             MetricsConfig `json:"metrics"`
+	Tracing             TracingConfig `json:"tracing"`
+	HealthCheckInterval Duration      `json:"health_check_interval"`
+}
+
+// MetricsConfig 指标配置，启用后 client.KafkaClient 构建的生产者和消费者自动记录指标
+type MetricsConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"` // 指标报告间隔，默认60秒
+}
+
+// TracingConfig 追踪配置，启用后 client.KafkaClient 构建的消费者自动创建consume跨度
+type TracingConfig struct {
+	Enabled     bool   `json:"enabled"`
+	ServiceName string `json:"service_name"`
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// FieldError 单个配置项的错误，Field 为配置路径，例如 producer.acks
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError 配置校验失败，包含所有不合法的配置项
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return "配置校验失败: " + strings.Join(msgs, "; ")
+}
+
+// Validate 校验配置，返回 *ValidationError，没有错误时返回nil
+func (c *KafkaConfig) Validate() error {
+	v := &validator{}
+
+	if len(c.Brokers) == 0 {
+		v.add("brokers", "至少需要一个broker地址")
+	}
+	for i, addr := range c.Brokers {
+		if !validAddr(addr) {
+			v.add(fmt.Sprintf("brokers[%d]", i), "无效的地址 %q，应为 host:port", addr)
+		}
+	}
+	v.nonNegative("dial_timeout", int64(c.DialTimeout))
+
+	c.Producer.validate(v)
+	c.Consumer.validate(v)
+	c.Admin.validate(v)
+	c.Security.validate(v)
+	c.Observability.validate(v)
+
+	if len(v.errs) > 0 {
+		return &ValidationError{Errors: v.errs}
+	}
+	return nil
+}
+
+func (p ProducerConfig) validate(v *validator) {
+	if p.Acks != "" {
+		var acks kafka.RequiredAcks
+		if err := acks.UnmarshalText([]byte(p.Acks)); err != nil {
+			v.add("producer.acks", "无效的值 %q，应为 all、one 或 none", p.Acks)
+		}
+	}
+	if p.Compression != "" {
+		var c kafka.Compression
+		if err := c.UnmarshalText([]byte(p.Compression)); err != nil {
+			v.add("producer.compression", "无效的值 %q，应为 none、gzip、snappy、lz4 或 zstd", p.Compression)
+		}
+	}
+	if p.Partitioner != "" {
+		if _, ok := partitioners[p.Partitioner]; !ok {
+			v.add("producer.partitioner", "无效的值 %q，应为 %s", p.Partitioner, strings.Join(partitionerNames(), "、"))
+		}
+	}
+	if p.Partitioner == "header" && p.PartitionHeader == "" {
+		v.add("producer.partition_header", "partitioner 为 header 时不能为空")
+	}
+	v.nonNegative("producer.batch_size", int64(p.BatchSize))
+	v.nonNegative("producer.batch_bytes", p.BatchBytes)
+	v.nonNegative("producer.batch_timeout", int64(p.BatchTimeout))
+	v.nonNegative("producer.write_timeout", int64(p.WriteTimeout))
+	v.nonNegative("producer.read_timeout", int64(p.ReadTimeout))
+	v.nonNegative("producer.max_attempts", int64(p.MaxAttempts))
+	v.nonNegative("producer.queue_size", int64(p.QueueSize))
+	v.oneOf("producer.backpressure", p.Backpressure, "block", "fail", "drop_oldest")
+	v.nonNegative("producer.flush_interval", int64(p.FlushInterval))
+	p.RateLimit.validate(v, "producer.rate_limit")
+	v.nonNegative("producer.spool.segment_bytes", p.Spool.SegmentBytes)
+	v.nonNegative("producer.spool.max_bytes", p.Spool.MaxBytes)
+	v.nonNegative("producer.spool.max_age", int64(p.Spool.MaxAge))
+	v.nonNegative("producer.spool.retry_interval", int64(p.Spool.RetryInterval))
+}
+
+func (c ConsumerConfig) validate(v *validator) {
+	v.oneOf("consumer.start_offset", c.StartOffset, "earliest", "latest")
+	v.nonNegative("consumer.min_bytes", int64(c.MinBytes))
+	v.nonNegative("consumer.max_bytes", int64(c.MaxBytes))
+	if c.MinBytes > 0 && c.MaxBytes > 0 && c.MinBytes > c.MaxBytes {
+		v.add("consumer.min_bytes", "不能大于 consumer.max_bytes (%d > %d)", c.MinBytes, c.MaxBytes)
+	}
+	v.nonNegative("consumer.max_wait", int64(c.MaxWait))
+	v.nonNegative("consumer.commit_interval", int64(c.CommitInterval))
+	v.nonNegative("consumer.heartbeat_interval", int64(c.HeartbeatInterval))
+	v.nonNegative("consumer.session_timeout", int64(c.SessionTimeout))
+	v.nonNegative("consumer.rebalance_timeout", int64(c.RebalanceTimeout))
+	if c.HeartbeatInterval > 0 && c.SessionTimeout > 0 && c.HeartbeatInterval >= c.SessionTimeout {
+		v.add("consumer.heartbeat_interval", "必须小于 consumer.session_timeout (%s >= %s)",
+			c.HeartbeatInterval.Std(), c.SessionTimeout.Std())
+	}
+	for i, name := range c.Balancers {
+		if _, ok := groupBalancers[name]; !ok {
+			v.add(fmt.Sprintf("consumer.balancers[%d]", i), "无效的值 %q，应为 range 或 round_robin", name)
+		}
+	}
+	v.oneOf("consumer.isolation_level", c.IsolationLevel, "read_uncommitted", "read_committed")
+	c.RateLimit.validate(v, "consumer.rate_limit")
+}
+
+func (r RateLimitConfig) validate(v *validator, path string) {
+	v.nonNegative(path+".rate", int64(r.Rate))
+	v.nonNegative(path+".per", int64(r.Per))
+	v.nonNegative(path+".burst", int64(r.Burst))
+}
+
+func (a AdminConfig) validate(v *validator) {
+	v.nonNegative("admin.timeout", int64(a.Timeout))
+	v.nonNegative("admin.partitions", int64(a.Partitions))
+	v.nonNegative("admin.replication_factor", int64(a.ReplicationFactor))
+}
+
+func (s SecurityConfig) validate(v *validator) {
+	if !s.TLS.Enabled && (s.TLS.CAFile != "" || s.TLS.CertFile != "") {
+		v.add("security.tls.enabled", "设置了证书文件但未启用TLS")
+	}
+	if s.TLS.Enabled {
+		if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+			v.add("security.tls", "cert_file 和 key_file 必须同时设置")
+		}
+		v.fileExists("security.tls.ca_file", s.TLS.CAFile)
+		v.fileExists("security.tls.cert_file", s.TLS.CertFile)
+		v.fileExists("security.tls.key_file", s.TLS.KeyFile)
+	}
+
+	if s.SASL.Mechanism != "" {
+		v.oneOf("security.sasl.mechanism", s.SASL.Mechanism, "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512")
+		if s.SASL.Username == "" {
+			v.add("security.sasl.username", "使用SASL时不能为空")
+		}
+		if s.SASL.Password == "" {
+			v.add("security.sasl.password", "使用SASL时不能为空")
+		}
+	}
+}
+
+func (o ObservabilityConfig) validate(v *validator) {
+	v.oneOf("observability.log_level", o.LogLevel, "info", "error")
+	v.nonNegative("observability.metrics.interval", int64(o.Metrics.Interval))
+	v.nonNegative("observability.health_check_interval", int64(o.HealthCheckInterval))
+}
+
+// validAddr 地址格式为 host:port
+func validAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// validator 收集校验错误
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) nonNegative(field string, n int64) {
+	if n < 0 {
+		v.add(field, "不能为负数")
+	}
+}
+
+// oneOf 值为空或在允许的取值中
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "无效的值 %q，应为 %s", value, strings.Join(allowed, "、"))
+}
+
+func (v *validator) fileExists(field, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.add(field, "文件不可用: %v", err)
+	}
+}
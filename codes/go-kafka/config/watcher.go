@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-kafka/utils"
+)
+
+// ConnectionFields 影响连接的配置项，变化后需要重新创建生产者和消费者
+var ConnectionFields = []string{"brokers", "client_id", "dial_timeout", "security"}
+
+// Change 配置项的变化，Field 为配置路径，例如 producer.batch_size
+type Change struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Changes 一次重新加载中发生变化的配置项
+type Changes []Change
+
+// Has 是否有配置项在prefixes之下，prefix可以是配置段（producer）或配置项（producer.batch_size）
+func (c Changes) Has(prefixes ...string) bool {
+	return len(c.Fields(prefixes...)) > 0
+}
+
+// Fields 返回在prefixes之下发生变化的配置项
+func (c Changes) Fields(prefixes ...string) []string {
+	var fields []string
+	for _, change := range c {
+		for _, prefix := range prefixes {
+			if change.Field == prefix || strings.HasPrefix(change.Field, prefix+".") {
+				fields = append(fields, change.Field)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// Except 去掉在prefixes之下的配置项
+func (c Changes) Except(prefixes ...string) Changes {
+	var rest Changes
+	for _, change := range c {
+		if !(Changes{change}).Has(prefixes...) {
+			rest = append(rest, change)
+		}
+	}
+	return rest
+}
+
+// Diff 比较两个配置，返回发生变化的配置项，密码类配置的值不出现在结果中
+func Diff(old, new *KafkaConfig) Changes {
+	var changes Changes
+	diffValue(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", &changes)
+	return changes
+}
+
+func diffValue(a, b reflect.Value, path string, changes *Changes) {
+	if a.Kind() == reflect.Struct && a.Type() != durationType {
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if name := jsonName(t.Field(i)); name != "" {
+				diffValue(a.Field(i), b.Field(i), joinPath(path, name), changes)
+			}
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return
+	}
+	change := Change{Field: path, Old: a.Interface(), New: b.Interface()}
+	if strings.HasSuffix(path, "password") {
+		change.Old, change.New = "******", "******"
+	}
+	*changes = append(*changes, change)
+}
+
+// Reloadable 支持热更新配置的组件
+type Reloadable interface {
+	// Reload 应用可以热更新的变化，返回与该组件相关、需要重启才能生效的配置项
+	Reload(cfg *KafkaConfig, changes Changes) []string
+}
+
+// ReloadFunc 函数形式的 Reloadable
+type ReloadFunc func(cfg *KafkaConfig, changes Changes) []string
+
+func (f ReloadFunc) Reload(cfg *KafkaConfig, changes Changes) []string {
+	return f(cfg, changes)
+}
+
+// ReloadReport 一次重新加载的结果
+type ReloadReport struct {
+	Changes         Changes
+	RestartRequired []string // 需要重启才能生效的配置项，已去重排序
+}
+
+// Watcher 监视配置文件，文件内容变化或收到SIGHUP时重新加载
+// 新配置校验失败时保留当前配置；校验通过后通知订阅者，observability.log_level 由 Watcher 直接应用
+type Watcher struct {
+	path     string
+	profile  string
+	interval time.Duration
+	onReload func(report *ReloadReport, err error)
+	logger   *utils.Logger
+
+	reloadMu    sync.Mutex // 保证重新加载按顺序通知订阅者
+	mu          sync.Mutex
+	current     *KafkaConfig
+	content     []byte
+	subscribers []Reloadable
+}
+
+// WatcherOption 配置监视器选项
+type WatcherOption func(*Watcher)
+
+// WithPollInterval 设置检查文件变化的间隔，默认2秒
+func WithPollInterval(d time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		if d > 0 {
+			w.interval = d
+		}
+	}
+}
+
+// WithReloadHandler 设置 Start 中每次重新加载后的回调，err 不为nil时表示加载或校验失败
+func WithReloadHandler(fn func(report *ReloadReport, err error)) WatcherOption {
+	return func(w *Watcher) {
+		w.onReload = fn
+	}
+}
+
+// NewWatcher 加载配置文件并创建监视器，参数与 Load 相同
+func NewWatcher(path, profile string, options ...WatcherOption) (*Watcher, error) {
+	w := &Watcher{
+		path:     path,
+		profile:  profile,
+		interval: 2 * time.Second,
+		logger:   utils.NewLogger("[ConfigWatcher]"),
+	}
+	for _, opt := range options {
+		opt(w)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	cfg, err := load(path, content, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	w.current = cfg
+	w.content = content
+	return w, nil
+}
+
+// Current 返回当前配置，配置对象加载后不再修改，重新加载时替换为新对象
+func (w *Watcher) Current() *KafkaConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Subscribe 订阅配置变化
+func (w *Watcher) Subscribe(r Reloadable) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, r)
+}
+
+// Reload 立即重新加载配置文件，配置没有变化时返回空的报告
+func (w *Watcher) Reload() (*ReloadReport, error) {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	w.mu.Lock()
+	w.content = content // 加载失败时不再重复尝试同样的内容
+	w.mu.Unlock()
+
+	next, err := load(w.path, content, w.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	next.Transport = w.current.Transport
+	report := &ReloadReport{Changes: Diff(w.current, next)}
+	if len(report.Changes) > 0 {
+		w.current = next
+	}
+	subscribers := append([]Reloadable(nil), w.subscribers...)
+	w.mu.Unlock()
+
+	if len(report.Changes) == 0 {
+		return report, nil
+	}
+
+	if level := next.Observability.LogLevel; level != "" && report.Changes.Has("observability.log_level") {
+		utils.SetLevel(level)
+	}
+
+	restart := make(map[string]bool)
+	for _, s := range subscribers {
+		for _, field := range s.Reload(next, report.Changes) {
+			restart[field] = true
+		}
+	}
+	for field := range restart {
+		report.RestartRequired = append(report.RestartRequired, field)
+	}
+	sort.Strings(report.RestartRequired)
+	return report, nil
+}
+
+// Start 监视配置文件直到ctx取消
+func (w *Watcher) Start(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			w.logger.Info("收到SIGHUP，重新加载配置")
+			w.handle(w.Reload())
+		case <-ticker.C:
+			if w.modified() {
+				w.handle(w.Reload())
+			}
+		}
+	}
+}
+
+// modified 文件内容是否与上次加载时不同，比较内容而不是修改时间，避免时间精度问题
+func (w *Watcher) modified() bool {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return false // 编辑器保存时文件可能短暂不存在
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !bytes.Equal(content, w.content)
+}
+
+func (w *Watcher) handle(report *ReloadReport, err error) {
+	if err != nil {
+		w.logger.Error("重新加载配置失败，继续使用当前配置:", err)
+	} else if len(report.Changes) > 0 {
+		w.logger.Info("配置已重新加载，变化:", len(report.Changes))
+		if len(report.RestartRequired) > 0 {
+			w.logger.Info("以下配置需要重启才能生效:", strings.Join(report.RestartRequired, ", "))
+		}
+	}
+
+	if w.onReload != nil {
+		w.onReload(report, err)
+	}
+}
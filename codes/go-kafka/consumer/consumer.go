@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/transport"
+)
+
+// Consumer 消费者通用接口
+// SimpleConsumer、GroupConsumer、ManualCommitConsumer 和 ConsumerGroupManager 都实现了该接口
+type Consumer interface {
+	// Start 开始消费，阻塞直到ctx取消或消费者关闭
+	Start(ctx context.Context, handler MessageHandler) error
+	// Close 关闭消费者
+	Close() error
+	// Stats 获取消费统计
+	Stats() kafka.ReaderStats
+	// Lag 获取消费延迟
+	Lag(ctx context.Context) (int64, error)
+}
+
+var (
+	_ Consumer = (*SimpleConsumer)(nil)
+	_ Consumer = (*GroupConsumer)(nil)
+	_ Consumer = (*ManualCommitConsumer)(nil)
+	_ Consumer = (*ConsumerGroupManager)(nil)
+)
+
+// readLag 获取消费延迟
+// kafka-go 在消费者组模式下不支持 ReadLag，此时使用统计信息中的延迟
+func readLag(ctx context.Context, reader transport.Reader, grouped bool) (int64, error) {
+	if grouped {
+		return reader.Stats().Lag, nil
+	}
+	return reader.ReadLag(ctx)
+}
+
+// fetchRetryBackoff 读取消息失败后重新读取前的等待时间
+const fetchRetryBackoff = 1 * time.Second
+
+// waitRetry 等待d，ctx取消时提前返回false
+func waitRetry(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
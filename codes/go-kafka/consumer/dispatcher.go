@@ -0,0 +1,283 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/middleware"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// Ordering 并发处理时的顺序保证
+type Ordering int
+
+const (
+	OrderByPartition Ordering = iota // 同一分区的消息按顺序处理
+	OrderByKey                       // 同一key的消息按顺序处理，同分区不同key可以并行
+)
+
+// errNotProcessed 处理函数返回该错误时，消息不计入已处理，分区水位不会越过它
+var errNotProcessed = errors.New("消息未处理完成")
+
+// CommitFunc 提交偏移量的函数
+type CommitFunc func(ctx context.Context, msgs ...kafka.Message) error
+
+// Dispatcher 并发分发器，将消息分发到工作协程池并行处理
+// 同一分区（或同一key）的消息总是由同一个工作协程处理以保证顺序，
+// 每个分区只提交连续处理完成的最高偏移量，崩溃重启后不会跳过未处理的消息；
+// 处理失败时按失败策略处理，默认阻塞重试，分区水位不会越过失败的消息
+type Dispatcher struct {
+	workers        int
+	queueSize      int
+	ordering       Ordering
+	commitInterval time.Duration
+	policy         FailurePolicy
+	dlq            middleware.DeadLetterHandler
+	retryBackoff   time.Duration
+	logger         *utils.Logger
+}
+
+// DispatcherOption 分发器配置选项
+type DispatcherOption func(*Dispatcher)
+
+// WithWorkers 设置工作协程数
+func WithWorkers(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.workers = n
+		}
+	}
+}
+
+// WithQueueSize 设置每个工作协程的队列长度，队列满时暂停读取
+func WithQueueSize(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.queueSize = n
+		}
+	}
+}
+
+// WithOrdering 设置顺序保证级别
+func WithOrdering(o Ordering) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.ordering = o
+	}
+}
+
+// WithCommitInterval 设置提交偏移量的间隔
+func WithCommitInterval(interval time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		if interval > 0 {
+			d.commitInterval = interval
+		}
+	}
+}
+
+// WithDispatchFailurePolicy 设置处理失败时的策略，与 ManualCommitConsumer.SetFailurePolicy 相同，FailureSkip 需要提供死信队列
+func WithDispatchFailurePolicy(policy FailurePolicy, dlq middleware.DeadLetterHandler) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.policy = policy
+		d.dlq = dlq
+	}
+}
+
+// WithDispatchRetryBackoff 设置阻塞重试和读取失败后重新读取的间隔
+func WithDispatchRetryBackoff(backoff time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		if backoff > 0 {
+			d.retryBackoff = backoff
+		}
+	}
+}
+
+// NewDispatcher 创建并发分发器
+func NewDispatcher(options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		workers:        4,
+		queueSize:      100,
+		ordering:       OrderByPartition,
+		commitInterval: 1 * time.Second,
+		policy:         FailureBlock,
+		retryBackoff:   1 * time.Second,
+		logger:         utils.NewLogger("[Dispatcher]"),
+	}
+
+	for _, opt := range options {
+		opt(d)
+	}
+
+	return d
+}
+
+// Run 从reader读取消息并分发处理，阻塞直到ctx取消或reader关闭
+// commit 为nil时不提交偏移量（例如指定分区消费）；ctx取消后正在处理的消息通过ctx中断，
+// 队列中尚未开始处理的消息被丢弃，退出前等待工作协程结束并提交已处理完成的偏移量。
+// 失败策略为 FailureStop 时，处理失败后按上述方式停止并返回处理错误
+func (d *Dispatcher) Run(ctx context.Context, reader transport.Reader, handler MessageHandler, commit CommitFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracker := newOffsetTracker()
+	var stopOnce sync.Once
+	var stopErr error
+
+	// 启动工作协程
+	var wg sync.WaitGroup
+	queues := make([]chan kafka.Message, d.workers)
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, d.queueSize)
+		wg.Add(1)
+		go func(queue <-chan kafka.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				// ctx取消后丢弃队列中未处理的消息，它们不会被提交，重启后重新消费
+				if ctx.Err() != nil {
+					continue
+				}
+				done, err := d.process(ctx, msg, handler)
+				if done {
+					tracker.Done(msg)
+				}
+				if err != nil {
+					stopOnce.Do(func() {
+						stopErr = err
+						cancel()
+					})
+				}
+			}
+		}(queues[i])
+	}
+
+	// 定时提交
+	stopCommit := make(chan struct{})
+	commitDone := make(chan struct{})
+	go func() {
+		defer close(commitDone)
+		if commit == nil {
+			return
+		}
+
+		ticker := time.NewTicker(d.commitInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.commitReady(tracker, commit)
+			case <-stopCommit:
+				return
+			}
+		}
+	}()
+
+	// 读取并分发
+	for ctx.Err() == nil {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				break
+			}
+			d.logger.Error("读取消息失败:", err)
+			waitRetry(ctx, d.retryBackoff)
+			continue
+		}
+
+		// 先登记再分发，未能分发的消息会阻止水位越过它
+		tracker.Track(msg)
+
+		select {
+		case queues[d.route(msg)] <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	// 等待已分发的消息处理完成
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	close(stopCommit)
+	<-commitDone
+	if commit != nil {
+		d.commitReady(tracker, commit)
+	}
+
+	return stopErr
+}
+
+// process 调用业务处理函数并按失败策略处理错误，返回消息是否可以提交；FailureStop 时返回处理错误
+// ctx取消导致的失败不提交，消息会在重启后重新消费
+func (d *Dispatcher) process(ctx context.Context, msg kafka.Message, handler MessageHandler) (bool, error) {
+	if handler == nil {
+		return true, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := handler(ctx, msg)
+		if err == nil {
+			return true, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, errNotProcessed) {
+			return false, nil
+		}
+
+		switch d.policy {
+		case FailureStop:
+			d.logger.Error("处理消息失败，停止消费, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+			return false, err
+
+		case FailureSkip:
+			if d.dlq == nil {
+				d.logger.Error("未配置死信队列，跳过消息, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+				return true, nil
+			}
+			dlqErr := d.dlq.SendToDLQ(ctx, msg, err)
+			if dlqErr == nil {
+				d.logger.Info("消息已发送到死信队列, partition:", msg.Partition, "offset:", msg.Offset)
+				return true, nil
+			}
+			// 死信队列不可用时阻塞重试，避免丢失消息
+			d.logger.Error("发送死信队列失败:", dlqErr)
+
+		default:
+			d.logger.Error("处理消息失败，第", attempt, "次重试, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(d.retryBackoff):
+		}
+	}
+}
+
+// route 选择处理消息的工作协程
+func (d *Dispatcher) route(msg kafka.Message) int {
+	if d.ordering == OrderByKey && len(msg.Key) > 0 {
+		h := fnv.New32a()
+		h.Write(msg.Key)
+		return int(h.Sum32() % uint32(d.workers))
+	}
+	return msg.Partition % d.workers
+}
+
+// commitReady 提交各分区连续处理完成的最高偏移量，失败时放回等待下次提交
+func (d *Dispatcher) commitReady(tracker *offsetTracker, commit CommitFunc) {
+	msgs := tracker.Ready()
+	if len(msgs) == 0 {
+		return
+	}
+
+	if err := commit(context.Background(), msgs...); err != nil {
+		d.logger.Error("提交偏移量失败:", err)
+		tracker.Restore(msgs)
+	}
+}
@@ -2,12 +2,15 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
@@ -19,8 +22,9 @@ type GroupConsumer struct {
 	wg         sync.WaitGroup
 	ctx        context.Context
 	cancel     context.CancelFunc
-	consumer   *kafka.Reader
+	consumer   transport.Reader
 	instanceID string
+	dispatcher *Dispatcher
 }
 
 // NewGroupConsumer 创建消费者组实例
@@ -65,65 +69,99 @@ func (c *GroupConsumer) Connect() error {
 		StartOffset: kafka.FirstOffset, // 首次消费从头开始
 		// StartOffset: kafka.LastOffset, // 首次消费从最新开始
 
-		// 再平衡回调
-		GroupBalancerFunc: func(memberAssignments map[string][]int32) {
-			c.logger.Info("分区再平衡完成，分配的分区:", memberAssignments[c.instanceID])
-		},
-
 		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
 			c.logger.Error(fmt.Sprintf(msg, args...))
 		}),
 	}
 
-	c.consumer = kafka.NewReader(config)
+	if err := c.config.ApplyReader(&config); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	c.consumer = c.config.GetTransport().NewReader(config)
 	c.logger.Info("消费者组连接成功, groupID:", c.config.GroupID)
 	return nil
 }
 
-// Start 开始消费
-func (c *GroupConsumer) Start(handler MessageHandler) {
-	c.handler = handler
+// UseDispatcher 使用并发分发器处理消息，需在Start之前调用
+func (c *GroupConsumer) UseDispatcher(d *Dispatcher) {
+	c.dispatcher = d
+}
+
+// Start 开始消费，阻塞直到ctx取消或调用 Stop，实现 Consumer 接口
+func (c *GroupConsumer) Start(ctx context.Context, handler MessageHandler) error {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	c.run(ctx, handler)
+	return nil
+}
+
+// startAsync 在后台协程中开始消费
+func (c *GroupConsumer) startAsync(ctx context.Context, handler MessageHandler) {
 	c.wg.Add(1)
 
 	go func() {
 		defer c.wg.Done()
-		c.logger.Info("消费者实例启动:", c.instanceID)
+		c.run(ctx, handler)
+	}()
+}
 
-		for {
-			select {
-			case <-c.ctx.Done():
+// run 消费循环，ctx取消或调用 Stop 时退出
+func (c *GroupConsumer) run(ctx context.Context, handler MessageHandler) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.ctx, cancel)
+	defer stop()
+
+	c.handler = handler
+	c.logger.Info("消费者实例启动:", c.instanceID)
+
+	if c.dispatcher != nil {
+		if err := c.dispatcher.Run(ctx, c.consumer, c.handleMessage, c.consumer.CommitMessages); err != nil {
+			c.logger.Error("消费者实例处理失败:", c.instanceID, err)
+		}
+		c.logger.Info("消费者实例停止:", c.instanceID)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("消费者实例停止:", c.instanceID)
+			return
+		default:
+		}
+
+		// 读取消息
+		msg, err := c.consumer.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
 				c.logger.Info("消费者实例停止:", c.instanceID)
 				return
-			default:
 			}
-
-			// 读取消息
-			msg, err := c.consumer.ReadMessage(c.ctx)
-			if err != nil {
-				if c.ctx.Err() != nil {
-					return
-				}
-				c.logger.Error("读取消息失败:", err)
-				continue
+			c.logger.Error("读取消息失败:", err)
+			if !waitRetry(ctx, fetchRetryBackoff) {
+				return
 			}
+			continue
+		}
 
-			// 处理消息
-			if err := c.handleMessage(msg); err != nil {
-				c.logger.Error("处理消息失败:", err)
-			}
+		// 处理消息
+		if err := c.handleMessage(ctx, msg); err != nil {
+			c.logger.Error("处理消息失败:", err)
 		}
-	}()
+	}
 }
 
 // handleMessage 处理消息并提交偏移量
-func (c *GroupConsumer) handleMessage(msg kafka.Message) error {
+func (c *GroupConsumer) handleMessage(ctx context.Context, msg kafka.Message) error {
 	c.logger.Info("处理消息, partition:", msg.Partition,
 		"offset:", msg.Offset,
 		"instance:", c.instanceID)
 
 	// 执行业务逻辑
 	if c.handler != nil {
-		if err := c.handler(msg); err != nil {
+		if err := c.handler(ctx, msg); err != nil {
 			// 业务处理失败，可以选择重试或记录
 			return err
 		}
@@ -158,6 +196,11 @@ func (c *GroupConsumer) Stats() kafka.ReaderStats {
 	return c.consumer.Stats()
 }
 
+// Lag 获取消费延迟，实现 Consumer 接口
+func (c *GroupConsumer) Lag(ctx context.Context) (int64, error) {
+	return readLag(ctx, c.consumer, true)
+}
+
 // CommitMessages 手动提交消息偏移量（如开启自动提交则无需调用）
 func (c *GroupConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
 	return c.consumer.CommitMessages(ctx, msgs...)
@@ -165,33 +208,86 @@ func (c *GroupConsumer) CommitMessages(ctx context.Context, msgs ...kafka.Messag
 
 // ConsumerGroupManager 消费者组管理器，管理多个消费实例
 type ConsumerGroupManager struct {
-	consumers []*GroupConsumer
-	config    *config.KafkaConfig
-	logger    *utils.Logger
+	consumers  []*GroupConsumer
+	config     *config.KafkaConfig
+	logger     *utils.Logger
+	instances  int
+	dispatcher *Dispatcher
+	mu         sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
 }
 
 // NewConsumerGroupManager 创建消费者组管理器
 func NewConsumerGroupManager(cfg *config.KafkaConfig) *ConsumerGroupManager {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ConsumerGroupManager{
-		config: cfg,
-		logger: utils.NewLogger("[ConsumerGroupManager]"),
+		config:    cfg,
+		logger:    utils.NewLogger("[ConsumerGroupManager]"),
+		instances: 1,
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 }
 
-// StartConsumers 启动多个消费者实例
+// SetInstances 设置 Start 启动的消费者实例数
+func (m *ConsumerGroupManager) SetInstances(n int) {
+	if n > 0 {
+		m.instances = n
+	}
+}
+
+// UseDispatcher 各消费者实例使用并发分发器处理消息，需在启动之前调用
+func (m *ConsumerGroupManager) UseDispatcher(d *Dispatcher) {
+	m.dispatcher = d
+}
+
+// StartConsumers 启动多个消费者实例（非阻塞）
 func (m *ConsumerGroupManager) StartConsumers(count int, handler MessageHandler) error {
-	m.consumers = make([]*GroupConsumer, count)
+	return m.startConsumers(m.ctx, count, handler)
+}
+
+// Start 启动消费者实例并阻塞，直到ctx取消或调用 Close，实现 Consumer 接口
+func (m *ConsumerGroupManager) Start(ctx context.Context, handler MessageHandler) error {
+	if err := m.startConsumers(ctx, m.instances, handler); err != nil {
+		m.StopAll()
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-m.ctx.Done():
+	}
+
+	m.StopAll()
+	return nil
+}
+
+// startConsumers 创建、连接并在后台启动消费者实例
+func (m *ConsumerGroupManager) startConsumers(ctx context.Context, count int, handler MessageHandler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.consumers = make([]*GroupConsumer, 0, count)
 
 	for i := 0; i < count; i++ {
 		instanceID := fmt.Sprintf("instance-%d", i)
 		consumer := NewGroupConsumer(m.config, instanceID)
 
 		if err := consumer.Connect(); err != nil {
+			// 关闭已启动的实例，Stats 和 Lag 不会访问未连接的实例
+			for _, c := range m.consumers {
+				c.Close()
+			}
+			m.consumers = nil
 			return fmt.Errorf("连接消费者%d失败: %w", i, err)
 		}
 
-		consumer.Start(handler)
-		m.consumers[i] = consumer
+		if m.dispatcher != nil {
+			consumer.UseDispatcher(m.dispatcher)
+		}
+		consumer.startAsync(ctx, handler)
+		m.consumers = append(m.consumers, consumer)
 		m.logger.Info("启动消费者实例:", instanceID)
 	}
 
@@ -201,10 +297,57 @@ func (m *ConsumerGroupManager) StartConsumers(count int, handler MessageHandler)
 
 // StopAll 停止所有消费者
 func (m *ConsumerGroupManager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.logger.Info("正在停止所有消费者...")
 	for _, c := range m.consumers {
 		if c != nil {
 			c.Close()
 		}
 	}
+	m.consumers = nil
+}
+
+// Close 停止所有消费者，实现 Consumer 接口
+func (m *ConsumerGroupManager) Close() error {
+	m.cancel()
+	m.StopAll()
+	return nil
+}
+
+// Stats 汇总所有实例的消费统计
+func (m *ConsumerGroupManager) Stats() kafka.ReaderStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total := kafka.ReaderStats{Topic: m.config.Topic}
+	for _, c := range m.consumers {
+		stats := c.Stats()
+		total.Dials += stats.Dials
+		total.Fetches += stats.Fetches
+		total.Messages += stats.Messages
+		total.Bytes += stats.Bytes
+		total.Rebalances += stats.Rebalances
+		total.Timeouts += stats.Timeouts
+		total.Errors += stats.Errors
+		total.Lag += stats.Lag
+	}
+	return total
+}
+
+// Lag 汇总所有实例的消费延迟
+func (m *ConsumerGroupManager) Lag(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for _, c := range m.consumers {
+		lag, err := c.Lag(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += lag
+	}
+	return total, nil
 }
@@ -2,23 +2,43 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/middleware"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
+// FailurePolicy 消息处理失败时的策略
+type FailurePolicy int
+
+const (
+	FailureBlock FailurePolicy = iota // 阻塞重试直到成功，分区水位不会越过失败的消息
+	FailureSkip                       // 发送到死信队列后跳过
+	FailureStop                       // 停止消费，Start 返回错误
+)
+
 // ManualCommitConsumer 手动提交偏移量的消费者，确保消息不丢失
+// 按分区跟踪处理进度，只提交连续处理成功的最高偏移量
 type ManualCommitConsumer struct {
-	reader         *kafka.Reader
+	reader         transport.Reader
 	config         *config.KafkaConfig
 	logger         *utils.Logger
-	uncommitted    []kafka.Message
+	tracker        *offsetTracker
+	processed      int // 上次提交后处理完成的消息数
 	commitMutex    sync.Mutex
 	maxUncommitted int
+	commitInterval time.Duration
+	policy         FailurePolicy
+	dlq            middleware.DeadLetterHandler
+	retryBackoff   time.Duration
+	dispatcher     *Dispatcher
 }
 
 // NewManualCommitConsumer 创建手动提交消费者
@@ -30,8 +50,31 @@ func NewManualCommitConsumer(cfg *config.KafkaConfig, maxUncommitted int) *Manua
 	return &ManualCommitConsumer{
 		config:         cfg,
 		logger:         utils.NewLogger("[ManualCommitConsumer]"),
+		tracker:        newOffsetTracker(),
 		maxUncommitted: maxUncommitted,
-		uncommitted:    make([]kafka.Message, 0, maxUncommitted),
+		commitInterval: 5 * time.Second,
+		policy:         FailureBlock,
+		retryBackoff:   1 * time.Second,
+	}
+}
+
+// SetCommitInterval 设置定时提交间隔，与数量阈值同时生效，0表示只按数量提交
+func (c *ManualCommitConsumer) SetCommitInterval(interval time.Duration) {
+	if interval >= 0 {
+		c.commitInterval = interval
+	}
+}
+
+// SetFailurePolicy 设置处理失败时的策略，FailureSkip 需要提供死信队列
+func (c *ManualCommitConsumer) SetFailurePolicy(policy FailurePolicy, dlq middleware.DeadLetterHandler) {
+	c.policy = policy
+	c.dlq = dlq
+}
+
+// SetRetryBackoff 设置阻塞重试的间隔
+func (c *ManualCommitConsumer) SetRetryBackoff(backoff time.Duration) {
+	if backoff > 0 {
+		c.retryBackoff = backoff
 	}
 }
 
@@ -58,105 +101,240 @@ func (c *ManualCommitConsumer) Connect() error {
 		}),
 	}
 
-	c.reader = kafka.NewReader(config)
+	if err := c.config.ApplyReader(&config); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	config.CommitInterval = 0 // 偏移量由本消费者提交，忽略配置的自动提交间隔
+	c.reader = c.config.GetTransport().NewReader(config)
 	c.logger.Info("手动提交消费者连接成功")
 	return nil
 }
 
-// Start 开始消费并手动提交
+// UseDispatcher 使用并发分发器处理消息，需在Start之前调用
+// 分发器按提交间隔提交各分区连续处理完成的最高偏移量
+func (c *ManualCommitConsumer) UseDispatcher(d *Dispatcher) {
+	c.dispatcher = d
+}
+
+// Start 开始消费并手动提交，阻塞直到ctx取消或消费者关闭
+// 失败策略为 FailureStop 时，提交已连续处理成功的偏移量后返回处理错误
 func (c *ManualCommitConsumer) Start(ctx context.Context, handler MessageHandler) error {
 	c.logger.Info("开始消费（手动提交模式）...")
 
+	if c.dispatcher != nil {
+		return c.startDispatcher(ctx, handler)
+	}
+
+	stopTicker := c.startCommitTicker(ctx)
+	defer stopTicker()
+
 	for {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("收到停止信号")
 			// 退出前尝试提交剩余消息
-			c.commitUncommitted(ctx)
+			c.commitUncommitted(context.Background())
 			return nil
 		default:
 		}
 
-		// 读取消息
-		msg, err := c.reader.ReadMessage(ctx)
+		// 读取消息，使用 FetchMessage 避免消费者组模式下自动提交
+		msg, err := c.reader.FetchMessage(ctx)
 		if err != nil {
 			if ctx.Err() != nil {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				// 消费者已关闭，提交关闭期间处理完成的消息
+				if err := c.Commit(context.Background()); err != nil {
+					c.logger.Error("关闭时提交失败:", err)
+				}
 				return nil
 			}
 			c.logger.Error("读取消息失败:", err)
+			waitRetry(ctx, c.retryBackoff)
 			continue
 		}
 
 		// 处理消息
-		processErr := c.processAndCommit(msg, handler)
-		if processErr != nil {
-			c.logger.Error("处理消息失败:", processErr)
-			// 可以选择重试或记录死信队列
+		c.tracker.Track(msg)
+		if err := c.processAndCommit(ctx, msg, handler); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			c.logger.Error("处理消息失败，停止消费:", err)
+			c.commitUncommitted(context.Background())
+			return err
 		}
 	}
 }
 
-// processAndCommit 处理消息并管理提交
-func (c *ManualCommitConsumer) processAndCommit(msg kafka.Message, handler MessageHandler) error {
-	c.logger.Info("处理消息, partition:", msg.Partition, "offset:", msg.Offset)
+// startDispatcher 使用并发分发器消费，失败策略在各工作协程中执行
+func (c *ManualCommitConsumer) startDispatcher(ctx context.Context, handler MessageHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// 执行业务逻辑
-	if handler != nil {
-		if err := handler(msg); err != nil {
-			// 业务处理失败，不提交偏移量，消息会被重新消费
-			return fmt.Errorf("业务处理失败: %w", err)
+	var stopOnce sync.Once
+	var stopErr error
+
+	c.dispatcher.Run(ctx, c.reader, func(ctx context.Context, msg kafka.Message) error {
+		err := c.process(ctx, msg, handler)
+		if err == nil {
+			return nil
 		}
+
+		if ctx.Err() == nil {
+			stopOnce.Do(func() {
+				stopErr = err
+				cancel()
+			})
+		}
+		// 未处理成功的消息不提交
+		return fmt.Errorf("%w: %v", errNotProcessed, err)
+	}, c.commitMessages)
+
+	return stopErr
+}
+
+// processAndCommit 处理消息并管理提交
+func (c *ManualCommitConsumer) processAndCommit(ctx context.Context, msg kafka.Message, handler MessageHandler) error {
+	if err := c.process(ctx, msg, handler); err != nil {
+		// 未处理成功，分区水位停留在该消息之前，消息会被重新消费
+		return err
 	}
 
-	// 业务处理成功，加入未提交队列
+	// 处理完成，推进分区水位
+	c.tracker.Done(msg)
+
 	c.commitMutex.Lock()
-	c.uncommitted = append(c.uncommitted, msg)
-	shouldCommit := len(c.uncommitted) >= c.maxUncommitted
+	c.processed++
+	shouldCommit := c.processed >= c.maxUncommitted
 	c.commitMutex.Unlock()
 
 	// 达到阈值，执行提交
 	if shouldCommit {
-		if err := c.Commit(ctx.Background()); err != nil {
+		if err := c.Commit(ctx); err != nil {
 			c.logger.Error("批量提交失败:", err)
-			return err
 		}
 	}
 
 	return nil
 }
 
-// Commit 手动提交所有未提交的消息
+// process 执行业务逻辑并按失败策略处理错误
+// 返回nil表示消息已处理完成（成功或已发送到死信队列），可以提交
+func (c *ManualCommitConsumer) process(ctx context.Context, msg kafka.Message, handler MessageHandler) error {
+	c.logger.Info("处理消息, partition:", msg.Partition, "offset:", msg.Offset)
+
+	if handler == nil {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := handler(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		// 消费者关闭导致的失败，不执行失败策略，消息会被重新消费
+		if ctx.Err() != nil {
+			return fmt.Errorf("业务处理失败: %w", err)
+		}
+
+		switch c.policy {
+		case FailureStop:
+			return fmt.Errorf("业务处理失败: %w", err)
+
+		case FailureSkip:
+			if c.dlq == nil {
+				c.logger.Error("未配置死信队列，跳过消息, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+				return nil
+			}
+			dlqErr := c.dlq.SendToDLQ(ctx, msg, err)
+			if dlqErr == nil {
+				c.logger.Info("消息已发送到死信队列, partition:", msg.Partition, "offset:", msg.Offset)
+				return nil
+			}
+			// 死信队列不可用时阻塞重试，避免丢失消息
+			c.logger.Error("发送死信队列失败:", dlqErr)
+
+		default:
+			c.logger.Error("业务处理失败，第", attempt, "次重试, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("业务处理失败: %w", err)
+		case <-time.After(c.retryBackoff):
+		}
+	}
+}
+
+// startCommitTicker 启动定时提交，返回停止函数
+func (c *ManualCommitConsumer) startCommitTicker(ctx context.Context) func() {
+	if c.commitInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(c.commitInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Commit(ctx); err != nil && ctx.Err() == nil {
+					c.logger.Error("定时提交失败:", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Commit 手动提交各分区连续处理成功的最高偏移量
 func (c *ManualCommitConsumer) Commit(ctx context.Context) error {
 	c.commitMutex.Lock()
 	defer c.commitMutex.Unlock()
 
-	if len(c.uncommitted) == 0 {
+	msgs := c.tracker.Ready()
+	if len(msgs) == 0 {
 		return nil
 	}
 
-	// 复制消息
-	msgs := make([]kafka.Message, len(c.uncommitted))
-	copy(msgs, c.uncommitted)
+	if err := c.commitMessages(ctx, msgs...); err != nil {
+		// 放回等待下次提交
+		c.tracker.Restore(msgs)
+		return err
+	}
 
-	// 提交偏移量
+	c.processed = 0
+	return nil
+}
+
+// commitMessages 提交偏移量
+func (c *ManualCommitConsumer) commitMessages(ctx context.Context, msgs ...kafka.Message) error {
 	start := time.Now()
 	if err := c.reader.CommitMessages(ctx, msgs...); err != nil {
 		return fmt.Errorf("提交偏移量失败: %w", err)
 	}
 
-	c.logger.Info("提交成功，数量:", len(msgs), "耗时:", time.Since(start))
-
-	// 清空已提交消息
-	c.uncommitted = c.uncommitted[:0]
+	c.logger.Info("提交成功，分区数:", len(msgs), "耗时:", time.Since(start))
 	return nil
 }
 
 // commitUncommitted 尝试提交剩余消息（用于关闭时）
 func (c *ManualCommitConsumer) commitUncommitted(ctx context.Context) {
-	c.commitMutex.Lock()
-	count := len(c.uncommitted)
-	c.commitMutex.Unlock()
-
+	count := c.GetUncommittedCount()
 	if count > 0 {
 		c.logger.Info("关闭前提交剩余消息:", count)
 		if err := c.Commit(ctx); err != nil {
@@ -181,13 +359,23 @@ func (c *ManualCommitConsumer) Close() error {
 }
 
 // GetLag 获取当前消费延迟
-func (c *ManualCommitConsumer) GetLag(ctx context.Context) (kafka.Lag, error) {
-	return c.reader.ReadLag(ctx)
+func (c *ManualCommitConsumer) GetLag(ctx context.Context) (int64, error) {
+	return c.Lag(ctx)
+}
+
+// Lag 获取消费延迟，实现 Consumer 接口
+func (c *ManualCommitConsumer) Lag(ctx context.Context) (int64, error) {
+	return readLag(ctx, c.reader, c.config.GroupID != "")
+}
+
+// Stats 获取消费统计
+func (c *ManualCommitConsumer) Stats() kafka.ReaderStats {
+	return c.reader.Stats()
 }
 
-// GetUncommittedCount 获取未提交消息数量
+// GetUncommittedCount 获取已处理但未提交的消息数量
 func (c *ManualCommitConsumer) GetUncommittedCount() int {
 	c.commitMutex.Lock()
 	defer c.commitMutex.Unlock()
-	return len(c.uncommitted)
+	return c.processed
 }
@@ -0,0 +1,133 @@
+package consumer
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// topicPartition 分区标识
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// trackedMessage 已读取、等待处理完成的消息
+type trackedMessage struct {
+	msg  kafka.Message
+	done bool
+}
+
+// partitionTracker 单个分区的处理进度，按读取顺序记录消息
+type partitionTracker struct {
+	inflight []*trackedMessage
+	byOffset map[int64]*trackedMessage
+}
+
+// offsetTracker 按分区跟踪消息处理进度，只推进连续处理完成的水位
+// 并发处理时，较新的消息先完成也不会越过仍在处理中的旧消息，保证提交后不会跳过未处理的消息
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionTracker
+	ready      map[topicPartition]kafka.Message // 可提交但尚未提交的最高连续消息
+}
+
+// newOffsetTracker 创建偏移量跟踪器
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[topicPartition]*partitionTracker),
+		ready:      make(map[topicPartition]kafka.Message),
+	}
+}
+
+// Track 记录已读取的消息
+// 偏移量回退说明发生了再平衡或重置，丢弃该分区之前的记录
+func (t *offsetTracker) Track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	p, ok := t.partitions[tp]
+	if !ok || (len(p.inflight) > 0 && msg.Offset <= p.inflight[len(p.inflight)-1].msg.Offset) {
+		p = &partitionTracker{byOffset: make(map[int64]*trackedMessage)}
+		t.partitions[tp] = p
+		delete(t.ready, tp)
+	}
+
+	m := &trackedMessage{msg: msg}
+	p.inflight = append(p.inflight, m)
+	p.byOffset[msg.Offset] = m
+}
+
+// Done 标记消息处理完成，水位推进时返回true
+func (t *offsetTracker) Done(msg kafka.Message) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	p, ok := t.partitions[tp]
+	if !ok {
+		return false
+	}
+
+	m, ok := p.byOffset[msg.Offset]
+	if !ok {
+		return false
+	}
+	m.done = true
+
+	advanced := false
+	for len(p.inflight) > 0 && p.inflight[0].done {
+		t.ready[tp] = p.inflight[0].msg
+		delete(p.byOffset, p.inflight[0].msg.Offset)
+		p.inflight = p.inflight[1:]
+		advanced = true
+	}
+	return advanced
+}
+
+// Ready 取出每个分区可提交的最高连续消息
+func (t *offsetTracker) Ready() []kafka.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.ready) == 0 {
+		return nil
+	}
+
+	msgs := make([]kafka.Message, 0, len(t.ready))
+	for tp, msg := range t.ready {
+		msgs = append(msgs, msg)
+		delete(t.ready, tp)
+	}
+	return msgs
+}
+
+// Restore 提交失败时放回可提交消息，已有更新的水位时忽略
+func (t *offsetTracker) Restore(msgs []kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, msg := range msgs {
+		tp := topicPartition{msg.Topic, msg.Partition}
+		if cur, ok := t.ready[tp]; !ok || cur.Offset < msg.Offset {
+			t.ready[tp] = msg
+		}
+	}
+}
+
+// Pending 返回已读取但尚未处理完成的消息数
+func (t *offsetTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, p := range t.partitions {
+		for _, m := range p.inflight {
+			if !m.done {
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,236 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/middleware"
+	"go-kafka/producer"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// TransformFunc 处理一条输入消息，返回要写入的输出消息，未指定Topic的输出消息写入管道的输出Topic
+type TransformFunc func(ctx context.Context, msg kafka.Message) ([]kafka.Message, error)
+
+// Pipeline 读取-处理-写入管道，实现恰好一次处理
+// 每批输入消息产生的输出消息和输入偏移量在同一事务中提交；处理或提交失败时中止事务并按失败策略重试整批，
+// 输出Topic上 read_committed 的消费者不会读到中止事务的消息。输入同样以 read_committed 读取
+type Pipeline struct {
+	config       *config.KafkaConfig
+	outputTopic  string
+	producer     *producer.TransactionalProducer
+	reader       transport.Reader
+	logger       *utils.Logger
+	batchSize    int
+	batchTimeout time.Duration
+	policy       FailurePolicy
+	dlq          middleware.DeadLetterHandler
+	retryBackoff time.Duration
+}
+
+// NewPipeline 创建管道，从 cfg.Topic 以消费者组 cfg.GroupID 读取，写入 outputTopic
+// 事务ID为 producer.transactional_id，同时运行的多个实例需要使用不同的ID
+func NewPipeline(cfg *config.KafkaConfig, outputTopic string) *Pipeline {
+	return &Pipeline{
+		config:       cfg,
+		outputTopic:  outputTopic,
+		logger:       utils.NewLogger("[Pipeline]"),
+		batchSize:    100,
+		batchTimeout: 100 * time.Millisecond,
+		policy:       FailureBlock,
+		retryBackoff: 1 * time.Second,
+	}
+}
+
+// SetBatch 设置每个事务包含的最大输入消息数，以及凑批的最长等待
+func (p *Pipeline) SetBatch(size int, timeout time.Duration) {
+	if size > 0 {
+		p.batchSize = size
+	}
+	if timeout > 0 {
+		p.batchTimeout = timeout
+	}
+}
+
+// SetFailurePolicy 设置处理失败时的策略
+// FailureBlock 中止事务后重试整批；FailureSkip 将失败的消息发送到死信队列后继续（死信队列不在事务中）；
+// FailureStop 中止事务后 Start 返回错误
+func (p *Pipeline) SetFailurePolicy(policy FailurePolicy, dlq middleware.DeadLetterHandler) {
+	p.policy = policy
+	p.dlq = dlq
+}
+
+// SetRetryBackoff 设置重试整批的间隔
+func (p *Pipeline) SetRetryBackoff(backoff time.Duration) {
+	if backoff > 0 {
+		p.retryBackoff = backoff
+	}
+}
+
+// Connect 连接到Kafka，创建事务生产者和输入读取器
+func (p *Pipeline) Connect() error {
+	out := *p.config
+	out.Topic = p.outputTopic
+	tp := producer.NewTransactionalProducer(&out, "")
+	if err := tp.Connect(); err != nil {
+		return err
+	}
+
+	readerConfig := kafka.ReaderConfig{
+		Brokers:  p.config.Brokers,
+		Topic:    p.config.Topic,
+		GroupID:  p.config.GroupID,
+		MinBytes: 1,
+		MaxBytes: 10e6,
+		MaxWait:  500 * time.Millisecond,
+	}
+	if err := p.config.ApplyReader(&readerConfig); err != nil {
+		tp.Close()
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	readerConfig.CommitInterval = 0 // 偏移量随事务提交
+	readerConfig.IsolationLevel = kafka.ReadCommitted
+
+	p.producer = tp
+	p.reader = p.config.GetTransport().NewReader(readerConfig)
+	p.logger.Info("管道连接成功:", p.config.Topic, "->", p.outputTopic)
+	return nil
+}
+
+// Start 开始处理，阻塞直到ctx取消或管道关闭；读取失败时等待 retryBackoff 后重试，生产者被隔离或失败策略为 FailureStop 时返回错误
+// ctx取消时未提交的批次被中止，重启后从上次提交的偏移量继续
+func (p *Pipeline) Start(ctx context.Context, transform TransformFunc) error {
+	for {
+		batch, err := p.fetch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil // 读取器已关闭
+		}
+		if err != nil {
+			p.logger.Error("读取消息失败:", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(p.retryBackoff):
+			}
+			continue
+		}
+
+		for attempt := 1; ; attempt++ {
+			err := p.processBatch(ctx, batch, transform)
+			if err == nil {
+				break
+			}
+
+			p.abort()
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, transport.ErrProducerFenced) || p.policy == FailureStop {
+				return err
+			}
+
+			p.logger.Error("事务失败，第", attempt, "次重试，消息数:", len(batch), "error:", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(p.retryBackoff):
+			}
+		}
+	}
+}
+
+// fetch 阻塞读取第一条消息，之后在 batchTimeout 内凑满一批
+func (p *Pipeline) fetch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := p.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, p.batchTimeout)
+	defer cancel()
+
+	for len(batch) < p.batchSize {
+		msg, err := p.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			break
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+// processBatch 在一个事务中处理一批消息并提交输入偏移量
+func (p *Pipeline) processBatch(ctx context.Context, batch []kafka.Message, transform TransformFunc) error {
+	if err := p.producer.Begin(); err != nil {
+		return err
+	}
+
+	for _, msg := range batch {
+		out, err := transform(ctx, msg)
+		if err != nil {
+			if p.policy != FailureSkip {
+				return fmt.Errorf("处理消息失败, partition: %d, offset: %d: %w", msg.Partition, msg.Offset, err)
+			}
+			if p.dlq == nil {
+				p.logger.Error("未配置死信队列，跳过消息, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+				continue
+			}
+			if dlqErr := p.dlq.SendToDLQ(ctx, msg, err); dlqErr != nil {
+				return fmt.Errorf("发送死信队列失败: %w", dlqErr)
+			}
+			continue
+		}
+
+		if len(out) > 0 {
+			if err := p.producer.Send(ctx, out...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := p.producer.SendOffsets(ctx, p.config.GroupID, batch...); err != nil {
+		return err
+	}
+	if err := p.producer.Commit(ctx); err != nil {
+		return err
+	}
+
+	p.logger.Info("事务提交成功，消息数:", len(batch))
+	return nil
+}
+
+// abort 中止进行中的事务
+func (p *Pipeline) abort() {
+	if err := p.producer.Abort(context.Background()); err != nil && !errors.Is(err, transport.ErrNoTransaction) {
+		p.logger.Error("中止事务失败:", err)
+	}
+}
+
+// Stats 获取输入的消费统计
+func (p *Pipeline) Stats() kafka.ReaderStats {
+	return p.reader.Stats()
+}
+
+// Close 关闭管道，进行中的事务被中止
+func (p *Pipeline) Close() error {
+	var errs []error
+	if p.reader != nil {
+		errs = append(errs, p.reader.Close())
+	}
+	if p.producer != nil {
+		errs = append(errs, p.producer.Close())
+	}
+
+	p.logger.Info("管道已关闭")
+	return errors.Join(errs...)
+}
@@ -2,23 +2,35 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/middleware"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
 // MessageHandler 消息处理函数类型
-type MessageHandler func(msg kafka.Message) error
+// ctx 来自 Start，消费者关闭时取消，处理函数应在ctx取消后尽快返回
+type MessageHandler func(ctx context.Context, msg kafka.Message) error
+
+// Adapt 将不带ctx的处理函数转换为 MessageHandler，与 middleware.Adapt 相同
+func Adapt(fn func(msg kafka.Message) error) MessageHandler {
+	return MessageHandler(middleware.Adapt(fn))
+}
 
 // SimpleConsumer 简单消费者（单分区）
 type SimpleConsumer struct {
-	reader    *kafka.Reader
+	reader    transport.Reader
 	config    *config.KafkaConfig
 	logger    *utils.Logger
 	partition int
+
+	dispatcher *Dispatcher
 }
 
 // NewSimpleConsumer 创建简单消费者
@@ -33,13 +45,21 @@ func NewSimpleConsumer(cfg *config.KafkaConfig, partition int) *SimpleConsumer {
 
 // Connect 连接到Kafka
 func (c *SimpleConsumer) Connect() error {
+	// kafka-go 不允许同时指定分区和消费者组
+	groupID, partition := c.config.GroupID, c.partition
+	if partition >= 0 {
+		groupID = ""
+	} else {
+		partition = 0
+	}
+
 	config := kafka.ReaderConfig{
 		Brokers: c.config.Brokers,
 		Topic:   c.config.Topic,
-		GroupID: c.config.GroupID,
+		GroupID: groupID,
 
 		// 分区配置
-		Partition:        c.partition,
+		Partition:        partition,
 		MinBytes:         1,    // 最小抓取字节
 		MaxBytes:         10e6, // 10MB 最大抓取字节
 		MaxWait:          1 * time.Second,
@@ -57,15 +77,34 @@ func (c *SimpleConsumer) Connect() error {
 		}),
 	}
 
-	c.reader = kafka.NewReader(config)
+	if err := c.config.ApplyReader(&config); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	c.reader = c.config.GetTransport().NewReader(config)
 	c.logger.Info("消费者连接成功, topic:", c.config.Topic)
 	return nil
 }
 
-// Start 开始消费（阻塞）
+// UseDispatcher 使用并发分发器处理消息，需在Start之前调用
+func (c *SimpleConsumer) UseDispatcher(d *Dispatcher) {
+	c.dispatcher = d
+}
+
+// Start 开始消费，阻塞直到ctx取消或消费者关闭
 func (c *SimpleConsumer) Start(ctx context.Context, handler MessageHandler) error {
 	c.logger.Info("开始消费消息...")
 
+	if c.dispatcher != nil {
+		// 指定分区消费时没有消费者组，不提交偏移量
+		var commit CommitFunc
+		if c.grouped() {
+			commit = c.reader.CommitMessages
+		}
+		return c.dispatcher.Run(ctx, c.reader, func(ctx context.Context, msg kafka.Message) error {
+			return c.processMessage(ctx, msg, handler)
+		}, commit)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -77,15 +116,18 @@ func (c *SimpleConsumer) Start(ctx context.Context, handler MessageHandler) erro
 		// 读取消息
 		msg, err := c.reader.ReadMessage(ctx)
 		if err != nil {
-			if ctx.Err() != nil {
-				return nil // 上下文取消
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil // 上下文取消或消费者已关闭
 			}
 			c.logger.Error("读取消息失败:", err)
+			if !waitRetry(ctx, fetchRetryBackoff) {
+				return nil
+			}
 			continue
 		}
 
 		// 处理消息
-		if err := c.processMessage(msg, handler); err != nil {
+		if err := c.processMessage(ctx, msg, handler); err != nil {
 			c.logger.Error("处理消息失败:", err)
 			// 可以选择重试或跳过
 		}
@@ -93,14 +135,14 @@ func (c *SimpleConsumer) Start(ctx context.Context, handler MessageHandler) erro
 }
 
 // processMessage 处理单条消息
-func (c *SimpleConsumer) processMessage(msg kafka.Message, handler MessageHandler) error {
+func (c *SimpleConsumer) processMessage(ctx context.Context, msg kafka.Message, handler MessageHandler) error {
 	c.logger.Info("收到消息, partition:", msg.Partition,
 		"offset:", msg.Offset,
 		"key:", string(msg.Key))
 
 	// 调用业务处理函数
 	if handler != nil {
-		if err := handler(msg); err != nil {
+		if err := handler(ctx, msg); err != nil {
 			return err
 		}
 	}
@@ -151,6 +193,16 @@ func (c *SimpleConsumer) Close() error {
 }
 
 // FetchLag 获取消费延迟信息
-func (c *SimpleConsumer) FetchLag(ctx context.Context) (kafka.Lag, error) {
-	return c.reader.ReadLag(ctx)
+func (c *SimpleConsumer) FetchLag(ctx context.Context) (int64, error) {
+	return c.Lag(ctx)
+}
+
+// Lag 获取消费延迟，实现 Consumer 接口
+func (c *SimpleConsumer) Lag(ctx context.Context) (int64, error) {
+	return readLag(ctx, c.reader, c.grouped())
+}
+
+// grouped 是否以消费者组模式消费
+func (c *SimpleConsumer) grouped() bool {
+	return c.partition < 0 && c.config.GroupID != ""
 }
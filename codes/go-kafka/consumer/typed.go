@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/middleware"
+	"go-kafka/serializer"
+	"go-kafka/utils"
+)
+
+// Metadata 消息元数据
+type Metadata struct {
+	Topic     string
+	Key       string
+	Headers   map[string]string
+	Partition int
+	Offset    int64
+	Time      time.Time
+}
+
+// TypedHandler 强类型消息处理函数
+type TypedHandler[T any] func(ctx context.Context, value T, meta Metadata) error
+
+// DecodeError 反序列化失败的错误
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("反序列化失败: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrorHandler 处理反序列化失败的消息，返回nil表示跳过该消息，返回错误则作为处理失败
+type DecodeErrorHandler func(ctx context.Context, msg kafka.Message, err *DecodeError) error
+
+// TypedOption 强类型消费配置选项
+type TypedOption func(*typedOptions)
+
+type typedOptions struct {
+	onDecodeError DecodeErrorHandler
+}
+
+// WithDecodeErrorHandler 设置反序列化失败时的处理函数，默认记录日志后跳过
+func WithDecodeErrorHandler(fn DecodeErrorHandler) TypedOption {
+	return func(o *typedOptions) {
+		if fn != nil {
+			o.onDecodeError = fn
+		}
+	}
+}
+
+// WithDecodeDLQ 反序列化失败的消息发送到死信队列后跳过，发送失败时作为处理失败
+func WithDecodeDLQ(dlq middleware.DeadLetterHandler) TypedOption {
+	return WithDecodeErrorHandler(func(ctx context.Context, msg kafka.Message, err *DecodeError) error {
+		if dlqErr := dlq.SendToDLQ(ctx, msg, err); dlqErr != nil {
+			return fmt.Errorf("%w (发送死信队列失败: %v)", err, dlqErr)
+		}
+		return nil
+	})
+}
+
+// Decode 将强类型处理函数转换为 MessageHandler，使用s反序列化消息
+// 反序列化失败的消息不会交给handler，而是交给 DecodeErrorHandler
+func Decode[T any](s serializer.Serializer, handler TypedHandler[T], options ...TypedOption) MessageHandler {
+	logger := utils.NewLogger("[TypedConsumer]")
+	opts := typedOptions{
+		onDecodeError: func(ctx context.Context, msg kafka.Message, err *DecodeError) error {
+			logger.Error("跳过无法反序列化的消息, partition:", msg.Partition, "offset:", msg.Offset, "error:", err)
+			return nil
+		},
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	return func(ctx context.Context, msg kafka.Message) error {
+		var value T
+		if err := serializer.DeserializeWithHeaders(s, msg.Value, serializer.HeadersOf(msg), &value); err != nil {
+			return opts.onDecodeError(ctx, msg, &DecodeError{Err: err})
+		}
+		return handler(ctx, value, MetadataOf(msg))
+	}
+}
+
+// MetadataOf 提取消息元数据
+func MetadataOf(msg kafka.Message) Metadata {
+	return Metadata{
+		Topic:     msg.Topic,
+		Key:       string(msg.Key),
+		Headers:   serializer.HeadersOf(msg),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      msg.Time,
+	}
+}
+
+// TypedConsumer 强类型消费者，通过序列化器将消息反序列化为T
+type TypedConsumer[T any] struct {
+	consumer   Consumer
+	serializer serializer.Serializer
+	options    []TypedOption
+}
+
+// NewTypedConsumer 创建强类型消费者，c 需已完成连接
+func NewTypedConsumer[T any](c Consumer, s serializer.Serializer, options ...TypedOption) *TypedConsumer[T] {
+	return &TypedConsumer[T]{
+		consumer:   c,
+		serializer: s,
+		options:    options,
+	}
+}
+
+// Start 开始消费，阻塞直到ctx取消或消费者关闭
+func (c *TypedConsumer[T]) Start(ctx context.Context, handler TypedHandler[T]) error {
+	return c.consumer.Start(ctx, c.Handler(handler))
+}
+
+// Handler 返回反序列化后调用handler的 MessageHandler，可以再套用中间件
+func (c *TypedConsumer[T]) Handler(handler TypedHandler[T]) MessageHandler {
+	return Decode(c.serializer, handler, c.options...)
+}
+
+// Stats 获取消费统计
+func (c *TypedConsumer[T]) Stats() kafka.ReaderStats {
+	return c.consumer.Stats()
+}
+
+// Lag 获取消费延迟
+func (c *TypedConsumer[T]) Lag(ctx context.Context) (int64, error) {
+	return c.consumer.Lag(ctx)
+}
+
+// Consumer 返回底层消费者
+func (c *TypedConsumer[T]) Consumer() Consumer {
+	return c.consumer
+}
+
+// Close 关闭消费者
+func (c *TypedConsumer[T]) Close() error {
+	return c.consumer.Close()
+}
@@ -0,0 +1,193 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/middleware"
+	"go-kafka/retry"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// 死信消息头，原始位置、重试次数和错误与重试Topic使用相同的消息头，
+// 重试阶梯最终写入死信队列的消息同样可以被 Replayer 重放
+const (
+	HeaderError             = retry.HeaderError
+	HeaderAttempt           = retry.HeaderAttempt
+	HeaderOriginalTopic     = retry.HeaderOriginalTopic
+	HeaderOriginalPartition = retry.HeaderOriginalPartition
+	HeaderOriginalOffset    = retry.HeaderOriginalOffset
+	HeaderTimestamp         = "x-dlq-timestamp" // 进入死信队列的时间（RFC3339）
+	HeaderPanic             = "x-dlq-panic"     // panic值
+	HeaderStack             = "x-dlq-stack"     // panic时的调用栈
+)
+
+// internalHeaders 重放时需要去掉的消息头
+var internalHeaders = map[string]bool{
+	HeaderError:             true,
+	HeaderAttempt:           true,
+	HeaderOriginalTopic:     true,
+	HeaderOriginalPartition: true,
+	HeaderOriginalOffset:    true,
+	HeaderTimestamp:         true,
+	HeaderPanic:             true,
+	HeaderStack:             true,
+	retry.HeaderNotBefore:   true,
+}
+
+// options 死信队列配置
+type options struct {
+	topic string
+}
+
+// Option 死信队列配置选项，Handler 和 Replayer 通用
+type Option func(*options)
+
+// WithTopic 设置死信队列Topic，默认为 <topic>.dlq
+func WithTopic(topic string) Option {
+	return func(o *options) {
+		if topic != "" {
+			o.topic = topic
+		}
+	}
+}
+
+// newOptions 应用配置选项
+func newOptions(cfg *config.KafkaConfig, opts []Option) options {
+	o := options{topic: cfg.Topic + ".dlq"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Handler 基于Kafka的死信队列处理器，实现 middleware.DeadLetterHandler
+type Handler struct {
+	config *config.KafkaConfig
+	topic  string
+	writer transport.Writer
+	logger *utils.Logger
+}
+
+var _ middleware.DeadLetterHandler = (*Handler)(nil)
+
+// NewHandler 创建死信队列处理器
+func NewHandler(cfg *config.KafkaConfig, opts ...Option) *Handler {
+	o := newOptions(cfg, opts)
+	return &Handler{
+		config: cfg,
+		topic:  o.topic,
+		logger: utils.NewLogger("[DLQ]"),
+	}
+}
+
+// Connect 连接到Kafka
+func (h *Handler) Connect() error {
+	w := &kafka.Writer{
+		Addr:  kafka.TCP(h.config.Brokers...),
+		Topic: h.topic,
+
+		AllowAutoTopicCreation: true,
+
+		WriteTimeout: 10 * time.Second,
+		MaxAttempts:  3,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	if err := h.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	// 死信消息不能丢失，始终等待所有副本确认，不受配置影响
+	w.Balancer = &kafka.Hash{}
+	w.RequiredAcks = kafka.RequireAll
+	h.writer = h.config.GetTransport().NewWriter(w)
+
+	h.logger.Info("死信队列连接成功, topic:", h.topic)
+	return nil
+}
+
+// Topic 返回死信队列Topic
+func (h *Handler) Topic() string {
+	return h.topic
+}
+
+// SendToDLQ 将处理失败的消息写入死信队列
+func (h *Handler) SendToDLQ(ctx context.Context, msg kafka.Message, err error) error {
+	out := kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: deadLetterHeaders(msg, err),
+		Time:    time.Now(),
+	}
+
+	if writeErr := h.writer.WriteMessages(ctx, out); writeErr != nil {
+		return fmt.Errorf("写入死信队列失败: %w", writeErr)
+	}
+
+	h.logger.Info("消息已写入死信队列, topic:", msg.Topic, "partition:", msg.Partition, "offset:", msg.Offset)
+	return nil
+}
+
+// Close 关闭死信队列处理器
+func (h *Handler) Close() error {
+	if h.writer != nil {
+		if err := h.writer.Close(); err != nil {
+			return fmt.Errorf("关闭死信队列失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// deadLetterHeaders 构造死信消息头，保留业务消息头，已经过重试的消息沿用首次失败时的原始位置
+func deadLetterHeaders(msg kafka.Message, cause error) []kafka.Header {
+	origTopic, origPartition, origOffset := msg.Topic, strconv.Itoa(msg.Partition), strconv.FormatInt(msg.Offset, 10)
+	if t := header(msg, HeaderOriginalTopic); t != "" {
+		origTopic = t
+		origPartition = header(msg, HeaderOriginalPartition)
+		origOffset = header(msg, HeaderOriginalOffset)
+	}
+
+	headers := make([]kafka.Header, 0, len(msg.Headers)+8)
+	for _, h := range msg.Headers {
+		if !internalHeaders[h.Key] {
+			headers = append(headers, h)
+		}
+	}
+
+	headers = append(headers,
+		kafka.Header{Key: HeaderAttempt, Value: []byte(strconv.Itoa(retry.Attempt(msg) + 1))},
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(origTopic)},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte(origPartition)},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte(origOffset)},
+		kafka.Header{Key: HeaderTimestamp, Value: []byte(time.Now().Format(time.RFC3339Nano))},
+	)
+
+	if cause != nil {
+		headers = append(headers, kafka.Header{Key: HeaderError, Value: []byte(cause.Error())})
+
+		var panicErr *middleware.PanicError
+		if errors.As(cause, &panicErr) {
+			headers = append(headers,
+				kafka.Header{Key: HeaderPanic, Value: []byte(fmt.Sprint(panicErr.Value))},
+				kafka.Header{Key: HeaderStack, Value: panicErr.Stack},
+			)
+		}
+	}
+
+	return headers
+}
+
+// header 读取消息头
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
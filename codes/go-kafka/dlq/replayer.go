@@ -0,0 +1,196 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// Filter 死信消息过滤条件，返回true表示需要重放
+type Filter func(msg kafka.Message) bool
+
+// ByError 错误信息包含指定内容的消息
+func ByError(substr string) Filter {
+	return func(msg kafka.Message) bool {
+		return strings.Contains(header(msg, HeaderError), substr)
+	}
+}
+
+// ByKey 指定key的消息
+func ByKey(key string) Filter {
+	return func(msg kafka.Message) bool {
+		return string(msg.Key) == key
+	}
+}
+
+// ByTimeRange 在 [from, to) 时间范围内进入死信队列的消息，零值表示不限制
+func ByTimeRange(from, to time.Time) Filter {
+	return func(msg kafka.Message) bool {
+		t := deadLetterTime(msg)
+		if !from.IsZero() && t.Before(from) {
+			return false
+		}
+		if !to.IsZero() && !t.Before(to) {
+			return false
+		}
+		return true
+	}
+}
+
+// Replayer 死信队列重放器，读取死信队列中符合条件的消息并重新发送到原始Topic
+type Replayer struct {
+	config *config.KafkaConfig
+	topic  string
+	writer transport.Writer
+	logger *utils.Logger
+}
+
+// NewReplayer 创建死信队列重放器
+func NewReplayer(cfg *config.KafkaConfig, opts ...Option) *Replayer {
+	o := newOptions(cfg, opts)
+	return &Replayer{
+		config: cfg,
+		topic:  o.topic,
+		logger: utils.NewLogger("[DLQReplayer]"),
+	}
+}
+
+// Connect 连接到Kafka
+func (r *Replayer) Connect() error {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(r.config.Brokers...),
+		WriteTimeout: 10 * time.Second,
+		MaxAttempts:  3,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	if err := r.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	w.Balancer = &kafka.Hash{} // 相同key回到同一分区
+	w.RequiredAcks = kafka.RequireAll
+	r.writer = r.config.GetTransport().NewWriter(w)
+
+	r.logger.Info("重放器连接成功, topic:", r.topic)
+	return nil
+}
+
+// Replay 读取死信队列当前的全部消息，将满足所有过滤条件的消息重新发送到原始Topic
+// 重放不提交偏移量，也不删除死信消息，返回重放的消息数
+func (r *Replayer) Replay(ctx context.Context, filters ...Filter) (int, error) {
+	partitions, err := r.config.GetTransport().LookupPartitions(ctx, r.config.Brokers, r.topic)
+	if err != nil {
+		return 0, fmt.Errorf("查询死信队列分区失败: %w", err)
+	}
+
+	replayed := 0
+	for _, partition := range partitions {
+		n, err := r.replayPartition(ctx, partition, filters)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+	}
+
+	r.logger.Info("重放完成, 消息数:", replayed)
+	return replayed, nil
+}
+
+// replayPartition 重放一个分区，读到开始时的末尾为止
+func (r *Replayer) replayPartition(ctx context.Context, partition int, filters []Filter) (int, error) {
+	rc := kafka.ReaderConfig{
+		Brokers:   r.config.Brokers,
+		Topic:     r.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+		MaxWait:   500 * time.Millisecond,
+	}
+	if err := r.config.ApplyReader(&rc); err != nil {
+		return 0, fmt.Errorf("连接配置错误: %w", err)
+	}
+	rc.StartOffset = kafka.FirstOffset // 总是从头重放
+	reader := r.config.GetTransport().NewReader(rc)
+	defer reader.Close()
+
+	remaining, err := reader.ReadLag(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("读取分区%d延迟失败: %w", partition, err)
+	}
+
+	replayed := 0
+	for ; remaining > 0; remaining-- {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			return replayed, fmt.Errorf("读取死信消息失败: %w", err)
+		}
+
+		if !matches(msg, filters) {
+			continue
+		}
+
+		topic := header(msg, HeaderOriginalTopic)
+		if topic == "" {
+			r.logger.Error("死信消息缺少原始Topic，跳过, partition:", msg.Partition, "offset:", msg.Offset)
+			continue
+		}
+
+		if err := r.writer.WriteMessages(ctx, restore(msg, topic)); err != nil {
+			return replayed, fmt.Errorf("重放消息到%s失败: %w", topic, err)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// Close 关闭重放器
+func (r *Replayer) Close() error {
+	if r.writer != nil {
+		if err := r.writer.Close(); err != nil {
+			return fmt.Errorf("关闭重放器失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// matches 是否满足所有过滤条件
+func matches(msg kafka.Message, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// restore 还原为发送到原始Topic的消息，去掉死信和重试相关的消息头
+func restore(msg kafka.Message, topic string) kafka.Message {
+	var headers []kafka.Header
+	for _, h := range msg.Headers {
+		if !internalHeaders[h.Key] {
+			headers = append(headers, h)
+		}
+	}
+
+	return kafka.Message{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    time.Now(),
+	}
+}
+
+// deadLetterTime 进入死信队列的时间，没有消息头时使用消息时间
+func deadLetterTime(msg kafka.Message) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, header(msg, HeaderTimestamp)); err == nil {
+		return t
+	}
+	return msg.Time
+}
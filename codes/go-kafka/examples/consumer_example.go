@@ -50,7 +50,7 @@ func runSimpleConsumer(cfg *config.KafkaConfig) {
 	defer c.Close()
 
 	// 定义消息处理函数
-	handler := func(msg kafka.Message) error {
+	handler := func(ctx context.Context, msg kafka.Message) error {
 		fmt.Printf("收到消息: partition=%d, offset=%d, key=%s, value=%s\\n",
 			msg.Partition,
 			msg.Offset,
@@ -87,7 +87,7 @@ func runGroupConsumer(cfg *config.KafkaConfig) {
 	manager := consumer.NewConsumerGroupManager(cfg)
 
 	// 定义消息处理函数
-	handler := func(msg kafka.Message) error {
+	handler := func(ctx context.Context, msg kafka.Message) error {
 		fmt.Printf("[%s] 处理消息: partition=%d, offset=%d, value=%s\\n",
 			time.Now().Format("15:04:05"),
 			msg.Partition,
@@ -129,7 +129,7 @@ func runManualCommitConsumer(cfg *config.KafkaConfig) {
 	defer c.Close()
 
 	// 消息处理函数
-	handler := func(msg kafka.Message) error {
+	handler := func(ctx context.Context, msg kafka.Message) error {
 		fmt.Printf("处理消息: partition=%d, offset=%d, value=%s\\n",
 			msg.Partition,
 			msg.Offset,
@@ -208,10 +208,11 @@ func runLagMonitor(cfg *config.KafkaConfig) {
 			continue
 		}
 
-		fmt.Printf("[%s] 消费延迟: partition=%d, lag=%d, offset=%d\\n",
+		stats := c.Stats()
+		fmt.Printf("[%s] 消费延迟: partition=%s, lag=%d, offset=%d\\n",
 			time.Now().Format("15:04:05"),
-			lag.Partition,
-			lag.Lag,
-			lag.Offset)
+			stats.Partition,
+			lag,
+			stats.Offset)
 	}
 }
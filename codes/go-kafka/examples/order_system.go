@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,7 +17,9 @@ import (
 	"go-kafka/consumer"
 	"go-kafka/middleware"
 	"go-kafka/producer"
+	"go-kafka/serializer"
 	"go-kafka/topic"
+	"go-kafka/transport"
 )
 
 // Order 订单结构
@@ -44,6 +47,38 @@ type OrderEvent struct {
 	Data      Order     `json:"data"`
 }
 
+// orderEventSchema OrderEvent 的JSON Schema，生产和消费时按该schema校验
+const orderEventSchema = `{
+	"type": "object",
+	"required": ["type", "order_id", "timestamp", "data"],
+	"properties": {
+		"type": {"enum": ["created", "paid", "shipped", "cancelled"]},
+		"order_id": {"type": "string", "minLength": 1},
+		"timestamp": {"type": "string", "format": "date-time"},
+		"data": {
+			"type": "object",
+			"required": ["id", "user_id", "amount", "items"],
+			"properties": {
+				"id": {"type": "string", "minLength": 1},
+				"user_id": {"type": "string", "minLength": 1},
+				"amount": {"type": "number", "minimum": 0},
+				"items": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/item"}}
+			}
+		}
+	},
+	"definitions": {
+		"item": {
+			"type": "object",
+			"required": ["product_id", "quantity", "price"],
+			"properties": {
+				"product_id": {"type": "string"},
+				"quantity": {"type": "integer", "minimum": 1},
+				"price": {"type": "number", "exclusiveMinimum": 0}
+			}
+		}
+	}
+}`
+
 const (
 	TopicOrders         = "orders"
 	TopicOrderEvents    = "order-events"
@@ -62,6 +97,11 @@ func main() {
 		GroupID: ConsumerGroupOrders,
 	}
 
+	// KAFKA_TRANSPORT=memory 时使用进程内Broker，advanced 示例可以在没有Kafka的环境下完整运行
+	if os.Getenv("KAFKA_TRANSPORT") == "memory" {
+		cfg.Transport = transport.NewBroker(transport.WithDefaultPartitions(3))
+	}
+
 	switch os.Args[1] {
 	case "setup":
 		setupTopics(cfg)
@@ -131,7 +171,10 @@ func runOrderProducer(cfg *config.KafkaConfig) {
 	if err := p.Connect(); err != nil {
 		log.Fatal("连接失败:", err)
 	}
-	defer p.Close()
+
+	// 发送前按schema校验，不满足约定的订单事件不会发出
+	vp := producer.NewValidatingProducer(p, newOrderValidator(), TopicOrders+"-value")
+	defer vp.Close()
 
 	// 模拟生成订单
 	ticker := time.NewTicker(2 * time.Second)
@@ -159,7 +202,7 @@ func runOrderProducer(cfg *config.KafkaConfig) {
 
 			data, _ := json.Marshal(event)
 
-			if err := p.Send(order.ID, string(data)); err != nil {
+			if err := vp.SendMessage(context.Background(), order.ID, string(data)); err != nil {
 				log.Printf("发送失败: %v", err)
 			} else {
 				orderCount++
@@ -172,16 +215,17 @@ func runOrderProducer(cfg *config.KafkaConfig) {
 // runOrderConsumer 订单消费者
 func runOrderConsumer(cfg *config.KafkaConfig) {
 	fmt.Println("启动订单消费者...")
-	fmt.Println("使用中间件: Recovery, Logger, Retry")
+	fmt.Println("使用中间件: Recovery, Logger, ValidatePayload, Retry")
 
-	// 创建中间件链
+	// 创建中间件链，校验放在重试之前，无效消息不会重试
 	chain := middleware.Chain(
 		middleware.Recovery(),
 		middleware.Logger(),
+		middleware.ValidatePayload(newOrderValidator(), middleware.TopicSubject(), nil),
 		middleware.Retry(3, 2*time.Second),
 	)
 
-	handler := chain(func(msg kafka.Message) error {
+	handler := chain(func(ctx context.Context, msg kafka.Message) error {
 		var event OrderEvent
 		if err := json.Unmarshal(msg.Value, &event); err != nil {
 			return err
@@ -231,7 +275,7 @@ func runAnalyticsConsumer(cfg *config.KafkaConfig) {
 	stats := make(map[string]int)
 	var mu sync.Mutex
 
-	handler := func(msg kafka.Message) error {
+	handler := func(ctx context.Context, msg kafka.Message) error {
 		var event OrderEvent
 		if err := json.Unmarshal(msg.Value, &event); err != nil {
 			return err
@@ -330,6 +374,19 @@ func runAdvancedClient(cfg *config.KafkaConfig) {
 	consumer.Start(ctx)
 }
 
+// newOrderValidator 注册订单事件的schema并创建校验器
+// 示例使用进程内注册中心，实际项目中使用 serializer.NewRegistryClient 连接共享的注册中心
+func newOrderValidator() *serializer.Validator {
+	registry := serializer.NewSchemaRegistry()
+	if _, err := registry.Register(TopicOrders+"-value", serializer.Schema{
+		Type: serializer.SchemaTypeJSON,
+		Data: []byte(orderEventSchema),
+	}); err != nil {
+		log.Fatal("注册schema失败:", err)
+	}
+	return serializer.NewValidator(registry)
+}
+
 // generateRandomOrder 生成随机订单
 func generateRandomOrder(seq int) Order {
 	items := []Item{
@@ -105,7 +105,7 @@ func runAsyncProducer(cfg *config.KafkaConfig) {
 		key := fmt.Sprintf("async-key-%d", i)
 		value := fmt.Sprintf("异步消息 %d", i)
 
-		if err := p.SendAsync(key, value); err != nil {
+		if _, err := p.SendAsync(key, value); err != nil {
 			fmt.Println("发送失败:", err)
 		}
 	}
@@ -113,8 +113,8 @@ func runAsyncProducer(cfg *config.KafkaConfig) {
 	fmt.Println("100条消息已加入发送队列")
 	fmt.Printf("待发送消息数: %d\\n", p.PendingMessages())
 
-	// 等待一段时间让消息发送完成
-	time.Sleep(2 * time.Second)
+	// 等待队列中的消息发送完成
+	p.Flush()
 }
 
 // runBatchProducer 批量生产者示例
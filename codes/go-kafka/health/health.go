@@ -35,7 +35,11 @@ type Checker interface {
 }
 
 // NewHealthChecker 创建健康检查器
+// interval 为0时使用 observability.health_check_interval 配置，都未设置时为30秒
 func NewHealthChecker(cfg *config.KafkaConfig, interval time.Duration) *HealthChecker {
+	if interval == 0 {
+		interval = cfg.Observability.HealthCheckInterval.Std()
+	}
 	if interval == 0 {
 		interval = 30 * time.Second
 	}
@@ -141,7 +145,7 @@ func (bc *BrokerChecker) Check(ctx context.Context) HealthStatus {
 	start := time.Now()
 
 	for _, broker := range bc.config.Brokers {
-		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		conn, err := bc.config.DialContext(ctx, "tcp", broker)
 		if err != nil {
 			return HealthStatus{
 				Status:  "unhealthy",
@@ -178,7 +182,7 @@ func (tc *TopicChecker) Check(ctx context.Context) HealthStatus {
 
 	start := time.Now()
 
-	conn, err := kafka.Dial("tcp", tc.config.Brokers[0])
+	conn, err := tc.config.DialContext(ctx, "tcp", tc.config.Brokers[0])
 	if err != nil {
 		return HealthStatus{
 			Status:  "unhealthy",
@@ -220,6 +224,12 @@ func (lc *LatencyChecker) Check(ctx context.Context) HealthStatus {
 		Addr:  kafka.TCP(lc.config.Brokers...),
 		Topic: lc.config.Topic,
 	}
+	if err := lc.config.ApplyWriter(writer); err != nil {
+		return HealthStatus{
+			Status:  "unhealthy",
+			Message: fmt.Sprintf("连接配置错误: %v", err),
+		}
+	}
 	defer writer.Close()
 
 	err := writer.WriteMessages(ctx, kafka.Message{
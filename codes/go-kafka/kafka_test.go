@@ -2,25 +2,64 @@ package kafka_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go-kafka/client"
 	"go-kafka/config"
 	"go-kafka/consumer"
+	"go-kafka/dlq"
+	"go-kafka/metrics"
+	"go-kafka/middleware"
+	"go-kafka/partitioner"
 	"go-kafka/producer"
+	"go-kafka/retry"
+	"go-kafka/serializer"
+	"go-kafka/spool"
+	"go-kafka/tracer"
+	"go-kafka/transport"
+	"go-kafka/utils"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// newTestConfig 创建使用内存Broker的测试配置，无需启动Kafka
+func newTestConfig(topic, groupID string) (*config.KafkaConfig, *transport.Broker) {
+	broker := transport.NewBroker()
+	return &config.KafkaConfig{
+		Brokers:   []string{"localhost:9092"},
+		Topic:     topic,
+		GroupID:   groupID,
+		Transport: broker,
+	}, broker
+}
+
 // TestSimpleProducer 测试简单生产者
 func TestSimpleProducer(t *testing.T) {
-	cfg := &config.KafkaConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   "test-topic",
-	}
+	cfg, broker := newTestConfig("test-topic", "")
 
 	p := producer.NewSimpleProducer(cfg)
 	if err := p.Connect(); err != nil {
-		t.Skip("无法连接Kafka，跳过测试:", err)
+		t.Fatalf("连接失败: %v", err)
 	}
 	defer p.Close()
 
@@ -32,54 +71,133 @@ func TestSimpleProducer(t *testing.T) {
 	if err != nil {
 		t.Errorf("发送消息失败: %v", err)
 	}
+
+	err = p.SendMessageWithHeaders(ctx, "test-key", "with-headers", map[string]string{"source": "test"})
+	if err != nil {
+		t.Errorf("发送消息失败: %v", err)
+	}
+
+	msgs := broker.Messages("test-topic")
+	if len(msgs) != 2 {
+		t.Fatalf("期望2条消息，得到 %d", len(msgs))
+	}
+	if string(msgs[0].Key) != "test-key" || string(msgs[0].Value) != "test-value" {
+		t.Errorf("消息内容错误: %s=%s", msgs[0].Key, msgs[0].Value)
+	}
+	if len(msgs[1].Headers) != 1 || msgs[1].Headers[0].Key != "source" {
+		t.Errorf("消息头错误: %v", msgs[1].Headers)
+	}
 }
 
 // TestSimpleConsumer 测试简单消费者
 func TestSimpleConsumer(t *testing.T) {
-	cfg := &config.KafkaConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   "test-topic",
-		GroupID: "test-group",
-	}
+	cfg, _ := newTestConfig("test-topic", "test-group")
+
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	defer p.Close()
 
 	c := consumer.NewSimpleConsumer(cfg, -1)
 	if err := c.Connect(); err != nil {
-		t.Skip("无法连接Kafka，跳过测试:", err)
+		t.Fatalf("连接失败: %v", err)
 	}
 	defer c.Close()
 
-	// 测试读取消息（超时模式）
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	done := make(chan bool)
-	go func() {
-		_, err := c.ReadMessage(ctx)
+	// 没有消息时读取会阻塞到超时
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	if _, err := c.ReadMessage(shortCtx); err == nil {
+		t.Error("空Topic读取应该超时")
+	}
+	shortCancel()
+
+	if err := p.SendMessage(ctx, "k1", "v1"); err != nil {
+		t.Fatalf("发送消息失败: %v", err)
+	}
+
+	msg, err := c.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+	if string(msg.Value) != "v1" {
+		t.Errorf("期望 v1，得到 %s", msg.Value)
+	}
+
+	// 关闭后 Start 返回
+	done := make(chan error, 1)
+	go func() { done <- c.Start(ctx, nil) }()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
 		if err != nil {
-			// 超时是预期的
-			t.Logf("读取消息超时（预期）: %v", err)
+			t.Errorf("Start 返回错误: %v", err)
 		}
-		done <- true
-	}()
+	case <-time.After(2 * time.Second):
+		t.Fatal("关闭后 Start 未返回")
+	}
+}
 
-	select {
-	case <-done:
-		// 测试通过
-	case <-time.After(10 * time.Second):
-		t.Error("消费者测试超时")
+// TestPartitionConsumer 测试指定分区消费和偏移量设置
+func TestPartitionConsumer(t *testing.T) {
+	cfg, broker := newTestConfig("partition-topic", "")
+	broker.CreateTopic("partition-topic", 2)
+
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		p.SendMessage(ctx, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+
+	var expected []kafka.Message
+	for _, msg := range broker.Messages("partition-topic") {
+		if msg.Partition == 1 {
+			expected = append(expected, msg)
+		}
+	}
+	if len(expected) < 2 {
+		t.Fatalf("分区1的消息太少: %d", len(expected))
+	}
+
+	c := consumer.NewSimpleConsumer(cfg, 1)
+	c.Connect()
+	defer c.Close()
+
+	if err := c.SetOffset(1); err != nil {
+		t.Fatalf("设置偏移量失败: %v", err)
+	}
+
+	msg, err := c.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+	if msg.Partition != 1 || msg.Offset != 1 || string(msg.Key) != string(expected[1].Key) {
+		t.Errorf("读取位置错误: partition=%d offset=%d key=%s", msg.Partition, msg.Offset, msg.Key)
+	}
+
+	lag, err := c.FetchLag(ctx)
+	if err != nil {
+		t.Fatalf("获取延迟失败: %v", err)
+	}
+	if lag != int64(len(expected)-2) {
+		t.Errorf("期望延迟 %d，得到 %d", len(expected)-2, lag)
 	}
 }
 
 // TestBatchProducer 测试批量生产者
 func TestBatchProducer(t *testing.T) {
-	cfg := &config.KafkaConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   "test-batch-topic",
-	}
+	cfg, broker := newTestConfig("test-batch-topic", "")
 
 	p := producer.NewBatchProducer(cfg)
 	if err := p.Connect(); err != nil {
-		t.Skip("无法连接Kafka，跳过测试:", err)
+		t.Fatalf("连接失败: %v", err)
 	}
 	defer p.Close()
 
@@ -96,135 +214,3503 @@ func TestBatchProducer(t *testing.T) {
 	if err != nil {
 		t.Errorf("刷新失败: %v", err)
 	}
+
+	if n := len(broker.Messages("test-batch-topic")); n != 10 {
+		t.Errorf("期望10条消息，得到 %d", n)
+	}
 }
 
-// TestMiddlewareChain 测试中间件链
-func TestMiddlewareChain(t *testing.T) {
-	callOrder := []string{}
+// TestBatchProducerFlushOnClose 测试关闭时发送缓冲区中的剩余消息
+func TestBatchProducerFlushOnClose(t *testing.T) {
+	cfg, broker := newTestConfig("test-batch-topic", "")
 
-	m1 := func(next func(kafka.Message) error) func(kafka.Message) error {
-		return func(msg kafka.Message) error {
-			callOrder = append(callOrder, "m1-before")
-			err := next(msg)
-			callOrder = append(callOrder, "m1-after")
-			return err
-		}
+	p := producer.NewBatchProducer(cfg, producer.WithBatchSize(100))
+	p.Connect()
+
+	for i := 0; i < 5; i++ {
+		p.Send("batch-key", "batch-value")
 	}
 
-	m2 := func(next func(kafka.Message) error) func(kafka.Message) error {
-		return func(msg kafka.Message) error {
-			callOrder = append(callOrder, "m2-before")
-			err := next(msg)
-			callOrder = append(callOrder, "m2-after")
-			return err
-		}
+	if err := p.Close(); err != nil {
+		t.Fatalf("关闭失败: %v", err)
 	}
 
-	chain := func(final func(kafka.Message) error) func(kafka.Message) error {
-		return func(msg kafka.Message) error {
-			handler := final
-			handler = m1(handler)
-			handler = m2(handler)
-			return handler(msg)
-		}
+	if n := len(broker.Messages("test-batch-topic")); n != 5 {
+		t.Errorf("期望5条消息，得到 %d", n)
 	}
+}
 
-	handler := chain(func(msg kafka.Message) error {
-		callOrder = append(callOrder, "handler")
-		return nil
-	})
+// TestAsyncProducer 测试异步生产者回调
+func TestAsyncProducer(t *testing.T) {
+	cfg, broker := newTestConfig("async-topic", "")
 
-	msg := kafka.Message{Value: []byte("test")}
-	handler(msg)
+	var delivered int64
+	p := producer.NewAsyncProducer(cfg, func(msg kafka.Message, err error) {
+		if err == nil {
+			atomic.AddInt64(&delivered, 1)
+		}
+	})
+	p.Connect()
 
-	// 验证调用顺序
-	expected := []string{"m2-before", "m1-before", "handler", "m1-after", "m2-after"}
-	for i, v := range expected {
-		if i >= len(callOrder) || callOrder[i] != v {
-			t.Errorf("中间件调用顺序错误，期望 %v，得到 %v", expected, callOrder)
-			break
+	for i := 0; i < 20; i++ {
+		if _, err := p.SendAsync("async-key", fmt.Sprintf("value-%d", i)); err != nil {
+			t.Errorf("发送消息失败: %v", err)
 		}
 	}
-}
 
-// BenchmarkProducer 生产者性能测试
-func BenchmarkProducer(b *testing.B) {
-	cfg := &config.KafkaConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   "benchmark-topic",
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&delivered) < 20 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
 	}
+	p.Close()
 
-	p := producer.NewBatchProducer(cfg)
-	if err := p.Connect(); err != nil {
-		b.Skip("无法连接Kafka，跳过测试:", err)
+	if n := atomic.LoadInt64(&delivered); n != 20 {
+		t.Errorf("期望回调20次，得到 %d", n)
+	}
+	if n := len(broker.Messages("async-topic")); n != 20 {
+		t.Errorf("期望20条消息，得到 %d", n)
 	}
+}
+
+// TestAsyncProducerFlush 测试异步生产者 Flush 等待队列中的消息发送完成
+func TestAsyncProducerFlush(t *testing.T) {
+	cfg, broker := newTestConfig("async-topic", "")
+
+	p := producer.NewAsyncProducer(cfg, nil)
+	p.Connect()
 	defer p.Close()
 
 	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		p.SendMessageWithHeaders(ctx, "async-key", fmt.Sprintf("value-%d", i), map[string]string{"seq": fmt.Sprint(i)})
+	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		p.Send("benchmark-key", "benchmark-value")
+	if err := p.Flush(); err != nil {
+		t.Fatalf("刷新失败: %v", err)
 	}
-	p.Flush()
+	if n := len(broker.Messages("async-topic")); n != 10 {
+		t.Errorf("期望10条消息，得到 %d", n)
+	}
+}
+
+// TestAsyncProducerDelivery 测试异步生产者的投递结果：每条入队的消息报告一次，包括失败、丢弃和关闭
+func TestAsyncProducerDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Futures", func(t *testing.T) {
+		cfg, broker := newTestConfig("delivery-topic", "")
+		broker.CreateTopic("delivery-topic", 2)
+
+		var reports int64
+		p := producer.NewAsyncProducer(cfg, func(msg kafka.Message, err error) {
+			atomic.AddInt64(&reports, 1)
+		})
+		p.Connect()
+		defer p.Close()
+
+		var deliveries []*producer.Delivery
+		for i := 0; i < 10; i++ {
+			d, err := p.SendAsync(strconv.Itoa(i), "value-"+strconv.Itoa(i))
+			if err != nil {
+				t.Fatalf("入队失败: %v", err)
+			}
+			deliveries = append(deliveries, d)
+		}
+		d, _ := p.Produce(ctx, kafka.Message{Value: []byte("with-headers"), Headers: []kafka.Header{{Key: "h", Value: []byte("1")}}})
+		deliveries = append(deliveries, d)
+
+		for _, d := range deliveries {
+			msg, err := d.Wait(ctx)
+			if err != nil {
+				t.Fatalf("投递失败: %v", err)
+			}
+			stored := broker.Messages("delivery-topic")
+			found := false
+			for _, m := range stored {
+				if m.Partition == msg.Partition && m.Offset == msg.Offset {
+					found = string(m.Value) == string(msg.Value)
+				}
+			}
+			if !found || msg.Topic != "delivery-topic" {
+				t.Errorf("投递结果与写入的消息不一致: %s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+			}
+		}
+		if n := atomic.LoadInt64(&reports); n != 11 {
+			t.Errorf("期望回调11次，得到 %d", n)
+		}
+	})
+
+	t.Run("WriteError", func(t *testing.T) {
+		cfg, broker := newTestConfig("delivery-topic", "")
+		broker.SetWriteError(errors.New("broker不可用"))
+
+		p := producer.NewAsyncProducer(cfg, nil)
+		p.Connect()
+		defer p.Close()
+
+		var cbErr error
+		done := make(chan struct{})
+		p.SendAsyncWithCallback("k", "v", func(err error) {
+			cbErr = err
+			close(done)
+		})
+		d, _ := p.SendAsync("k", "v")
+		if _, err := d.Wait(ctx); err == nil || !strings.Contains(err.Error(), "broker不可用") {
+			t.Errorf("期望发送错误，得到 %v", err)
+		}
+		<-done
+		if cbErr == nil {
+			t.Errorf("SendAsyncWithCallback 应报告发送错误")
+		}
+	})
+
+	t.Run("Backpressure", func(t *testing.T) {
+		cfg, broker := newTestConfig("delivery-topic", "")
+		cfg.Producer.QueueSize = 2
+		cfg.Producer.Backpressure = "fail"
+
+		// 未连接时后台协程未启动，队列不会被消费
+		p := producer.NewAsyncProducer(cfg, nil)
+		d1, _ := p.SendAsync("k", "1")
+		d2, _ := p.SendAsync("k", "2")
+		if _, err := p.SendAsync("k", "3"); !errors.Is(err, producer.ErrQueueFull) {
+			t.Errorf("期望 ErrQueueFull，得到 %v", err)
+		}
+
+		p.SetBackpressure(producer.BackpressureBlock)
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := p.Produce(timeout, kafka.Message{Value: []byte("3")}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("阻塞模式下应等待到ctx超时，得到 %v", err)
+		}
+
+		p.SetBackpressure(producer.BackpressureDropOldest)
+		d3, err := p.SendAsync("k", "3")
+		if err != nil {
+			t.Fatalf("丢弃最早的消息后应入队: %v", err)
+		}
+		if _, err := d1.Wait(ctx); !errors.Is(err, producer.ErrDropped) {
+			t.Errorf("最早的消息应以 ErrDropped 报告，得到 %v", err)
+		}
+
+		p.Connect()
+		p.Flush()
+		for _, d := range []*producer.Delivery{d2, d3} {
+			if _, err := d.Wait(ctx); err != nil {
+				t.Errorf("投递失败: %v", err)
+			}
+		}
+		p.Close()
+		if got := len(broker.Messages("delivery-topic")); got != 2 {
+			t.Errorf("期望2条消息，得到 %d", got)
+		}
+		if _, err := p.SendAsync("k", "4"); !errors.Is(err, producer.ErrProducerClosed) {
+			t.Errorf("关闭后应返回 ErrProducerClosed，得到 %v", err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		cfg, broker := newTestConfig("delivery-topic", "")
+		cfg.Producer.QueueSize = 10
+
+		var reports int64
+		p := producer.NewAsyncProducer(cfg, func(msg kafka.Message, err error) {
+			atomic.AddInt64(&reports, 1)
+		})
+		p.Connect()
+
+		// 关闭时并发入队，被接受的消息都应在 Close 返回前报告且只报告一次
+		var accepted int64
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p.SendMessage(ctx, "k", "v") == nil {
+					atomic.AddInt64(&accepted, 1)
+				}
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		p.Close()
+		wg.Wait()
+
+		if a, r := atomic.LoadInt64(&accepted), atomic.LoadInt64(&reports); a == 0 || a != r {
+			t.Errorf("入队 %d 条，报告 %d 次", a, r)
+		}
+		if n := int64(len(broker.Messages("delivery-topic"))); n != atomic.LoadInt64(&accepted) {
+			t.Errorf("入队 %d 条，写入 %d 条", atomic.LoadInt64(&accepted), n)
+		}
+	})
 }
 
-// IntegrationTest 集成测试
-func IntegrationTest(t *testing.T) {
-	if testing.Short() {
-		t.Skip("跳过集成测试")
+// TestProducerBuilder 测试客户端构建器使用统一的 Producer 接口和装饰器
+func TestProducerBuilder(t *testing.T) {
+	cfg, broker := newTestConfig("builder-topic", "")
+	m := metrics.NewMetrics()
+
+	kc := client.NewClient(cfg)
+	p, err := kc.Producer().
+		WithBatchSize(10).
+		Wrap(func(p producer.Producer) producer.Producer {
+			return metrics.NewInstrumentedProducer(p, m)
+		}).
+		Build()
+	if err != nil {
+		t.Fatalf("构建生产者失败: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := p.Send(ctx, fmt.Sprintf("key-%d", i), map[string]int{"seq": i}); err != nil {
+			t.Errorf("发送消息失败: %v", err)
+		}
 	}
+	p.Close()
 
-	cfg := &config.KafkaConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   "integration-test-topic",
-		GroupID: "integration-test-group",
+	if n := len(broker.Messages("builder-topic")); n != 3 {
+		t.Errorf("期望3条消息，得到 %d", n)
+	}
+	if n := atomic.LoadUint64(&m.MessagesProduced); n != 3 {
+		t.Errorf("期望指标记录3条消息，得到 %d", n)
 	}
 
-	// 生产者
+	// 自定义生产者
+	custom := producer.NewSimpleProducer(cfg)
+	custom.Connect()
+	p, err = kc.Producer().WithProducer(custom).Build()
+	if err != nil {
+		t.Fatalf("构建生产者失败: %v", err)
+	}
+	defer p.Close()
+
+	if p.Producer() != producer.Producer(custom) {
+		t.Error("应该使用自定义生产者")
+	}
+}
+
+// TestManualCommitConsumer 测试手动提交消费者达到阈值后提交偏移量
+func TestManualCommitConsumer(t *testing.T) {
+	cfg, broker := newTestConfig("manual-topic", "manual-group")
+
 	p := producer.NewSimpleProducer(cfg)
-	if err := p.Connect(); err != nil {
-		t.Skip("无法连接Kafka:", err)
+	p.Connect()
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		p.SendMessage(ctx, "manual-key", fmt.Sprintf("value-%d", i))
 	}
 
-	// 发送测试消息
-	ctx := context.Background()
+	c := consumer.NewManualCommitConsumer(cfg, 5)
+	c.Connect()
+
+	received := make(chan kafka.Message, 5)
+	go c.Start(ctx, func(ctx context.Context, msg kafka.Message) error {
+		received <- msg
+		return nil
+	})
+
 	for i := 0; i < 5; i++ {
-		if err := p.SendMessage(ctx, "integration-key", "integration-value"); err != nil {
-			t.Fatalf("发送消息失败: %v", err)
+		select {
+		case <-received:
+		case <-ctx.Done():
+			t.Fatalf("测试超时，只收到 %d 条消息", i)
 		}
 	}
-	p.Close()
 
-	// 消费者
-	received := 0
-	done := make(chan bool)
+	cancel()
+	c.Close()
 
-	c := consumer.NewSimpleConsumer(cfg, -1)
-	if err := c.Connect(); err != nil {
-		t.Fatalf("连接消费者失败: %v", err)
+	if offset := broker.CommittedOffset("manual-group", "manual-topic", 0); offset != 5 {
+		t.Errorf("期望提交偏移量5，得到 %d", offset)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	t.Run("Close", func(t *testing.T) {
+		// 关闭后 Start 提交关闭期间处理完成的消息并返回
+		cfg, broker := newTestConfig("manual-close", "manual-close-group")
+		p := producer.NewSimpleProducer(cfg)
+		p.Connect()
+		defer p.Close()
+		for i := 0; i < 3; i++ {
+			p.SendMessage(context.Background(), "k", fmt.Sprintf("value-%d", i))
+		}
 
-	go func() {
-		c.Start(ctx, func(msg kafka.Message) error {
-			received++
-			t.Logf("收到消息: %s", string(msg.Value))
-			if received >= 5 {
-				done <- true
+		c := consumer.NewManualCommitConsumer(cfg, 100)
+		c.Connect()
+		blocked, release := make(chan struct{}), make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Start(context.Background(), func(ctx context.Context, msg kafka.Message) error {
+				if msg.Offset == 2 {
+					close(blocked)
+					<-release
+				}
+				return nil
+			})
+		}()
+
+		<-blocked
+		c.Close()
+		if offset := broker.CommittedOffset("manual-close-group", "manual-close", 0); offset != 2 {
+			t.Errorf("Close 应提交已处理的消息，偏移量 %d", offset)
+		}
+		close(release)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start 返回错误: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("关闭后 Start 未返回")
+		}
+		if offset := broker.CommittedOffset("manual-close-group", "manual-close", 0); offset != 3 {
+			t.Errorf("Start 返回前应提交关闭期间处理完成的消息，偏移量 %d", offset)
+		}
+	})
+}
+
+// memoryDLQ 记录发送到死信队列的消息
+type memoryDLQ struct {
+	mu   sync.Mutex
+	msgs []kafka.Message
+}
+
+func (d *memoryDLQ) SendToDLQ(ctx context.Context, msg kafka.Message, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.msgs = append(d.msgs, msg)
+	return nil
+}
+
+// TestManualCommitFailurePolicy 测试处理失败时不会提交越过失败消息的偏移量
+func TestManualCommitFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    consumer.FailurePolicy
+		failTimes int   // value-2 失败的次数，-1表示一直失败
+		committed int64 // 期望的提交偏移量
+		wantErr   bool
+	}{
+		{"block", consumer.FailureBlock, 2, 5, false},
+		{"skip", consumer.FailureSkip, -1, 5, false},
+		{"stop", consumer.FailureStop, -1, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, broker := newTestConfig("policy-topic", "policy-group")
+
+			p := producer.NewSimpleProducer(cfg)
+			p.Connect()
+			for i := 0; i < 5; i++ {
+				p.SendMessage(context.Background(), "policy-key", fmt.Sprintf("value-%d", i))
+			}
+			p.Close()
+
+			dlq := &memoryDLQ{}
+			c := consumer.NewManualCommitConsumer(cfg, 100)
+			c.SetFailurePolicy(tt.policy, dlq)
+			c.SetRetryBackoff(10 * time.Millisecond)
+			c.Connect()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var failures int
+			err := c.Start(ctx, func(ctx context.Context, msg kafka.Message) error {
+				if string(msg.Value) == "value-2" && (tt.failTimes < 0 || failures < tt.failTimes) {
+					failures++
+					return fmt.Errorf("处理失败")
+				}
+				if string(msg.Value) == "value-4" {
+					cancel()
+				}
+				return nil
+			})
+			c.Close()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Start 返回错误 %v，期望返回错误: %v", err, tt.wantErr)
+			}
+			if offset := broker.CommittedOffset("policy-group", "policy-topic", 0); offset != tt.committed {
+				t.Errorf("期望提交偏移量%d，得到 %d", tt.committed, offset)
+			}
+			if tt.policy == consumer.FailureSkip && len(dlq.msgs) != 1 {
+				t.Errorf("期望1条死信消息，得到 %d", len(dlq.msgs))
 			}
-			return nil
 		})
-	}()
+	}
+}
+
+// TestConsumerGroupRebalance 测试消费者组在多实例间分配分区
+func TestConsumerGroupRebalance(t *testing.T) {
+	cfg, broker := newTestConfig("group-topic", "group")
+	broker.CreateTopic("group-topic", 4)
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	partitions := make(map[int]bool)
+	done := make(chan struct{})
+
+	manager := consumer.NewConsumerGroupManager(cfg)
+	err := manager.StartConsumers(2, func(ctx context.Context, msg kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[string(msg.Value)]++
+		partitions[msg.Partition] = true
+		if seen[string(msg.Value)] == 1 && len(seen) == 20 {
+			close(done)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("启动消费者失败: %v", err)
+	}
+
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	defer p.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		p.SendMessage(ctx, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+	}
 
 	select {
 	case <-done:
-		t.Logf("成功消费 %d 条消息", received)
-	case <-ctx.Done():
-		t.Fatalf("测试超时，只收到 %d 条消息", received)
+	case <-time.After(5 * time.Second):
+		t.Fatal("测试超时")
 	}
+	manager.StopAll()
 
-	c.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	for v, n := range seen {
+		if n != 1 {
+			t.Errorf("消息 %s 被消费了 %d 次", v, n)
+		}
+	}
+	if len(partitions) != 4 {
+		t.Errorf("期望消费4个分区，得到 %d", len(partitions))
+	}
+
+	// 连接失败后不保留未连接的实例，Stats 和 Lag 不会panic
+	bad, _ := newTestConfig("group-topic", "group")
+	bad.Security.TLS.Enabled = true
+	bad.Security.TLS.CAFile = filepath.Join(t.TempDir(), "missing.pem")
+	failed := consumer.NewConsumerGroupManager(bad)
+	if err := failed.StartConsumers(2, nil); err == nil {
+		t.Fatal("TLS配置错误时应启动失败")
+	}
+	failed.Stats()
+	if lag, err := failed.Lag(ctx); err != nil || lag != 0 {
+		t.Errorf("Lag = %d, %v", lag, err)
+	}
+}
+
+// TestConsumerBuilderModes 测试客户端构建器的各种消费模式
+func TestConsumerBuilderModes(t *testing.T) {
+	cfg, broker := newTestConfig("builder-topic", "")
+	broker.CreateTopic("builder-topic", 2)
+
+	kc := client.NewClient(cfg)
+	p, _ := kc.Producer().Build()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		p.Send(ctx, fmt.Sprintf("key-%d", i), i)
+	}
+	p.Close()
+
+	// consume 使用构建器创建消费者，消费 n 条消息后返回收到的分区
+	consume := func(b *client.ConsumerBuilder, n int) map[int]int {
+		c, err := b.Use(middleware.Recovery()).Build()
+		if err != nil {
+			t.Fatalf("构建消费者失败: %v", err)
+		}
+		defer c.Close()
+
+		var mu sync.Mutex
+		partitions := make(map[int]int)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var count int
+		c.Handle(func(msg kafka.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			partitions[msg.Partition]++
+			if count++; count == n {
+				cancel()
+			}
+			return nil
+		})
+		c.Start(ctx)
+
+		if ctx.Err() != context.Canceled {
+			t.Fatalf("测试超时，只收到 %d 条消息", count)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		return partitions
+	}
+
+	if partitions := consume(kc.Consumer("group-a").Group(2), 10); len(partitions) != 2 {
+		t.Errorf("消费者组模式应消费2个分区，得到 %v", partitions)
+	}
+
+	var expected int
+	for _, msg := range broker.Messages("builder-topic") {
+		if msg.Partition == 1 {
+			expected++
+		}
+	}
+	partitions := consume(kc.Consumer("").Partition(1), expected)
+	if len(partitions) != 1 || partitions[1] != expected {
+		t.Errorf("指定分区模式只应消费分区1，得到 %v", partitions)
+	}
+
+	consume(kc.Consumer("group-b").ManualCommit(), 10)
+	committed := broker.CommittedOffset("group-b", "builder-topic", 0) + broker.CommittedOffset("group-b", "builder-topic", 1)
+	if committed != 10 {
+		t.Errorf("手动提交模式应提交全部10条消息，得到 %d", committed)
+	}
+}
+
+// TestTypedProducerConsumer 测试强类型生产和消费，反序列化失败的消息进入死信队列
+func TestTypedProducerConsumer(t *testing.T) {
+	type payment struct {
+		ID     string  `json:"id"`
+		Amount float64 `json:"amount"`
+	}
+
+	cfg, _ := newTestConfig("typed-topic", "typed-group")
+	kc := client.NewClient(cfg)
+	pw, _ := kc.Producer().Build()
+	ctx := context.Background()
+
+	tp := client.NewTypedProducer[payment](pw)
+	tp.SendWithHeaders(ctx, "p-1", payment{ID: "p-1", Amount: 10.5}, map[string]string{"tenant": "a"})
+	pw.Producer().SendMessage(ctx, "bad", "not-json")
+	tp.Send(ctx, "p-2", payment{ID: "p-2", Amount: 20})
+	tp.Close()
+
+	c := consumer.NewSimpleConsumer(cfg, -1)
+	c.Connect()
+	dlq := &memoryDLQ{}
+	tc := consumer.NewTypedConsumer[payment](c, &serializer.JSONSerializer{}, consumer.WithDecodeDLQ(dlq))
+	defer tc.Close()
+
+	var received []payment
+	var metas []consumer.Metadata
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tc.Start(ctx, func(ctx context.Context, value payment, meta consumer.Metadata) error {
+		received = append(received, value)
+		metas = append(metas, meta)
+		if len(received) == 2 {
+			cancel()
+		}
+		return nil
+	})
+
+	if len(received) != 2 || received[0].ID != "p-1" || received[1].Amount != 20 {
+		t.Fatalf("强类型消息解码错误: %+v", received)
+	}
+	if metas[0].Key != "p-1" || metas[0].Headers["tenant"] != "a" || metas[1].Offset != 2 {
+		t.Errorf("元数据错误: %+v", metas)
+	}
+	if len(dlq.msgs) != 1 || string(dlq.msgs[0].Key) != "bad" {
+		t.Errorf("反序列化失败的消息应进入死信队列，得到 %d 条", len(dlq.msgs))
+	}
+}
+
+// TestCompressionEncryption 测试压缩和信封加密包装器，消费端按消息头自动解码，密钥轮换后仍能解密历史消息
+func TestCompressionEncryption(t *testing.T) {
+	type order struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+		Notes string `json:"notes"`
+	}
+
+	keyring := serializer.NewKeyring()
+	oldKeyID, _ := keyring.Rotate()
+
+	compressed, err := serializer.NewCompressionSerializer(&serializer.JSONSerializer{}, kafka.Zstd, serializer.WithCompressionThreshold(64))
+	if err != nil {
+		t.Fatalf("创建压缩序列化器失败: %v", err)
+	}
+	s := serializer.NewEncryptionSerializer(compressed, keyring)
+
+	cfg, broker := newTestConfig("secure-orders", "secure-group")
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	tp := producer.NewTypedProducer[order](p, s)
+	ctx := context.Background()
+
+	large := order{ID: "o-1", Email: "a@example.com", Notes: strings.Repeat("fragile ", 100)}
+	if err := tp.SendWithHeaders(ctx, "o-1", large, map[string]string{"tenant": "a"}); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	newKeyID, _ := keyring.Rotate()
+	tp.Send(ctx, "o-2", order{ID: "o-2", Email: "b@example.com"})
+	tp.Close()
+
+	msgs := broker.Messages("secure-orders")
+	if len(msgs) != 2 {
+		t.Fatalf("期望2条消息，得到 %d", len(msgs))
+	}
+	h0, h1 := serializer.HeadersOf(msgs[0]), serializer.HeadersOf(msgs[1])
+	if h0[serializer.HeaderEncryptionKeyID] != oldKeyID || h1[serializer.HeaderEncryptionKeyID] != newKeyID {
+		t.Errorf("密钥ID消息头错误: %s %s", h0[serializer.HeaderEncryptionKeyID], h1[serializer.HeaderEncryptionKeyID])
+	}
+	if h0[serializer.HeaderContentEncoding] != "zstd" || h1[serializer.HeaderContentEncoding] != "" {
+		t.Errorf("只有超过阈值的消息应被压缩: %q %q", h0[serializer.HeaderContentEncoding], h1[serializer.HeaderContentEncoding])
+	}
+	if h0["tenant"] != "a" {
+		t.Error("业务消息头丢失")
+	}
+	if strings.Contains(string(msgs[1].Value), "b@example.com") {
+		t.Error("消息内容未加密")
+	}
+
+	// 消费端只需要能访问新旧密钥
+	c := consumer.NewSimpleConsumer(cfg, -1)
+	c.Connect()
+	tc := consumer.NewTypedConsumer[order](c, s)
+	defer tc.Close()
+
+	var received []order
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	tc.Start(ctx, func(ctx context.Context, value order, meta consumer.Metadata) error {
+		received = append(received, value)
+		if len(received) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if len(received) != 2 || received[0] != large || received[1].Email != "b@example.com" {
+		t.Fatalf("解密后的消息错误: %+v", received)
+	}
+
+	// 删除旧密钥后历史消息无法解密；篡改密钥ID消息头时解密失败
+	keyring.Remove(oldKeyID)
+	var out order
+	if err := serializer.DeserializeWithHeaders(s, msgs[0].Value, h0, &out); !errors.Is(err, serializer.ErrKeyNotFound) {
+		t.Errorf("期望 ErrKeyNotFound，得到 %v", err)
+	}
+	other, _ := serializer.NewStaticKeyProvider(oldKeyID, make([]byte, 32))
+	if err := serializer.NewEncryptionSerializer(compressed, other).DeserializeWithHeaders(msgs[0].Value, h0, &out); err == nil {
+		t.Error("使用错误的密钥解密应失败")
+	}
+
+	// 没有消息头时不能序列化，未加密的消息可以直接读取
+	if _, err := s.Serialize(large); !errors.Is(err, serializer.ErrHeadersRequired) {
+		t.Errorf("期望 ErrHeadersRequired，得到 %v", err)
+	}
+	if err := s.Deserialize([]byte(`{"id":"plain"}`), &out); err != nil || out.ID != "plain" {
+		t.Errorf("读取未加密消息失败: %+v %v", out, err)
+	}
+
+	// 密钥文件
+	keyPath := filepath.Join(t.TempDir(), "order.key")
+	os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0o600)
+	fileKeys, err := serializer.NewKeyFileProvider(keyPath)
+	if err != nil {
+		t.Fatalf("读取密钥文件失败: %v", err)
+	}
+	if id, _, _ := fileKeys.CurrentKey(); id != serializer.KeyFingerprint(make([]byte, 32)) {
+		t.Errorf("密钥文件的ID应为密钥指纹: %s", id)
+	}
+}
+
+// TestCloudEvents 测试CloudEvents二进制模式和结构化模式的编解码
+func TestCloudEvents(t *testing.T) {
+	type orderCreated struct {
+		OrderID string  `json:"order_id"`
+		Amount  float64 `json:"amount"`
+	}
+
+	created := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	msg := serializer.NewCloudEvent("/orders", "com.example.order.created", orderCreated{OrderID: "o-1", Amount: 99.5})
+	msg.Event.ID = "evt-1"
+	msg.Event.Subject = "o-1"
+	msg.Event.Time = created
+	msg.Event.Extensions = map[string]string{serializer.PartitionKeyExtension: "user-7"}
+	msg.Headers = map[string]string{"tenant": "a"}
+
+	s := &serializer.JSONSerializer{}
+
+	// 二进制模式：属性在 ce_ 消息头中
+	binary, err := serializer.EncodeMessage(msg, s)
+	if err != nil {
+		t.Fatalf("二进制模式编码失败: %v", err)
+	}
+	headers := serializer.HeadersOf(binary)
+	if headers["ce_specversion"] != "1.0" || headers["ce_id"] != "evt-1" || headers["ce_type"] != "com.example.order.created" ||
+		headers["content-type"] != "application/json" || headers["tenant"] != "a" {
+		t.Errorf("二进制模式消息头错误: %v", headers)
+	}
+	if string(binary.Key) != "user-7" {
+		t.Errorf("partitionkey 应作为消息Key: %s", binary.Key)
+	}
+	if string(binary.Value) != `{"order_id":"o-1","amount":99.5}` {
+		t.Errorf("二进制模式消息体应只有data: %s", binary.Value)
+	}
+
+	// 结构化模式：属性和data都在JSON信封中
+	msg.Event.Mode = serializer.StructuredMode
+	structured, err := serializer.EncodeMessage(msg, s)
+	if err != nil {
+		t.Fatalf("结构化模式编码失败: %v", err)
+	}
+	var envelope map[string]interface{}
+	json.Unmarshal(structured.Value, &envelope)
+	if envelope["specversion"] != "1.0" || envelope["source"] != "/orders" || envelope["time"] != "2024-05-01T08:30:00Z" {
+		t.Errorf("结构化模式信封错误: %s", structured.Value)
+	}
+	if data, ok := envelope["data"].(map[string]interface{}); !ok || data["order_id"] != "o-1" {
+		t.Errorf("JSON数据应直接嵌入信封: %s", structured.Value)
+	}
+
+	for _, kafkaMsg := range []kafka.Message{binary, structured} {
+		var out orderCreated
+		decoded, err := serializer.DecodeMessage(kafkaMsg, s, &out)
+		if err != nil {
+			t.Fatalf("解码失败: %v", err)
+		}
+		if !decoded.IsCloudEvent() || decoded.ID() != "evt-1" || decoded.Source() != "/orders" ||
+			decoded.Type() != "com.example.order.created" || decoded.Subject() != "o-1" || !decoded.Time().Equal(created) {
+			t.Errorf("CloudEvents属性错误: %+v", decoded.Event)
+		}
+		if out.OrderID != "o-1" || out.Amount != 99.5 {
+			t.Errorf("data解码错误: %+v", out)
+		}
+		if decoded.Headers["tenant"] != "a" || decoded.Headers["ce_id"] != "" {
+			t.Errorf("消息头应只保留业务消息头: %v", decoded.Headers)
+		}
+	}
+
+	// 加密后的data在结构化模式中使用 data_base64
+	keys, _ := serializer.NewStaticKeyProvider("k1", make([]byte, 32))
+	encrypted := serializer.NewEncryptionSerializer(s, keys)
+	msg.Event.DataContentType = "application/json"
+	secure, err := serializer.EncodeMessage(msg, encrypted)
+	if err != nil {
+		t.Fatalf("加密编码失败: %v", err)
+	}
+	if !strings.Contains(string(secure.Value), `"data_base64"`) {
+		t.Errorf("加密的data应使用data_base64: %s", secure.Value)
+	}
+	var out orderCreated
+	if _, err := serializer.DecodeMessage(secure, encrypted, &out); err != nil || out.OrderID != "o-1" {
+		t.Errorf("解密结构化事件失败: %+v %v", out, err)
+	}
+
+	// 其他实现手写的结构化事件：文本数据按JSON格式规范写为JSON字符串
+	handWritten := func(contentType, data string) kafka.Message {
+		return kafka.Message{
+			Value: []byte(`{"specversion":"1.0","id":"e-9","source":"/billing","type":"invoice.sent",` +
+				`"datacontenttype":"` + contentType + `","data":` + data + `}`),
+			Headers: []kafka.Header{{Key: serializer.HeaderContentType, Value: []byte("application/cloudevents+json")}},
+		}
+	}
+	var text string
+	if decoded, err := serializer.DecodeMessage(handWritten("text/plain", `"hello"`), &serializer.StringSerializer{}, &text); err != nil || text != "hello" || decoded.ID() != "e-9" {
+		t.Errorf("text/plain 的JSON字符串数据应去掉引号: %q %v", text, err)
+	}
+	var quoted string
+	if _, err := serializer.DecodeMessage(handWritten("application/json", `"hello"`), s, &quoted); err != nil || quoted != "hello" {
+		t.Errorf("application/json 的数据按JSON解码: %q %v", quoted, err)
+	}
+	var object map[string]interface{}
+	if _, err := serializer.DecodeMessage(handWritten("application/json", `{"n":1}`), s, &object); err != nil || object["n"] != float64(1) {
+		t.Errorf("JSON对象数据解码错误: %v %v", object, err)
+	}
+
+	// 缺少必需属性
+	invalid := serializer.Message{Data: "x", Event: &serializer.CloudEvent{Source: "/orders"}}
+	if _, err := serializer.EncodeMessage(invalid, s); !errors.Is(err, serializer.ErrInvalidCloudEvent) {
+		t.Errorf("期望 ErrInvalidCloudEvent，得到 %v", err)
+	}
+
+	// 普通消息不受影响
+	plain, _ := serializer.EncodeMessage(serializer.Message{Key: "k", Data: "x"}, s)
+	decoded, err := serializer.DecodeMessage(plain, s, new(string))
+	if err != nil || decoded.IsCloudEvent() || decoded.ID() != "" {
+		t.Errorf("普通消息不应解析为CloudEvents: %+v %v", decoded, err)
+	}
+}
+
+// TestBinarySerializers 测试Avro、Protobuf、MessagePack序列化和按schema ID解码
+func TestBinarySerializers(t *testing.T) {
+	type payment struct {
+		ID     string  `json:"id"`
+		Amount float64 `json:"amount"`
+	}
+
+	registry := serializer.NewSchemaRegistry()
+	registry.Register("payments-avro", serializer.Schema{Type: serializer.SchemaTypeAvro, Data: []byte(`{
+		"type": "record", "name": "Payment",
+		"fields": [{"name": "id", "type": "string"}, {"name": "amount", "type": "double"}]
+	}`)})
+	registry.Register("payments-proto", serializer.Schema{Type: serializer.SchemaTypeProtobuf})
+	registry.Register("payments-msgpack", serializer.Schema{Type: serializer.SchemaTypeMsgPack})
+
+	avro, err := serializer.NewAvroSerializer(registry, "payments-avro")
+	if err != nil {
+		t.Fatalf("创建Avro序列化器失败: %v", err)
+	}
+	proto, _ := serializer.NewProtobufSerializer(registry, "payments-proto")
+	msgpack, _ := serializer.NewMsgPackSerializer(registry, "payments-msgpack")
+
+	in := payment{ID: "p-1", Amount: 10.5}
+	avroData, err := avro.Serialize(in)
+	if err != nil {
+		t.Fatalf("Avro序列化失败: %v", err)
+	}
+	protoData, err := proto.Serialize(wrapperspb.String("p-1"))
+	if err != nil {
+		t.Fatalf("Protobuf序列化失败: %v", err)
+	}
+	msgpackData, err := msgpack.Serialize(in)
+	if err != nil {
+		t.Fatalf("MessagePack序列化失败: %v", err)
+	}
+
+	// 头部为 magic byte + 4字节schema ID
+	for i, data := range [][]byte{avroData, protoData, msgpackData} {
+		id, _, err := serializer.DecodeFrame(data)
+		if err != nil || id != i+1 {
+			t.Errorf("消息头部错误: id=%d err=%v", id, err)
+		}
+	}
+
+	var out payment
+	if err := avro.Deserialize(avroData, &out); err != nil || out != in {
+		t.Errorf("Avro往返失败: %+v %v", out, err)
+	}
+	out = payment{}
+	if err := msgpack.Deserialize(msgpackData, &out); err != nil || out != in {
+		t.Errorf("MessagePack往返失败: %+v %v", out, err)
+	}
+	if err := msgpack.Deserialize(avroData, &out); err == nil {
+		t.Error("schema类型不匹配时应返回错误")
+	}
+
+	// 按消息中的schema ID选择解码器
+	framed := serializer.NewFramedSerializer(registry, nil)
+	out = payment{}
+	if err := framed.Deserialize(avroData, &out); err != nil || out != in {
+		t.Errorf("按ID解码Avro失败: %+v %v", out, err)
+	}
+	out = payment{}
+	if err := framed.Deserialize(msgpackData, &out); err != nil || out != in {
+		t.Errorf("按ID解码MessagePack失败: %+v %v", out, err)
+	}
+	var s wrapperspb.StringValue
+	if err := framed.Deserialize(protoData, &s); err != nil || s.GetValue() != "p-1" {
+		t.Errorf("按ID解码Protobuf失败: %q %v", s.GetValue(), err)
+	}
+	if err := framed.Deserialize([]byte(`{"id":"p-1"}`), &out); !errors.Is(err, serializer.ErrInvalidFrame) {
+		t.Errorf("非二进制格式应返回 ErrInvalidFrame，得到 %v", err)
+	}
+}
+
+// TestSchemaRegistry 测试注册中心的版本管理、兼容性检查、文件持久化和REST API
+func TestSchemaRegistry(t *testing.T) {
+	v1 := serializer.Schema{Type: serializer.SchemaTypeAvro, Data: []byte(`{
+		"type": "record", "name": "Payment",
+		"fields": [{"name": "id", "type": "string"}]
+	}`)}
+	// 新增带默认值的字段：向后兼容
+	v2 := serializer.Schema{Type: serializer.SchemaTypeAvro, Data: []byte(`{
+		"type": "record", "name": "Payment",
+		"fields": [{"name": "id", "type": "string"}, {"name": "amount", "type": "double", "default": 0}]
+	}`)}
+	// 新增没有默认值的字段：新版本不能读取旧数据
+	v3 := serializer.Schema{Type: serializer.SchemaTypeAvro, Data: []byte(`{
+		"type": "record", "name": "Payment",
+		"fields": [{"name": "id", "type": "string"}, {"name": "currency", "type": "string"}]
+	}`)}
+
+	path := filepath.Join(t.TempDir(), "registry.json")
+	registry, err := serializer.OpenSchemaRegistry(path)
+	if err != nil {
+		t.Fatalf("打开注册中心失败: %v", err)
+	}
+
+	id1, err := registry.Register("payments-value", v1)
+	if err != nil {
+		t.Fatalf("注册v1失败: %v", err)
+	}
+	if id, _ := registry.Register("payments-value", v1); id != id1 {
+		t.Errorf("重复注册应返回相同ID: %d != %d", id, id1)
+	}
+	id2, err := registry.Register("payments-value", v2)
+	if err != nil || id2 == id1 {
+		t.Fatalf("注册v2失败: id=%d err=%v", id2, err)
+	}
+	if _, err := registry.Register("payments-value", v3); !errors.Is(err, serializer.ErrIncompatibleSchema) {
+		t.Errorf("不兼容的schema应被拒绝，得到 %v", err)
+	}
+	if id, _ := registry.Register("refunds-value", v1); id != id1 {
+		t.Errorf("相同schema在不同subject下应共享ID: %d != %d", id, id1)
+	}
+
+	if versions, _ := registry.Versions("payments-value"); len(versions) != 2 {
+		t.Errorf("期望2个版本，得到 %v", versions)
+	}
+	if s, _ := registry.GetVersion("payments-value", 1); s.ID != id1 {
+		t.Errorf("版本1的ID错误: %d", s.ID)
+	}
+
+	// 关闭兼容性检查后可以注册
+	registry.SetCompatibility("payments-value", serializer.CompatibilityNone)
+	if _, err := registry.Register("payments-value", v3); err != nil {
+		t.Errorf("NONE模式下注册失败: %v", err)
+	}
+
+	// 重新打开后数据仍在，新ID不与已有ID冲突
+	reopened, err := serializer.OpenSchemaRegistry(path)
+	if err != nil {
+		t.Fatalf("重新打开注册中心失败: %v", err)
+	}
+	if latest, _ := reopened.Get("payments-value"); latest.Version != 3 {
+		t.Errorf("重新打开后最新版本应为3，得到 %d", latest.Version)
+	}
+	if reopened.Compatibility("payments-value") != serializer.CompatibilityNone {
+		t.Error("subject的兼容性模式未持久化")
+	}
+
+	// 写入文件失败时回滚，不跳过ID
+	dir := filepath.Join(t.TempDir(), "registry")
+	os.Mkdir(dir, 0o755)
+	failing, _ := serializer.OpenSchemaRegistry(filepath.Join(dir, "registry.json"))
+	first, _ := failing.Register("a-value", v1)
+	os.RemoveAll(dir)
+	if _, err := failing.Register("b-value", serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{"type": "object"}`)}); err == nil {
+		t.Fatal("目录不存在时注册应失败")
+	}
+	os.Mkdir(dir, 0o755)
+	if id, err := failing.Register("b-value", serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{"type": "object"}`)}); err != nil || id != first+1 {
+		t.Errorf("回滚后新ID = %d，期望 %d: %v", id, first+1, err)
+	}
+
+	// JSON Schema：新增必填属性不向后兼容，新增可选属性兼容
+	orderV1 := serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`)}
+	reopened.Register("orders-value", orderV1)
+	optional := serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id"]}`)}
+	if err := reopened.CheckCompatibility("orders-value", optional); err != nil {
+		t.Errorf("新增可选属性应兼容: %v", err)
+	}
+	required := serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{"type": "object", "properties": {"id": {"type": "string"}, "note": {"type": "string"}}, "required": ["id", "note"]}`)}
+	if err := reopened.CheckCompatibility("orders-value", required); !errors.Is(err, serializer.ErrIncompatibleSchema) {
+		t.Errorf("新增必填属性应不兼容，得到 %v", err)
+	}
+
+	// 通过REST API访问，客户端与本地注册中心可以互换
+	server := httptest.NewServer(serializer.NewRegistryHandler(reopened))
+	defer server.Close()
+	var remote serializer.Registry = serializer.NewRegistryClient(server.URL)
+
+	s, err := remote.Get("payments-value")
+	if err != nil || s.Version != 3 || s.Type != serializer.SchemaTypeAvro {
+		t.Errorf("通过REST获取最新版本失败: %+v %v", s, err)
+	}
+	if s, err := remote.GetByID(id2); err != nil || s.ID != id2 {
+		t.Errorf("通过REST按ID获取失败: %+v %v", s, err)
+	}
+	if _, err := remote.Get("missing"); !errors.Is(err, serializer.ErrSubjectNotFound) {
+		t.Errorf("期望 ErrSubjectNotFound，得到 %v", err)
+	}
+	if err := remote.CheckCompatibility("refunds-value", v3); !errors.Is(err, serializer.ErrIncompatibleSchema) {
+		t.Errorf("通过REST检查兼容性应返回不兼容，得到 %v", err)
+	}
+	if _, err := remote.Register("refunds-value", v3); !errors.Is(err, serializer.ErrIncompatibleSchema) {
+		t.Errorf("通过REST注册不兼容schema应失败，得到 %v", err)
+	}
+
+	avro, err := serializer.NewAvroSerializer(remote, "refunds-value")
+	if err != nil {
+		t.Fatalf("使用REST客户端创建Avro序列化器失败: %v", err)
+	}
+	data, _ := avro.Serialize(map[string]interface{}{"id": "r-1"})
+	if id, _, _ := serializer.DecodeFrame(data); id != id1 {
+		t.Errorf("消息头部的schema ID错误: %d", id)
+	}
+}
+
+// TestPayloadValidation 测试按JSON Schema校验生产和消费的消息
+func TestPayloadValidation(t *testing.T) {
+	registry := serializer.NewSchemaRegistry()
+	registry.Register("orders-value", serializer.Schema{Type: serializer.SchemaTypeJSON, Data: []byte(`{
+		"type": "object",
+		"required": ["order_id", "data"],
+		"properties": {
+			"order_id": {"type": "string", "minLength": 1},
+			"data": {
+				"type": "object",
+				"properties": {
+					"items": {"type": "array", "items": {"$ref": "#/definitions/item"}}
+				}
+			}
+		},
+		"definitions": {
+			"item": {
+				"type": "object",
+				"required": ["price"],
+				"properties": {"price": {"type": "number", "exclusiveMinimum": 0}, "quantity": {"type": "integer"}}
+			}
+		}
+	}`)})
+	validator := serializer.NewValidator(registry)
+
+	valid := `{"order_id": "o-1", "data": {"items": [{"price": 1}, {"price": 2.5, "quantity": 2}]}}`
+	invalid := `{"order_id": "o-2", "data": {"items": [{"price": 1}, {"price": 2}, {"price": -3, "quantity": 1.5}]}}`
+
+	if err := validator.Validate("orders-value", []byte(valid)); err != nil {
+		t.Errorf("合法的消息校验失败: %v", err)
+	}
+	err := validator.Validate("orders-value", []byte(invalid))
+	var verr *serializer.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("期望 *ValidationError，得到 %v", err)
+	}
+	if len(verr.Violations) != 2 || verr.Violations[0].Path != "data.items[2].price" || verr.Violations[1].Path != "data.items[2].quantity" {
+		t.Errorf("错误路径不正确: %v", verr.Violations)
+	}
+	if err := validator.Validate("orders-value", []byte(`{"data": {}}`)); !errors.As(err, &verr) || verr.Violations[0].Path != "order_id" {
+		t.Errorf("缺少必填属性应返回属性路径，得到 %v", err)
+	}
+	if err := validator.Validate("missing-value", []byte(valid)); !errors.Is(err, serializer.ErrSubjectNotFound) {
+		t.Errorf("subject不存在时应返回 ErrSubjectNotFound，得到 %v", err)
+	}
+
+	// 生产者：无效消息被拒绝，不会发送
+	cfg, broker := newTestConfig("orders", "validation-group")
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	vp := producer.NewValidatingProducer(p, validator, "orders-value")
+	defer vp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := vp.SendMessage(ctx, "o-1", valid); err != nil {
+		t.Errorf("发送合法消息失败: %v", err)
+	}
+	if err := vp.SendMessageWithHeaders(ctx, "o-2", invalid, nil); !errors.As(err, &verr) {
+		t.Errorf("无效消息应被拒绝，得到 %v", err)
+	}
+	if msgs := broker.Messages("orders"); len(msgs) != 1 {
+		t.Fatalf("期望只发送1条消息，得到 %d", len(msgs))
+	}
+
+	// 消费者：无效消息进入死信队列，不交给处理函数
+	p.SendMessage(ctx, "o-2", invalid)
+
+	dlq := &memoryDLQ{}
+	var handled []string
+	handler := middleware.ValidatePayload(validator, middleware.TopicSubject(), dlq)(
+		func(ctx context.Context, msg kafka.Message) error {
+			handled = append(handled, string(msg.Key))
+			return nil
+		})
+	for _, msg := range broker.Messages("orders") {
+		if err := handler(ctx, msg); err != nil {
+			t.Errorf("无效消息发送到死信队列后应跳过: %v", err)
+		}
+	}
+	if len(handled) != 1 || handled[0] != "o-1" {
+		t.Errorf("只有合法消息应交给处理函数: %v", handled)
+	}
+	if len(dlq.msgs) != 1 || string(dlq.msgs[0].Key) != "o-2" {
+		t.Errorf("无效消息应进入死信队列: %d", len(dlq.msgs))
+	}
+
+	// 未设置死信队列时返回校验错误
+	handler = middleware.ValidatePayload(validator, middleware.FixedSubject("orders-value"), nil)(
+		func(ctx context.Context, msg kafka.Message) error { return nil })
+	if err := handler(ctx, kafka.Message{Value: []byte(invalid)}); !errors.As(err, &verr) {
+		t.Errorf("期望校验错误，得到 %v", err)
+	}
+
+	// 压缩和加密的Topic：ValidatingSerializer 在压缩、加密之前校验明文
+	keys, _ := serializer.NewStaticKeyProvider("k1", make([]byte, 32))
+	compressed, _ := serializer.NewCompressionSerializer(
+		serializer.NewValidatingSerializer(&serializer.StringSerializer{}, validator, "orders-value"), kafka.Gzip)
+	secure := serializer.NewEncryptionSerializer(compressed, keys)
+	value, headers, err := serializer.SerializeWithHeaders(secure, valid, nil)
+	if err != nil {
+		t.Fatalf("合法的消息序列化失败: %v", err)
+	}
+	if _, _, err := serializer.SerializeWithHeaders(secure, invalid, nil); !errors.As(err, &verr) {
+		t.Errorf("序列化时应校验明文，得到 %v", err)
+	}
+	var decoded string
+	if err := serializer.DeserializeWithHeaders(secure, value, headers, &decoded); err != nil || decoded != valid {
+		t.Errorf("解密解压后校验失败: %v", err)
+	}
+
+	// 直接校验消息值的中间件和生产者遇到压缩或加密的payload时返回配置错误，不进入死信队列
+	encoded := kafka.Message{Value: value}
+	for k, v := range headers {
+		encoded.Headers = append(encoded.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	dlqCount := len(dlq.msgs)
+	handler = middleware.ValidatePayload(validator, middleware.FixedSubject("orders-value"), dlq)(
+		func(ctx context.Context, msg kafka.Message) error { return nil })
+	if err := handler(ctx, encoded); !errors.Is(err, serializer.ErrEncodedPayload) || len(dlq.msgs) != dlqCount {
+		t.Errorf("加密的消息应返回 ErrEncodedPayload，得到 %v", err)
+	}
+	if err := vp.SendMessageWithHeaders(ctx, "k", string(value), headers); !errors.Is(err, serializer.ErrEncodedPayload) {
+		t.Errorf("加密的消息应返回 ErrEncodedPayload，得到 %v", err)
+	}
+
+	// 直接调用包装器时消息头不能为nil
+	if _, err := compressed.SerializeWithHeaders(valid, nil); !errors.Is(err, serializer.ErrHeadersRequired) {
+		t.Errorf("期望 ErrHeadersRequired，得到 %v", err)
+	}
+	if _, err := secure.SerializeWithHeaders(valid, nil); !errors.Is(err, serializer.ErrHeadersRequired) {
+		t.Errorf("期望 ErrHeadersRequired，得到 %v", err)
+	}
+}
+
+// recordingTransport 记录生产者和消费者创建读写器时使用的配置
+type recordingTransport struct {
+	transport.Transport
+	mu      sync.Mutex
+	writers []*kafka.Writer
+	readers []kafka.ReaderConfig
+}
+
+func (r *recordingTransport) NewWriter(w *kafka.Writer) transport.Writer {
+	r.mu.Lock()
+	r.writers = append(r.writers, w)
+	r.mu.Unlock()
+	return r.Transport.NewWriter(w)
+}
+
+func (r *recordingTransport) NewReader(cfg kafka.ReaderConfig) transport.Reader {
+	r.mu.Lock()
+	r.readers = append(r.readers, cfg)
+	r.mu.Unlock()
+	return r.Transport.NewReader(cfg)
+}
+
+// TestConfigLoading 测试配置文件、profile、环境变量覆盖、校验以及各组件使用配置
+func TestConfigLoading(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	yamlPath := write("kafka.yaml", `
+brokers: [localhost:9092]
+topic: orders
+client_id: order-service
+producer:
+  acks: one
+  compression: snappy
+  batch_timeout: 20ms
+consumer:
+  start_offset: latest
+  session_timeout: 45s
+  balancers: [round_robin]
+profiles:
+  prod:
+    brokers: [kafka-1:9092, kafka-2:9092]
+    producer:
+      acks: all
+`)
+	cfg, err := config.Load(yamlPath, "prod")
+	if err != nil {
+		t.Fatalf("加载YAML失败: %v", err)
+	}
+	if len(cfg.Brokers) != 2 || cfg.Brokers[1] != "kafka-2:9092" || cfg.Topic != "orders" || cfg.GroupID != "test-group" {
+		t.Errorf("profile应覆盖brokers，其余保留文件和默认值: %+v", cfg)
+	}
+	if cfg.Producer.Acks != "all" || cfg.Producer.Compression != "snappy" || cfg.Producer.BatchTimeout.Std() != 20*time.Millisecond {
+		t.Errorf("profile应只覆盖 producer.acks: %+v", cfg.Producer)
+	}
+
+	// 三种格式解析结果相同，环境变量优先于文件
+	jsonPath := write("kafka.json", `{"brokers": ["localhost:9092"], "topic": "orders", "client_id": "order-service",
+		"producer": {"acks": "one", "compression": "snappy", "batch_timeout": "20ms"},
+		"consumer": {"start_offset": "latest", "session_timeout": "45s", "balancers": ["round_robin"]}}`)
+	tomlPath := write("kafka.toml", `
+brokers = ["localhost:9092"]
+topic = "orders"
+client_id = "order-service"
+
+[producer]
+acks = "one"
+compression = "snappy"
+batch_timeout = "20ms"
+
+[consumer]
+start_offset = "latest"
+session_timeout = "45s"
+balancers = ["round_robin"]
+`)
+	t.Setenv("KAFKA_PRODUCER_BATCH_SIZE", "64")
+	t.Setenv("KAFKA_BROKERS", "kafka-env:9092")
+	var loaded []*config.KafkaConfig
+	for _, path := range []string{yamlPath, jsonPath, tomlPath} {
+		c, err := config.Load(path, "")
+		if err != nil {
+			t.Fatalf("加载 %s 失败: %v", filepath.Base(path), err)
+		}
+		loaded = append(loaded, c)
+	}
+	for i, c := range loaded {
+		if c.Brokers[0] != "kafka-env:9092" || c.Producer.BatchSize != 64 || c.Producer.Acks != "one" ||
+			c.Consumer.SessionTimeout.Std() != 45*time.Second || c.Consumer.Balancers[0] != "round_robin" {
+			t.Errorf("第%d个文件加载结果错误: %+v", i, c)
+		}
+	}
+
+	// 错误信息指出具体的配置项
+	cases := []struct {
+		name, content, profile, want string
+	}{
+		{"unknown.yaml", "producer:\n  batch_sise: 10\n", "", "producer.batch_sise: 未知的配置项"},
+		{"type.yaml", "producer:\n  batch_size: many\n", "", "producer.batch_size: 应为整数"},
+		{"duration.json", `{"consumer": {"max_wait": "soon"}}`, "", "consumer.max_wait: 无效的时长"},
+		{"profile.yaml", "profiles:\n  dev:\n    consumer: {start_offst: latest}\n", "", "profiles.dev.consumer.start_offst: 未知的配置项"},
+		{"missing.yaml", "topic: a\n", "staging", "没有profile staging"},
+		{"acks.yaml", "producer:\n  acks: some\n", "", "producer.acks: 无效的值"},
+		{"broker.yaml", "brokers: [localhost:9092, kafka-2]\n", "", "brokers[1]: 无效的地址"},
+		{"sasl.toml", "[security.sasl]\nmechanism = \"SCRAM-SHA-256\"\nusername = \"app\"\n", "", "security.sasl.password: 使用SASL时不能为空"},
+		{"config.ini", "topic=a", "", "不支持的配置文件格式"},
+	}
+	t.Setenv("KAFKA_BROKERS", "")
+	for _, tc := range cases {
+		_, err := config.Load(write(tc.name, tc.content), tc.profile)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: 期望错误包含 %q，得到 %v", tc.name, tc.want, err)
+		}
+	}
+
+	t.Setenv("KAFKA_CONSUMER_MAX_WAIT", "later")
+	if _, err := config.Load("", ""); err == nil || !strings.Contains(err.Error(), "KAFKA_CONSUMER_MAX_WAIT") {
+		t.Errorf("无效的环境变量应报错: %v", err)
+	}
+	t.Setenv("KAFKA_CONSUMER_MAX_WAIT", "")
+
+	invalid := config.DefaultConfig()
+	invalid.Producer.BatchSize = -1
+	invalid.Consumer.HeartbeatInterval = config.Duration(30 * time.Second)
+	invalid.Consumer.SessionTimeout = config.Duration(10 * time.Second)
+	var verr *config.ValidationError
+	if err := invalid.Validate(); !errors.As(err, &verr) || len(verr.Errors) != 2 ||
+		verr.Errors[0].Field != "producer.batch_size" || verr.Errors[1].Field != "consumer.heartbeat_interval" {
+		t.Errorf("校验应返回全部错误: %v", err)
+	}
+
+	// 各组件使用配置覆盖默认值，选项优先于配置
+	base, _ := newTestConfig("config-topic", "config-group")
+	rec := &recordingTransport{Transport: base.Transport}
+	cfg.Brokers = base.Brokers
+	cfg.GroupID = "config-group"
+	cfg.Transport = rec
+
+	sp := producer.NewSimpleProducer(cfg)
+	sp.Connect()
+	defer sp.Close()
+	bp := producer.NewBatchProducer(cfg, producer.WithCompression(kafka.Zstd))
+	bp.Connect()
+	defer bp.Close()
+	gc := consumer.NewGroupConsumer(cfg, "1")
+	gc.Connect()
+	defer gc.Close()
+
+	w := rec.writers[0]
+	if w.RequiredAcks != kafka.RequireAll || w.Compression != kafka.Snappy || w.BatchTimeout != 20*time.Millisecond ||
+		w.BatchSize != 100 || w.MaxAttempts != 3 {
+		t.Errorf("SimpleProducer 应使用配置并保留未配置项的默认值: %+v", w)
+	}
+	if w.Transport.(*kafka.Transport).ClientID != "order-service" {
+		t.Errorf("生产者应使用配置的 client_id")
+	}
+	if w := rec.writers[1]; w.Compression != kafka.Zstd || w.BatchSize != 500 {
+		t.Errorf("WithCompression 应优先于配置: %v %d", w.Compression, w.BatchSize)
+	}
+	r := rec.readers[0]
+	if r.StartOffset != kafka.LastOffset || r.SessionTimeout != 45*time.Second || r.HeartbeatInterval != 3*time.Second ||
+		len(r.GroupBalancers) != 1 || r.Dialer.ClientID != "order-service" {
+		t.Errorf("GroupConsumer 应使用配置: %+v", r)
+	}
+}
+
+// writeTestCertificate 生成自签名证书和私钥，返回PEM文件路径
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// TestSecurityConfig 测试TLS和SASL配置应用到所有连接
+func TestSecurityConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	cfg, _ := newTestConfig("secure-topic", "secure-group")
+	rec := &recordingTransport{Transport: cfg.Transport}
+	cfg.Transport = rec
+	cfg.ClientID = "secure-client"
+	cfg.Security = config.SecurityConfig{
+		TLS: config.TLSConfig{
+			Enabled:    true,
+			CAFile:     certFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "kafka.internal",
+		},
+		SASL: config.SASLConfig{Mechanism: "SCRAM-SHA-512", Username: "app", Password: "secret"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("配置应合法: %v", err)
+	}
+
+	dialer, err := cfg.Dialer()
+	if err != nil {
+		t.Fatalf("创建Dialer失败: %v", err)
+	}
+	if dialer.TLS == nil || dialer.TLS.RootCAs == nil || len(dialer.TLS.Certificates) != 1 || dialer.TLS.ServerName != "kafka.internal" {
+		t.Errorf("Dialer 应使用TLS配置: %+v", dialer.TLS)
+	}
+	if dialer.SASLMechanism == nil || dialer.SASLMechanism.Name() != "SCRAM-SHA-512" || dialer.ClientID != "secure-client" {
+		t.Errorf("Dialer 应使用SASL配置: %v", dialer.SASLMechanism)
+	}
+
+	// 生产者、消费者、死信队列使用相同的安全设置
+	sp := producer.NewSimpleProducer(cfg)
+	if err := sp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	sc := consumer.NewSimpleConsumer(cfg, -1)
+	if err := sc.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	h := dlq.NewHandler(cfg)
+	if err := h.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for i, w := range rec.writers {
+		wt, ok := w.Transport.(*kafka.Transport)
+		if !ok || wt.TLS == nil || wt.SASL == nil || wt.SASL.Name() != "SCRAM-SHA-512" {
+			t.Errorf("第%d个写入器缺少安全设置: %+v", i, w.Transport)
+		}
+	}
+	if d := rec.readers[0].Dialer; d == nil || d.TLS == nil || d.SASLMechanism == nil {
+		t.Errorf("读取器缺少安全设置: %+v", d)
+	}
+
+	plainCfg := *cfg
+	plainCfg.Security.SASL.Mechanism = "PLAIN"
+	if m, err := plainCfg.Security.SASLMechanism(); err != nil || m.Name() != "PLAIN" {
+		t.Errorf("PLAIN 认证机制错误: %v %v", m, err)
+	}
+
+	// 证书文件内容错误时连接失败，错误指出配置项
+	badCA := filepath.Join(dir, "bad-ca.pem")
+	os.WriteFile(badCA, []byte("not a certificate"), 0o600)
+	badCfg := *cfg
+	badCfg.Security.TLS.CAFile = badCA
+	if err := producer.NewSimpleProducer(&badCfg).Connect(); err == nil || !strings.Contains(err.Error(), "security.tls.ca_file") {
+		t.Errorf("无效的CA证书应导致连接失败: %v", err)
+	}
+
+	disabled := *cfg
+	disabled.Security.TLS.Enabled = false
+	if err := disabled.Validate(); err == nil || !strings.Contains(err.Error(), "security.tls.enabled") {
+		t.Errorf("设置了证书但未启用TLS应校验失败: %v", err)
+	}
+}
+
+// TestDispatcherOrdering 测试并发分发器的顺序保证和偏移量提交
+func TestConfigReload(t *testing.T) {
+	defer utils.SetLevel("info")
+
+	path := filepath.Join(t.TempDir(), "kafka.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+brokers: [localhost:9092]
+topic: reload-topic
+producer:
+  batch_size: 100
+  flush_interval: 1m
+consumer:
+  session_timeout: 45s
+  rate_limit:
+    rate: 5
+observability:
+  metrics:
+    enabled: true
+    interval: 1m
+`)
+
+	w, err := config.NewWatcher(path, "")
+	if err != nil {
+		t.Fatalf("创建监视器失败: %v", err)
+	}
+	testCfg, broker := newTestConfig("reload-topic", "reload-group")
+	w.Current().Transport = testCfg.Transport
+
+	bp := producer.NewBatchProducer(w.Current())
+	if err := bp.Connect(); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer bp.Close()
+
+	rl := middleware.NewRateLimiter(5, time.Second)
+	for i := 0; i < 5; i++ {
+		rl.Allow("")
+	}
+
+	m := metrics.NewMetrics()
+	go m.Start(time.Minute)
+	defer m.Stop()
+
+	c := client.NewClient(w.Current())
+	defer c.Close()
+	if _, err := c.Consumer("reload-group").Build(); err != nil {
+		t.Fatalf("构建消费者失败: %v", err)
+	}
+
+	w.Subscribe(bp)
+	w.Subscribe(rl)
+	w.Subscribe(m)
+	w.Subscribe(c)
+
+	write(`
+brokers: [kafka-1:9092]
+topic: reload-topic
+producer:
+  batch_size: 2
+  flush_interval: 50ms
+consumer:
+  session_timeout: 30s
+  rate_limit:
+    rate: 1000
+observability:
+  log_level: error
+  metrics:
+    enabled: true
+    interval: 5s
+`)
+	report, err := w.Reload()
+	if err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+
+	for _, field := range []string{"brokers", "producer.batch_size", "producer.flush_interval",
+		"consumer.session_timeout", "consumer.rate_limit.rate", "observability.log_level", "observability.metrics.interval"} {
+		if !report.Changes.Has(field) {
+			t.Errorf("变化中缺少 %s: %+v", field, report.Changes)
+		}
+	}
+	// 批量大小、刷新间隔、限流和报告间隔实时生效，连接和会话超时需要重启
+	if got := strings.Join(report.RestartRequired, ","); got != "brokers,consumer.session_timeout" {
+		t.Errorf("需要重启的配置项 = %s", got)
+	}
+	if w.Current().Brokers[0] != "kafka-1:9092" || c.Config() != w.Current() {
+		t.Errorf("当前配置应替换为新配置")
+	}
+
+	if err := bp.Send("k1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond) // 新的刷新间隔生效
+	if n := len(broker.Messages("reload-topic")); n != 1 {
+		t.Errorf("刷新间隔应改为50ms，已发送 %d 条", n)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !rl.Allow("") {
+		t.Errorf("限流速度应提高到每秒1000个")
+	}
+	if m.Interval() != 5*time.Second {
+		t.Errorf("指标报告间隔 = %v", m.Interval())
+	}
+
+	// 内容相同时没有变化，校验失败时保留当前配置
+	if report, err := w.Reload(); err != nil || len(report.Changes) != 0 {
+		t.Errorf("配置未变化时应返回空报告: %+v, %v", report, err)
+	}
+	current := w.Current()
+	write("brokers: [kafka-1:9092]\nproducer:\n  batch_size: -1\n")
+	if _, err := w.Reload(); err == nil || !strings.Contains(err.Error(), "producer.batch_size: 不能为负数") {
+		t.Errorf("无效配置应返回校验错误: %v", err)
+	}
+	if w.Current() != current {
+		t.Errorf("校验失败时应保留当前配置")
+	}
+
+	// 关闭后的生产者和消费者不再接收配置变化
+	c3 := client.NewClient(testCfg)
+	pw, _ := c3.Producer().Build()
+	cw, _ := c3.Consumer("reload-group").Build()
+	updated := *testCfg
+	updated.Producer.Acks = "all"
+	updated.Consumer.SessionTimeout = config.Duration(10 * time.Second)
+	changes := config.Diff(testCfg, &updated)
+	if restart := c3.Reload(&updated, changes); len(restart) != 2 {
+		t.Errorf("生产者和消费者都应报告需要重启: %v", restart)
+	}
+	pw.Close()
+	cw.Close()
+	if restart := c3.Reload(&updated, changes); len(restart) != 0 {
+		t.Errorf("关闭后不应再处理配置变化: %v", restart)
+	}
+	closed := producer.NewBatchProducer(testCfg)
+	closed.Connect()
+	closed.Close()
+	if restart := closed.Reload(&updated, config.Changes{{Field: "producer.batch_size"}}); restart != nil {
+		t.Errorf("关闭后的批量生产者不应处理配置变化: %v", restart)
+	}
+
+	// Start 检测到文件变化后自动重新加载
+	write("brokers: [kafka-1:9092]\n")
+	reloaded := make(chan *config.ReloadReport, 1)
+	w2, err := config.NewWatcher(path, "", config.WithPollInterval(10*time.Millisecond),
+		config.WithReloadHandler(func(report *config.ReloadReport, err error) {
+			if err == nil {
+				reloaded <- report
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w2.Start(ctx)
+
+	write("brokers: [kafka-1:9092]\nproducer:\n  batch_size: 8\n")
+	select {
+	case report := <-reloaded:
+		if !report.Changes.Has("producer") {
+			t.Errorf("应检测到 producer.batch_size 的变化: %+v", report.Changes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("文件变化后没有重新加载")
+	}
+}
+
+func TestDispatcherOrdering(t *testing.T) {
+	for _, ordering := range []consumer.Ordering{consumer.OrderByPartition, consumer.OrderByKey} {
+		t.Run(fmt.Sprintf("ordering-%d", ordering), func(t *testing.T) {
+			cfg, broker := newTestConfig("dispatch-topic", "dispatch-group")
+			broker.CreateTopic("dispatch-topic", 3)
+
+			p := producer.NewSimpleProducer(cfg)
+			p.Connect()
+			ctx := context.Background()
+			const total = 60
+			for i := 0; i < total; i++ {
+				p.SendMessage(ctx, fmt.Sprintf("key-%d", i%5), fmt.Sprintf("%d", i))
+			}
+			p.Close()
+
+			c := consumer.NewSimpleConsumer(cfg, -1)
+			c.Connect()
+			c.UseDispatcher(consumer.NewDispatcher(
+				consumer.WithWorkers(4),
+				consumer.WithOrdering(ordering),
+				consumer.WithCommitInterval(10*time.Millisecond),
+			))
+
+			var mu sync.Mutex
+			lastOffset := make(map[string]int64)
+			var count int32
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			c.Start(ctx, func(ctx context.Context, msg kafka.Message) error {
+				// 按顺序保证级别检查同一分区或同一key内的偏移量递增
+				group := fmt.Sprintf("p%d", msg.Partition)
+				if ordering == consumer.OrderByKey {
+					group += "/" + string(msg.Key)
+				}
+
+				mu.Lock()
+				if last, ok := lastOffset[group]; ok && msg.Offset <= last {
+					t.Errorf("%s 乱序: offset %d 在 %d 之后处理", group, msg.Offset, last)
+				}
+				lastOffset[group] = msg.Offset
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+				if atomic.AddInt32(&count, 1) == total {
+					cancel()
+				}
+				return nil
+			})
+			c.Close()
+
+			if count != total {
+				t.Fatalf("期望处理%d条消息，得到 %d", total, count)
+			}
+
+			// 每个分区都应提交到最后一条消息之后
+			expected := make(map[int]int64)
+			for _, msg := range broker.Messages("dispatch-topic") {
+				expected[msg.Partition]++
+			}
+			for partition, n := range expected {
+				if committed := broker.CommittedOffset("dispatch-group", "dispatch-topic", partition); committed != n {
+					t.Errorf("分区%d 提交偏移量错误，期望 %d，得到 %d", partition, n, committed)
+				}
+			}
+		})
+	}
+}
+
+// TestDispatcherFailure 测试并发分发器的失败策略：失败的消息不计入已处理，分区水位不会越过它
+func TestDispatcherFailure(t *testing.T) {
+	setup := func(t *testing.T, topic string) (*config.KafkaConfig, *transport.Broker) {
+		cfg, broker := newTestConfig(topic, topic+"-group")
+		broker.CreateTopic(topic, 1)
+		p := producer.NewSimpleProducer(cfg)
+		p.Connect()
+		for i := 0; i < 5; i++ {
+			p.SendMessage(context.Background(), "key", strconv.Itoa(i))
+		}
+		p.Close()
+		return cfg, broker
+	}
+	failAt := func(offset int64, processed *int32) consumer.MessageHandler {
+		return func(ctx context.Context, msg kafka.Message) error {
+			if msg.Offset == offset {
+				return errors.New("处理失败")
+			}
+			atomic.AddInt32(processed, 1)
+			return nil
+		}
+	}
+
+	t.Run("block", func(t *testing.T) {
+		cfg, broker := setup(t, "dispatch-block")
+		c := consumer.NewSimpleConsumer(cfg, -1)
+		c.Connect()
+		defer c.Close()
+		c.UseDispatcher(consumer.NewDispatcher(
+			consumer.WithWorkers(2),
+			consumer.WithCommitInterval(10*time.Millisecond),
+			consumer.WithDispatchRetryBackoff(10*time.Millisecond),
+		))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		var processed int32
+		c.Start(ctx, failAt(2, &processed))
+
+		// 同一分区的消息阻塞在失败的消息上，只提交它之前的偏移量
+		if n := atomic.LoadInt32(&processed); n != 2 {
+			t.Errorf("失败的消息之后不应继续处理，已处理 %d 条", n)
+		}
+		if committed := broker.CommittedOffset("dispatch-block-group", "dispatch-block", 0); committed != 2 {
+			t.Errorf("期望提交偏移量 2，得到 %d", committed)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		cfg, broker := setup(t, "dispatch-skip")
+		dlqHandler := &memoryDLQ{}
+		c := consumer.NewSimpleConsumer(cfg, -1)
+		c.Connect()
+		defer c.Close()
+		c.UseDispatcher(consumer.NewDispatcher(
+			consumer.WithCommitInterval(10*time.Millisecond),
+			consumer.WithDispatchFailurePolicy(consumer.FailureSkip, dlqHandler),
+		))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		var processed int32
+		c.Start(ctx, failAt(2, &processed))
+
+		if n := atomic.LoadInt32(&processed); n != 4 || len(dlqHandler.msgs) != 1 {
+			t.Errorf("失败的消息应发送到死信队列后跳过，已处理 %d 条，死信 %d 条", n, len(dlqHandler.msgs))
+		}
+		if committed := broker.CommittedOffset("dispatch-skip-group", "dispatch-skip", 0); committed != 5 {
+			t.Errorf("期望提交偏移量 5，得到 %d", committed)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		cfg, broker := setup(t, "dispatch-stop")
+		c := consumer.NewSimpleConsumer(cfg, -1)
+		c.Connect()
+		defer c.Close()
+		c.UseDispatcher(consumer.NewDispatcher(
+			consumer.WithWorkers(1),
+			consumer.WithDispatchFailurePolicy(consumer.FailureStop, nil),
+		))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var processed int32
+		if err := c.Start(ctx, failAt(2, &processed)); err == nil || !strings.Contains(err.Error(), "处理失败") {
+			t.Errorf("FailureStop 应返回处理错误，得到 %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Errorf("处理失败后应立即停止")
+		}
+		if committed := broker.CommittedOffset("dispatch-stop-group", "dispatch-stop", 0); committed != 2 {
+			t.Errorf("期望提交偏移量 2，得到 %d", committed)
+		}
+	})
+
+	t.Run("fetch-error", func(t *testing.T) {
+		// 读取失败时等待重试间隔，不会持续重试刷屏
+		cfg, broker := newTestConfig("dispatch-fetch", "dispatch-fetch-group")
+		var fetches int64
+		reader := &failingReader{Reader: broker.NewReader(kafka.ReaderConfig{Topic: cfg.Topic, GroupID: cfg.GroupID}), fetches: &fetches}
+		d := consumer.NewDispatcher(consumer.WithDispatchRetryBackoff(50 * time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		d.Run(ctx, reader, func(ctx context.Context, msg kafka.Message) error { return nil }, reader.CommitMessages)
+		if n := atomic.LoadInt64(&fetches); n == 0 || n > 10 {
+			t.Errorf("200ms 内读取 %d 次，读取失败后应等待重试间隔", n)
+		}
+	})
+}
+
+// TestHandlerContext 测试处理函数收到的ctx在超时和关闭时取消，并携带追踪信息
+func TestHandlerContext(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		handler := middleware.Timeout(20 * time.Millisecond)(func(ctx context.Context, msg kafka.Message) error {
+			<-ctx.Done()
+			close(cancelled)
+			return ctx.Err()
+		})
+
+		if err := handler(context.Background(), kafka.Message{}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("超时应返回 DeadlineExceeded，得到 %v", err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("超时后处理函数的ctx应被取消")
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		cfg, broker := newTestConfig("shutdown-topic", "shutdown-group")
+		broker.CreateTopic("shutdown-topic", 1)
+
+		p := producer.NewSimpleProducer(cfg)
+		p.Connect()
+		for i := 0; i < 3; i++ {
+			p.SendMessage(context.Background(), "key", fmt.Sprintf("%d", i))
+		}
+		p.Close()
+
+		c := consumer.NewSimpleConsumer(cfg, -1)
+		c.Connect()
+		defer c.Close()
+		c.UseDispatcher(consumer.NewDispatcher(consumer.WithWorkers(1), consumer.WithCommitInterval(time.Hour)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// 第二条消息处理中关闭消费者，处理函数通过ctx感知关闭
+		var interrupted atomic.Bool
+		c.Start(ctx, func(hctx context.Context, msg kafka.Message) error {
+			if msg.Offset == 0 {
+				return nil
+			}
+			cancel()
+			<-hctx.Done()
+			interrupted.Store(true)
+			return hctx.Err()
+		})
+
+		if !interrupted.Load() {
+			t.Fatal("关闭时正在处理的消息应收到ctx取消")
+		}
+		// 被中断的消息和队列中的消息不提交，重启后重新消费
+		if committed := broker.CommittedOffset("shutdown-group", "shutdown-topic", 0); committed != 1 {
+			t.Errorf("期望提交偏移量 1，得到 %d", committed)
+		}
+	})
+
+	t.Run("tracing", func(t *testing.T) {
+		tr := tracer.NewTracer("test")
+		handler := tr.Middleware(nil)(func(ctx context.Context, msg kafka.Message) error {
+			if traceID := ctx.Value("trace-id"); traceID != "t-1" {
+				t.Errorf("ctx应携带消息头中的trace-id，得到 %v", traceID)
+			}
+			if ctx.Value("span-id") == "s-1" {
+				t.Error("ctx应携带新的consume跨度")
+			}
+			if ctx.Err() == nil {
+				t.Error("ctx应保留上游的取消状态")
+			}
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		handler(ctx, kafka.Message{Headers: []kafka.Header{
+			{Key: "trace-id", Value: []byte("t-1")},
+			{Key: "span-id", Value: []byte("s-1")},
+		}})
+
+		// 处理函数panic时跨度仍然结束并报告
+		reporter := &spanRecorder{}
+		panicking := middleware.Recovery()(tr.Middleware(reporter)(func(ctx context.Context, msg kafka.Message) error {
+			panic("boom")
+		}))
+		if err := panicking(context.Background(), kafka.Message{}); err == nil {
+			t.Error("panic应转换为错误")
+		}
+		if len(reporter.spans) != 1 || reporter.spans[0].EndTime.IsZero() || reporter.spans[0].Tags["error"] != "panic" {
+			t.Errorf("panic时应结束并报告跨度: %+v", reporter.spans)
+		}
+	})
+}
+
+// spanRecorder 记录报告的跨度
+type spanRecorder struct {
+	spans []*tracer.Span
+}
+
+func (r *spanRecorder) Report(span *tracer.Span) {
+	r.spans = append(r.spans, span)
+}
+
+// TestRetryLadder 测试失败消息经过重试Topic后重新投递，最终进入死信队列
+func TestRetryLadder(t *testing.T) {
+	cfg, broker := newTestConfig("orders", "orders-group")
+
+	p := producer.NewSimpleProducer(cfg)
+	p.Connect()
+	ctx := context.Background()
+	p.SendMessage(ctx, "flaky", "flaky")
+	p.SendMessage(ctx, "broken", "broken")
+	p.Close()
+
+	ladder := retry.NewLadder(cfg, []time.Duration{20 * time.Millisecond, 50 * time.Millisecond})
+	ladder.Connect()
+	defer ladder.Close()
+
+	var mu sync.Mutex
+	attempts := make(map[string][]time.Time)
+	succeeded := make(chan struct{})
+	handler := func(ctx context.Context, msg kafka.Message) error {
+		if msg.Topic != "orders" {
+			t.Errorf("重试消息应还原原始Topic，得到 %s", msg.Topic)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := string(msg.Key)
+		attempts[key] = append(attempts[key], time.Now())
+
+		// flaky 第2次重试时成功，broken 一直失败
+		if key == "flaky" && retry.Attempt(msg) == 2 {
+			close(succeeded)
+			return nil
+		}
+		return fmt.Errorf("处理失败: %s", key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := consumer.NewSimpleConsumer(cfg, -1)
+	c.Connect()
+	defer c.Close()
+	go c.Start(ctx, func(ctx context.Context, msg kafka.Message) error {
+		return ladder.Middleware()(handler)(ctx, msg)
+	})
+	go ladder.Start(ctx, handler)
+
+	select {
+	case <-succeeded:
+	case <-ctx.Done():
+		t.Fatal("测试超时，flaky 消息没有重试成功")
+	}
+
+	// 等待 broken 进入死信队列
+	for len(broker.Messages("orders.dlq")) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("测试超时，broken 消息没有进入死信队列")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	dead := broker.Messages("orders.dlq")
+	if len(dead) != 1 || string(dead[0].Key) != "broken" {
+		t.Fatalf("死信队列应只有 broken 消息，得到 %d 条", len(dead))
+	}
+	if retry.Attempt(dead[0]) != 3 {
+		t.Errorf("死信消息重试次数应为3，得到 %d", retry.Attempt(dead[0]))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n := len(attempts["broken"]); n != 3 {
+		t.Errorf("broken 应处理3次，得到 %d", n)
+	}
+	// 第二级重试延迟至少50ms
+	if tries := attempts["broken"]; len(tries) == 3 && tries[2].Sub(tries[1]) < 50*time.Millisecond {
+		t.Errorf("第二级重试过早: %v", tries[2].Sub(tries[1]))
+	}
+
+	t.Run("FetchError", func(t *testing.T) {
+		// 读取重试消息失败时等待重试间隔
+		cfg, broker := newTestConfig("orders-broken", "orders-broken-group")
+		var fetches int64
+		cfg.Transport = &failingReaderTransport{Broker: broker, fetches: &fetches}
+		ladder := retry.NewLadder(cfg, []time.Duration{time.Millisecond, time.Millisecond}, retry.WithRouteBackoff(50*time.Millisecond))
+		ladder.Connect()
+		defer ladder.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		ladder.Start(ctx, func(ctx context.Context, msg kafka.Message) error { return nil })
+		if n := atomic.LoadInt64(&fetches); n == 0 || n > 20 {
+			t.Errorf("200ms 内两级共读取 %d 次，读取失败后应等待重试间隔", n)
+		}
+	})
+}
+
+// TestDeadLetterReplay 测试死信队列写入和按条件重放
+func TestDeadLetterReplay(t *testing.T) {
+	cfg, broker := newTestConfig("payments", "")
+	ctx := context.Background()
+
+	h := dlq.NewHandler(cfg)
+	h.Connect()
+	defer h.Close()
+
+	// 通过 Recovery + DeadLetterQueue 中间件写入死信队列
+	handler := middleware.Chain(middleware.DeadLetterQueue(h), middleware.Recovery())(func(ctx context.Context, msg kafka.Message) error {
+		switch string(msg.Key) {
+		case "panic":
+			panic("boom")
+		case "timeout":
+			return fmt.Errorf("下游超时")
+		}
+		return fmt.Errorf("余额不足")
+	})
+	for i, key := range []string{"panic", "timeout", "balance"} {
+		handler(context.Background(), kafka.Message{
+			Topic:     "payments",
+			Partition: 0,
+			Offset:    int64(i),
+			Key:       []byte(key),
+			Value:     []byte("v-" + key),
+			Headers:   []kafka.Header{{Key: "trace-id", Value: []byte("t-" + key)}},
+		})
+	}
+
+	dead := broker.Messages("payments.dlq")
+	if len(dead) != 3 {
+		t.Fatalf("期望3条死信消息，得到 %d", len(dead))
+	}
+	headers := make(map[string]string)
+	for _, hd := range dead[0].Headers {
+		headers[hd.Key] = string(hd.Value)
+	}
+	if headers[dlq.HeaderPanic] != "boom" || headers[dlq.HeaderStack] == "" {
+		t.Errorf("panic消息应记录panic值和调用栈: %v", headers)
+	}
+	if headers[dlq.HeaderOriginalTopic] != "payments" || headers[dlq.HeaderOriginalOffset] != "0" || headers[dlq.HeaderAttempt] != "1" {
+		t.Errorf("原始位置消息头错误: %v", headers)
+	}
+
+	r := dlq.NewReplayer(cfg)
+	r.Connect()
+	defer r.Close()
+
+	n, err := r.Replay(ctx, dlq.ByError("超时"), dlq.ByTimeRange(time.Now().Add(-time.Minute), time.Time{}))
+	if err != nil || n != 1 {
+		t.Fatalf("按错误重放应重放1条消息，得到 %d, %v", n, err)
+	}
+	if n, _ := r.Replay(ctx, dlq.ByKey("balance")); n != 1 {
+		t.Errorf("按key重放应重放1条消息，得到 %d", n)
+	}
+	if n, _ := r.Replay(ctx, dlq.ByTimeRange(time.Time{}, time.Now().Add(-time.Minute))); n != 0 {
+		t.Errorf("时间范围外不应重放消息，得到 %d", n)
+	}
+
+	replayed := broker.Messages("payments")
+	if len(replayed) != 2 || string(replayed[0].Key) != "timeout" || string(replayed[1].Key) != "balance" {
+		t.Fatalf("重放到原始Topic的消息错误: %v", replayed)
+	}
+	if len(replayed[0].Headers) != 1 || replayed[0].Headers[0].Key != "trace-id" {
+		t.Errorf("重放消息应只保留业务消息头: %v", replayed[0].Headers)
+	}
+}
+
+// TestMiddlewareChain 测试中间件链
+func TestMiddlewareChain(t *testing.T) {
+	callOrder := []string{}
+
+	m1 := func(next func(kafka.Message) error) func(kafka.Message) error {
+		return func(msg kafka.Message) error {
+			callOrder = append(callOrder, "m1-before")
+			err := next(msg)
+			callOrder = append(callOrder, "m1-after")
+			return err
+		}
+	}
+
+	m2 := func(next func(kafka.Message) error) func(kafka.Message) error {
+		return func(msg kafka.Message) error {
+			callOrder = append(callOrder, "m2-before")
+			err := next(msg)
+			callOrder = append(callOrder, "m2-after")
+			return err
+		}
+	}
+
+	chain := func(final func(kafka.Message) error) func(kafka.Message) error {
+		return func(msg kafka.Message) error {
+			handler := final
+			handler = m1(handler)
+			handler = m2(handler)
+			return handler(msg)
+		}
+	}
+
+	handler := chain(func(msg kafka.Message) error {
+		callOrder = append(callOrder, "handler")
+		return nil
+	})
+
+	msg := kafka.Message{Value: []byte("test")}
+	handler(msg)
+
+	// 验证调用顺序
+	expected := []string{"m2-before", "m1-before", "handler", "m1-after", "m2-after"}
+	for i, v := range expected {
+		if i >= len(callOrder) || callOrder[i] != v {
+			t.Errorf("中间件调用顺序错误，期望 %v，得到 %v", expected, callOrder)
+			break
+		}
+	}
+}
+
+// TestCircuitBreaker 测试熔断器的并发安全、半开探测、失败率和暂停模式
+func TestCircuitBreaker(t *testing.T) {
+	failing := func() error { return fmt.Errorf("下游不可用") }
+
+	t.Run("half-open-probes", func(t *testing.T) {
+		var mu sync.Mutex
+		var transitions []string
+		cb := middleware.NewCircuitBreaker(5, 20*time.Millisecond,
+			middleware.WithHalfOpenProbes(2),
+			middleware.WithStateChange(func(from, to middleware.BreakerState) {
+				mu.Lock()
+				defer mu.Unlock()
+				transitions = append(transitions, from.String()+"->"+to.String())
+			}),
+		)
+		m := metrics.NewMetrics()
+		m.ObserveBreaker(cb)
+
+		// 并发失败触发熔断
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cb.Execute(failing)
+			}()
+		}
+		wg.Wait()
+
+		if cb.State() != middleware.StateOpen {
+			t.Fatalf("连续失败后应熔断，当前状态 %s", cb.State())
+		}
+		if err := cb.Execute(func() error { return nil }); err != middleware.ErrCircuitOpen {
+			t.Errorf("熔断时应拒绝请求，得到 %v", err)
+		}
+
+		// 半开状态只放行2个探测请求
+		time.Sleep(30 * time.Millisecond)
+		release := make(chan struct{})
+		var allowed, rejected int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := cb.Execute(func() error {
+					atomic.AddInt32(&allowed, 1)
+					<-release
+					return nil
+				})
+				if err == middleware.ErrCircuitOpen {
+					atomic.AddInt32(&rejected, 1)
+				}
+			}()
+		}
+		for atomic.LoadInt32(&allowed)+atomic.LoadInt32(&rejected) < 10 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+
+		if allowed != 2 {
+			t.Errorf("半开状态应放行2个探测请求，得到 %d", allowed)
+		}
+		if cb.State() != middleware.StateClosed {
+			t.Errorf("探测成功后应关闭熔断器，当前状态 %s", cb.State())
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+		if fmt.Sprint(transitions) != fmt.Sprint(expected) {
+			t.Errorf("状态变化错误，期望 %v，得到 %v", expected, transitions)
+		}
+		if snapshot := m.Snapshot(); snapshot["circuit_breaker_trips"] != uint64(1) || snapshot["circuit_breaker_state"] != "closed" {
+			t.Errorf("熔断器指标错误: %v", snapshot)
+		}
+	})
+
+	t.Run("failure-rate", func(t *testing.T) {
+		cb := middleware.NewCircuitBreaker(0, time.Second, middleware.WithFailureRate(0.5, time.Second, 10))
+		for i := 0; i < 5; i++ {
+			cb.Execute(func() error { return nil })
+		}
+		for i := 0; i < 4; i++ {
+			cb.Execute(failing)
+		}
+		if cb.State() != middleware.StateClosed {
+			t.Fatalf("失败率未达到阈值时不应熔断")
+		}
+		cb.Execute(failing)
+		if cb.State() != middleware.StateOpen {
+			t.Errorf("失败率达到50%%时应熔断，当前状态 %s", cb.State())
+		}
+	})
+
+	t.Run("pause-on-open", func(t *testing.T) {
+		cb := middleware.NewCircuitBreaker(1, 50*time.Millisecond, middleware.WithPauseOnOpen())
+		var healthy atomic.Bool
+		handler := cb.Middleware()(func(ctx context.Context, msg kafka.Message) error {
+			if !healthy.Load() {
+				return fmt.Errorf("下游不可用")
+			}
+			return nil
+		})
+
+		handler(context.Background(), kafka.Message{})
+		healthy.Store(true)
+
+		// 熔断期间处理函数阻塞等待恢复，而不是返回错误
+		start := time.Now()
+		if err := handler(context.Background(), kafka.Message{}); err != nil {
+			t.Errorf("暂停模式下恢复后应处理成功，得到 %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Errorf("熔断期间应暂停处理，只等待了 %v", elapsed)
+		}
+		if stats := cb.Stats(); stats.Trips != 1 || stats.State != middleware.StateClosed {
+			t.Errorf("熔断器统计错误: %+v", stats)
+		}
+	})
+
+	t.Run("panic-in-probe", func(t *testing.T) {
+		cb := middleware.NewCircuitBreaker(1, 10*time.Millisecond)
+		cb.Execute(failing)
+		time.Sleep(20 * time.Millisecond)
+
+		// 探测请求panic由外层的 Recovery 捕获，探测名额仍需释放
+		handler := middleware.Recovery()(cb.Middleware()(func(ctx context.Context, msg kafka.Message) error {
+			panic("处理函数崩溃")
+		}))
+		if err := handler(context.Background(), kafka.Message{}); err == nil {
+			t.Fatalf("panic 应作为错误返回")
+		}
+		if stats := cb.Stats(); stats.State != middleware.StateOpen || stats.Failures != 2 {
+			t.Errorf("panic 应计为探测失败并重新熔断: %+v", stats)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if err := cb.Execute(func() error { return nil }); err != nil || cb.State() != middleware.StateClosed {
+			t.Errorf("探测成功后应恢复，得到 %v，状态 %s", err, cb.State())
+		}
+	})
+
+	t.Run("tiny-window", func(t *testing.T) {
+		cb := middleware.NewCircuitBreaker(0, time.Second, middleware.WithFailureRate(0.5, 5*time.Nanosecond, 1))
+		cb.Execute(failing)
+		if cb.State() != middleware.StateOpen {
+			t.Errorf("窗口小于桶数时也应正常统计，当前状态 %s", cb.State())
+		}
+	})
+}
+
+// TestRateLimiter 测试令牌桶限流器的突发、阻塞等待、按key限流和停止
+func TestRateLimiter(t *testing.T) {
+	t.Run("burst-and-wait", func(t *testing.T) {
+		rl := middleware.NewRateLimiter(100, time.Second, middleware.WithBurst(2))
+		defer rl.Stop()
+
+		if !rl.Allow("") || !rl.Allow("") || rl.Allow("") {
+			t.Fatal("突发容量为2时应只允许连续2个请求")
+		}
+
+		// 令牌耗尽后等待补充，5个令牌约需50ms
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			if err := rl.Wait(context.Background()); err != nil {
+				t.Fatalf("等待令牌失败: %v", err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Errorf("应等待令牌补充，只用了 %v", elapsed)
+		}
+	})
+
+	t.Run("context-and-stop", func(t *testing.T) {
+		rl := middleware.NewRateLimiter(1, time.Minute)
+		rl.Wait(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := rl.Wait(ctx); err != context.DeadlineExceeded {
+			t.Errorf("ctx超时应返回 DeadlineExceeded，得到 %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- rl.Wait(context.Background()) }()
+		time.Sleep(10 * time.Millisecond)
+		rl.Stop()
+
+		select {
+		case err := <-done:
+			if err != middleware.ErrLimiterStopped {
+				t.Errorf("停止后应返回 ErrLimiterStopped，得到 %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("停止后等待中的请求没有返回")
+		}
+	})
+
+	t.Run("per-key", func(t *testing.T) {
+		rl := middleware.NewRateLimiter(1, time.Minute,
+			middleware.WithKeyFunc(middleware.ByHeader("tenant")),
+			middleware.WithNonBlocking(),
+		)
+		defer rl.Stop()
+
+		handler := rl.Middleware()(func(ctx context.Context, msg kafka.Message) error { return nil })
+		tenant := func(name string) kafka.Message {
+			return kafka.Message{Headers: []kafka.Header{{Key: "tenant", Value: []byte(name)}}}
+		}
+
+		if err := handler(context.Background(), tenant("a")); err != nil {
+			t.Errorf("租户a的第一条消息应放行: %v", err)
+		}
+		if err := handler(context.Background(), tenant("a")); err != middleware.ErrRateLimited {
+			t.Errorf("租户a超出限制应返回 ErrRateLimited，得到 %v", err)
+		}
+		if err := handler(context.Background(), tenant("b")); err != nil {
+			t.Errorf("租户b不受租户a限流影响: %v", err)
+		}
+	})
+
+	t.Run("producer", func(t *testing.T) {
+		cfg, broker := newTestConfig("limited-topic", "")
+		sp := producer.NewSimpleProducer(cfg)
+		sp.Connect()
+
+		rl := middleware.NewRateLimiter(50, time.Second, middleware.WithBurst(1))
+		defer rl.Stop()
+		p := producer.NewRateLimitedProducer(sp, rl)
+		defer p.Close()
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			if err := p.SendMessage(context.Background(), "key", fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("发送失败: %v", err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+			t.Errorf("生产者应被限流，5条消息只用了 %v", elapsed)
+		}
+		if n := len(broker.Messages("limited-topic")); n != 5 {
+			t.Errorf("期望5条消息，得到 %d", n)
+		}
+	})
+}
+
+// orderEvent 与 examples/order_system.go 中的订单事件结构一致
+func TestIdempotentProducer(t *testing.T) {
+	// sequences 按分区检查序列号从0开始连续递增，返回生产者ID
+	sequences := func(t *testing.T, msgs []kafka.Message) string {
+		t.Helper()
+		var id string
+		next := make(map[int]int64)
+		for _, msg := range msgs {
+			h := serializer.HeadersOf(msg)
+			if id == "" {
+				id = h[producer.HeaderProducerID]
+			}
+			if h[producer.HeaderProducerID] != id {
+				t.Fatalf("生产者ID不一致: %v", h)
+			}
+			if h[producer.HeaderSequence] != strconv.FormatInt(next[msg.Partition], 10) {
+				t.Fatalf("分区 %d 的序列号 = %s，期望 %d", msg.Partition, h[producer.HeaderSequence], next[msg.Partition])
+			}
+			next[msg.Partition]++
+		}
+		if len(next) < 2 {
+			t.Errorf("消息应分布在多个分区: %v", next)
+		}
+		return id
+	}
+
+	cfg, broker := newTestConfig("idem-batch", "")
+	broker.CreateTopic("idem-batch", 3)
+	cfg.ClientID = "orders"
+	cfg.Producer.Idempotent = true
+
+	bp := producer.NewBatchProducer(cfg, producer.WithBatchSize(7))
+	if err := bp.Connect(); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		if err := bp.Send(fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp.Close()
+
+	msgs := broker.Messages("idem-batch")
+	if len(msgs) != 30 {
+		t.Fatalf("期望30条消息，实际 %d", len(msgs))
+	}
+	if id := sequences(t, msgs); id != bp.ProducerID() || !strings.HasPrefix(id, "orders-") {
+		t.Errorf("生产者ID = %s，ProducerID() = %s", id, bp.ProducerID())
+	}
+
+	cfg, broker = newTestConfig("idem-async", "")
+	broker.CreateTopic("idem-async", 3)
+	cfg.Producer.Idempotent = true
+	ap := producer.NewAsyncProducer(cfg, nil)
+	if err := ap.Connect(); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	for i := 0; i < 250; i++ {
+		if err := ap.SendMessage(context.Background(), fmt.Sprintf("key-%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ap.Flush()
+	ap.Close()
+	if msgs := broker.Messages("idem-async"); len(msgs) != 250 {
+		t.Fatalf("期望250条消息，实际 %d", len(msgs))
+	} else {
+		sequences(t, msgs)
+	}
+
+	t.Run("Dedup", func(t *testing.T) {
+		store := middleware.NewMemoryDedupStore(0)
+		var handled int
+		failOffset := int64(-1)
+		handler := middleware.Dedup(store)(func(ctx context.Context, msg kafka.Message) error {
+			if msg.Offset == failOffset {
+				failOffset = -1
+				return errors.New("处理失败")
+			}
+			handled++
+			return nil
+		})
+
+		// 第一次投递时一条消息处理失败，重新投递后应被处理；整批重放时全部丢弃
+		failOffset = msgs[5].Offset
+		for _, msg := range msgs {
+			if err := handler(context.Background(), msg); err != nil {
+				if err := handler(context.Background(), msg); err != nil {
+					t.Fatalf("重新投递失败: %v", err)
+				}
+			}
+		}
+		for _, msg := range msgs {
+			handler(context.Background(), msg)
+		}
+		if handled != len(msgs) {
+			t.Errorf("处理了 %d 条，期望 %d 条", handled, len(msgs))
+		}
+
+		// 没有序列号的消息不去重
+		plain := kafka.Message{Topic: "idem-async", Value: []byte("plain")}
+		handler(context.Background(), plain)
+		handler(context.Background(), plain)
+		if handled != len(msgs)+2 {
+			t.Errorf("没有序列号的消息应全部处理")
+		}
+
+		lru := middleware.NewMemoryDedupStore(2)
+		lru.Save("a", 1)
+		lru.Save("b", 1)
+		lru.Last("a")
+		lru.Save("c", 1)
+		if _, ok, _ := lru.Last("b"); ok || lru.Len() != 2 {
+			t.Errorf("应淘汰最久未使用的记录")
+		}
+	})
+
+	t.Run("FileStore", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dedup.log")
+		store, err := middleware.NewFileDedupStore(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for seq := int64(0); seq < 3000; seq++ {
+			if err := store.Save("p1/orders/0", seq); err != nil {
+				t.Fatal(err)
+			}
+		}
+		store.Save("p1/orders/1", 7)
+		store.Save("p1/orders/1", 3) // 较小的序列号不覆盖
+		store.Close()
+
+		// 重新打开后记录仍在，文件已压缩
+		store, err = middleware.NewFileDedupStore(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if seq, ok, _ := store.Last("p1/orders/0"); !ok || seq != 2999 {
+			t.Errorf("p1/orders/0 = %d, %v", seq, ok)
+		}
+		if seq, _, _ := store.Last("p1/orders/1"); seq != 7 {
+			t.Errorf("p1/orders/1 = %d", seq)
+		}
+		if data, _ := os.ReadFile(path); strings.Count(string(data), "\n") != 2 {
+			t.Errorf("打开时应压缩为每个key一行: %q", data)
+		}
+
+		// 生产者重启后ID变化，超过容量时淘汰最久未使用的记录，文件不会无限增长
+		lruPath := filepath.Join(t.TempDir(), "lru.log")
+		lru, err := middleware.NewFileDedupStore(lruPath, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5000; i++ {
+			lru.Save(fmt.Sprintf("p%d/orders/0", i), 1)
+		}
+		lru.Last("p4998/orders/0")
+		lru.Save("p5000/orders/0", 1)
+		if _, ok, _ := lru.Last("p4999/orders/0"); ok || lru.Len() != 2 {
+			t.Errorf("应淘汰最久未使用的记录")
+		}
+		if data, _ := os.ReadFile(lruPath); strings.Count(string(data), "\n") > 2*2+1024 {
+			t.Errorf("文件应定期压缩，当前 %d 行", strings.Count(string(data), "\n"))
+		}
+		lru.Close()
+
+		// 重新打开时按写入顺序恢复，保留最近写入的记录
+		lru, _ = middleware.NewFileDedupStore(lruPath, 2)
+		defer lru.Close()
+		if _, ok, _ := lru.Last("p5000/orders/0"); !ok || lru.Len() != 2 {
+			t.Errorf("重新打开后应保留最近写入的记录")
+		}
+	})
+
+	t.Run("SlowLookup", func(t *testing.T) {
+		// 查询分区期间缓冲区的其他操作不被阻塞
+		cfg, broker := newTestConfig("idem-slow", "")
+		broker.CreateTopic("idem-slow", 3)
+		gate := make(chan struct{})
+		cfg.Transport = &slowLookupTransport{Transport: broker, gate: gate}
+		cfg.Producer.Idempotent = true
+
+		bp := producer.NewBatchProducer(cfg, producer.WithBatchSize(100))
+		if err := bp.Connect(); err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+		sent := make(chan error, 1)
+		go func() { sent <- bp.Send("key", "value") }()
+
+		done := make(chan struct{})
+		go func() {
+			bp.BufferSize()
+			bp.Flush()
+			bp.SetBatchSize(50)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("查询分区时阻塞了 Flush 和 BufferSize")
+		}
+
+		close(gate)
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+		bp.Close()
+		if msgs := broker.Messages("idem-slow"); len(msgs) != 1 {
+			t.Errorf("期望1条消息，实际 %d", len(msgs))
+		}
+	})
+}
+
+// slowLookupTransport 查询分区时等待 gate 关闭
+type slowLookupTransport struct {
+	transport.Transport
+	gate chan struct{}
+}
+
+func (s *slowLookupTransport) LookupPartitions(ctx context.Context, brokers []string, topic string) ([]int, error) {
+	select {
+	case <-s.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.Transport.LookupPartitions(ctx, brokers, topic)
+}
+
+// readValues 从分区0读取最多n条消息的值，超时后返回已读取的
+func readValues(t *testing.T, cfg *config.KafkaConfig, topic string, isolation kafka.IsolationLevel, n int) []string {
+	t.Helper()
+	r := cfg.GetTransport().NewReader(kafka.ReaderConfig{Brokers: cfg.Brokers, Topic: topic, IsolationLevel: isolation})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var values []string
+	for len(values) < n {
+		msg, err := r.FetchMessage(ctx)
+		if err != nil {
+			break
+		}
+		values = append(values, string(msg.Value))
+	}
+	return values
+}
+
+// TestTransactionalPipeline 测试事务生产者和恰好一次处理管道
+func TestTransactionalPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Visibility", func(t *testing.T) {
+		cfg, _ := newTestConfig("txn-topic", "")
+		p := producer.NewTransactionalProducer(cfg, "txn-a")
+		if err := p.Connect(); err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+		defer p.Close()
+
+		if err := p.SendMessage(ctx, "k", "outside"); !errors.Is(err, transport.ErrNoTransaction) {
+			t.Errorf("事务外发送应返回 ErrNoTransaction, got %v", err)
+		}
+
+		p.Begin()
+		p.SendMessage(ctx, "k", "aborted")
+		if err := p.Abort(ctx); err != nil {
+			t.Fatalf("中止事务失败: %v", err)
+		}
+		p.Begin()
+		p.SendMessage(ctx, "k", "committed")
+		if err := p.Commit(ctx); err != nil {
+			t.Fatalf("提交事务失败: %v", err)
+		}
+		p.Begin()
+		p.SendMessage(ctx, "k", "open")
+
+		if got := readValues(t, cfg, "txn-topic", kafka.ReadCommitted, 3); strings.Join(got, ",") != "committed" {
+			t.Errorf("read_committed 应只读到已提交的消息: %v", got)
+		}
+		if got := readValues(t, cfg, "txn-topic", kafka.ReadUncommitted, 3); strings.Join(got, ",") != "aborted,committed,open" {
+			t.Errorf("read_uncommitted 应读到所有消息: %v", got)
+		}
+
+		// 相同事务ID的新实例隔离旧实例并中止其事务
+		p2 := producer.NewTransactionalProducer(cfg, "txn-a")
+		if err := p2.Connect(); err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+		defer p2.Close()
+		if err := p.Commit(ctx); !errors.Is(err, transport.ErrProducerFenced) {
+			t.Errorf("被隔离的生产者应返回 ErrProducerFenced, got %v", err)
+		}
+		p2.Begin()
+		p2.SendMessage(ctx, "k", "next")
+		p2.Commit(ctx)
+		if got := readValues(t, cfg, "txn-topic", kafka.ReadCommitted, 3); strings.Join(got, ",") != "committed,next" {
+			t.Errorf("被中止的事务不应可见: %v", got)
+		}
+	})
+
+	t.Run("Pipeline", func(t *testing.T) {
+		cfg, broker := newTestConfig("orders", "order-pipeline")
+		cfg.Producer.TransactionalID = "order-pipeline-0"
+
+		p := producer.NewSimpleProducer(cfg)
+		p.Connect()
+		for i := 0; i < 10; i++ {
+			p.SendMessage(ctx, strconv.Itoa(i), strconv.Itoa(i))
+		}
+		p.Close()
+
+		pipeline := consumer.NewPipeline(cfg, "order-events")
+		pipeline.SetBatch(4, 20*time.Millisecond)
+		pipeline.SetRetryBackoff(time.Millisecond)
+		if err := pipeline.Connect(); err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		var failed int64
+		done := make(chan error, 1)
+		go func() {
+			done <- pipeline.Start(runCtx, func(ctx context.Context, msg kafka.Message) ([]kafka.Message, error) {
+				// 第5条消息第一次处理失败，整批中止后重试
+				if string(msg.Value) == "5" && atomic.AddInt64(&failed, 1) == 1 {
+					return nil, fmt.Errorf("模拟处理失败")
+				}
+				return []kafka.Message{{Key: msg.Key, Value: []byte("event-" + string(msg.Value))}}, nil
+			})
+		}()
+
+		for broker.CommittedOffset("order-pipeline", "orders", 0) != 10 {
+			if runCtx.Err() != nil {
+				t.Fatalf("测试超时，提交偏移量 %d", broker.CommittedOffset("order-pipeline", "orders", 0))
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start 返回错误: %v", err)
+		}
+		pipeline.Close()
+
+		got := readValues(t, cfg, "order-events", kafka.ReadCommitted, 20)
+		if len(got) != 10 {
+			t.Fatalf("期望10条输出消息，得到 %d: %v", len(got), got)
+		}
+		for i, v := range got {
+			if v != "event-"+strconv.Itoa(i) {
+				t.Errorf("输出消息 %d = %s", i, v)
+			}
+		}
+		if n := len(broker.Messages("order-events")); n <= 10 {
+			t.Errorf("中止的事务应留下未提交的消息，日志中共 %d 条", n)
+		}
+	})
+
+	t.Run("FetchError", func(t *testing.T) {
+		// 读取失败时按 retryBackoff 重试，关闭管道后 Start 返回
+		cfg, broker := newTestConfig("orders-broken", "broken-pipeline")
+		cfg.Producer.TransactionalID = "broken-pipeline-0"
+		var fetches int64
+		cfg.Transport = &failingReaderTransport{Broker: broker, fetches: &fetches}
+
+		pipeline := consumer.NewPipeline(cfg, "order-events")
+		pipeline.SetRetryBackoff(50 * time.Millisecond)
+		if err := pipeline.Connect(); err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- pipeline.Start(ctx, func(ctx context.Context, msg kafka.Message) ([]kafka.Message, error) {
+				return nil, nil
+			})
+		}()
+		time.Sleep(200 * time.Millisecond)
+		if n := atomic.LoadInt64(&fetches); n == 0 || n > 10 {
+			t.Errorf("200ms 内读取 %d 次，读取失败后应等待 retryBackoff", n)
+		}
+
+		pipeline.Close()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start 返回错误: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("关闭管道后 Start 未返回")
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		cfg := &config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "txn-topic"}
+		p := producer.NewTransactionalProducer(cfg, "txn-b")
+		if err := p.Connect(); !errors.Is(err, transport.ErrTransactionsUnsupported) {
+			t.Errorf("期望 ErrTransactionsUnsupported, got %v", err)
+		}
+	})
+}
+
+// failingReaderTransport 创建的读取器在关闭前读取总是失败
+type failingReaderTransport struct {
+	*transport.Broker
+	fetches *int64
+}
+
+func (f *failingReaderTransport) NewReader(cfg kafka.ReaderConfig) transport.Reader {
+	return &failingReader{Reader: f.Broker.NewReader(cfg), fetches: f.fetches}
+}
+
+type failingReader struct {
+	transport.Reader
+	fetches *int64
+	closed  int32
+}
+
+func (r *failingReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	atomic.AddInt64(r.fetches, 1)
+	if atomic.LoadInt32(&r.closed) == 1 {
+		return kafka.Message{}, io.EOF
+	}
+	return kafka.Message{}, errors.New("模拟读取失败")
+}
+
+func (r *failingReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	return r.Reader.Close()
+}
+
+// flakyProducer 按消息内容模拟发送失败
+type flakyProducer struct {
+	producer.Producer
+	fail func(value string) error
+}
+
+func (p *flakyProducer) SendMessageWithHeaders(ctx context.Context, key, value string, headers map[string]string) error {
+	if err := p.fail(value); err != nil {
+		return err
+	}
+	return p.Producer.SendMessageWithHeaders(ctx, key, value, headers)
+}
+
+// TestOutboxRelay 测试发件箱中继：按聚合保序、失败重试、毒丸事件和重启后恢复
+func TestOutboxRelay(t *testing.T) {
+	cfg, broker := newTestConfig("outbox-topic", "")
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.log")
+
+	store, err := producer.NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatalf("打开发件箱失败: %v", err)
+	}
+	for _, e := range [][2]string{{"A", "a1"}, {"B", "b1"}, {"A", "a2"}, {"C", "c1"}, {"A", "a3"}, {"B", "b2"}} {
+		if _, err := store.Append(ctx, producer.OutboxRecord{AggregateID: e[0], Value: e[1], Headers: map[string]string{"type": "order"}}); err != nil {
+			t.Fatalf("写入发件箱失败: %v", err)
+		}
+	}
+
+	sp := producer.NewSimpleProducer(cfg)
+	sp.Connect()
+	defer sp.Close()
+
+	var a2Attempts int64
+	p := &flakyProducer{Producer: sp, fail: func(value string) error {
+		switch {
+		case value == "a2" && atomic.AddInt64(&a2Attempts, 1) == 1:
+			return errors.New("临时错误")
+		case value == "c1":
+			return errors.New("消息过大")
+		}
+		return nil
+	}}
+
+	var poisoned []producer.OutboxRecord
+	relay := producer.NewOutboxRelay(store, p)
+	relay.SetMaxAttempts(2)
+	relay.SetRetryBackoff(time.Millisecond)
+	relay.SetPoisonHandler(func(rec producer.OutboxRecord, err error) {
+		poisoned = append(poisoned, rec)
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("第 %d 轮发送失败: %v", i+1, err)
+		}
+		time.Sleep(5 * time.Millisecond) // 等待重试间隔
+	}
+
+	byKey := make(map[string][]string)
+	for _, msg := range broker.Messages("outbox-topic") {
+		byKey[string(msg.Key)] = append(byKey[string(msg.Key)], string(msg.Value))
+		if len(msg.Headers) != 1 || string(msg.Headers[0].Value) != "order" {
+			t.Errorf("消息头丢失: %v", msg.Headers)
+		}
+	}
+	if got := strings.Join(byKey["A"], ","); got != "a1,a2,a3" {
+		t.Errorf("聚合A的顺序错误: %s", got)
+	}
+	if got := strings.Join(byKey["B"], ","); got != "b1,b2" {
+		t.Errorf("聚合B的顺序错误: %s", got)
+	}
+	if len(byKey["C"]) != 0 {
+		t.Errorf("毒丸事件不应发送: %v", byKey["C"])
+	}
+	if len(poisoned) != 1 || poisoned[0].Value != "c1" || poisoned[0].Attempts != 2 {
+		t.Errorf("毒丸回调错误: %+v", poisoned)
+	}
+	store.Close()
+
+	// 重启后已发送的事件不再发送，毒丸事件保留
+	store, err = producer.NewFileOutboxStore(path)
+	if err != nil {
+		t.Fatalf("重新打开发件箱失败: %v", err)
+	}
+	defer store.Close()
+	if pending, _ := store.Pending(ctx, 10); len(pending) != 0 {
+		t.Errorf("不应有未发送的事件: %+v", pending)
+	}
+	if list, _ := store.Poisoned(ctx); len(list) != 1 || list[0].LastError != "消息过大" {
+		t.Errorf("毒丸事件未保留: %+v", list)
+	}
+
+	// 写入后通知中继立即发送
+	relay = producer.NewOutboxRelay(store, sp)
+	relay.SetPollInterval(time.Minute)
+	runCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	go relay.Start(runCtx)
+
+	// 压缩后重新打开不重复使用已删除事件的ID
+	if id, err := store.Append(ctx, producer.OutboxRecord{AggregateID: "D", Value: "d1"}); err != nil || id != 7 {
+		t.Errorf("新事件ID = %d，期望 7: %v", id, err)
+	}
+	relay.Notify()
+	for len(broker.Messages("outbox-topic")) != 6 {
+		if runCtx.Err() != nil {
+			t.Fatal("通知后事件未发送")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Run("Outage", func(t *testing.T) {
+		// Broker 不可用时失败的事件按退避间隔重试，不会立即耗尽重试次数
+		store, err := producer.NewFileOutboxStore(filepath.Join(t.TempDir(), "outbox.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		for i := 0; i < 4; i++ {
+			store.Append(ctx, producer.OutboxRecord{AggregateID: strconv.Itoa(i), Value: "v"})
+		}
+
+		var sends int64
+		down := &flakyProducer{Producer: sp, fail: func(value string) error {
+			atomic.AddInt64(&sends, 1)
+			return errors.New("broker不可用")
+		}}
+		relay := producer.NewOutboxRelay(store, down)
+		relay.SetBatchSize(4)
+		relay.SetPollInterval(5 * time.Millisecond)
+		relay.SetRetryBackoff(100 * time.Millisecond)
+
+		runCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+		defer cancel()
+		relay.Start(runCtx)
+
+		// 第一次发送后100ms重试，下一次重试在300ms之后
+		if n := atomic.LoadInt64(&sends); n < 4 || n > 8 {
+			t.Errorf("150ms 内发送 %d 次，期望每条事件最多2次", n)
+		}
+		if pending, _ := store.Pending(ctx, 10); len(pending) != 4 || pending[0].Attempts > 2 {
+			t.Errorf("事件不应成为毒丸: %+v", pending)
+		}
+	})
+}
+
+// TestPartitioners 测试分区器：Java兼容的murmur2、粘性、一致性哈希、显式分区和按消息头分区
+func TestPartitioners(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Murmur2", func(t *testing.T) {
+		// Kafka Java 客户端 UtilsTest 中的测试数据
+		cases := map[string]int32{
+			"21":                         -973932308,
+			"foobar":                     -790332482,
+			"a-little-bit-long-string":   -985981536,
+			"a-little-bit-longer-string": -1486304829,
+			"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+			"abc": 479470107,
+		}
+		for key, want := range cases {
+			if got := int32(partitioner.Murmur2Hash([]byte(key))); got != want {
+				t.Errorf("murmur2(%q) = %d, 期望 %d", key, got, want)
+			}
+		}
+
+		partitions := []int{0, 1, 2, 3, 4, 5}
+		b := &partitioner.Murmur2{}
+		java := kafka.Murmur2Balancer{Consistent: true}
+		for i := 0; i < 100; i++ {
+			msg := kafka.Message{Key: []byte("order-" + strconv.Itoa(i))}
+			if got, want := b.Balance(msg, partitions...), java.Balance(msg, partitions...); got != want {
+				t.Errorf("key %s 分区 %d，期望 %d", msg.Key, got, want)
+			}
+		}
+	})
+
+	t.Run("Sticky", func(t *testing.T) {
+		b := &partitioner.Sticky{BatchBytes: 100}
+		partitions := []int{0, 1, 2, 3}
+		msg := kafka.Message{Value: make([]byte, 10)}
+
+		first := b.Balance(msg, partitions...)
+		for i := 1; i < 10; i++ {
+			if p := b.Balance(msg, partitions...); p != first {
+				t.Fatalf("第 %d 条消息换到了分区 %d", i+1, p)
+			}
+		}
+		if p := b.Balance(msg, partitions...); p == first {
+			t.Errorf("写满 BatchBytes 后应切换分区")
+		}
+		keyed := kafka.Message{Key: []byte("k")}
+		if b.Balance(keyed, partitions...) != (&partitioner.Murmur2{}).Balance(keyed, partitions...) {
+			t.Errorf("有key的消息应按murmur2选择分区")
+		}
+	})
+
+	t.Run("ConsistentHash", func(t *testing.T) {
+		b := &partitioner.ConsistentHash{}
+		before, after := []int{0, 1, 2, 3, 4, 5, 6, 7}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}
+
+		moved := 0
+		for i := 0; i < 1000; i++ {
+			msg := kafka.Message{Key: []byte("user-" + strconv.Itoa(i))}
+			p1, p2 := b.Balance(msg, before...), b.Balance(msg, after...)
+			if p1 != p2 {
+				moved++
+				if p2 != 8 {
+					t.Errorf("扩容后 key %s 从 %d 移到了旧分区 %d", msg.Key, p1, p2)
+				}
+			}
+		}
+		if moved == 0 || moved > 200 {
+			t.Errorf("扩容后移动了 %d/1000 个key", moved)
+		}
+	})
+
+	t.Run("Explicit", func(t *testing.T) {
+		cfg, broker := newTestConfig("explicit-topic", "")
+		broker.CreateTopic("explicit-topic", 4)
+
+		p := producer.NewSimpleProducer(cfg)
+		p.SetPartitioner(&partitioner.Explicit{})
+		p.Connect()
+		defer p.Close()
+
+		if err := p.SendMessageWithHeaders(ctx, "k", "v", map[string]string{partitioner.HeaderPartition: "2"}); err != nil {
+			t.Fatalf("发送失败: %v", err)
+		}
+		if msgs := broker.Messages("explicit-topic"); len(msgs) != 1 || msgs[0].Partition != 2 {
+			t.Errorf("消息应写入分区2: %+v", msgs)
+		}
+		if err := p.SendMessageWithHeaders(ctx, "k", "v", map[string]string{partitioner.HeaderPartition: "9"}); err == nil {
+			t.Errorf("分区不存在时应发送失败")
+		}
+	})
+
+	t.Run("ByHeader", func(t *testing.T) {
+		cfg, broker := newTestConfig("tenant-topic", "")
+		broker.CreateTopic("tenant-topic", 8)
+		cfg.Producer.Partitioner = "header"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "producer.partition_header") {
+			t.Errorf("缺少 partition_header 应校验失败: %v", err)
+		}
+		cfg.Producer.PartitionHeader = "tenant"
+
+		kc := client.NewClient(cfg)
+		defer kc.Close()
+		pw, err := kc.Producer().Build()
+		if err != nil {
+			t.Fatalf("创建生产者失败: %v", err)
+		}
+		for i := 0; i < 20; i++ {
+			pw.SendWithHeaders(ctx, "order-"+strconv.Itoa(i), "v", map[string]string{"tenant": "acme"})
+		}
+
+		partitions := make(map[int]bool)
+		for _, msg := range broker.Messages("tenant-topic") {
+			partitions[msg.Partition] = true
+		}
+		if len(partitions) != 1 {
+			t.Errorf("相同租户的消息应写入同一分区: %v", partitions)
+		}
+
+		// 构建器设置的分区器优先于配置
+		pw2, _ := kc.Producer().WithPartitioner(&partitioner.Explicit{}).Build()
+		pw2.SendWithHeaders(ctx, "k", "v", map[string]string{"tenant": "acme", partitioner.HeaderPartition: "7"})
+		msgs := broker.Messages("tenant-topic")
+		if last := msgs[len(msgs)-1]; last.Partition != 7 {
+			t.Errorf("WithPartitioner 未生效，分区 %d", last.Partition)
+		}
+	})
+}
+
+// TestProducerSpool 测试生产者的本地磁盘缓存：发送失败写入缓存、重启后按顺序重放、损坏检测和容量限制
+func TestProducerSpool(t *testing.T) {
+	ctx := context.Background()
+
+	waitDrained := func(t *testing.T, source metrics.SpoolSource) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for source.SpoolStats().Messages > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if st := source.SpoolStats(); st.Messages != 0 || st.Segments != 0 {
+			t.Fatalf("缓存未重放完: %+v", st)
+		}
+	}
+
+	t.Run("ReplayAfterRestart", func(t *testing.T) {
+		cfg, broker := newTestConfig("spool-topic", "")
+		broker.CreateTopic("spool-topic", 1)
+		cfg.Producer.Spool = config.SpoolConfig{Dir: t.TempDir(), RetryInterval: config.Duration(20 * time.Millisecond)}
+		broker.SetWriteError(errors.New("broker不可用"))
+
+		// 发送失败的消息写入缓存，Flush 不返回错误
+		bp := producer.NewBatchProducer(cfg, producer.WithBatchSize(100))
+		bp.Connect()
+		for i := 0; i < 5; i++ {
+			bp.Send(strconv.Itoa(i), "value-"+strconv.Itoa(i))
+		}
+		if err := bp.Flush(); err != nil {
+			t.Fatalf("写入缓存的消息不应返回错误: %v", err)
+		}
+		if st := bp.SpoolStats(); st.Messages != 5 || st.Batches != 1 {
+			t.Errorf("期望缓存5条消息，得到 %+v", st)
+		}
+		bp.Close()
+
+		// 重启后缓存仍在，新的批次追加到缓存末尾
+		bp = producer.NewBatchProducer(cfg, producer.WithBatchSize(100))
+		bp.Connect()
+		if st := bp.SpoolStats(); st.Messages != 5 {
+			t.Errorf("重启后期望缓存5条消息，得到 %+v", st)
+		}
+		for i := 5; i < 8; i++ {
+			bp.Send(strconv.Itoa(i), "value-"+strconv.Itoa(i))
+		}
+		bp.Flush()
+		bp.Close()
+
+		// 连接恢复后由异步生产者按写入顺序重放，之后的新消息在缓存的消息之后写入
+		broker.SetWriteError(nil)
+		ap := producer.NewAsyncProducer(cfg, nil)
+		ap.Connect()
+		waitDrained(t, ap)
+		d, _ := ap.SendAsync("8", "value-8")
+		if _, err := d.Wait(ctx); err != nil {
+			t.Fatalf("投递失败: %v", err)
+		}
+		ap.Close()
+
+		msgs := broker.Messages("spool-topic")
+		if len(msgs) != 9 {
+			t.Fatalf("期望9条消息，得到 %d", len(msgs))
+		}
+		for i, msg := range msgs {
+			if string(msg.Value) != "value-"+strconv.Itoa(i) {
+				t.Errorf("第%d条消息顺序错误: %s", i, msg.Value)
+			}
+		}
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		cfg, broker := newTestConfig("spool-idem", "")
+		broker.CreateTopic("spool-idem", 3)
+		cfg.Producer.Idempotent = true
+		cfg.Producer.Partitioner = "murmur2"
+		cfg.Producer.Spool = config.SpoolConfig{Dir: t.TempDir(), RetryInterval: config.Duration(20 * time.Millisecond)}
+		broker.SetWriteError(errors.New("broker不可用"))
+
+		bp := producer.NewBatchProducer(cfg, producer.WithBatchSize(100))
+		bp.Connect()
+		defer bp.Close()
+		for i := 0; i < 9; i++ {
+			bp.Send("key-"+strconv.Itoa(i), strconv.Itoa(i))
+		}
+		bp.Flush()
+
+		broker.SetWriteError(nil)
+		waitDrained(t, bp)
+
+		// 重放的消息写入分配序列号时的分区，每个分区的序列号从0连续递增，Dedup 不会丢弃
+		msgs := broker.Messages("spool-idem")
+		if len(msgs) != 9 {
+			t.Fatalf("期望9条消息，得到 %d", len(msgs))
+		}
+		next := make(map[int]int)
+		for _, msg := range msgs {
+			for _, h := range msg.Headers {
+				if h.Key == producer.HeaderSequence {
+					if string(h.Value) != strconv.Itoa(next[msg.Partition]) {
+						t.Errorf("分区 %d 的序列号 %s 不连续", msg.Partition, h.Value)
+					}
+					next[msg.Partition]++
+				}
+			}
+		}
+		if len(next) < 2 {
+			t.Errorf("消息应按key分布到多个分区: %v", next)
+		}
+
+		dedup := middleware.Dedup(middleware.NewMemoryDedupStore(100))
+		var handled int
+		handler := dedup(func(ctx context.Context, msg kafka.Message) error {
+			handled++
+			return nil
+		})
+		for _, msg := range msgs {
+			handler(ctx, msg)
+		}
+		if handled != 9 {
+			t.Errorf("Dedup 期望处理9条消息，得到 %d", handled)
+		}
+	})
+
+	t.Run("AsyncOrder", func(t *testing.T) {
+		// 没有幂等时启用缓存的批次也依次写入缓存和发送，重放后保持发送顺序
+		cfg, broker := newTestConfig("spool-order", "")
+		broker.CreateTopic("spool-order", 1)
+		cfg.Producer.BatchSize = 1
+		cfg.Producer.Spool = config.SpoolConfig{Dir: t.TempDir(), RetryInterval: config.Duration(20 * time.Millisecond)}
+		broker.SetWriteError(errors.New("broker不可用"))
+
+		ap := producer.NewAsyncProducer(cfg, nil)
+		ap.Connect()
+		defer ap.Close()
+		for i := 0; i < 500; i++ {
+			if _, err := ap.SendAsync(strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ap.Flush()
+
+		broker.SetWriteError(nil)
+		waitDrained(t, ap)
+		msgs := broker.Messages("spool-order")
+		if len(msgs) != 500 {
+			t.Fatalf("期望500条消息，得到 %d", len(msgs))
+		}
+		for i, msg := range msgs {
+			if string(msg.Value) != strconv.Itoa(i) {
+				t.Fatalf("第%d条消息顺序错误: %s", i, msg.Value)
+			}
+		}
+	})
+
+	t.Run("AsyncSpooled", func(t *testing.T) {
+		cfg, broker := newTestConfig("spool-topic", "")
+		cfg.Producer.Spool = config.SpoolConfig{Dir: t.TempDir(), RetryInterval: config.Duration(20 * time.Millisecond)}
+		broker.SetWriteError(errors.New("broker不可用"))
+
+		var spooled int64
+		ap := producer.NewAsyncProducer(cfg, func(msg kafka.Message, err error) {
+			if errors.Is(err, producer.ErrSpooled) {
+				atomic.AddInt64(&spooled, 1)
+			}
+		})
+		ap.Connect()
+		defer ap.Close()
+
+		d, _ := ap.Produce(ctx, kafka.Message{Key: []byte("k"), Value: []byte("v"), Headers: []kafka.Header{{Key: "h", Value: []byte("1")}}})
+		if _, err := d.Wait(ctx); !errors.Is(err, producer.ErrSpooled) {
+			t.Fatalf("期望 ErrSpooled，得到 %v", err)
+		}
+		// Wait 在回调之前返回
+		for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&spooled) == 0 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if atomic.LoadInt64(&spooled) != 1 {
+			t.Errorf("回调应报告 ErrSpooled")
+		}
+
+		// 缓存深度通过指标报告
+		m := metrics.NewMetrics()
+		m.ObserveSpool(ap)
+		if n := m.Snapshot()["spool_messages"]; n != int64(1) {
+			t.Errorf("spool_messages 期望1，得到 %v", n)
+		}
+
+		broker.SetWriteError(nil)
+		waitDrained(t, ap)
+		msgs := broker.Messages("spool-topic")
+		if len(msgs) != 1 || string(msgs[0].Headers[0].Value) != "1" {
+			t.Errorf("重放的消息不完整: %+v", msgs)
+		}
+	})
+
+	t.Run("Corruption", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := spool.Open(config.SpoolConfig{Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			s.Append([]kafka.Message{{Value: []byte("batch-" + strconv.Itoa(i))}})
+		}
+		s.Close()
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		if len(segments) != 1 {
+			t.Fatalf("期望1个段文件，得到 %v", segments)
+		}
+		data, _ := os.ReadFile(segments[0])
+
+		// 崩溃时写了一半的记录在打开时截断
+		os.WriteFile(segments[0], data[:len(data)-3], 0o644)
+		s, _ = spool.Open(config.SpoolConfig{Dir: dir})
+		if st := s.Stats(); st.Batches != 2 || st.Corrupted != 0 {
+			t.Errorf("期望截断最后一个批次，得到 %+v", st)
+		}
+		s.Close()
+
+		// 校验和不匹配的记录及之后的数据丢弃
+		data, _ = os.ReadFile(segments[0])
+		data[len(data)-2] ^= 0xff
+		os.WriteFile(segments[0], data, 0o644)
+		s, _ = spool.Open(config.SpoolConfig{Dir: dir})
+		defer s.Close()
+		if st := s.Stats(); st.Batches != 1 || st.Corrupted != 1 {
+			t.Errorf("期望丢弃损坏的批次，得到 %+v", st)
+		}
+
+		var replayed []string
+		n, err := s.Replay(ctx, func(ctx context.Context, msgs []kafka.Message) error {
+			for _, msg := range msgs {
+				replayed = append(replayed, string(msg.Value))
+			}
+			return nil
+		})
+		if err != nil || n != 1 || len(replayed) != 1 || replayed[0] != "batch-0" {
+			t.Errorf("重放结果错误: %d %v %v", n, replayed, err)
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		dir := t.TempDir()
+		s, _ := spool.Open(config.SpoolConfig{Dir: dir, SegmentBytes: 100, MaxBytes: 400, MaxAge: config.Duration(50 * time.Millisecond)})
+		defer s.Close()
+
+		batch := []kafka.Message{{Value: []byte(strings.Repeat("x", 50))}}
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			err = s.Append(batch)
+		}
+		if !errors.Is(err, spool.ErrFull) {
+			t.Fatalf("期望 ErrFull，得到 %v", err)
+		}
+		if st := s.Stats(); st.Segments < 2 || st.Bytes > 400 {
+			t.Errorf("段文件应按 SegmentBytes 滚动且总大小不超过 MaxBytes: %+v", st)
+		}
+
+		// 重放失败时批次保留
+		failed := errors.New("发送失败")
+		if _, err := s.Replay(ctx, func(context.Context, []kafka.Message) error { return failed }); err != failed {
+			t.Errorf("期望返回发送错误，得到 %v", err)
+		}
+		pending := s.Stats().Messages
+
+		// 超过 MaxAge 的段文件丢弃，不再发送
+		time.Sleep(80 * time.Millisecond)
+		n, _ := s.Replay(ctx, func(context.Context, []kafka.Message) error { return nil })
+		if st := s.Stats(); n != 0 || st.Expired != pending || st.Segments != 0 {
+			t.Errorf("过期的消息应丢弃: 重放 %d, %+v", n, st)
+		}
+		if err := s.Append(batch); err != nil {
+			t.Errorf("丢弃后应可继续写入: %v", err)
+		}
+	})
+}
+
+type orderEvent struct {
+	Type    string `json:"type"`
+	OrderID string `json:"order_id"`
+	Data    struct {
+		ID     string  `json:"id"`
+		Amount float64 `json:"amount"`
+	} `json:"data"`
+}
+
+// TestOrderSystemFlow 测试订单系统示例流程：批量生产者 + 带中间件的消费者
+func TestOrderSystemFlow(t *testing.T) {
+	cfg, _ := newTestConfig("orders", "order-service")
+
+	p := producer.NewBatchProducer(cfg, producer.WithBatchSize(10), producer.WithCompression(kafka.Snappy))
+	p.Connect()
+
+	for i := 0; i < 5; i++ {
+		var event orderEvent
+		event.Type = "created"
+		event.OrderID = fmt.Sprintf("ORD-%d", i)
+		event.Data.ID = event.OrderID
+		event.Data.Amount = 1199.98
+		data, _ := json.Marshal(event)
+		p.Send(event.OrderID, string(data))
+	}
+	p.Close()
+
+	var attempts int64
+	processed := make(chan orderEvent, 5)
+
+	chain := middleware.Chain(
+		middleware.Recovery(),
+		middleware.Retry(3, time.Millisecond),
+	)
+	handler := chain(func(ctx context.Context, msg kafka.Message) error {
+		var event orderEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			return err
+		}
+		// 第一次处理失败，由Retry中间件重试
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			return fmt.Errorf("模拟处理失败")
+		}
+		processed <- event
+		return nil
+	})
+
+	c := consumer.NewSimpleConsumer(cfg, -1)
+	c.Connect()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go c.Start(ctx, consumer.MessageHandler(handler))
+
+	for i := 0; i < 5; i++ {
+		select {
+		case event := <-processed:
+			if event.Type != "created" || event.Data.Amount != 1199.98 {
+				t.Errorf("订单事件内容错误: %+v", event)
+			}
+		case <-ctx.Done():
+			t.Fatalf("测试超时，只处理了 %d 个订单", i)
+		}
+	}
+}
+
+// BenchmarkProducer 生产者性能测试
+func BenchmarkProducer(b *testing.B) {
+	cfg, _ := newTestConfig("benchmark-topic", "")
+
+	p := producer.NewBatchProducer(cfg)
+	if err := p.Connect(); err != nil {
+		b.Fatal("连接失败:", err)
+	}
+	defer p.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Send("benchmark-key", "benchmark-value")
+	}
+	p.Flush()
+}
+
+// TestIntegration 集成测试：生产后由消费者组消费并提交偏移量
+func TestIntegration(t *testing.T) {
+	cfg, broker := newTestConfig("integration-test-topic", "integration-test-group")
+
+	// 生产者
+	p := producer.NewSimpleProducer(cfg)
+	if err := p.Connect(); err != nil {
+		t.Fatalf("连接生产者失败: %v", err)
+	}
+
+	// 发送测试消息
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := p.SendMessage(ctx, "integration-key", "integration-value"); err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+	}
+	p.Close()
+
+	// 消费者
+	var received int64
+	done := make(chan bool)
+
+	c := consumer.NewSimpleConsumer(cfg, -1)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("连接消费者失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	go func() {
+		c.Start(ctx, func(ctx context.Context, msg kafka.Message) error {
+			t.Logf("收到消息: %s", string(msg.Value))
+			if atomic.AddInt64(&received, 1) == 5 {
+				done <- true
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+		t.Logf("成功消费 %d 条消息", atomic.LoadInt64(&received))
+	case <-ctx.Done():
+		t.Fatalf("测试超时，只收到 %d 条消息", atomic.LoadInt64(&received))
+	}
+
+	cancel()
+	c.Close()
+
+	if offset := broker.CommittedOffset("integration-test-group", "integration-test-topic", 0); offset != 5 {
+		t.Errorf("期望提交偏移量5，得到 %d", offset)
+	}
 }
@@ -9,6 +9,10 @@ import (
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/middleware"
+	"go-kafka/producer"
+	"go-kafka/spool"
 )
 
 // Metrics 指标收集器
@@ -30,13 +34,25 @@ type Metrics struct {
 	ConnectionErrors uint64
 	RebalanceEvents  uint64
 
+	// 熔断器指标
+	CircuitBreakerState int32 // middleware.BreakerState
+	CircuitBreakerTrips uint64
+
 	mu       sync.RWMutex
 	handlers []MetricsHandler
+	spools   []SpoolSource
 	started  bool
+	interval time.Duration
+	ticker   *time.Ticker
 	ctx      context.Context
 	cancel   context.CancelFunc
 }
 
+// SpoolSource 带本地磁盘缓存的生产者，例如 producer.BatchProducer 和 producer.AsyncProducer
+type SpoolSource interface {
+	SpoolStats() spool.Stats
+}
+
 // MetricsHandler 指标处理器接口
 type MetricsHandler interface {
 	Handle(m *Metrics)
@@ -58,21 +74,26 @@ func (m *Metrics) RegisterHandler(handler MetricsHandler) {
 	m.handlers = append(m.handlers, handler)
 }
 
-// Start 启动指标报告
+// Start 启动指标报告，启动前调用过 SetInterval 时使用其设置的间隔
 func (m *Metrics) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+
 	m.mu.Lock()
 	if m.started {
 		m.mu.Unlock()
 		return
 	}
 	m.started = true
-	m.mu.Unlock()
-
-	if interval == 0 {
-		interval = 60 * time.Second
+	if m.interval > 0 {
+		interval = m.interval
 	}
+	m.interval = interval
+	m.ticker = time.NewTicker(interval)
+	ticker := m.ticker
+	m.mu.Unlock()
 
-	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -85,6 +106,36 @@ func (m *Metrics) Start(interval time.Duration) {
 	}
 }
 
+// SetInterval 修改指标报告间隔，报告已启动时立即生效，d 不大于0时恢复默认的60秒
+func (m *Metrics) SetInterval(d time.Duration) {
+	if d <= 0 {
+		d = 60 * time.Second
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.interval = d
+	if m.ticker != nil {
+		m.ticker.Reset(d)
+	}
+}
+
+// Interval 当前的指标报告间隔
+func (m *Metrics) Interval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.interval
+}
+
+// Reload 实时应用 observability.metrics.interval，启用或关闭指标需要重启
+func (m *Metrics) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	if changes.Has("observability.metrics.interval") {
+		m.SetInterval(cfg.Observability.Metrics.Interval.Std())
+	}
+	return changes.Fields("observability.metrics.enabled")
+}
+
 // Stop 停止指标报告
 func (m *Metrics) Stop() {
 	m.cancel()
@@ -131,8 +182,45 @@ func (m *Metrics) UpdateLag(lag int64) {
 	atomic.StoreInt64(&m.CurrentLag, lag)
 }
 
+// ObserveBreaker 跟踪熔断器的状态和熔断次数
+func (m *Metrics) ObserveBreaker(cb *middleware.CircuitBreaker) {
+	atomic.StoreInt32(&m.CircuitBreakerState, int32(cb.State()))
+	cb.OnStateChange(func(from, to middleware.BreakerState) {
+		atomic.StoreInt32(&m.CircuitBreakerState, int32(to))
+		if to == middleware.StateOpen {
+			atomic.AddUint64(&m.CircuitBreakerTrips, 1)
+		}
+	})
+}
+
+// ObserveSpool 跟踪生产者本地缓存的深度，多个生产者的缓存合计报告
+func (m *Metrics) ObserveSpool(source SpoolSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spools = append(m.spools, source)
+}
+
+// spoolStats 合计本地缓存的状态
+func (m *Metrics) spoolStats() spool.Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var total spool.Stats
+	for _, source := range m.spools {
+		st := source.SpoolStats()
+		total.Segments += st.Segments
+		total.Bytes += st.Bytes
+		total.Batches += st.Batches
+		total.Messages += st.Messages
+		total.Expired += st.Expired
+		total.Corrupted += st.Corrupted
+	}
+	return total
+}
+
 // Snapshot 获取指标快照
 func (m *Metrics) Snapshot() map[string]interface{} {
+	spooled := m.spoolStats()
 	return map[string]interface{}{
 		"messages_produced": atomic.LoadUint64(&m.MessagesProduced),
 		"bytes_produced":    atomic.LoadUint64(&m.BytesProduced),
@@ -141,6 +229,15 @@ func (m *Metrics) Snapshot() map[string]interface{} {
 		"bytes_consumed":    atomic.LoadUint64(&m.BytesConsumed),
 		"consume_errors":    atomic.LoadUint64(&m.ConsumeErrors),
 		"current_lag":       atomic.LoadInt64(&m.CurrentLag),
+
+		"circuit_breaker_state": middleware.BreakerState(atomic.LoadInt32(&m.CircuitBreakerState)).String(),
+		"circuit_breaker_trips": atomic.LoadUint64(&m.CircuitBreakerTrips),
+
+		"spool_messages":  spooled.Messages,
+		"spool_bytes":     spooled.Bytes,
+		"spool_segments":  spooled.Segments,
+		"spool_expired":   spooled.Expired,
+		"spool_corrupted": spooled.Corrupted,
 	}
 }
 
@@ -155,6 +252,7 @@ func (m *Metrics) Reset() {
 	atomic.StoreUint64(&m.ConsumeErrors, 0)
 	atomic.StoreInt64(&m.ConsumeLatency, 0)
 	atomic.StoreInt64(&m.CurrentLag, 0)
+	atomic.StoreUint64(&m.CircuitBreakerTrips, 0)
 }
 
 // String 返回字符串表示
@@ -205,46 +303,60 @@ func (h *PrometheusHandler) Handle(m *Metrics) {
 	fmt.Printf("# %s_messages_consumed %v\\n", h.prefix, snapshot["messages_consumed"])
 }
 
-// InstrumentedProducer 带指标的生产者包装器
+// InstrumentedProducer 带指标的生产者包装器，实现 producer.Producer 接口
 type InstrumentedProducer struct {
-	producer interface {
-		WriteMessages(ctx context.Context, msgs ...kafka.Message) error
-		Close() error
-	}
-	metrics *Metrics
+	producer producer.Producer
+	metrics  *Metrics
 }
 
-func NewInstrumentedProducer(producer interface {
-	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
-	Close() error
-}, metrics *Metrics) *InstrumentedProducer {
+var _ producer.Producer = (*InstrumentedProducer)(nil)
+
+func NewInstrumentedProducer(producer producer.Producer, metrics *Metrics) *InstrumentedProducer {
 	return &InstrumentedProducer{
 		producer: producer,
 		metrics:  metrics,
 	}
 }
 
-func (p *InstrumentedProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+func (p *InstrumentedProducer) SendMessage(ctx context.Context, key, value string) error {
 	start := time.Now()
+	err := p.producer.SendMessage(ctx, key, value)
+	p.record(len(value), time.Since(start), err)
+	return err
+}
 
-	totalBytes := 0
-	for _, msg := range msgs {
-		totalBytes += len(msg.Value)
-	}
-
-	err := p.producer.WriteMessages(ctx, msgs...)
-
-	latency := time.Since(start)
+func (p *InstrumentedProducer) SendMessageWithHeaders(
+	ctx context.Context,
+	key, value string,
+	headers map[string]string,
+) error {
+	start := time.Now()
+	err := p.producer.SendMessageWithHeaders(ctx, key, value, headers)
+	p.record(len(value), time.Since(start), err)
+	return err
+}
 
+// record 记录发送结果
+func (p *InstrumentedProducer) record(bytes int, latency time.Duration, err error) {
 	if err != nil {
 		p.metrics.RecordProduceError()
 	} else {
-		p.metrics.RecordProduced(totalBytes, latency)
+		p.metrics.RecordProduced(bytes, latency)
 	}
+}
 
+func (p *InstrumentedProducer) Flush() error {
+	err := p.producer.Flush()
+	if err != nil {
+		p.metrics.RecordProduceError()
+	}
 	return err
 }
 
+func (p *InstrumentedProducer) Stats() kafka.WriterStats {
+	return p.producer.Stats()
+}
+
 func (p *InstrumentedProducer) Close() error {
 	return p.producer.Close()
 }
@@ -287,3 +399,19 @@ func (c *InstrumentedConsumer) ReadMessage(ctx context.Context) (kafka.Message,
 func (c *InstrumentedConsumer) Close() error {
 	return c.consumer.Close()
 }
+
+// Middleware 返回记录消费指标的中间件，处理时间作为消费延迟
+func (m *Metrics) Middleware() middleware.Middleware {
+	return func(next middleware.HandlerFunc) middleware.HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err != nil {
+				m.RecordConsumeError()
+			} else {
+				m.RecordConsumed(len(msg.Value), time.Since(start))
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrCircuitOpen 熔断器打开时拒绝请求返回的错误
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// errPanicked 被保护的函数panic时计入的失败
+var errPanicked = errors.New("protected function panicked")
+
+// BreakerState 熔断器状态
+type BreakerState int32
+
+const (
+	StateClosed   BreakerState = iota // 关闭：正常放行
+	StateOpen                         // 打开：拒绝所有请求
+	StateHalfOpen                     // 半开：只放行有限个探测请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats 熔断器统计
+type BreakerStats struct {
+	State     BreakerState
+	Requests  uint64 // 放行的请求数
+	Successes uint64
+	Failures  uint64
+	Rejected  uint64 // 被拒绝的请求数
+	Trips     uint64 // 熔断次数
+}
+
+// breakerBuckets 滑动窗口的桶数
+const breakerBuckets = 10
+
+// bucket 滑动窗口中的一个时间桶
+type bucket struct {
+	epoch    int64 // 桶对应的时间片序号
+	total    int
+	failures int
+}
+
+// CircuitBreaker 熔断器，可在多个协程中并发使用
+// 默认连续失败 threshold 次后熔断，也可以使用滑动窗口失败率熔断；
+// 熔断 resetTime 后进入半开状态，放行有限个探测请求，全部成功后恢复
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	threshold int
+	resetTime time.Duration
+	openedAt  time.Time
+	failures  int // 连续失败次数
+
+	// 半开状态
+	halfOpenProbes int
+	probing        int
+	probeSuccesses int
+
+	// 滑动窗口
+	failureRate float64
+	window      time.Duration
+	minRequests int
+	buckets     [breakerBuckets]bucket
+
+	pauseOnOpen bool
+	changed     chan struct{} // 状态变化时关闭并替换，用于唤醒暂停中的请求
+	callbacks   []func(from, to BreakerState)
+	stats       BreakerStats
+}
+
+// BreakerOption 熔断器配置选项
+type BreakerOption func(*CircuitBreaker)
+
+// WithHalfOpenProbes 设置半开状态放行的探测请求数，全部成功后关闭熔断器
+func WithHalfOpenProbes(n int) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		if n > 0 {
+			cb.halfOpenProbes = n
+		}
+	}
+}
+
+// WithFailureRate 使用滑动窗口失败率熔断
+// window 内请求数不少于 minRequests 且失败率达到 rate 时熔断，可与连续失败次数同时生效
+func WithFailureRate(rate float64, window time.Duration, minRequests int) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		if rate > 0 && window > 0 {
+			cb.failureRate = rate
+			cb.window = window
+			cb.minRequests = minRequests
+		}
+	}
+}
+
+// WithStateChange 设置状态变化回调
+func WithStateChange(fn func(from, to BreakerState)) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.callbacks = append(cb.callbacks, fn)
+	}
+}
+
+// WithPauseOnOpen 熔断时中间件阻塞等待恢复而不是返回错误
+// 处理函数阻塞会让消费循环停止读取，相当于暂停reader，消息不会因熔断而被跳过；ctx取消时停止等待
+func WithPauseOnOpen() BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.pauseOnOpen = true
+	}
+}
+
+// NewCircuitBreaker 创建熔断器
+// threshold: 连续失败次数阈值，<=0 时只使用失败率；resetTime: 熔断后进入半开状态的等待时间
+func NewCircuitBreaker(threshold int, resetTime time.Duration, options ...BreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		threshold:      threshold,
+		resetTime:      resetTime,
+		state:          StateClosed,
+		halfOpenProbes: 1,
+		changed:        make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(cb)
+	}
+
+	return cb
+}
+
+// OnStateChange 添加状态变化回调，回调在锁外同步执行
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to BreakerState)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.callbacks = append(cb.callbacks, fn)
+}
+
+// State 返回当前状态
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// 打开状态超时后按半开状态报告，实际转换在下一次请求时发生
+	if cb.state == StateOpen && time.Since(cb.openedAt) >= cb.resetTime {
+		return StateHalfOpen
+	}
+	return cb.state
+}
+
+// Stats 返回统计信息
+func (cb *CircuitBreaker) Stats() BreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	stats := cb.stats
+	stats.State = cb.state
+	return stats
+}
+
+// Execute 在熔断器保护下执行fn，熔断时返回 ErrCircuitOpen
+// fn panic 时计为失败并继续panic，半开状态的探测名额同样会释放
+func (cb *CircuitBreaker) Execute(fn func() error) error {
+	state, err := cb.allow()
+	if err != nil {
+		return err
+	}
+
+	completed := false
+	defer func() {
+		if !completed {
+			cb.record(state, errPanicked)
+		}
+	}()
+
+	err = fn()
+	completed = true
+	cb.record(state, err)
+	return err
+}
+
+// Middleware 返回熔断中间件
+func (cb *CircuitBreaker) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			for {
+				err := cb.Execute(func() error { return next(ctx, msg) })
+				if !errors.Is(err, ErrCircuitOpen) || !cb.pauseOnOpen {
+					return err
+				}
+				if err := cb.wait(ctx); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// allow 判断是否放行请求，返回放行时的状态
+func (cb *CircuitBreaker) allow() (BreakerState, error) {
+	cb.mu.Lock()
+	var notify func()
+	defer func() {
+		cb.mu.Unlock()
+		if notify != nil {
+			notify()
+		}
+	}()
+
+	if cb.state == StateOpen {
+		if time.Since(cb.openedAt) < cb.resetTime {
+			cb.stats.Rejected++
+			return cb.state, ErrCircuitOpen
+		}
+		notify = cb.transition(StateHalfOpen)
+	}
+
+	if cb.state == StateHalfOpen {
+		if cb.probing+cb.probeSuccesses >= cb.halfOpenProbes {
+			cb.stats.Rejected++
+			return cb.state, ErrCircuitOpen
+		}
+		cb.probing++
+	}
+
+	cb.stats.Requests++
+	return cb.state, nil
+}
+
+// record 记录请求结果并更新状态
+func (cb *CircuitBreaker) record(state BreakerState, err error) {
+	cb.mu.Lock()
+	var notify func()
+	defer func() {
+		cb.mu.Unlock()
+		if notify != nil {
+			notify()
+		}
+	}()
+
+	if err != nil {
+		cb.stats.Failures++
+	} else {
+		cb.stats.Successes++
+	}
+
+	if state == StateHalfOpen {
+		// 探测期间状态可能已经改变，只在仍处于半开时处理探测结果
+		if cb.state != StateHalfOpen {
+			return
+		}
+		cb.probing--
+		if err != nil {
+			notify = cb.transition(StateOpen)
+			return
+		}
+		if cb.probeSuccesses++; cb.probeSuccesses >= cb.halfOpenProbes {
+			notify = cb.transition(StateClosed)
+		}
+		return
+	}
+
+	if cb.state != StateClosed {
+		return
+	}
+
+	now := time.Now()
+	cb.observe(now, err != nil)
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.tripped(now) {
+		notify = cb.transition(StateOpen)
+	}
+}
+
+// tripped 是否达到熔断条件
+func (cb *CircuitBreaker) tripped(now time.Time) bool {
+	if cb.threshold > 0 && cb.failures >= cb.threshold {
+		return true
+	}
+
+	if cb.failureRate <= 0 {
+		return false
+	}
+
+	total, failures := cb.windowCounts(now)
+	return total > 0 && total >= cb.minRequests && float64(failures)/float64(total) >= cb.failureRate
+}
+
+// observe 将请求结果计入滑动窗口
+func (cb *CircuitBreaker) observe(now time.Time, failed bool) {
+	if cb.failureRate <= 0 {
+		return
+	}
+
+	epoch := now.UnixNano() / cb.bucketWidth()
+	b := &cb.buckets[epoch%breakerBuckets]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+}
+
+// bucketWidth 每个桶的纳秒数，窗口小于 breakerBuckets 纳秒时为1
+func (cb *CircuitBreaker) bucketWidth() int64 {
+	if width := int64(cb.window / breakerBuckets); width > 0 {
+		return width
+	}
+	return 1
+}
+
+// windowCounts 统计滑动窗口内的请求数和失败数
+func (cb *CircuitBreaker) windowCounts(now time.Time) (total, failures int) {
+	epoch := now.UnixNano() / cb.bucketWidth()
+	for _, b := range cb.buckets {
+		if epoch-b.epoch < breakerBuckets {
+			total += b.total
+			failures += b.failures
+		}
+	}
+	return total, failures
+}
+
+// transition 切换状态，返回需要在锁外执行的通知函数
+func (cb *CircuitBreaker) transition(to BreakerState) func() {
+	from := cb.state
+	if from == to {
+		return nil
+	}
+
+	cb.state = to
+	switch to {
+	case StateOpen:
+		cb.openedAt = time.Now()
+		cb.stats.Trips++
+	case StateHalfOpen:
+		cb.probing = 0
+		cb.probeSuccesses = 0
+	case StateClosed:
+		cb.failures = 0
+		cb.buckets = [breakerBuckets]bucket{}
+	}
+
+	close(cb.changed)
+	cb.changed = make(chan struct{})
+
+	callbacks := make([]func(from, to BreakerState), len(cb.callbacks))
+	copy(callbacks, cb.callbacks)
+
+	return func() {
+		log.Printf("[CircuitBreaker] state changed: %s -> %s", from, to)
+		for _, fn := range callbacks {
+			fn(from, to)
+		}
+	}
+}
+
+// wait 等待状态变化或熔断超时，ctx取消时返回错误
+func (cb *CircuitBreaker) wait(ctx context.Context) error {
+	cb.mu.Lock()
+	changed := cb.changed
+	var timeout time.Duration
+	if cb.state == StateOpen {
+		timeout = cb.resetTime - time.Since(cb.openedAt)
+	} else {
+		// 半开状态等待探测结果
+		timeout = cb.resetTime
+	}
+	cb.mu.Unlock()
+
+	if timeout <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
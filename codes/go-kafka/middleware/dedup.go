@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/producer"
+)
+
+// DedupStore 记录每个生产者在每个分区上已处理的最大序列号
+type DedupStore interface {
+	// Last 返回key已处理的最大序列号，没有记录时 ok 为false
+	Last(key string) (seq int64, ok bool, err error)
+	// Save 记录已处理的序列号，只在seq大于已记录的值时更新
+	Save(key string, seq int64) error
+}
+
+// Dedup 去重中间件，丢弃幂等生产者（producer.idempotent）重发的消息
+// 按生产者ID、Topic和分区跟踪已处理的最大序列号，序列号不大于它的消息直接确认而不交给处理函数；
+// 处理成功后才记录序列号，处理失败重新投递的消息不会被丢弃。没有序列号消息头的消息不做去重
+// 并发处理时应使用按分区保序的分发器，否则同一分区中较小序列号的消息失败后重新投递会被丢弃
+func Dedup(store DedupStore) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			key, seq, ok := dedupKey(msg)
+			if !ok {
+				return next(ctx, msg)
+			}
+
+			last, found, err := store.Last(key)
+			if err != nil {
+				return fmt.Errorf("读取去重记录失败: %w", err)
+			}
+			if found && seq <= last {
+				log.Printf("[Dedup] duplicate message dropped: key=%s, seq=%d, last=%d", key, seq, last)
+				return nil
+			}
+
+			if err := next(ctx, msg); err != nil {
+				return err
+			}
+			if err := store.Save(key, seq); err != nil {
+				return fmt.Errorf("保存去重记录失败: %w", err)
+			}
+			return nil
+		}
+	}
+}
+
+// dedupKey 从消息头中读取生产者ID和序列号，key 格式为 生产者ID/Topic/分区
+func dedupKey(msg kafka.Message) (key string, seq int64, ok bool) {
+	var id, seqText string
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case producer.HeaderProducerID:
+			id = string(h.Value)
+		case producer.HeaderSequence:
+			seqText = string(h.Value)
+		}
+	}
+	if id == "" || seqText == "" {
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseInt(seqText, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return id + "/" + msg.Topic + "/" + strconv.Itoa(msg.Partition), seq, true
+}
+
+// MemoryDedupStore 内存去重记录，超过容量时淘汰最久未使用的记录
+type MemoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // 最近使用的在前
+	entries  map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key string
+	seq int64
+}
+
+var _ DedupStore = (*MemoryDedupStore)(nil)
+
+// NewMemoryDedupStore 创建内存去重记录，capacity 不大于0时为10000
+// 每个生产者实例在每个分区上占用一条记录，被淘汰的生产者重发的消息不再被识别
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	return &MemoryDedupStore{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Last 返回key已处理的最大序列号
+func (s *MemoryDedupStore) Last(key string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return 0, false, nil
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(*dedupEntry).seq, true, nil
+}
+
+// Save 记录已处理的序列号
+func (s *MemoryDedupStore) Save(key string, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		entry := e.Value.(*dedupEntry)
+		entry.seq = max(entry.seq, seq)
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&dedupEntry{key: key, seq: seq})
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).key)
+	}
+	return nil
+}
+
+// Len 当前的记录数
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// each 从最久未使用到最近使用依次访问记录
+func (s *MemoryDedupStore) each(fn func(key string, seq int64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*dedupEntry)
+		fn(entry.key, entry.seq)
+	}
+}
+
+// FileDedupStore 本地文件去重记录，进程重启后仍能识别重发的消息
+// 每次更新追加一行，打开时和追加的行数过多时压缩为每个key一行；
+// 与 MemoryDedupStore 相同，超过容量时淘汰最久未使用的记录，压缩时从文件中删除；重新打开时按写入顺序恢复使用顺序
+type FileDedupStore struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	cache *MemoryDedupStore
+	lines int
+}
+
+var _ DedupStore = (*FileDedupStore)(nil)
+
+// NewFileDedupStore 打开或创建去重记录文件，capacity 不大于0时为10000，不再使用时需要调用 Close
+func NewFileDedupStore(path string, capacity int) (*FileDedupStore, error) {
+	s := &FileDedupStore{
+		path:  path,
+		cache: NewMemoryDedupStore(capacity),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 按写入顺序读取已有的记录，忽略进程崩溃时写了一半的行
+func (s *FileDedupStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("打开去重记录失败: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, seqText, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseInt(seqText, 10, 64)
+		if err != nil {
+			continue
+		}
+		s.cache.Save(key, seq)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取去重记录失败: %w", err)
+	}
+	return nil
+}
+
+// compact 将当前记录写入临时文件后替换原文件
+func (s *FileDedupStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("创建去重记录失败: %w", err)
+	}
+
+	// 按使用顺序写入，重新打开时保持淘汰顺序
+	w := bufio.NewWriter(f)
+	s.cache.each(func(key string, seq int64) {
+		fmt.Fprintf(w, "%s\t%d\n", key, seq)
+	})
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("写入去重记录失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("写入去重记录失败: %w", err)
+	}
+	f.Close()
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("替换去重记录失败: %w", err)
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开去重记录失败: %w", err)
+	}
+	s.lines = s.cache.Len()
+
+	// 确保重命名持久化
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Last 返回key已处理的最大序列号
+func (s *FileDedupStore) Last(key string) (int64, bool, error) {
+	return s.cache.Last(key)
+}
+
+// Save 记录已处理的序列号并追加到文件
+func (s *FileDedupStore) Save(key string, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok, _ := s.cache.Last(key); ok && seq <= last {
+		return nil
+	}
+	if s.file == nil {
+		return fmt.Errorf("去重记录已关闭")
+	}
+
+	if _, err := fmt.Fprintf(s.file, "%s\t%d\n", key, seq); err != nil {
+		return err
+	}
+	s.cache.Save(key, seq)
+	s.lines++
+
+	// 被淘汰的记录和旧的行在压缩时删除
+	if s.lines > 2*s.cache.Len()+1024 {
+		return s.compact()
+	}
+	return nil
+}
+
+// Len 当前的记录数
+func (s *FileDedupStore) Len() int {
+	return s.cache.Len()
+}
+
+// Close 关闭记录文件
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
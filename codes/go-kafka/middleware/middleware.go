@@ -4,13 +4,22 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"runtime/debug"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
 // HandlerFunc 处理函数类型
-type HandlerFunc func(msg kafka.Message) error
+// ctx 来自消费者，消费者关闭时取消，并携带上游中间件设置的超时和追踪信息
+type HandlerFunc func(ctx context.Context, msg kafka.Message) error
+
+// Adapt 将不带ctx的处理函数转换为 HandlerFunc
+func Adapt(fn func(msg kafka.Message) error) HandlerFunc {
+	return func(ctx context.Context, msg kafka.Message) error {
+		return fn(msg)
+	}
+}
 
 // Middleware 中间件类型
 type Middleware func(HandlerFunc) HandlerFunc
@@ -18,27 +27,37 @@ type Middleware func(HandlerFunc) HandlerFunc
 // Chain 中间件链
 func Chain(middlewares ...Middleware) Middleware {
 	return func(final HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
+		return func(ctx context.Context, msg kafka.Message) error {
 			handler := final
 			for i := len(middlewares) - 1; i >= 0; i-- {
 				handler = middlewares[i](handler)
 			}
-			return handler(msg)
+			return handler(ctx, msg)
 		}
 	}
 }
 
+// PanicError 处理函数panic时 Recovery 返回的错误，保留panic值和调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic recovered: %v", e.Value)
+}
+
 // Recovery  panic 恢复中间件
 func Recovery() Middleware {
 	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) (err error) {
+		return func(ctx context.Context, msg kafka.Message) (err error) {
 			defer func() {
 				if r := recover(); r != nil {
-					err = fmt.Errorf("panic recovered: %v", r)
+					err = &PanicError{Value: r, Stack: debug.Stack()}
 					log.Printf("[Recovery] panic: %v, key: %s", r, string(msg.Key))
 				}
 			}()
-			return next(msg)
+			return next(ctx, msg)
 		}
 	}
 }
@@ -46,13 +65,13 @@ func Recovery() Middleware {
 // Logger 日志中间件
 func Logger() Middleware {
 	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
+		return func(ctx context.Context, msg kafka.Message) error {
 			start := time.Now()
 
 			log.Printf("[Before] partition=%d, offset=%d, key=%s",
 				msg.Partition, msg.Offset, string(msg.Key))
 
-			err := next(msg)
+			err := next(ctx, msg)
 
 			duration := time.Since(start)
 			if err != nil {
@@ -68,14 +87,14 @@ func Logger() Middleware {
 	}
 }
 
-// Retry 重试中间件
+// Retry 重试中间件，ctx取消时停止重试
 func Retry(maxRetries int, delay time.Duration) Middleware {
 	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
+		return func(ctx context.Context, msg kafka.Message) error {
 			var err error
 
 			for i := 0; i <= maxRetries; i++ {
-				err = next(msg)
+				err = next(ctx, msg)
 				if err == nil {
 					return nil
 				}
@@ -83,7 +102,12 @@ func Retry(maxRetries int, delay time.Duration) Middleware {
 				if i < maxRetries {
 					log.Printf("[Retry] attempt %d/%d failed for key=%s: %v",
 						i+1, maxRetries, string(msg.Key), err)
-					time.Sleep(delay * time.Duration(i+1)) // 指数退避
+
+					select {
+					case <-ctx.Done():
+						return err
+					case <-time.After(delay * time.Duration(i+1)): // 指数退避
+					}
 				}
 			}
 
@@ -92,76 +116,27 @@ func Retry(maxRetries int, delay time.Duration) Middleware {
 	}
 }
 
-// Timeout 超时中间件
+// Timeout 超时中间件，超时后取消传给处理函数的ctx
 func Timeout(d time.Duration) Middleware {
 	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
-			ctx, cancel := context.WithTimeout(context.Background(), d)
+		return func(ctx context.Context, msg kafka.Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
 			defer cancel()
 
 			done := make(chan error, 1)
 			go func() {
-				done <- next(msg)
+				done <- next(ctx, msg)
 			}()
 
 			select {
 			case err := <-done:
 				return err
 			case <-ctx.Done():
-				return fmt.Errorf("handler timeout after %v", d)
-			}
-		}
-	}
-}
-
-// CircuitBreaker 熔断中间件（简化版）
-type CircuitBreaker struct {
-	failures    int
-	threshold   int
-	lastFailure time.Time
-	resetTime   time.Duration
-	state       string // "closed", "open", "half-open"
-}
-
-func NewCircuitBreaker(threshold int, resetTime time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		threshold: threshold,
-		resetTime: resetTime,
-		state:     "closed",
-	}
-}
-
-func (cb *CircuitBreaker) Middleware() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
-			// 检查熔断状态
-			if cb.state == "open" {
-				if time.Since(cb.lastFailure) > cb.resetTime {
-					cb.state = "half-open"
-					cb.failures = 0
-					log.Printf("[CircuitBreaker] entering half-open state")
-				} else {
-					return fmt.Errorf("circuit breaker is open")
+				if ctx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf("handler timeout after %v: %w", d, ctx.Err())
 				}
+				return ctx.Err()
 			}
-
-			err := next(msg)
-
-			if err != nil {
-				cb.failures++
-				cb.lastFailure = time.Now()
-
-				if cb.failures >= cb.threshold {
-					cb.state = "open"
-					log.Printf("[CircuitBreaker] entering open state after %d failures", cb.failures)
-				}
-			} else if cb.state == "half-open" {
-				cb.state = "closed"
-				cb.failures = 0
-				log.Printf("[CircuitBreaker] entering closed state")
-			}
-
-			return err
 		}
 	}
 }
@@ -171,15 +146,16 @@ type DeadLetterHandler interface {
 	SendToDLQ(ctx context.Context, msg kafka.Message, err error) error
 }
 
+// DeadLetterQueue 处理失败的消息发送到死信队列，ctx已取消（消费者关闭）导致的失败不发送
 func DeadLetterQueue(dlqHandler DeadLetterHandler) Middleware {
 	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
-			err := next(msg)
-			if err != nil {
+		return func(ctx context.Context, msg kafka.Message) error {
+			err := next(ctx, msg)
+			if err != nil && ctx.Err() == nil {
 				log.Printf("[DLQ] sending message to DLQ: key=%s, error=%v",
 					string(msg.Key), err)
 
-				dlqErr := dlqHandler.SendToDLQ(context.Background(), msg, err)
+				dlqErr := dlqHandler.SendToDLQ(ctx, msg, err)
 				if dlqErr != nil {
 					log.Printf("[DLQ] failed to send to DLQ: %v", dlqErr)
 				}
@@ -188,48 +164,3 @@ func DeadLetterQueue(dlqHandler DeadLetterHandler) Middleware {
 		}
 	}
 }
-
-// RateLimiter 限流中间件
-type RateLimiter struct {
-	tokens   chan struct{}
-	interval time.Duration
-}
-
-func NewRateLimiter(rate int, per time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		tokens:   make(chan struct{}, rate),
-		interval: per / time.Duration(rate),
-	}
-
-	// 预填充令牌
-	for i := 0; i < rate; i++ {
-		rl.tokens <- struct{}{}
-	}
-
-	// 定时补充令牌
-	go func() {
-		ticker := time.NewTicker(rl.interval)
-		defer ticker.Stop()
-		for range ticker.C {
-			select {
-			case rl.tokens <- struct{}{}:
-			default:
-			}
-		}
-	}()
-
-	return rl
-}
-
-func (rl *RateLimiter) Middleware() Middleware {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(msg kafka.Message) error {
-			select {
-			case <-rl.tokens:
-				return next(msg)
-			default:
-				return fmt.Errorf("rate limit exceeded")
-			}
-		}
-	}
-}
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+)
+
+var (
+	// ErrRateLimited 非阻塞模式下没有可用令牌时返回的错误
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrLimiterStopped 限流器已停止
+	ErrLimiterStopped = errors.New("rate limiter stopped")
+)
+
+// KeyFunc 从消息中提取限流的key，相同key共享一个令牌桶
+type KeyFunc func(msg kafka.Message) string
+
+// ByMessageKey 按消息key限流
+func ByMessageKey() KeyFunc {
+	return func(msg kafka.Message) string {
+		return string(msg.Key)
+	}
+}
+
+// ByHeader 按消息头的值限流，没有该消息头的消息共享一个令牌桶
+func ByHeader(name string) KeyFunc {
+	return func(msg kafka.Message) string {
+		for _, h := range msg.Headers {
+			if h.Key == name {
+				return string(h.Value)
+			}
+		}
+		return ""
+	}
+}
+
+// tokenBucket 令牌桶，令牌按时间惰性补充，可以为负数表示已被预约
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter 令牌桶限流器，可在多个协程中并发使用
+// 令牌按 rate/per 的速度补充，最多积累 burst 个；默认阻塞等待令牌，可用于消费者中间件和生产者
+type RateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // 每秒补充的令牌数
+	burst    int
+	keyFunc  KeyFunc
+	blocking bool
+	idleTTL  time.Duration
+	buckets  map[string]*tokenBucket
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// RateLimiterOption 限流器配置选项
+type RateLimiterOption func(*RateLimiter)
+
+// WithBurst 设置令牌桶容量，即允许的突发请求数，默认等于 rate
+func WithBurst(n int) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		if n > 0 {
+			rl.burst = n
+		}
+	}
+}
+
+// WithKeyFunc 按key分别限流，每个key有独立的令牌桶
+func WithKeyFunc(fn KeyFunc) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.keyFunc = fn
+	}
+}
+
+// WithNonBlocking 没有令牌时立即返回 ErrRateLimited 而不是等待
+func WithNonBlocking() RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.blocking = false
+	}
+}
+
+// WithIdleTTL 设置按key限流时空闲令牌桶的回收时间，默认1分钟
+func WithIdleTTL(ttl time.Duration) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		if ttl > 0 {
+			rl.idleTTL = ttl
+		}
+	}
+}
+
+// NewRateLimiter 创建限流器，每 per 时间补充 rate 个令牌
+// 按key限流时会启动后台协程回收空闲的令牌桶，不再使用时需要调用 Stop
+func NewRateLimiter(rate int, per time.Duration, options ...RateLimiterOption) *RateLimiter {
+	if rate <= 0 {
+		rate = 1
+	}
+	if per <= 0 {
+		per = time.Second
+	}
+
+	rl := &RateLimiter{
+		rate:     float64(rate) / per.Seconds(),
+		burst:    rate,
+		blocking: true,
+		idleTTL:  1 * time.Minute,
+		buckets:  make(map[string]*tokenBucket),
+		stopped:  make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt(rl)
+	}
+
+	if rl.keyFunc != nil {
+		go rl.evictIdle()
+	}
+
+	return rl
+}
+
+// Allow 尝试获取key的一个令牌，不等待
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b := rl.bucketLocked(key, time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait 等待全局令牌桶的一个令牌，ctx取消或限流器停止时返回错误
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	return rl.WaitKey(ctx, "")
+}
+
+// WaitMessage 按 KeyFunc 提取key后等待令牌，未设置 KeyFunc 时使用全局令牌桶
+func (rl *RateLimiter) WaitMessage(ctx context.Context, msg kafka.Message) error {
+	return rl.WaitKey(ctx, rl.key(msg))
+}
+
+// WaitKey 等待key的一个令牌，ctx取消或限流器停止时返回错误
+func (rl *RateLimiter) WaitKey(ctx context.Context, key string) error {
+	select {
+	case <-rl.stopped:
+		return ErrLimiterStopped
+	default:
+	}
+
+	// 预约令牌，令牌不足时计算需要等待的时间
+	rl.mu.Lock()
+	b := rl.bucketLocked(key, time.Now())
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / rl.rate * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		rl.cancel(key)
+		return ctx.Err()
+	case <-rl.stopped:
+		rl.cancel(key)
+		return ErrLimiterStopped
+	}
+}
+
+// SetRate 修改限流速度，已积累的令牌按原速度结算后不超过新的容量，burst 不大于0时等于 rate
+func (rl *RateLimiter) SetRate(rate int, per time.Duration, burst int) {
+	if rate <= 0 {
+		rate = 1
+	}
+	if per <= 0 {
+		per = time.Second
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	for key := range rl.buckets {
+		b := rl.bucketLocked(key, now)
+		b.tokens = min(b.tokens, float64(burst))
+	}
+	rl.rate = float64(rate) / per.Seconds()
+	rl.burst = burst
+}
+
+// Reload 实时应用 consumer.rate_limit，rate 改为0即关闭限流时需要重新构建消费者
+// 用于生产者限流时应订阅 producer.RateLimitedProducer
+func (rl *RateLimiter) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	if !changes.Has("consumer.rate_limit") {
+		return nil
+	}
+
+	rc := cfg.Consumer.RateLimit
+	if rc.Rate <= 0 {
+		return []string{"consumer.rate_limit.rate"}
+	}
+	rl.SetRate(rc.Rate, rc.Per.Std(), rc.Burst)
+	return nil
+}
+
+// Middleware 返回限流中间件
+func (rl *RateLimiter) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			if !rl.blocking {
+				if !rl.Allow(rl.key(msg)) {
+					return ErrRateLimited
+				}
+				return next(ctx, msg)
+			}
+
+			if err := rl.WaitMessage(ctx, msg); err != nil {
+				return err
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Stop 停止限流器，正在等待的请求返回 ErrLimiterStopped
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		close(rl.stopped)
+	})
+}
+
+// Close 停止限流器
+func (rl *RateLimiter) Close() error {
+	rl.Stop()
+	return nil
+}
+
+// key 提取限流key
+func (rl *RateLimiter) key(msg kafka.Message) string {
+	if rl.keyFunc == nil {
+		return ""
+	}
+	return rl.keyFunc(msg)
+}
+
+// cancel 归还未使用的预约令牌
+func (rl *RateLimiter) cancel(key string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if b, ok := rl.buckets[key]; ok {
+		b.tokens = min(b.tokens+1, float64(rl.burst))
+	}
+}
+
+// bucketLocked 获取key的令牌桶并按经过的时间补充令牌
+func (rl *RateLimiter) bucketLocked(key string, now time.Time) *tokenBucket {
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = b
+		return b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*rl.rate, float64(rl.burst))
+		b.last = now
+	}
+	return b
+}
+
+// evictIdle 定期回收已补满且空闲的令牌桶
+func (rl *RateLimiter) evictIdle() {
+	ticker := time.NewTicker(rl.idleTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.stopped:
+			return
+		case now := <-ticker.C:
+			rl.mu.Lock()
+			for key, b := range rl.buckets {
+				if now.Sub(b.last) >= rl.idleTTL && rl.bucketLocked(key, now).tokens >= float64(rl.burst) {
+					delete(rl.buckets, key)
+				}
+			}
+			rl.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/serializer"
+)
+
+// PayloadValidator payload校验接口，serializer.Validator 实现了该接口
+type PayloadValidator interface {
+	Validate(subject string, payload []byte) error
+}
+
+// SubjectFunc 从消息中提取schema的subject
+type SubjectFunc func(msg kafka.Message) string
+
+// TopicSubject 按 Confluent 的 TopicNameStrategy 使用 "<topic>-value" 作为subject
+func TopicSubject() SubjectFunc {
+	return func(msg kafka.Message) string {
+		return msg.Topic + "-value"
+	}
+}
+
+// FixedSubject 所有消息使用同一个subject
+func FixedSubject(subject string) SubjectFunc {
+	return func(msg kafka.Message) string {
+		return subject
+	}
+}
+
+// ValidatePayload 校验中间件，消息不满足schema时不交给处理函数
+// dlq 不为nil时将无效消息发送到死信队列后跳过，发送失败时返回错误；dlq 为nil时直接返回校验错误
+// 应放在 Retry 之前，无效消息重试也不会成功
+// 校验的是 msg.Value，压缩或加密的消息返回 serializer.ErrEncodedPayload，这类Topic应使用 serializer.ValidatingSerializer
+func ValidatePayload(validator PayloadValidator, subject SubjectFunc, dlq DeadLetterHandler) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			// 压缩或加密的payload无法校验，属于配置错误，不发送死信队列
+			if serializer.Encoded(serializer.HeadersOf(msg)) {
+				return serializer.ErrEncodedPayload
+			}
+
+			err := validator.Validate(subject(msg), msg.Value)
+			if err == nil {
+				return next(ctx, msg)
+			}
+
+			log.Printf("[Validate] invalid message: partition=%d, offset=%d, error=%v",
+				msg.Partition, msg.Offset, err)
+			if dlq == nil {
+				return err
+			}
+			if dlqErr := dlq.SendToDLQ(ctx, msg, err); dlqErr != nil {
+				return fmt.Errorf("%w (发送死信队列失败: %v)", err, dlqErr)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build sqlite
+
+package kafka_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-kafka/producer"
+	_ "modernc.org/sqlite"
+)
+
+// TestSQLiteOutboxStore 使用纯Go的SQLite驱动测试发件箱，运行: go test -tags sqlite -run TestSQLiteOutboxStore .
+func TestSQLiteOutboxStore(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := producer.NewSQLiteOutboxStore(ctx, db, "outbox; DROP TABLE x"); err == nil {
+		t.Error("无效的表名应返回错误")
+	}
+	store, err := producer.NewSQLiteOutboxStore(ctx, db, "outbox")
+	if err != nil {
+		t.Fatalf("创建发件箱失败: %v", err)
+	}
+	// 表已存在时重新创建不报错
+	if _, err := producer.NewSQLiteOutboxStore(ctx, db, "outbox"); err != nil {
+		t.Fatalf("重新创建发件箱失败: %v", err)
+	}
+
+	// 业务事务回滚时事件一起回滚，提交后才可见
+	tx, _ := db.BeginTx(ctx, nil)
+	if _, err := store.AppendTx(ctx, tx, producer.OutboxRecord{AggregateID: "A", Value: "rolled-back"}); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if pending, _ := store.Pending(ctx, 10); len(pending) != 0 {
+		t.Errorf("回滚的事件不应可见: %+v", pending)
+	}
+
+	tx, _ = db.BeginTx(ctx, nil)
+	store.AppendTx(ctx, tx, producer.OutboxRecord{AggregateID: "A", Value: "a1", Headers: map[string]string{"type": "order"}})
+	tx.Commit()
+
+	old := time.Now().Add(-time.Hour)
+	for _, v := range []string{"b1", "a2", "c1"} {
+		if _, err := store.Append(ctx, producer.OutboxRecord{AggregateID: v[:1], Value: v, CreatedAt: old}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 按ID顺序返回，limit 生效
+	pending, err := store.Pending(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 3 || pending[0].Value != "a1" || pending[1].Value != "b1" || pending[2].Value != "a2" {
+		t.Fatalf("Pending 顺序错误: %+v", pending)
+	}
+	if pending[0].Headers["type"] != "order" || pending[0].CreatedAt.IsZero() {
+		t.Errorf("消息头或写入时间丢失: %+v", pending[0])
+	}
+	a1, b1, a2 := pending[0].ID, pending[1].ID, pending[2].ID
+
+	// 普通失败保留在 Pending 中，毒丸事件移到 Poisoned
+	store.MarkFailed(ctx, b1, "临时错误", false)
+	all, _ := store.Pending(ctx, 10)
+	if len(all) != 4 || all[1].Attempts != 1 || all[1].LastError != "临时错误" {
+		t.Errorf("失败记录错误: %+v", all)
+	}
+	c1 := all[3].ID
+	store.MarkFailed(ctx, c1, "消息过大", true)
+	if list, _ := store.Poisoned(ctx); len(list) != 1 || list[0].ID != c1 || list[0].Attempts != 1 || list[0].LastError != "消息过大" {
+		t.Errorf("毒丸事件错误: %+v", list)
+	}
+	if all, _ := store.Pending(ctx, 10); len(all) != 3 {
+		t.Errorf("毒丸事件不应在 Pending 中: %+v", all)
+	}
+
+	// 只删除 before 之前写入的已发送事件
+	if err := store.MarkSent(ctx, a1, b1, a2); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := store.Pending(ctx, 10); len(all) != 0 {
+		t.Errorf("已发送的事件不应在 Pending 中: %+v", all)
+	}
+	n, err := store.DeleteSent(ctx, time.Now().Add(-time.Minute))
+	if err != nil || n != 2 {
+		t.Errorf("应删除2条较早的已发送事件，删除 %d: %v", n, err)
+	}
+	var rows int
+	db.QueryRowContext(ctx, "SELECT COUNT(*) FROM outbox").Scan(&rows)
+	if rows != 2 {
+		t.Errorf("应保留刚发送的事件和毒丸事件，剩余 %d 条", rows)
+	}
+}
@@ -0,0 +1,218 @@
+// Package partitioner 生产者分区器，均实现 kafka.Balancer
+// 通过各生产者的 SetPartitioner、client.ProducerBuilder.WithPartitioner 或配置项 producer.partitioner 使用
+package partitioner
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HeaderPartition Explicit 分区器读取的消息头，值为分区号
+const HeaderPartition = "x-partition"
+
+// Murmur2Hash Kafka Java 客户端 Utils.murmur2 的Go实现
+func Murmur2Hash(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// murmur2Partition 与Java客户端相同的分区计算：toPositive(murmur2(key)) % 分区数
+func murmur2Partition(data []byte, partitions []int) int {
+	return partitions[(Murmur2Hash(data)&0x7fffffff)%uint32(len(partitions))]
+}
+
+// Murmur2 按key的murmur2哈希选择分区，与Java客户端的默认分区器一致，多语言生产者写入相同的分区
+// 没有key（包括空key）的消息轮询分区，需要与Java客户端2.4之后的行为一致时使用 Sticky
+type Murmur2 struct {
+	next atomic.Uint32
+}
+
+func (b *Murmur2) Balance(msg kafka.Message, partitions ...int) int {
+	if len(msg.Key) == 0 {
+		return partitions[int(b.next.Add(1)-1)%len(partitions)]
+	}
+	return murmur2Partition(msg.Key, partitions)
+}
+
+// Sticky 没有key的消息连续写入同一分区，写满 BatchBytes 后随机换一个分区，使批次更大、请求更少
+// 有key的消息按murmur2哈希选择分区，与Java客户端2.4之后的默认分区器一致
+type Sticky struct {
+	BatchBytes int // 切换分区前写入的字节数，默认16384，与Java客户端的 batch.size 一致
+
+	mu      sync.Mutex
+	current int
+	chosen  bool
+	written int
+}
+
+func (b *Sticky) Balance(msg kafka.Message, partitions ...int) int {
+	if len(msg.Key) > 0 {
+		return murmur2Partition(msg.Key, partitions)
+	}
+
+	limit := b.BatchBytes
+	if limit <= 0 {
+		limit = 16384
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.chosen || b.written >= limit || !contains(partitions, b.current) {
+		b.current = pickOther(partitions, b.current, b.chosen)
+		b.chosen = true
+		b.written = 0
+	}
+	b.written += len(msg.Value)
+	return b.current
+}
+
+// pickOther 随机选择一个分区，分区数大于1时避开当前分区
+func pickOther(partitions []int, current int, avoid bool) int {
+	if len(partitions) == 1 || !avoid {
+		return partitions[rand.Intn(len(partitions))]
+	}
+	for {
+		if p := partitions[rand.Intn(len(partitions))]; p != current {
+			return p
+		}
+	}
+}
+
+func contains(partitions []int, partition int) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsistentHash 按key的一致性哈希（jump consistent hash）选择分区
+// 分区数从n增加到n+1时只有约1/(n+1)的key改变分区，且都移到新分区；取模哈希在扩容时几乎所有key都会移动。
+// 没有key的消息轮询分区
+type ConsistentHash struct {
+	next atomic.Uint32
+}
+
+func (b *ConsistentHash) Balance(msg kafka.Message, partitions ...int) int {
+	if len(msg.Key) == 0 {
+		return partitions[int(b.next.Add(1)-1)%len(partitions)]
+	}
+
+	h := fnv.New64a()
+	h.Write(msg.Key)
+	return partitions[jumpHash(h.Sum64(), len(partitions))]
+}
+
+// jumpHash Lamping 和 Veach 的 jump consistent hash，返回 [0, buckets) 中的桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Explicit 写入消息头 x-partition 指定的分区，没有该消息头时使用 Fallback（默认 Murmur2）
+// 指定的分区不存在或消息头不是数字时发送失败，不会写入其他分区
+type Explicit struct {
+	Fallback kafka.Balancer
+
+	keyHash Murmur2
+}
+
+func (b *Explicit) Balance(msg kafka.Message, partitions ...int) int {
+	value, ok := header(msg, HeaderPartition)
+	if !ok {
+		return fallback(b.Fallback, &b.keyHash, msg, partitions)
+	}
+
+	partition, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return partition
+}
+
+// ByHeader 按消息头 Header 的值的murmur2哈希选择分区，值相同的消息写入同一分区，适合key另有用途的场景
+// 没有该消息头时使用 Fallback（默认 Murmur2）
+type ByHeader struct {
+	Header   string
+	Fallback kafka.Balancer
+
+	keyHash Murmur2
+}
+
+func (b *ByHeader) Balance(msg kafka.Message, partitions ...int) int {
+	value, ok := header(msg, b.Header)
+	if !ok {
+		return fallback(b.Fallback, &b.keyHash, msg, partitions)
+	}
+	return murmur2Partition([]byte(value), partitions)
+}
+
+func fallback(b kafka.Balancer, def *Murmur2, msg kafka.Message, partitions []int) int {
+	if b != nil {
+		return b.Balance(msg, partitions...)
+	}
+	return def.Balance(msg, partitions...)
+}
+
+// header 读取消息头，同名消息头取最后一个
+func header(msg kafka.Message, key string) (string, bool) {
+	for i := len(msg.Headers) - 1; i >= 0; i-- {
+		if msg.Headers[i].Key == key {
+			return string(msg.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
+
+var (
+	_ kafka.Balancer = (*Murmur2)(nil)
+	_ kafka.Balancer = (*Sticky)(nil)
+	_ kafka.Balancer = (*ConsistentHash)(nil)
+	_ kafka.Balancer = (*Explicit)(nil)
+	_ kafka.Balancer = (*ByHeader)(nil)
+)
@@ -64,7 +64,7 @@ func NewConnPool(cfg *config.KafkaConfig, options ...PoolOption) *ConnPool {
 
 	// 设置连接工厂
 	pool.factory = func() (*kafka.Conn, error) {
-		return kafka.Dial("tcp", cfg.Brokers[0])
+		return cfg.DialContext(context.Background(), "tcp", cfg.Brokers[0])
 	}
 
 	// 初始化连接
@@ -192,14 +192,14 @@ func NewWriterPool(cfg *config.KafkaConfig, options ...kafka.WriterOption) *Writ
 	}
 }
 
-// GetWriter 获取Writer
-func (wp *WriterPool) GetWriter(topic string) *kafka.Writer {
+// GetWriter 获取Writer，TLS、SASL等连接配置错误时返回错误，不创建未加密的Writer
+func (wp *WriterPool) GetWriter(topic string) (*kafka.Writer, error) {
 	wp.mu.RLock()
 	writer, exists := wp.pool[topic]
 	wp.mu.RUnlock()
 
 	if exists {
-		return writer
+		return writer, nil
 	}
 
 	wp.mu.Lock()
@@ -207,7 +207,7 @@ func (wp *WriterPool) GetWriter(topic string) *kafka.Writer {
 
 	// 双重检查
 	if writer, exists := wp.pool[topic]; exists {
-		return writer
+		return writer, nil
 	}
 
 	// 创建新Writer
@@ -215,9 +215,12 @@ func (wp *WriterPool) GetWriter(topic string) *kafka.Writer {
 		Addr:  kafka.TCP(wp.config.Brokers...),
 		Topic: topic,
 	}
+	if err := wp.config.ApplyWriter(writer); err != nil {
+		return nil, fmt.Errorf("连接配置错误: %w", err)
+	}
 
 	wp.pool[topic] = writer
-	return writer
+	return writer, nil
 }
 
 // Close 关闭所有Writer
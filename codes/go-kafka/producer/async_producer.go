@@ -2,18 +2,22 @@ package producer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/spool"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
-// AsyncProducer 异步生产者，支持回调
+// AsyncProducer 异步生产者，按批次发送队列中的消息
+// 每条入队的消息的投递结果通过 SendAsync 返回的 Delivery 和创建时的回调各报告一次，包括关闭时仍在队列中的消息
 type AsyncProducer struct {
-	writer    *kafka.Writer
+	writer    transport.Writer
 	config    *config.KafkaConfig
 	logger    *utils.Logger
 	callback  func(msg kafka.Message, err error)
@@ -21,32 +25,65 @@ type AsyncProducer struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	msgChan   chan kafka.Message
+	flushChan chan chan struct{}
+	inflight  sync.WaitGroup
 	batchSize int
+	seq       *sequencer
+	lastBatch chan struct{} // 幂等模式和启用本地缓存时上一个批次发送完成时关闭
+
+	partitioner  kafka.Balancer
+	backpressure Backpressure
+	spooler      *spooler // 配置 producer.spool.dir 时发送失败的消息写入本地缓存，以 ErrSpooled 报告
+
+	closeMu sync.RWMutex // 入队持读锁，Close 持写锁，之后不再有消息入队
+	closed  bool
+	stop    chan struct{} // 不再有消息入队后关闭，后台协程发送剩余消息后退出
 }
 
 // NewAsyncProducer 创建异步生产者
-// callback: 消息发送后的回调函数
+// callback: 每条消息投递完成（发送成功、失败或被丢弃）后的回调函数，消息包含写入的分区和偏移量
 func NewAsyncProducer(cfg *config.KafkaConfig, callback func(msg kafka.Message, err error)) *AsyncProducer {
+	queueSize := 1000 // 缓冲通道
+	if cfg.Producer.QueueSize > 0 {
+		queueSize = cfg.Producer.QueueSize
+	}
+	backpressure, _ := ParseBackpressure(cfg.Producer.Backpressure) // 配置已校验
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &AsyncProducer{
-		config:    cfg,
-		logger:    utils.NewLogger("[AsyncProducer]"),
-		callback:  callback,
-		ctx:       ctx,
-		cancel:    cancel,
-		msgChan:   make(chan kafka.Message, 1000), // 缓冲通道
-		batchSize: 100,
+		config:       cfg,
+		logger:       utils.NewLogger("[AsyncProducer]"),
+		callback:     callback,
+		ctx:          ctx,
+		cancel:       cancel,
+		msgChan:      make(chan kafka.Message, queueSize),
+		flushChan:    make(chan chan struct{}),
+		batchSize:    100,
+		backpressure: backpressure,
+		stop:         make(chan struct{}),
 	}
 }
 
+// SetBackpressure 设置发送队列满时的处理方式，优先于 producer.backpressure
+func (p *AsyncProducer) SetBackpressure(b Backpressure) {
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+	p.backpressure = b
+}
+
+// SetPartitioner 设置分区器，优先于 producer.partitioner，需在 Connect 之前调用
+func (p *AsyncProducer) SetPartitioner(b kafka.Balancer) {
+	p.partitioner = b
+}
+
 // Connect 连接到Kafka
 func (p *AsyncProducer) Connect() error {
-	p.writer = &kafka.Writer{
+	w := &kafka.Writer{
 		Addr:         kafka.TCP(p.config.Brokers...),
 		Topic:        p.config.Topic,
 		Balancer:     &kafka.LeastBytes{}, // 使用最小字节分区器
 		RequiredAcks: kafka.RequireOne,    // 只需leader确认
-		Async:        true,                // 异步模式
+		Async:        false,               // 批次在后台协程中同步发送，发送结果用于报告投递
 		WriteTimeout: 10 * time.Second,
 		BatchTimeout: 50 * time.Millisecond, // 更短的批量超时，提高实时性
 		BatchSize:    p.batchSize,
@@ -57,6 +94,37 @@ func (p *AsyncProducer) Connect() error {
 			p.logger.Error(fmt.Sprintf(msg, args...))
 		}),
 	}
+	if err := p.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	p.batchSize = w.BatchSize
+	if p.partitioner != nil {
+		w.Balancer = p.partitioner
+	}
+	if p.config.Producer.Idempotent {
+		p.seq = newSequencer(p.config, w)
+	}
+	sp, err := newSpooler(p.config.Producer.Spool, p.logger)
+	if err != nil {
+		return err
+	}
+	// 写入后的分区和偏移量通过 Completion 报告，WriterData 为消息的 Delivery
+	// 启用本地缓存时发送失败的消息先写入缓存，由 flushBatch 报告
+	w.Completion = func(messages []kafka.Message, err error) {
+		if err != nil && sp != nil {
+			return
+		}
+		for _, msg := range messages {
+			p.report(msg, err)
+		}
+	}
+	p.writer = p.config.GetTransport().NewWriter(w)
+	if sp != nil {
+		p.spooler = sp
+		sp.start(func(ctx context.Context, msgs []kafka.Message) error {
+			return p.writer.WriteMessages(ctx, msgs...)
+		})
+	}
 
 	// 启动后台发送协程
 	p.wg.Add(1)
@@ -66,37 +134,133 @@ func (p *AsyncProducer) Connect() error {
 	return nil
 }
 
-// SendAsync 异步发送消息，非阻塞
-func (p *AsyncProducer) SendAsync(key, value string) error {
-	select {
-	case p.msgChan <- kafka.Message{
+// SendAsync 将消息放入发送队列，返回投递结果；队列满时按 Backpressure 处理，阻塞模式下一直等待
+func (p *AsyncProducer) SendAsync(key, value string) (*Delivery, error) {
+	return p.enqueue(context.Background(), kafka.Message{
 		Key:   []byte(key),
 		Value: []byte(value),
 		Time:  time.Now(),
-	}:
-		return nil
-	case <-p.ctx.Done():
-		return fmt.Errorf("producer is closed")
-	default:
-		return fmt.Errorf("message channel is full")
+	}, nil)
+}
+
+// Produce 将消息放入发送队列，返回投递结果；阻塞模式下队列满时等待直到ctx取消
+// 消息写入 config.Topic，不能设置 msg.Topic
+func (p *AsyncProducer) Produce(ctx context.Context, msg kafka.Message) (*Delivery, error) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
 	}
+	return p.enqueue(ctx, msg, nil)
 }
 
-// SendAsyncWithCallback 异步发送并立即回调
+// SendMessage 将消息放入发送队列，实现 Producer 接口，投递结果只通过回调报告
+func (p *AsyncProducer) SendMessage(ctx context.Context, key, value string) error {
+	_, err := p.enqueue(ctx, kafka.Message{
+		Key:   []byte(key),
+		Value: []byte(value),
+		Time:  time.Now(),
+	}, nil)
+	return err
+}
+
+// SendMessageWithHeaders 将带消息头的消息放入发送队列
+func (p *AsyncProducer) SendMessageWithHeaders(
+	ctx context.Context,
+	key, value string,
+	headers map[string]string,
+) error {
+	_, err := p.enqueue(ctx, kafka.Message{
+		Key:     []byte(key),
+		Value:   []byte(value),
+		Headers: toHeaders(headers),
+		Time:    time.Now(),
+	}, nil)
+	return err
+}
+
+// SendAsyncWithCallback 将消息放入发送队列，投递完成后调用cb；入队失败时立即以错误调用cb和回调函数
 func (p *AsyncProducer) SendAsyncWithCallback(key, value string, cb func(error)) {
-	go func() {
-		err := p.writer.WriteMessages(p.ctx, kafka.Message{
-			Key:   []byte(key),
-			Value: []byte(value),
-			Time:  time.Now(),
-		})
+	msg := kafka.Message{
+		Key:   []byte(key),
+		Value: []byte(value),
+		Time:  time.Now(),
+	}
+	if _, err := p.enqueue(context.Background(), msg, cb); err != nil {
 		if cb != nil {
 			cb(err)
 		}
 		if p.callback != nil {
-			p.callback(kafka.Message{Key: []byte(key), Value: []byte(value)}, err)
+			p.callback(msg, err)
+		}
+	}
+}
+
+// enqueue 入队，返回错误时消息未入队，不会再报告
+func (p *AsyncProducer) enqueue(ctx context.Context, msg kafka.Message, cb func(error)) (*Delivery, error) {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+
+	if p.closed {
+		return nil, ErrProducerClosed
+	}
+
+	d := newDelivery(cb)
+	msg.WriterData = d
+
+	select {
+	case p.msgChan <- msg:
+		return d, nil
+	default:
+	}
+
+	switch p.backpressure {
+	case BackpressureFail:
+		return nil, ErrQueueFull
+
+	case BackpressureDropOldest:
+		for {
+			select {
+			case p.msgChan <- msg:
+				return d, nil
+			default:
+			}
+			select {
+			case oldest := <-p.msgChan:
+				p.report(oldest, ErrDropped)
+			default:
+			}
 		}
-	}()
+	}
+
+	select {
+	case p.msgChan <- msg:
+		return d, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.ctx.Done():
+		return nil, ErrProducerClosed
+	}
+}
+
+// report 报告消息的投递结果，同一条消息只有第一次报告生效
+func (p *AsyncProducer) report(msg kafka.Message, err error) {
+	if d, ok := msg.WriterData.(*Delivery); ok {
+		d.resolve(msg, err, p.callback)
+	}
+}
+
+// Flush 发送队列中的全部消息，并等待投递完成
+func (p *AsyncProducer) Flush() error {
+	done := make(chan struct{})
+
+	select {
+	case p.flushChan <- done:
+	case <-p.ctx.Done():
+		return ErrProducerClosed
+	}
+
+	<-done
+	p.inflight.Wait()
+	return nil
 }
 
 // processMessages 后台处理消息发送
@@ -111,7 +275,7 @@ func (p *AsyncProducer) processMessages() {
 	for {
 		select {
 		case msg := <-p.msgChan:
-			batch = append(batch, msg)
+			batch = p.add(batch, msg)
 
 			// 批量达到阈值，立即发送
 			if len(batch) >= p.batchSize {
@@ -119,6 +283,13 @@ func (p *AsyncProducer) processMessages() {
 				batch = batch[:0] // 清空切片但保留容量
 			}
 
+		case done := <-p.flushChan:
+			// 手动刷新，取出队列中剩余的消息一起发送
+			batch = p.drain(batch)
+			p.flushBatch(batch)
+			batch = batch[:0]
+			close(done)
+
 		case <-ticker.C:
 			// 定时刷新，避免消息滞留
 			if len(batch) > 0 {
@@ -126,8 +297,9 @@ func (p *AsyncProducer) processMessages() {
 				batch = batch[:0]
 			}
 
-		case <-p.ctx.Done():
+		case <-p.stop:
 			// 关闭前发送剩余消息
+			batch = p.drain(batch)
 			if len(batch) > 0 {
 				p.flushBatch(batch)
 			}
@@ -136,6 +308,35 @@ func (p *AsyncProducer) processMessages() {
 	}
 }
 
+// drain 非阻塞地取出队列中的全部消息
+func (p *AsyncProducer) drain(batch []kafka.Message) []kafka.Message {
+	for {
+		select {
+		case msg := <-p.msgChan:
+			batch = p.add(batch, msg)
+		default:
+			return batch
+		}
+	}
+}
+
+// add 消息加入批次，幂等模式下按出队顺序分配序列号，分配失败的消息直接报告
+func (p *AsyncProducer) add(batch []kafka.Message, msg kafka.Message) []kafka.Message {
+	if p.seq != nil {
+		err := p.seq.refresh()
+		if err == nil {
+			err = p.seq.stamp(&msg)
+		}
+		if err != nil {
+			err = fmt.Errorf("分配序列号失败: %w", err)
+			p.logger.Error(err)
+			p.report(msg, err)
+			return batch
+		}
+	}
+	return append(batch, msg)
+}
+
 // flushBatch 批量发送消息
 func (p *AsyncProducer) flushBatch(batch []kafka.Message) {
 	if len(batch) == 0 {
@@ -146,14 +347,44 @@ func (p *AsyncProducer) flushBatch(batch []kafka.Message) {
 	msgs := make([]kafka.Message, len(batch))
 	copy(msgs, batch)
 
+	// 幂等模式和启用本地缓存时批次依次发送，后面的批次等待前一个批次完成
+	var prev, done chan struct{}
+	if p.seq != nil || p.spooler != nil {
+		prev, done = p.lastBatch, make(chan struct{})
+		p.lastBatch = done
+	}
+
+	p.inflight.Add(1)
 	go func(messages []kafka.Message) {
+		defer p.inflight.Done()
+		if done != nil {
+			defer close(done)
+		}
+		if prev != nil {
+			<-prev
+		}
+
+		if p.spooler != nil {
+			results := p.spooler.send(messages, func(msgs []kafka.Message) error {
+				return p.writer.WriteMessages(context.Background(), msgs...)
+			})
+			for i, msg := range messages {
+				p.report(msg, results[i])
+			}
+			return
+		}
+
 		err := p.writer.WriteMessages(context.Background(), messages...)
 
-		// 触发回调
-		if p.callback != nil {
-			for _, msg := range messages {
-				p.callback(msg, err)
+		// 已由 Completion 报告的消息不会重复报告，其余消息（例如未到达分区的）按返回的错误报告
+		var writeErrs kafka.WriteErrors
+		perMessage := errors.As(err, &writeErrs) && len(writeErrs) == len(messages)
+		for i, msg := range messages {
+			msgErr := err
+			if perMessage {
+				msgErr = writeErrs[i]
 			}
+			p.report(msg, msgErr)
 		}
 
 		if err != nil {
@@ -164,10 +395,33 @@ func (p *AsyncProducer) flushBatch(batch []kafka.Message) {
 	}(msgs)
 }
 
-// Close 关闭异步生产者
+// Close 关闭异步生产者，发送队列中剩余的消息，返回时所有消息的投递结果都已报告
 func (p *AsyncProducer) Close() error {
-	p.cancel()  // 通知协程退出
-	p.wg.Wait() // 等待后台协程完成
+	p.cancel() // 唤醒阻塞在入队上的调用
+
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.closeMu.Unlock()
+
+	close(p.stop)     // 通知协程发送剩余消息后退出
+	p.wg.Wait()       // 等待后台协程完成
+	p.inflight.Wait() // 等待发送中的批次完成
+
+	// 未连接时没有后台协程，队列中的消息直接报告
+	for len(p.msgChan) > 0 {
+		p.report(<-p.msgChan, ErrProducerClosed)
+	}
+
+	// 未重放的消息留在缓存中，下次启动时重放
+	if p.spooler != nil {
+		if err := p.spooler.close(); err != nil {
+			p.logger.Error("关闭本地缓存失败:", err)
+		}
+	}
 
 	if p.writer != nil {
 		if err := p.writer.Close(); err != nil {
@@ -175,7 +429,6 @@ func (p *AsyncProducer) Close() error {
 		}
 	}
 
-	close(p.msgChan)
 	p.logger.Info("异步生产者已关闭")
 	return nil
 }
@@ -185,6 +438,22 @@ func (p *AsyncProducer) Stats() kafka.WriterStats {
 	return p.writer.Stats()
 }
 
+// ProducerID 幂等模式下的生产者ID，未启用 producer.idempotent 或未连接时返回空字符串
+func (p *AsyncProducer) ProducerID() string {
+	if p.seq == nil {
+		return ""
+	}
+	return p.seq.producerID
+}
+
+// SpoolStats 本地缓存状态，未启用 producer.spool 时返回零值
+func (p *AsyncProducer) SpoolStats() spool.Stats {
+	if p.spooler == nil {
+		return spool.Stats{}
+	}
+	return p.spooler.stats()
+}
+
 // PendingMessages 获取待发送消息数量
 func (p *AsyncProducer) PendingMessages() int {
 	return len(p.msgChan)
@@ -8,22 +8,35 @@ import (
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/spool"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = 1 * time.Second
+)
+
 // BatchProducer 高性能批量生产者，适用于大数据量场景
+// 批量大小和刷新间隔可以通过 SetBatchSize、SetFlushInterval 或订阅配置变化在运行时修改
 type BatchProducer struct {
-	writer      *kafka.Writer
-	config      *config.KafkaConfig
-	logger      *utils.Logger
-	buffer      []kafka.Message
-	bufferMutex sync.Mutex
-	batchSize   int
-	flushTicker *time.Ticker
-	ctx         context.Context
-	cancel      context.CancelFunc
-	wg          sync.WaitGroup
-	compressor  kafka.Compression
+	writer        transport.Writer
+	config        *config.KafkaConfig
+	logger        *utils.Logger
+	buffer        []kafka.Message
+	bufferMutex   sync.Mutex
+	sendMutex     sync.Mutex // 幂等模式和启用本地缓存时批次依次发送
+	seq           *sequencer
+	batchSize     int
+	flushInterval time.Duration
+	flushTicker   *time.Ticker
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	compressor    kafka.Compression
+	partitioner   kafka.Balancer
+	spooler       *spooler // 配置 producer.spool.dir 时发送失败的消息写入本地缓存
 }
 
 // BatchProducerOption 批量生产者配置选项
@@ -36,6 +49,20 @@ func WithBatchSize(size int) BatchProducerOption {
 	}
 }
 
+// WithFlushInterval 设置定时刷新缓冲区的间隔
+func WithFlushInterval(d time.Duration) BatchProducerOption {
+	return func(p *BatchProducer) {
+		p.flushInterval = d
+	}
+}
+
+// WithPartitioner 设置分区器，优先于 producer.partitioner
+func WithPartitioner(b kafka.Balancer) BatchProducerOption {
+	return func(p *BatchProducer) {
+		p.partitioner = b
+	}
+}
+
 // WithCompression 设置压缩算法
 func WithCompression(algo kafka.Compression) BatchProducerOption {
 	return func(p *BatchProducer) {
@@ -48,13 +75,25 @@ func NewBatchProducer(cfg *config.KafkaConfig, options ...BatchProducerOption) *
 	ctx, cancel := context.WithCancel(context.Background())
 
 	p := &BatchProducer{
-		config:     cfg,
-		logger:     utils.NewLogger("[BatchProducer]"),
-		buffer:     make([]kafka.Message, 0, 1000),
-		batchSize:  500,
-		compressor: kafka.Lz4, // 默认使用lz4压缩
-		ctx:        ctx,
-		cancel:     cancel,
+		config:        cfg,
+		logger:        utils.NewLogger("[BatchProducer]"),
+		buffer:        make([]kafka.Message, 0, 1000),
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		compressor:    kafka.Lz4, // 默认使用lz4压缩
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+
+	// 配置文件中的设置优先于默认值，选项优先于配置文件
+	if cfg.Producer.BatchSize > 0 {
+		p.batchSize = cfg.Producer.BatchSize
+	}
+	if cfg.Producer.FlushInterval > 0 {
+		p.flushInterval = cfg.Producer.FlushInterval.Std()
+	}
+	if cfg.Producer.Compression != "" {
+		p.compressor.UnmarshalText([]byte(cfg.Producer.Compression))
 	}
 
 	// 应用选项
@@ -65,12 +104,17 @@ func NewBatchProducer(cfg *config.KafkaConfig, options ...BatchProducerOption) *
 	return p
 }
 
+// SetPartitioner 设置分区器，与 WithPartitioner 相同，需在 Connect 之前调用
+func (p *BatchProducer) SetPartitioner(b kafka.Balancer) {
+	p.partitioner = b
+}
+
 // Connect 连接到Kafka
 func (p *BatchProducer) Connect() error {
-	p.writer = &kafka.Writer{
+	w := &kafka.Writer{
 		Addr:         kafka.TCP(p.config.Brokers...),
 		Topic:        p.config.Topic,
-		Balancer:     &kafka.CRC32Balancer{}, // CRC32分区器，与Java客户端兼容
+		Balancer:     &kafka.CRC32Balancer{}, // CRC32分区器，与librdkafka兼容；与Java客户端一致使用 partitioner.Murmur2
 		RequiredAcks: kafka.RequireOne,
 		Async:        false, // 同步模式，我们自己控制批量
 
@@ -89,9 +133,34 @@ func (p *BatchProducer) Connect() error {
 		WriteBackoffMin: 100 * time.Millisecond,
 		WriteBackoffMax: 1 * time.Second,
 	}
+	if err := p.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	// 批量大小和压缩算法在创建时已按配置和选项确定
+	w.BatchSize = p.batchSize
+	w.Compression = p.compressor
+	if p.partitioner != nil {
+		w.Balancer = p.partitioner
+	}
+	if p.config.Producer.Idempotent {
+		p.seq = newSequencer(p.config, w)
+	}
+	sp, err := newSpooler(p.config.Producer.Spool, p.logger)
+	if err != nil {
+		return err
+	}
+	p.writer = p.config.GetTransport().NewWriter(w)
+	if sp != nil {
+		p.spooler = sp
+		sp.start(func(ctx context.Context, msgs []kafka.Message) error {
+			return p.writer.WriteMessages(ctx, msgs...)
+		})
+	}
 
 	// 启动定时刷新器
-	p.flushTicker = time.NewTicker(1 * time.Second)
+	p.bufferMutex.Lock()
+	p.flushTicker = time.NewTicker(p.flushInterval)
+	p.bufferMutex.Unlock()
 	p.wg.Add(1)
 	go p.autoFlush()
 
@@ -101,15 +170,49 @@ func (p *BatchProducer) Connect() error {
 
 // Send 添加消息到缓冲区（立即返回）
 func (p *BatchProducer) Send(key, value string) error {
-	p.bufferMutex.Lock()
-	defer p.bufferMutex.Unlock()
-
-	msg := kafka.Message{
+	return p.enqueue(kafka.Message{
 		Key:   []byte(key),
 		Value: []byte(value),
 		Time:  time.Now(),
+	})
+}
+
+// SendMessage 添加消息到缓冲区，实现 Producer 接口
+func (p *BatchProducer) SendMessage(ctx context.Context, key, value string) error {
+	return p.Send(key, value)
+}
+
+// SendMessageWithHeaders 添加带消息头的消息到缓冲区
+func (p *BatchProducer) SendMessageWithHeaders(
+	ctx context.Context,
+	key, value string,
+	headers map[string]string,
+) error {
+	return p.enqueue(kafka.Message{
+		Key:     []byte(key),
+		Value:   []byte(value),
+		Headers: toHeaders(headers),
+		Time:    time.Now(),
+	})
+}
+
+// enqueue 消息加入缓冲区，幂等模式下按入队顺序分配序列号
+// 分区列表在加锁前刷新，查询分区时不阻塞 Flush 和其他方法
+func (p *BatchProducer) enqueue(msg kafka.Message) error {
+	if p.seq != nil {
+		if err := p.seq.refresh(); err != nil {
+			return fmt.Errorf("分配序列号失败: %w", err)
+		}
 	}
 
+	p.bufferMutex.Lock()
+	defer p.bufferMutex.Unlock()
+
+	if p.seq != nil {
+		if err := p.seq.stamp(&msg); err != nil {
+			return fmt.Errorf("分配序列号失败: %w", err)
+		}
+	}
 	p.buffer = append(p.buffer, msg)
 
 	// 达到批量阈值，立即刷新
@@ -130,6 +233,12 @@ func (p *BatchProducer) SendStructured(key string, data interface{}) error {
 
 // Flush 手动刷新缓冲区
 func (p *BatchProducer) Flush() error {
+	if p.seq != nil || p.spooler != nil {
+		// 并发的刷新依次发送，避免序列号较大或较晚的批次先到达
+		p.sendMutex.Lock()
+		defer p.sendMutex.Unlock()
+	}
+
 	p.bufferMutex.Lock()
 
 	if len(p.buffer) == 0 {
@@ -153,8 +262,13 @@ func (p *BatchProducer) sendBatch(messages []kafka.Message) error {
 		return nil
 	}
 
+	if p.spooler != nil {
+		return p.sendSpooled(messages)
+	}
+
+	// 关闭时仍需发送剩余消息，因此不使用会被取消的 p.ctx
 	start := time.Now()
-	err := p.writer.WriteMessages(p.ctx, messages...)
+	err := p.writer.WriteMessages(context.Background(), messages...)
 	duration := time.Since(start)
 
 	if err != nil {
@@ -180,6 +294,25 @@ func (p *BatchProducer) sendBatch(messages []kafka.Message) error {
 	return nil
 }
 
+// sendSpooled 启用本地缓存时发送批次，写入缓存的消息视为成功，只返回写入缓存也失败的消息的错误
+func (p *BatchProducer) sendSpooled(messages []kafka.Message) error {
+	results := p.spooler.send(messages, func(msgs []kafka.Message) error {
+		return p.writer.WriteMessages(context.Background(), msgs...)
+	})
+
+	writeErrors := make(kafka.WriteErrors, len(messages))
+	for i, err := range results {
+		if err != nil && err != ErrSpooled {
+			writeErrors[i] = err
+			p.handleFailedMessage(messages[i], err)
+		}
+	}
+	if writeErrors.Count() > 0 {
+		return writeErrors
+	}
+	return nil
+}
+
 // handleFailedMessage 处理发送失败的消息
 func (p *BatchProducer) handleFailedMessage(msg kafka.Message, err error) {
 	// 实际项目中可以加入死信队列或重试队列
@@ -212,6 +345,13 @@ func (p *BatchProducer) Close() error {
 	// 最后刷新剩余消息
 	p.Flush()
 
+	// 未重放的消息留在缓存中，下次启动时重放
+	if p.spooler != nil {
+		if err := p.spooler.close(); err != nil {
+			p.logger.Error("关闭本地缓存失败:", err)
+		}
+	}
+
 	if p.writer != nil {
 		if err := p.writer.Close(); err != nil {
 			return fmt.Errorf("关闭生产者失败: %w", err)
@@ -222,6 +362,69 @@ func (p *BatchProducer) Close() error {
 	return nil
 }
 
+// SetBatchSize 修改触发刷新的缓冲消息数，size 不大于0时恢复默认值
+func (p *BatchProducer) SetBatchSize(size int) {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	p.bufferMutex.Lock()
+	defer p.bufferMutex.Unlock()
+
+	p.batchSize = size
+	if len(p.buffer) >= p.batchSize && p.ctx.Err() == nil {
+		go p.Flush()
+	}
+}
+
+// SetFlushInterval 修改定时刷新的间隔，d 不大于0时恢复默认值
+func (p *BatchProducer) SetFlushInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultFlushInterval
+	}
+
+	p.bufferMutex.Lock()
+	defer p.bufferMutex.Unlock()
+
+	p.flushInterval = d
+	if p.flushTicker != nil && p.ctx.Err() == nil {
+		p.flushTicker.Reset(d)
+	}
+}
+
+// Reload 实时应用 producer.batch_size 和 producer.flush_interval，
+// 返回其他需要重新创建生产者才能生效的变化，producer.rate_limit 由 RateLimitedProducer 处理；关闭后不做任何处理
+func (p *BatchProducer) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	if p.ctx.Err() != nil {
+		return nil
+	}
+	if changes.Has("producer.batch_size") {
+		p.SetBatchSize(cfg.Producer.BatchSize)
+	}
+	if changes.Has("producer.flush_interval") {
+		p.SetFlushInterval(cfg.Producer.FlushInterval.Std())
+	}
+
+	fields := append([]string{"topic", "producer"}, config.ConnectionFields...)
+	return changes.Except("producer.batch_size", "producer.flush_interval", "producer.rate_limit").Fields(fields...)
+}
+
+// ProducerID 幂等模式下的生产者ID，未启用 producer.idempotent 或未连接时返回空字符串
+func (p *BatchProducer) ProducerID() string {
+	if p.seq == nil {
+		return ""
+	}
+	return p.seq.producerID
+}
+
+// SpoolStats 本地缓存状态，未启用 producer.spool 时返回零值
+func (p *BatchProducer) SpoolStats() spool.Stats {
+	if p.spooler == nil {
+		return spool.Stats{}
+	}
+	return p.spooler.stats()
+}
+
 // BufferSize 获取当前缓冲区大小
 func (p *BatchProducer) BufferSize() int {
 	p.bufferMutex.Lock()
@@ -0,0 +1,85 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// AsyncProducer 报告投递结果时使用的错误
+var (
+	ErrQueueFull      = errors.New("message queue is full")
+	ErrDropped        = errors.New("message dropped from full queue")
+	ErrProducerClosed = errors.New("producer is closed")
+	ErrSpooled        = errors.New("message spooled to disk") // 发送失败，已写入本地缓存，连接恢复后重放
+)
+
+// Backpressure AsyncProducer 发送队列满时的处理方式
+type Backpressure int
+
+const (
+	BackpressureBlock      Backpressure = iota // 阻塞直到队列有空位、ctx取消或生产者关闭
+	BackpressureFail                           // 立即返回 ErrQueueFull
+	BackpressureDropOldest                     // 丢弃队列中最早的消息后入队，被丢弃的消息以 ErrDropped 报告
+)
+
+// ParseBackpressure 解析 producer.backpressure 配置，空字符串为 BackpressureBlock
+func ParseBackpressure(s string) (Backpressure, error) {
+	switch s {
+	case "", "block":
+		return BackpressureBlock, nil
+	case "fail":
+		return BackpressureFail, nil
+	case "drop_oldest":
+		return BackpressureDropOldest, nil
+	}
+	return BackpressureBlock, fmt.Errorf("无效的队列满处理方式 %q，应为 block、fail 或 drop_oldest", s)
+}
+
+// Delivery 异步发送的消息的投递结果
+// 消息发送成功、发送失败或被丢弃时完成，每条入队的消息只完成一次，Close 返回前所有消息都已完成
+type Delivery struct {
+	msg  kafka.Message
+	err  error
+	done chan struct{}
+	once sync.Once
+	cb   func(error)
+}
+
+func newDelivery(cb func(error)) *Delivery {
+	return &Delivery{done: make(chan struct{}), cb: cb}
+}
+
+// Done 投递完成时关闭
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait 等待投递完成，返回写入后的消息（包含Topic、分区和偏移量）和发送错误，ctx先取消时返回ctx的错误
+func (d *Delivery) Wait(ctx context.Context) (kafka.Message, error) {
+	select {
+	case <-d.done:
+		return d.msg, d.err
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+// resolve 记录投递结果，只有第一次调用生效
+func (d *Delivery) resolve(msg kafka.Message, err error, callback func(kafka.Message, error)) {
+	d.once.Do(func() {
+		msg.WriterData = nil
+		d.msg, d.err = msg, err
+		close(d.done)
+
+		if d.cb != nil {
+			d.cb(err)
+		}
+		if callback != nil {
+			callback(msg, err)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package producer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+)
+
+// 幂等模式写入的消息头，middleware.Dedup 据此丢弃重发的消息
+const (
+	HeaderProducerID = "x-producer-id"  // 生产者实例ID，每次创建生产者时生成
+	HeaderSequence   = "x-producer-seq" // 该生产者在分区上的序列号，从0开始递增
+)
+
+// partitionRefresh 幂等模式下分区列表的缓存时间
+const partitionRefresh = 10 * time.Second
+
+// sequencer 幂等模式下在发送前为消息选择分区并分配序列号
+// 分区由生产者自己选择，kafka.Writer 使用 pinnedBalancer 按消息上的分区写入，
+// 因此重试和重发的消息保持相同的分区和序列号
+type sequencer struct {
+	producerID string
+	balancer   kafka.Balancer
+	lookup     func(ctx context.Context) ([]int, error)
+
+	refreshMu  sync.Mutex // 查询分区时持有，查询期间不阻塞 stamp
+	mu         sync.Mutex
+	partitions []int
+	refreshed  time.Time
+	next       map[int]int64
+}
+
+// newSequencer 接管w的分区器，w 需已应用配置
+func newSequencer(cfg *config.KafkaConfig, w *kafka.Writer) *sequencer {
+	s := &sequencer{
+		producerID: newProducerID(cfg.ClientID),
+		balancer:   w.Balancer,
+		next:       make(map[int]int64),
+		lookup: func(ctx context.Context) ([]int, error) {
+			return cfg.GetTransport().LookupPartitions(ctx, cfg.Brokers, cfg.Topic)
+		},
+	}
+	if s.balancer == nil {
+		s.balancer = &kafka.RoundRobin{} // 与 kafka.Writer 的默认值一致
+	}
+
+	// 重复发送的消息必须与第一次序列号相同，只有 acks=all 才能保证已确认的消息不会丢失
+	w.Balancer = pinnedBalancer{}
+	w.RequiredAcks = kafka.RequireAll
+	return s
+}
+
+// newProducerID 生成生产者实例ID，设置了 client_id 时作为前缀便于排查
+func newProducerID(clientID string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	if clientID == "" {
+		return hex.EncodeToString(b)
+	}
+	return clientID + "-" + hex.EncodeToString(b)
+}
+
+// stamp 使用缓存的分区列表选择分区，写入生产者ID和序列号，不查询分区，调用前需先调用 refresh
+// 调用方需保证同一分区的消息按 stamp 的顺序发送
+func (s *sequencer) stamp(msg *kafka.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.partitions) == 0 {
+		return fmt.Errorf("分区列表未加载")
+	}
+
+	msg.Partition = s.balancer.Balance(*msg, s.partitions...)
+	seq := s.next[msg.Partition]
+	s.next[msg.Partition] = seq + 1
+
+	msg.Headers = append(msg.Headers,
+		kafka.Header{Key: HeaderProducerID, Value: []byte(s.producerID)},
+		kafka.Header{Key: HeaderSequence, Value: []byte(strconv.FormatInt(seq, 10))},
+	)
+	return nil
+}
+
+// refresh 分区列表过期时重新查询，查询失败时继续使用缓存
+// 查询可能耗时较长，调用方不应持有其他锁
+func (s *sequencer) refresh() error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.Lock()
+	cached := s.partitions != nil
+	fresh := cached && time.Since(s.refreshed) < partitionRefresh
+	s.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), partitionRefresh)
+	defer cancel()
+
+	partitions, err := s.lookup(ctx)
+	if err == nil && len(partitions) == 0 {
+		err = fmt.Errorf("Topic没有可用的分区")
+	}
+	if err != nil {
+		if cached {
+			return nil
+		}
+		return fmt.Errorf("查询分区失败: %w", err)
+	}
+
+	s.mu.Lock()
+	s.partitions = partitions
+	s.refreshed = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// pinnedBalancer 使用消息上已选择的分区
+type pinnedBalancer struct{}
+
+func (pinnedBalancer) Balance(msg kafka.Message, partitions ...int) int {
+	return msg.Partition
+}
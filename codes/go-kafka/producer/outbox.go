@@ -0,0 +1,238 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-kafka/utils"
+)
+
+// OutboxRecord 发件箱中的一条事件
+type OutboxRecord struct {
+	ID          int64             // 写入顺序，由存储分配
+	AggregateID string            // 聚合ID，作为消息key，同一聚合的事件按ID顺序发送
+	Value       string            // 消息内容
+	Headers     map[string]string // 消息头
+	CreatedAt   time.Time         // 写入时间
+	Attempts    int               // 发送失败的次数
+	LastError   string            // 最近一次发送失败的原因
+}
+
+// OutboxStore 发件箱存储
+// 业务数据和事件在同一个数据库事务中写入发件箱，由 OutboxRelay 发送到Kafka，进程在两者之间崩溃也不会丢失事件
+type OutboxStore interface {
+	// Append 写入事件，返回分配的ID；需要与业务数据原子写入时使用存储提供的事务写入方法
+	Append(ctx context.Context, rec OutboxRecord) (int64, error)
+	// Pending 按ID顺序返回最多limit条未发送的事件，不包括毒丸事件
+	Pending(ctx context.Context, limit int) ([]OutboxRecord, error)
+	// MarkSent 标记事件已发送
+	MarkSent(ctx context.Context, ids ...int64) error
+	// MarkFailed 记录一次发送失败，poison 为true时标记为毒丸事件，不再发送
+	MarkFailed(ctx context.Context, id int64, cause string, poison bool) error
+}
+
+// ErrPoisonRecord 返回该错误的发送失败直接将事件标记为毒丸，不再重试
+var ErrPoisonRecord = errors.New("poison outbox record")
+
+// maxOutboxRetryBackoff 发送失败的事件重试间隔的上限
+const maxOutboxRetryBackoff = 1 * time.Minute
+
+// OutboxRelay 发件箱中继，轮询发件箱并通过生产者发送未发送的事件
+// 同一聚合的事件按ID顺序发送：某条事件发送失败时，该聚合后续的事件等待它重试成功或成为毒丸后再发送。
+// 事件在生产者确认（Flush 返回）后才标记为已发送，标记前崩溃会重发，下游需要幂等处理。
+// 发送失败的事件按指数退避重试，Broker 短暂不可用时不会很快耗尽重试次数。
+// 同一个发件箱只应运行一个中继实例
+type OutboxRelay struct {
+	store        OutboxStore
+	producer     Producer
+	logger       *utils.Logger
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	retryBackoff time.Duration
+	onPoison     func(rec OutboxRecord, err error)
+	notify       chan struct{}
+
+	mu      sync.Mutex          // Start 和 RelayOnce 依次发送
+	retryAt map[int64]time.Time // 发送失败的事件下次可以重试的时间，只保存在内存中
+}
+
+// NewOutboxRelay 创建发件箱中继，p 建议使用 SimpleProducer，每条事件的发送错误可以单独处理；
+// 使用 BatchProducer 等缓冲生产者时 Flush 失败的整批事件都会重试。生产者的 Topic 即事件的 Topic
+func NewOutboxRelay(store OutboxStore, p Producer) *OutboxRelay {
+	return &OutboxRelay{
+		store:        store,
+		producer:     p,
+		logger:       utils.NewLogger("[OutboxRelay]"),
+		pollInterval: 1 * time.Second,
+		batchSize:    100,
+		maxAttempts:  5,
+		retryBackoff: 1 * time.Second,
+		notify:       make(chan struct{}, 1),
+		retryAt:      make(map[int64]time.Time),
+	}
+}
+
+// SetPollInterval 设置轮询间隔
+func (r *OutboxRelay) SetPollInterval(d time.Duration) {
+	if d > 0 {
+		r.pollInterval = d
+	}
+}
+
+// SetBatchSize 设置每次读取的最大事件数
+func (r *OutboxRelay) SetBatchSize(size int) {
+	if size > 0 {
+		r.batchSize = size
+	}
+}
+
+// SetMaxAttempts 设置事件成为毒丸前的最大发送次数
+func (r *OutboxRelay) SetMaxAttempts(attempts int) {
+	if attempts > 0 {
+		r.maxAttempts = attempts
+	}
+}
+
+// SetRetryBackoff 设置发送失败后第一次重试前的等待时间，之后每次失败加倍，最长1分钟
+func (r *OutboxRelay) SetRetryBackoff(d time.Duration) {
+	if d > 0 {
+		r.retryBackoff = d
+	}
+}
+
+// SetPoisonHandler 设置事件成为毒丸时的回调，例如告警或写入死信队列
+func (r *OutboxRelay) SetPoisonHandler(handler func(rec OutboxRecord, err error)) {
+	r.onPoison = handler
+}
+
+// Notify 唤醒中继立即发送，写入发件箱的事务提交后调用可以减少延迟
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Start 开始中继，阻塞直到ctx取消
+func (r *OutboxRelay) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		read, sent, err := r.relay(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			r.logger.Error("发送发件箱事件失败:", err)
+		}
+		// 读满一批且有事件发送成功时可能还有未发送的事件，直接继续；全部失败时等待下次轮询
+		if err == nil && read == r.batchSize && sent > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-r.notify:
+		}
+	}
+}
+
+// RelayOnce 读取一批未发送的事件并发送，返回读取的事件数
+// 未到重试时间的事件和同一聚合后续的事件本轮不发送
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	read, _, err := r.relay(ctx)
+	return read, err
+}
+
+// relay 读取一批未发送的事件并发送，返回读取和发送成功的事件数
+func (r *OutboxRelay) relay(ctx context.Context) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records, err := r.store.Pending(ctx, r.batchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("读取发件箱失败: %w", err)
+	}
+	if len(records) == 0 {
+		return 0, 0, nil
+	}
+
+	now := time.Now()
+	var sent []int64
+	blocked := make(map[string]bool) // 本轮有事件发送失败或等待重试的聚合
+	for _, rec := range records {
+		if blocked[rec.AggregateID] {
+			continue
+		}
+		if at, ok := r.retryAt[rec.ID]; ok && now.Before(at) {
+			blocked[rec.AggregateID] = true
+			continue
+		}
+
+		err := r.producer.SendMessageWithHeaders(ctx, rec.AggregateID, rec.Value, rec.Headers)
+		if err == nil {
+			sent = append(sent, rec.ID)
+			continue
+		}
+		if ctx.Err() != nil {
+			return len(records), 0, ctx.Err()
+		}
+
+		if !r.fail(ctx, rec, err) {
+			blocked[rec.AggregateID] = true
+		}
+	}
+
+	if len(sent) == 0 {
+		return len(records), 0, nil
+	}
+	if err := r.producer.Flush(); err != nil {
+		return len(records), 0, fmt.Errorf("发送事件失败，%d 条事件将重试: %w", len(sent), err)
+	}
+	if err := r.store.MarkSent(ctx, sent...); err != nil {
+		return len(records), 0, fmt.Errorf("标记事件已发送失败: %w", err)
+	}
+	for _, id := range sent {
+		delete(r.retryAt, id)
+	}
+	return len(records), len(sent), nil
+}
+
+// fail 记录发送失败，返回事件是否成为毒丸
+func (r *OutboxRelay) fail(ctx context.Context, rec OutboxRecord, err error) bool {
+	poison := errors.Is(err, ErrPoisonRecord) || rec.Attempts+1 >= r.maxAttempts
+	if markErr := r.store.MarkFailed(ctx, rec.ID, err.Error(), poison); markErr != nil {
+		r.logger.Error("记录发送失败出错, id:", rec.ID, "error:", markErr)
+	}
+
+	if !poison {
+		r.retryAt[rec.ID] = time.Now().Add(r.backoff(rec.Attempts + 1))
+		r.logger.Error("发送事件失败, id:", rec.ID, "aggregate:", rec.AggregateID, "attempts:", rec.Attempts+1, "error:", err)
+		return false
+	}
+	delete(r.retryAt, rec.ID)
+
+	r.logger.Error("事件成为毒丸, id:", rec.ID, "aggregate:", rec.AggregateID, "error:", err)
+	if r.onPoison != nil {
+		rec.Attempts++
+		rec.LastError = err.Error()
+		r.onPoison(rec, err)
+	}
+	return true
+}
+
+// backoff 第attempts次发送失败后的重试间隔
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	d := r.retryBackoff
+	for i := 1; i < attempts && d < maxOutboxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxOutboxRetryBackoff)
+}
@@ -0,0 +1,275 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileOutboxStore 本地文件发件箱，用于测试和没有数据库的单机服务
+// 每次写入和状态变化追加一行JSON，打开时和追加的行数过多时压缩，已发送的事件在压缩时删除
+type FileOutboxStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	nextID  int64
+	records map[int64]*fileOutboxEntry // 未发送和毒丸事件
+	lines   int
+}
+
+// fileOutboxEntry 日志中的一行
+type fileOutboxEntry struct {
+	Op     string        `json:"op"` // next、add、sent、failed
+	NextID int64         `json:"next_id,omitempty"`
+	Record *OutboxRecord `json:"record,omitempty"`
+	IDs    []int64       `json:"ids,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Poison bool          `json:"poison,omitempty"`
+}
+
+var _ OutboxStore = (*FileOutboxStore)(nil)
+
+// NewFileOutboxStore 打开或创建发件箱文件，不再使用时需要调用 Close
+func NewFileOutboxStore(path string) (*FileOutboxStore, error) {
+	s := &FileOutboxStore{
+		path:    path,
+		nextID:  1,
+		records: make(map[int64]*fileOutboxEntry),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 重放日志，忽略进程崩溃时写了一半的行
+func (s *FileOutboxStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("打开发件箱失败: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry fileOutboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		s.applyLocked(&entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取发件箱失败: %w", err)
+	}
+	return nil
+}
+
+// applyLocked 将一行日志应用到内存状态
+func (s *FileOutboxStore) applyLocked(entry *fileOutboxEntry) {
+	switch entry.Op {
+	case "next":
+		if entry.NextID > s.nextID {
+			s.nextID = entry.NextID
+		}
+	case "add":
+		if entry.Record == nil {
+			return
+		}
+		s.records[entry.Record.ID] = &fileOutboxEntry{Record: entry.Record, Poison: entry.Poison}
+		if entry.Record.ID >= s.nextID {
+			s.nextID = entry.Record.ID + 1
+		}
+	case "sent":
+		for _, id := range entry.IDs {
+			delete(s.records, id)
+		}
+	case "failed":
+		for _, id := range entry.IDs {
+			if e, ok := s.records[id]; ok {
+				e.Record.Attempts++
+				e.Record.LastError = entry.Error
+				e.Poison = entry.Poison
+			}
+		}
+	}
+}
+
+// compact 将下一个事件ID和未发送的事件写入临时文件后替换原文件
+// 第一行记录下一个事件ID，已发送的事件删除后重新打开也不会重复使用它们的ID
+func (s *FileOutboxStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("创建发件箱失败: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.Encode(&fileOutboxEntry{Op: "next", NextID: s.nextID})
+	for _, id := range s.sortedIDsLocked(true) {
+		e := s.records[id]
+		enc.Encode(&fileOutboxEntry{Op: "add", Record: e.Record, Poison: e.Poison})
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("写入发件箱失败: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("写入发件箱失败: %w", err)
+	}
+	f.Close()
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("替换发件箱失败: %w", err)
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开发件箱失败: %w", err)
+	}
+	s.lines = len(s.records) + 1
+
+	// 确保重命名持久化
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// writeLocked 追加一行日志并同步到磁盘，成功后更新内存状态
+func (s *FileOutboxStore) writeLocked(entry *fileOutboxEntry) error {
+	if s.file == nil {
+		return fmt.Errorf("发件箱已关闭")
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("写入发件箱失败: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("写入发件箱失败: %w", err)
+	}
+	s.applyLocked(entry)
+	s.lines++
+
+	if s.lines > 2*len(s.records)+1024 {
+		return s.compact()
+	}
+	return nil
+}
+
+// sortedIDsLocked 按ID排序的事件，withPoison 为false时不包括毒丸事件
+func (s *FileOutboxStore) sortedIDsLocked(withPoison bool) []int64 {
+	ids := make([]int64, 0, len(s.records))
+	for id, e := range s.records {
+		if withPoison || !e.Poison {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Append 写入事件，返回时已同步到磁盘
+func (s *FileOutboxStore) Append(ctx context.Context, rec OutboxRecord) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec.ID = s.nextID
+	rec.Attempts = 0
+	rec.LastError = ""
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+	if err := s.writeLocked(&fileOutboxEntry{Op: "add", Record: &rec}); err != nil {
+		return 0, err
+	}
+	return rec.ID, nil
+}
+
+// Pending 按ID顺序返回未发送的事件
+func (s *FileOutboxStore) Pending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.sortedIDsLocked(false)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	records := make([]OutboxRecord, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, *s.records[id].Record)
+	}
+	return records, nil
+}
+
+// MarkSent 标记事件已发送
+func (s *FileOutboxStore) MarkSent(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeLocked(&fileOutboxEntry{Op: "sent", IDs: ids})
+}
+
+// MarkFailed 记录一次发送失败
+func (s *FileOutboxStore) MarkFailed(ctx context.Context, id int64, cause string, poison bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[id]; !ok {
+		return nil
+	}
+	return s.writeLocked(&fileOutboxEntry{Op: "failed", IDs: []int64{id}, Error: cause, Poison: poison})
+}
+
+// Poisoned 按ID顺序返回毒丸事件
+func (s *FileOutboxStore) Poisoned(ctx context.Context) ([]OutboxRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []OutboxRecord
+	for _, id := range s.sortedIDsLocked(true) {
+		if e := s.records[id]; e.Poison {
+			records = append(records, *e.Record)
+		}
+	}
+	return records, nil
+}
+
+// Close 关闭发件箱文件
+func (s *FileOutboxStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 发件箱事件状态
+const (
+	outboxPending = 0
+	outboxSent    = 1
+	outboxPoison  = 2
+)
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLiteOutboxStore 基于 database/sql 的SQLite发件箱
+// 不依赖具体驱动，调用方导入驱动后打开数据库，例如 modernc.org/sqlite（"sqlite"）或 github.com/mattn/go-sqlite3（"sqlite3"）
+type SQLiteOutboxStore struct {
+	db    *sql.DB
+	table string
+}
+
+var _ OutboxStore = (*SQLiteOutboxStore)(nil)
+
+// NewSQLiteOutboxStore 创建发件箱，table 不存在时自动创建
+func NewSQLiteOutboxStore(ctx context.Context, db *sql.DB, table string) (*SQLiteOutboxStore, error) {
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("无效的表名: %q", table)
+	}
+
+	s := &SQLiteOutboxStore{db: db, table: table}
+	schema := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	aggregate_id TEXT    NOT NULL,
+	value        TEXT    NOT NULL,
+	headers      TEXT,
+	created_at   INTEGER NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	last_error   TEXT,
+	status       INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS %[1]s_status_id ON %[1]s (status, id);`, table)
+
+	for _, stmt := range strings.Split(schema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("创建发件箱表失败: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Append 写入事件
+func (s *SQLiteOutboxStore) Append(ctx context.Context, rec OutboxRecord) (int64, error) {
+	return s.append(ctx, s.db, rec)
+}
+
+// AppendTx 在业务事务中写入事件，事务提交后事件才对中继可见
+func (s *SQLiteOutboxStore) AppendTx(ctx context.Context, tx *sql.Tx, rec OutboxRecord) (int64, error) {
+	return s.append(ctx, tx, rec)
+}
+
+// execer sql.DB 和 sql.Tx 共有的方法
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *SQLiteOutboxStore) append(ctx context.Context, db execer, rec OutboxRecord) (int64, error) {
+	var headers []byte
+	if len(rec.Headers) > 0 {
+		headers, _ = json.Marshal(rec.Headers)
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+
+	res, err := db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (aggregate_id, value, headers, created_at) VALUES (?, ?, ?, ?)", s.table),
+		rec.AggregateID, rec.Value, string(headers), rec.CreatedAt.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("写入发件箱失败: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// Pending 按ID顺序返回未发送的事件
+func (s *SQLiteOutboxStore) Pending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT id, aggregate_id, value, headers, created_at, attempts, last_error
+FROM %s WHERE status = ? ORDER BY id LIMIT ?`, s.table),
+		outboxPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []OutboxRecord
+	for rows.Next() {
+		var (
+			rec       OutboxRecord
+			headers   sql.NullString
+			createdAt int64
+			lastError sql.NullString
+		)
+		if err := rows.Scan(&rec.ID, &rec.AggregateID, &rec.Value, &headers, &createdAt, &rec.Attempts, &lastError); err != nil {
+			return nil, err
+		}
+		if headers.String != "" {
+			if err := json.Unmarshal([]byte(headers.String), &rec.Headers); err != nil {
+				return nil, fmt.Errorf("解析事件 %d 的消息头失败: %w", rec.ID, err)
+			}
+		}
+		rec.CreatedAt = time.Unix(0, createdAt)
+		rec.LastError = lastError.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// MarkSent 标记事件已发送
+func (s *SQLiteOutboxStore) MarkSent(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, outboxSent)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET status = ? WHERE id IN (%s)", s.table, placeholders), args...)
+	return err
+}
+
+// MarkFailed 记录一次发送失败
+func (s *SQLiteOutboxStore) MarkFailed(ctx context.Context, id int64, cause string, poison bool) error {
+	status := outboxPending
+	if poison {
+		status = outboxPoison
+	}
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = ?, status = ? WHERE id = ?", s.table),
+		cause, status, id)
+	return err
+}
+
+// Poisoned 按ID顺序返回毒丸事件
+func (s *SQLiteOutboxStore) Poisoned(ctx context.Context) ([]OutboxRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT id, aggregate_id, value, attempts, last_error FROM %s WHERE status = ? ORDER BY id", s.table),
+		outboxPoison)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []OutboxRecord
+	for rows.Next() {
+		var rec OutboxRecord
+		var lastError sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.AggregateID, &rec.Value, &rec.Attempts, &lastError); err != nil {
+			return nil, err
+		}
+		rec.LastError = lastError.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// DeleteSent 删除在 before 之前写入且已发送的事件，返回删除的条数
+func (s *SQLiteOutboxStore) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE status = ? AND created_at < ?", s.table),
+		outboxSent, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,44 @@
+package producer
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Producer 生产者通用接口
+// SimpleProducer、AsyncProducer、BatchProducer 以及 metrics.InstrumentedProducer 等装饰器都实现了该接口
+type Producer interface {
+	// SendMessage 发送单条消息
+	SendMessage(ctx context.Context, key, value string) error
+	// SendMessageWithHeaders 发送带消息头的消息
+	SendMessageWithHeaders(ctx context.Context, key, value string, headers map[string]string) error
+	// Flush 发送所有缓冲中的消息，同步生产者直接返回
+	Flush() error
+	// Stats 获取统计信息
+	Stats() kafka.WriterStats
+	// Close 关闭生产者
+	Close() error
+}
+
+var (
+	_ Producer = (*SimpleProducer)(nil)
+	_ Producer = (*AsyncProducer)(nil)
+	_ Producer = (*BatchProducer)(nil)
+)
+
+// toHeaders 将map转换为Kafka消息头
+func toHeaders(headers map[string]string) []kafka.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	kafkaHeaders := make([]kafka.Header, 0, len(headers))
+	for k, v := range headers {
+		kafkaHeaders = append(kafkaHeaders, kafka.Header{
+			Key:   k,
+			Value: []byte(v),
+		})
+	}
+	return kafkaHeaders
+}
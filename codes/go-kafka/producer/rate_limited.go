@@ -0,0 +1,84 @@
+package producer
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+)
+
+// Limiter 限流器接口，middleware.RateLimiter 实现了该接口
+type Limiter interface {
+	// WaitMessage 等待发送该消息的令牌
+	WaitMessage(ctx context.Context, msg kafka.Message) error
+}
+
+// rateSetter 支持运行时修改速度的限流器，例如 middleware.RateLimiter
+type rateSetter interface {
+	SetRate(rate int, per time.Duration, burst int)
+}
+
+// RateLimitedProducer 限流生产者装饰器，发送前等待令牌，实现 Producer 接口
+type RateLimitedProducer struct {
+	producer Producer
+	limiter  Limiter
+}
+
+var _ Producer = (*RateLimitedProducer)(nil)
+
+// NewRateLimitedProducer 创建限流生产者
+func NewRateLimitedProducer(p Producer, limiter Limiter) *RateLimitedProducer {
+	return &RateLimitedProducer{
+		producer: p,
+		limiter:  limiter,
+	}
+}
+
+// SendMessage 等待令牌后发送消息
+func (p *RateLimitedProducer) SendMessage(ctx context.Context, key, value string) error {
+	msg := kafka.Message{Key: []byte(key), Value: []byte(value)}
+	if err := p.limiter.WaitMessage(ctx, msg); err != nil {
+		return err
+	}
+	return p.producer.SendMessage(ctx, key, value)
+}
+
+// SendMessageWithHeaders 等待令牌后发送带消息头的消息，可按消息头限流
+func (p *RateLimitedProducer) SendMessageWithHeaders(ctx context.Context, key, value string, headers map[string]string) error {
+	msg := kafka.Message{Key: []byte(key), Value: []byte(value), Headers: toHeaders(headers)}
+	if err := p.limiter.WaitMessage(ctx, msg); err != nil {
+		return err
+	}
+	return p.producer.SendMessageWithHeaders(ctx, key, value, headers)
+}
+
+// Reload 实时应用 producer.rate_limit，限流器不支持修改速度或 rate 改为0时需要重新创建生产者
+func (p *RateLimitedProducer) Reload(cfg *config.KafkaConfig, changes config.Changes) []string {
+	fields := changes.Fields("producer.rate_limit")
+	if len(fields) == 0 {
+		return nil
+	}
+
+	rc := cfg.Producer.RateLimit
+	if s, ok := p.limiter.(rateSetter); ok && rc.Rate > 0 {
+		s.SetRate(rc.Rate, rc.Per.Std(), rc.Burst)
+		return nil
+	}
+	return fields
+}
+
+// Flush 发送缓冲中的消息
+func (p *RateLimitedProducer) Flush() error {
+	return p.producer.Flush()
+}
+
+// Stats 获取统计信息
+func (p *RateLimitedProducer) Stats() kafka.WriterStats {
+	return p.producer.Stats()
+}
+
+// Close 关闭生产者，不会停止共享的限流器
+func (p *RateLimitedProducer) Close() error {
+	return p.producer.Close()
+}
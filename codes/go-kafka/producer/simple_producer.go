@@ -7,14 +7,16 @@ import (
 
 	"github.com/segmentio/kafka-go"
 	"go-kafka/config"
+	"go-kafka/transport"
 	"go-kafka/utils"
 )
 
 // SimpleProducer 简单同步生产者
 type SimpleProducer struct {
-	writer *kafka.Writer
-	config *config.KafkaConfig
-	logger *utils.Logger
+	writer      transport.Writer
+	config      *config.KafkaConfig
+	logger      *utils.Logger
+	partitioner kafka.Balancer
 }
 
 // NewSimpleProducer 创建简单生产者
@@ -25,9 +27,14 @@ func NewSimpleProducer(cfg *config.KafkaConfig) *SimpleProducer {
 	}
 }
 
+// SetPartitioner 设置分区器，优先于 producer.partitioner，需在 Connect 之前调用
+func (p *SimpleProducer) SetPartitioner(b kafka.Balancer) {
+	p.partitioner = b
+}
+
 // Connect 连接到Kafka
 func (p *SimpleProducer) Connect() error {
-	p.writer = &kafka.Writer{
+	w := &kafka.Writer{
 		Addr:     kafka.TCP(p.config.Brokers...),
 		Topic:    p.config.Topic,
 		Balancer: &kafka.Hash{}, // 使用Hash分区器，确保相同key的消息进入同一分区
@@ -46,6 +53,13 @@ func (p *SimpleProducer) Connect() error {
 		BatchSize:    100,
 		BatchBytes:   1048576, // 1MB
 	}
+	if err := p.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	if p.partitioner != nil {
+		w.Balancer = p.partitioner
+	}
+	p.writer = p.config.GetTransport().NewWriter(w)
 
 	p.logger.Info("生产者连接成功，brokers:", p.config.Brokers)
 	return nil
@@ -73,18 +87,10 @@ func (p *SimpleProducer) SendMessageWithHeaders(
 	key, value string,
 	headers map[string]string,
 ) error {
-	kafkaHeaders := make([]kafka.Header, 0, len(headers))
-	for k, v := range headers {
-		kafkaHeaders = append(kafkaHeaders, kafka.Header{
-			Key:   k,
-			Value: []byte(v),
-		})
-	}
-
 	msg := kafka.Message{
 		Key:     []byte(key),
 		Value:   []byte(value),
-		Headers: kafkaHeaders,
+		Headers: toHeaders(headers),
 		Time:    time.Now(),
 	}
 
@@ -115,6 +121,11 @@ func (p *SimpleProducer) SendMessagesBatch(ctx context.Context, messages []struc
 	return nil
 }
 
+// Flush 同步生产者没有缓冲区，直接返回
+func (p *SimpleProducer) Flush() error {
+	return nil
+}
+
 // Close 关闭生产者
 func (p *SimpleProducer) Close() error {
 	if p.writer != nil {
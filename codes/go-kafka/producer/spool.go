@@ -0,0 +1,170 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/spool"
+	"go-kafka/utils"
+)
+
+const defaultSpoolRetryInterval = 5 * time.Second
+
+// spooler BatchProducer 和 AsyncProducer 的本地磁盘缓存，配置 producer.spool.dir 时启用
+// 发送失败的消息写入缓存，后台协程定时按写入顺序重放；缓存中有未重放的消息时新的批次也写入缓存，
+// 启用后批次依次发送。重放的批次部分写入失败时整批重新发送，消息可能重复
+type spooler struct {
+	spool    *spool.Spool
+	logger   *utils.Logger
+	interval time.Duration
+
+	mu     sync.Mutex // 直接发送和重放依次进行，保证消息顺序
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newSpooler 打开缓存目录，未配置 producer.spool.dir 时返回nil
+func newSpooler(cfg config.SpoolConfig, logger *utils.Logger) (*spooler, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	sp, err := spool.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("打开本地缓存失败: %w", err)
+	}
+
+	s := &spooler{
+		spool:    sp,
+		logger:   logger,
+		interval: cfg.RetryInterval.Std(),
+	}
+	if s.interval <= 0 {
+		s.interval = defaultSpoolRetryInterval
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// start 启动后台重放，立即重放上次运行留下的消息
+func (s *spooler) start(write func(ctx context.Context, msgs []kafka.Message) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.replay(write)
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// replay 重放缓存中的全部消息，失败时等待下次重放
+func (s *spooler) replay(write func(ctx context.Context, msgs []kafka.Message) error) {
+	if !s.spool.Pending() {
+		return
+	}
+	n, err := s.spool.Replay(s.ctx, func(ctx context.Context, msgs []kafka.Message) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return write(ctx, msgs)
+	})
+	if n > 0 {
+		s.logger.Info("重放本地缓存的消息，数量:", n)
+	}
+	if err != nil && s.ctx.Err() == nil {
+		s.logger.Error("重放本地缓存失败:", err)
+	}
+}
+
+// send 发送批次，返回每条消息的结果：nil 为已发送，ErrSpooled 为已写入缓存，其他为发送和写入缓存都失败
+func (s *spooler) send(msgs []kafka.Message, write func(msgs []kafka.Message) error) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]error, len(msgs))
+
+	// 缓存中有未重放的消息，直接写入缓存，避免新消息先于缓存中的消息到达
+	if s.spool.Pending() {
+		s.save(msgs, nil, results, nil)
+		return results
+	}
+
+	err := write(msgs)
+	if err == nil {
+		return results
+	}
+
+	var writeErrs kafka.WriteErrors
+	perMessage := errors.As(err, &writeErrs) && len(writeErrs) == len(msgs)
+
+	var failed []kafka.Message
+	var index []int
+	for i := range msgs {
+		msgErr := err
+		if perMessage {
+			msgErr = writeErrs[i]
+		}
+		if msgErr != nil {
+			failed = append(failed, msgs[i])
+			index = append(index, i)
+			results[i] = msgErr
+		}
+	}
+	s.save(failed, index, results, err)
+	return results
+}
+
+// save 写入缓存，index 为消息在 results 中的位置（nil 表示依次对应），写入失败时保留原来的结果
+func (s *spooler) save(msgs []kafka.Message, index []int, results []error, cause error) {
+	stored := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		// 写入器已设置Topic；幂等模式下分区和序列号一起分配，重放时必须写入原来的分区，
+		// 其他模式下写入器的分区器不使用 msg.Partition，重放时重新选择分区
+		stored[i] = kafka.Message{Partition: msg.Partition, Key: msg.Key, Value: msg.Value, Headers: msg.Headers, Time: msg.Time}
+	}
+
+	err := s.spool.Append(stored)
+	for i := range msgs {
+		pos := i
+		if index != nil {
+			pos = index[i]
+		}
+		switch {
+		case err == nil:
+			results[pos] = ErrSpooled
+		case results[pos] == nil:
+			results[pos] = fmt.Errorf("写入本地缓存失败: %w", err)
+		}
+	}
+
+	if err != nil {
+		s.logger.Error("写入本地缓存失败，数量:", len(msgs), "error:", err)
+	} else if cause != nil {
+		s.logger.Error("批量发送失败，已写入本地缓存，数量:", len(msgs), "error:", cause)
+	}
+}
+
+// stats 缓存状态
+func (s *spooler) stats() spool.Stats {
+	return s.spool.Stats()
+}
+
+// close 停止重放并关闭缓存，未重放的消息在下次启动时重放
+func (s *spooler) close() error {
+	s.cancel()
+	s.wg.Wait()
+	return s.spool.Close()
+}
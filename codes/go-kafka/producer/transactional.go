@@ -0,0 +1,172 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// TransactionalProducer 事务生产者，同一事务中发送的消息和消费偏移量一起提交或中止
+// 需要支持事务的传输层（transport.Transactional），实现 Producer 接口，SendMessage 需在事务中调用
+type TransactionalProducer struct {
+	writer          transport.TxnWriter
+	config          *config.KafkaConfig
+	logger          *utils.Logger
+	transactionalID string
+	partitioner     kafka.Balancer
+}
+
+var _ Producer = (*TransactionalProducer)(nil)
+
+// NewTransactionalProducer 创建事务生产者，transactionalID 为空时使用 producer.transactional_id
+func NewTransactionalProducer(cfg *config.KafkaConfig, transactionalID string) *TransactionalProducer {
+	if transactionalID == "" {
+		transactionalID = cfg.Producer.TransactionalID
+	}
+	return &TransactionalProducer{
+		config:          cfg,
+		logger:          utils.NewLogger("[TransactionalProducer]"),
+		transactionalID: transactionalID,
+	}
+}
+
+// SetPartitioner 设置分区器，优先于 producer.partitioner，需在 Connect 之前调用
+func (p *TransactionalProducer) SetPartitioner(b kafka.Balancer) {
+	p.partitioner = b
+}
+
+// Connect 连接到Kafka，相同 transactionalID 的旧实例被隔离
+func (p *TransactionalProducer) Connect() error {
+	if p.transactionalID == "" {
+		return errors.New("事务生产者需要设置 transactional_id")
+	}
+
+	t, ok := p.config.GetTransport().(transport.Transactional)
+	if !ok {
+		return transport.ErrTransactionsUnsupported
+	}
+
+	// Topic 留空，未指定Topic的消息写入 config.Topic，事务中可以写入多个Topic
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(p.config.Brokers...),
+		Balancer:     &kafka.Hash{},
+		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  10 * time.Second,
+		MaxAttempts:  3,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	if err := p.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	w.RequiredAcks = kafka.RequireAll // 事务要求所有副本确认
+	if p.partitioner != nil {
+		w.Balancer = p.partitioner
+	}
+
+	writer, err := t.NewTxnWriter(w, p.transactionalID)
+	if err != nil {
+		return fmt.Errorf("初始化事务失败: %w", err)
+	}
+	p.writer = writer
+
+	p.logger.Info("事务生产者连接成功，transactionalID:", p.transactionalID)
+	return nil
+}
+
+// Begin 开始事务
+func (p *TransactionalProducer) Begin() error {
+	return p.writer.BeginTxn()
+}
+
+// Send 在当前事务中发送消息，未指定Topic的消息写入 config.Topic
+func (p *TransactionalProducer) Send(ctx context.Context, msgs ...kafka.Message) error {
+	for i := range msgs {
+		if msgs[i].Topic == "" {
+			msgs[i].Topic = p.config.Topic
+		}
+		if msgs[i].Time.IsZero() {
+			msgs[i].Time = time.Now()
+		}
+	}
+
+	if err := p.writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("事务中发送消息失败: %w", err)
+	}
+	return nil
+}
+
+// SendMessage 在当前事务中发送消息
+func (p *TransactionalProducer) SendMessage(ctx context.Context, key, value string) error {
+	return p.Send(ctx, kafka.Message{Key: []byte(key), Value: []byte(value)})
+}
+
+// SendMessageWithHeaders 在当前事务中发送带消息头的消息
+func (p *TransactionalProducer) SendMessageWithHeaders(ctx context.Context, key, value string, headers map[string]string) error {
+	return p.Send(ctx, kafka.Message{Key: []byte(key), Value: []byte(value), Headers: toHeaders(headers)})
+}
+
+// SendOffsets 将已消费的消息的偏移量加入当前事务，每个分区提交最大偏移量的下一条
+func (p *TransactionalProducer) SendOffsets(ctx context.Context, groupID string, consumed ...kafka.Message) error {
+	if len(consumed) == 0 {
+		return nil
+	}
+
+	offsets := make(map[string]map[int]int64)
+	for _, msg := range consumed {
+		if offsets[msg.Topic] == nil {
+			offsets[msg.Topic] = make(map[int]int64)
+		}
+		if next, ok := offsets[msg.Topic][msg.Partition]; !ok || msg.Offset+1 > next {
+			offsets[msg.Topic][msg.Partition] = msg.Offset + 1
+		}
+	}
+
+	if err := p.writer.SendOffsets(ctx, groupID, offsets); err != nil {
+		return fmt.Errorf("提交偏移量到事务失败: %w", err)
+	}
+	return nil
+}
+
+// Commit 提交事务
+func (p *TransactionalProducer) Commit(ctx context.Context) error {
+	if err := p.writer.CommitTxn(ctx); err != nil {
+		return fmt.Errorf("提交事务失败: %w", err)
+	}
+	return nil
+}
+
+// Abort 中止事务，事务中发送的消息不会被 read_committed 的消费者读取
+func (p *TransactionalProducer) Abort(ctx context.Context) error {
+	if err := p.writer.AbortTxn(ctx); err != nil {
+		return fmt.Errorf("中止事务失败: %w", err)
+	}
+	return nil
+}
+
+// Flush 消息在发送时已写入，直接返回
+func (p *TransactionalProducer) Flush() error {
+	return nil
+}
+
+// Stats 获取统计信息
+func (p *TransactionalProducer) Stats() kafka.WriterStats {
+	return p.writer.Stats()
+}
+
+// Close 关闭生产者，进行中的事务被中止
+func (p *TransactionalProducer) Close() error {
+	if p.writer != nil {
+		if err := p.writer.Close(); err != nil {
+			return fmt.Errorf("关闭生产者失败: %w", err)
+		}
+	}
+
+	p.logger.Info("事务生产者已关闭")
+	return nil
+}
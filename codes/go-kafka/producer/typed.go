@@ -0,0 +1,61 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/serializer"
+)
+
+// TypedProducer 强类型生产者，通过序列化器将T序列化后发送
+type TypedProducer[T any] struct {
+	producer   Producer
+	serializer serializer.Serializer
+}
+
+// NewTypedProducer 创建强类型生产者，p 需已完成连接
+func NewTypedProducer[T any](p Producer, s serializer.Serializer) *TypedProducer[T] {
+	return &TypedProducer[T]{
+		producer:   p,
+		serializer: s,
+	}
+}
+
+// Send 序列化后发送消息
+func (p *TypedProducer[T]) Send(ctx context.Context, key string, value T) error {
+	return p.SendWithHeaders(ctx, key, value, nil)
+}
+
+// SendWithHeaders 序列化后发送带消息头的消息
+// 序列化器实现 serializer.HeaderSerializer 时（如压缩、加密包装器），一并发送它写入的消息头
+func (p *TypedProducer[T]) SendWithHeaders(ctx context.Context, key string, value T, headers map[string]string) error {
+	data, headers, err := serializer.SerializeWithHeaders(p.serializer, value, headers)
+	if err != nil {
+		return fmt.Errorf("序列化失败: %w", err)
+	}
+	if len(headers) == 0 {
+		return p.producer.SendMessage(ctx, key, string(data))
+	}
+	return p.producer.SendMessageWithHeaders(ctx, key, string(data), headers)
+}
+
+// Flush 发送缓冲中的消息
+func (p *TypedProducer[T]) Flush() error {
+	return p.producer.Flush()
+}
+
+// Stats 获取统计信息
+func (p *TypedProducer[T]) Stats() kafka.WriterStats {
+	return p.producer.Stats()
+}
+
+// Producer 返回底层生产者
+func (p *TypedProducer[T]) Producer() Producer {
+	return p.producer
+}
+
+// Close 关闭生产者
+func (p *TypedProducer[T]) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,68 @@
+package producer
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/serializer"
+)
+
+// Validator payload校验接口，serializer.Validator 实现了该接口
+type Validator interface {
+	// Validate 按subject的schema校验payload
+	Validate(subject string, payload []byte) error
+}
+
+// ValidatingProducer 校验生产者装饰器，发送前按schema校验payload，不满足时拒绝发送，实现 Producer 接口
+// 校验的是传入的value，启用压缩或加密序列化器时应改用 serializer.ValidatingSerializer
+type ValidatingProducer struct {
+	producer  Producer
+	validator Validator
+	subject   string
+}
+
+var _ Producer = (*ValidatingProducer)(nil)
+
+// NewValidatingProducer 创建校验生产者，subject 通常为 "<topic>-value"
+func NewValidatingProducer(p Producer, validator Validator, subject string) *ValidatingProducer {
+	return &ValidatingProducer{
+		producer:  p,
+		validator: validator,
+		subject:   subject,
+	}
+}
+
+// SendMessage 校验通过后发送消息，校验失败时返回 *serializer.ValidationError
+func (p *ValidatingProducer) SendMessage(ctx context.Context, key, value string) error {
+	if err := p.validator.Validate(p.subject, []byte(value)); err != nil {
+		return err
+	}
+	return p.producer.SendMessage(ctx, key, value)
+}
+
+// SendMessageWithHeaders 校验通过后发送带消息头的消息
+// 消息头表明value已被压缩或加密时返回 serializer.ErrEncodedPayload，此时应使用 serializer.ValidatingSerializer
+func (p *ValidatingProducer) SendMessageWithHeaders(ctx context.Context, key, value string, headers map[string]string) error {
+	if serializer.Encoded(headers) {
+		return serializer.ErrEncodedPayload
+	}
+	if err := p.validator.Validate(p.subject, []byte(value)); err != nil {
+		return err
+	}
+	return p.producer.SendMessageWithHeaders(ctx, key, value, headers)
+}
+
+// Flush 发送缓冲中的消息
+func (p *ValidatingProducer) Flush() error {
+	return p.producer.Flush()
+}
+
+// Stats 获取统计信息
+func (p *ValidatingProducer) Stats() kafka.WriterStats {
+	return p.producer.Stats()
+}
+
+// Close 关闭生产者
+func (p *ValidatingProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,369 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+	"go-kafka/consumer"
+	"go-kafka/middleware"
+	"go-kafka/transport"
+	"go-kafka/utils"
+)
+
+// 重试消息头
+const (
+	HeaderAttempt           = "x-retry-attempt"      // 已重试次数
+	HeaderNotBefore         = "x-retry-not-before"   // 最早重新投递时间（Unix毫秒）
+	HeaderError             = "x-retry-error"        // 最近一次失败原因
+	HeaderOriginalTopic     = "x-original-topic"     // 原始Topic
+	HeaderOriginalPartition = "x-original-partition" // 原始分区
+	HeaderOriginalOffset    = "x-original-offset"    // 原始偏移量
+)
+
+// Ladder 重试Topic阶梯
+// 处理失败的消息依次进入 <topic>.retry.1 ... <topic>.retry.n，每一级延迟递增，
+// 最后一级仍失败时进入死信队列。延迟通过消息头中的最早投递时间实现，不会阻塞主Topic的消费
+type Ladder struct {
+	config   *config.KafkaConfig
+	delays   []time.Duration
+	dlqTopic string
+	backoff  time.Duration
+	writer   transport.Writer
+	readers  []transport.Reader
+	mu       sync.Mutex
+	logger   *utils.Logger
+}
+
+// Option 重试阶梯配置选项
+type Option func(*Ladder)
+
+// WithDLQTopic 设置死信队列Topic，默认为 <topic>.dlq
+func WithDLQTopic(topic string) Option {
+	return func(l *Ladder) {
+		if topic != "" {
+			l.dlqTopic = topic
+		}
+	}
+}
+
+// WithRouteBackoff 设置发送到重试Topic失败和读取重试消息失败后的重试间隔
+func WithRouteBackoff(backoff time.Duration) Option {
+	return func(l *Ladder) {
+		if backoff > 0 {
+			l.backoff = backoff
+		}
+	}
+}
+
+// NewLadder 创建重试阶梯
+// delays: 每一级重试Topic的延迟，为空时使用 10s、1m、10m
+func NewLadder(cfg *config.KafkaConfig, delays []time.Duration, options ...Option) *Ladder {
+	if len(delays) == 0 {
+		delays = []time.Duration{10 * time.Second, 1 * time.Minute, 10 * time.Minute}
+	}
+
+	l := &Ladder{
+		config:   cfg,
+		delays:   delays,
+		dlqTopic: cfg.Topic + ".dlq",
+		backoff:  1 * time.Second,
+		logger:   utils.NewLogger("[RetryLadder]"),
+	}
+
+	for _, opt := range options {
+		opt(l)
+	}
+
+	return l
+}
+
+// Connect 连接到Kafka
+func (l *Ladder) Connect() error {
+	w := &kafka.Writer{
+		Addr: kafka.TCP(l.config.Brokers...),
+
+		AllowAutoTopicCreation: true,
+
+		WriteTimeout: 10 * time.Second,
+		MaxAttempts:  3,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	if err := l.config.ApplyWriter(w); err != nil {
+		return fmt.Errorf("连接配置错误: %w", err)
+	}
+	// 重试消息不能丢失，且要保持相同key进入同一分区，不受配置影响
+	w.Balancer = &kafka.Hash{}
+	w.RequiredAcks = kafka.RequireAll
+	l.writer = l.config.GetTransport().NewWriter(w)
+
+	l.logger.Info("重试阶梯连接成功, 级数:", len(l.delays), "死信队列:", l.dlqTopic)
+	return nil
+}
+
+// RetryTopic 返回第n级重试Topic（从1开始）
+func (l *Ladder) RetryTopic(n int) string {
+	return fmt.Sprintf("%s.retry.%d", l.config.Topic, n)
+}
+
+// DLQTopic 返回死信队列Topic
+func (l *Ladder) DLQTopic() string {
+	return l.dlqTopic
+}
+
+// Route 将处理失败的消息发送到下一级重试Topic，超过最大级数时发送到死信队列
+func (l *Ladder) Route(ctx context.Context, msg kafka.Message, cause error) error {
+	attempt := Attempt(msg) + 1
+
+	topic := l.dlqTopic
+	var notBefore time.Time
+	if attempt <= len(l.delays) {
+		topic = l.RetryTopic(attempt)
+		notBefore = time.Now().Add(l.delays[attempt-1])
+	}
+
+	out := kafka.Message{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: retryHeaders(msg, attempt, notBefore, cause),
+		Time:    time.Now(),
+	}
+
+	if err := l.writer.WriteMessages(ctx, out); err != nil {
+		return fmt.Errorf("发送到%s失败: %w", topic, err)
+	}
+
+	l.logger.Info("消息已发送到", topic, "attempt:", attempt, "key:", string(msg.Key))
+	return nil
+}
+
+// SendToDLQ 实现 middleware.DeadLetterHandler，失败消息先进入重试阶梯而不是直接进入死信队列
+// 可用于 middleware.DeadLetterQueue 或 ManualCommitConsumer 的 FailureSkip 策略
+func (l *Ladder) SendToDLQ(ctx context.Context, msg kafka.Message, err error) error {
+	return l.Route(ctx, msg, err)
+}
+
+// Middleware 返回重试中间件，处理失败的消息发送到重试Topic后视为处理完成，不阻塞当前分区
+func (l *Ladder) Middleware() middleware.Middleware {
+	return func(next middleware.HandlerFunc) middleware.HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			err := next(ctx, msg)
+			if err == nil || ctx.Err() != nil {
+				// 消费者关闭导致的失败不进入重试Topic
+				return err
+			}
+
+			if routeErr := l.Route(ctx, msg, err); routeErr != nil {
+				return fmt.Errorf("%w (发送重试Topic失败: %v)", err, routeErr)
+			}
+			return nil
+		}
+	}
+}
+
+// Start 消费所有重试Topic，到达最早投递时间后重新调用handler，阻塞直到ctx取消
+// 每一级使用独立的消费者组 <groupID>.retry.<n>
+func (l *Ladder) Start(ctx context.Context, handler consumer.MessageHandler) error {
+	if l.config.GroupID == "" {
+		return errors.New("重试消费需要设置消费者组ID")
+	}
+
+	configs := make([]kafka.ReaderConfig, len(l.delays))
+	for i := range configs {
+		rc := kafka.ReaderConfig{
+			Brokers:  l.config.Brokers,
+			Topic:    l.RetryTopic(i + 1),
+			GroupID:  fmt.Sprintf("%s.retry.%d", l.config.GroupID, i+1),
+			MinBytes: 1,
+			MaxBytes: 10e6,
+			MaxWait:  1 * time.Second,
+		}
+		if err := l.config.ApplyReader(&rc); err != nil {
+			return fmt.Errorf("连接配置错误: %w", err)
+		}
+		// 重试消息处理完才提交，且不能跳过已有的重试消息
+		rc.CommitInterval = 0
+		rc.StartOffset = kafka.FirstOffset
+		configs[i] = rc
+	}
+
+	l.mu.Lock()
+	for _, rc := range configs {
+		l.readers = append(l.readers, l.config.GetTransport().NewReader(rc))
+	}
+	readers := l.readers
+	l.mu.Unlock()
+
+	l.logger.Info("开始消费重试Topic, 级数:", len(readers))
+
+	var wg sync.WaitGroup
+	for _, reader := range readers {
+		wg.Add(1)
+		go func(reader transport.Reader) {
+			defer wg.Done()
+			l.consume(ctx, reader, handler)
+		}(reader)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// consume 消费一级重试Topic
+// 同一级的延迟相同，消息按最早投递时间有序，等待队首消息即可
+func (l *Ladder) consume(ctx context.Context, reader transport.Reader, handler consumer.MessageHandler) {
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+			l.logger.Error("读取重试消息失败:", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.backoff):
+			}
+			continue
+		}
+
+		// 等待到最早投递时间
+		if wait := time.Until(NotBefore(msg)); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		if err := handler(ctx, original(msg)); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// 发送到下一级，失败时重试，避免提交后丢失消息
+			for {
+				routeErr := l.Route(ctx, msg, err)
+				if routeErr == nil {
+					break
+				}
+				l.logger.Error(routeErr)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(l.backoff):
+				}
+			}
+		}
+
+		if err := reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			l.logger.Error("提交重试消息失败:", err)
+		}
+	}
+}
+
+// Close 关闭重试阶梯
+func (l *Ladder) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, reader := range l.readers {
+		reader.Close()
+	}
+	l.readers = nil
+
+	if l.writer != nil {
+		if err := l.writer.Close(); err != nil {
+			return fmt.Errorf("关闭重试阶梯失败: %w", err)
+		}
+	}
+
+	l.logger.Info("重试阶梯已关闭")
+	return nil
+}
+
+// Attempt 返回消息已重试的次数，原始消息为0
+func Attempt(msg kafka.Message) int {
+	attempt, _ := strconv.Atoi(header(msg, HeaderAttempt))
+	return attempt
+}
+
+// NotBefore 返回消息的最早投递时间，没有设置时返回零值
+func NotBefore(msg kafka.Message) time.Time {
+	ms, err := strconv.ParseInt(header(msg, HeaderNotBefore), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// header 读取消息头
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// retryHeaders 构造重试消息头，保留业务消息头和首次失败时的原始位置
+func retryHeaders(msg kafka.Message, attempt int, notBefore time.Time, cause error) []kafka.Header {
+	origTopic, origPartition, origOffset := msg.Topic, strconv.Itoa(msg.Partition), strconv.FormatInt(msg.Offset, 10)
+	if t := header(msg, HeaderOriginalTopic); t != "" {
+		origTopic = t
+		origPartition = header(msg, HeaderOriginalPartition)
+		origOffset = header(msg, HeaderOriginalOffset)
+	}
+
+	headers := make([]kafka.Header, 0, len(msg.Headers)+6)
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderAttempt, HeaderNotBefore, HeaderError,
+			HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset:
+			continue
+		}
+		headers = append(headers, h)
+	}
+
+	headers = append(headers,
+		kafka.Header{Key: HeaderAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(origTopic)},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte(origPartition)},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte(origOffset)},
+	)
+	if cause != nil {
+		headers = append(headers, kafka.Header{Key: HeaderError, Value: []byte(cause.Error())})
+	}
+	if !notBefore.IsZero() {
+		// 向上取整到毫秒，重试消息不会早于配置的延迟投递
+		ms := notBefore.Add(time.Millisecond - 1).UnixMilli()
+		headers = append(headers, kafka.Header{Key: HeaderNotBefore, Value: []byte(strconv.FormatInt(ms, 10))})
+	}
+	return headers
+}
+
+// original 还原消息的原始Topic、分区和偏移量，便于handler按原始Topic处理
+func original(msg kafka.Message) kafka.Message {
+	topic := header(msg, HeaderOriginalTopic)
+	if topic == "" {
+		return msg
+	}
+
+	msg.Topic = topic
+	if partition, err := strconv.Atoi(header(msg, HeaderOriginalPartition)); err == nil {
+		msg.Partition = partition
+	}
+	if offset, err := strconv.ParseInt(header(msg, HeaderOriginalOffset), 10, 64); err == nil {
+		msg.Offset = offset
+	}
+	return msg
+}
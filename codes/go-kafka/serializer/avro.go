@@ -0,0 +1,129 @@
+package serializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// AvroSerializer Avro二进制序列化，消息头部携带schema ID
+// 结构体通过JSON转换为Avro的JSON编码，union字段需要按Avro的JSON编码组织（例如 {"string": "v"}）
+type AvroSerializer struct {
+	registry Registry
+	schemaID int
+	codec    *goavro.Codec
+	decoder  *avroDecoder
+}
+
+// NewAvroSerializer 创建Avro序列化器，使用subject在注册中心的schema写入
+// 反序列化按消息中的schema ID查找写入时的schema，兼容schema演进
+func NewAvroSerializer(registry Registry, subject string) (*AvroSerializer, error) {
+	schema, err := registry.Get(subject)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type != SchemaTypeAvro {
+		return nil, fmt.Errorf("subject %s 的schema类型为 %s，不是Avro", subject, schema.Type)
+	}
+
+	decoder := newAvroDecoder()
+	codec, err := decoder.codec(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AvroSerializer{
+		registry: registry,
+		schemaID: schema.ID,
+		codec:    codec,
+		decoder:  decoder,
+	}, nil
+}
+
+func (s *AvroSerializer) Serialize(data interface{}) ([]byte, error) {
+	native, err := avroNative(s.codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := s.codec.BinaryFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("Avro编码失败: %w", err)
+	}
+	return EncodeFrame(s.schemaID, payload), nil
+}
+
+func (s *AvroSerializer) Deserialize(data []byte, v interface{}) error {
+	return decodeFramed(s.registry, SchemaTypeAvro, s.decoder, data, v)
+}
+
+// avroNative 将数据转换为goavro的原生类型，map直接使用，其他类型通过JSON转换
+func avroNative(codec *goavro.Codec, data interface{}) (interface{}, error) {
+	if m, ok := data.(map[string]interface{}); ok {
+		return m, nil
+	}
+
+	text, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := codec.NativeFromTextual(text)
+	if err != nil {
+		return nil, fmt.Errorf("转换为Avro数据失败: %w", err)
+	}
+	return native, nil
+}
+
+// avroDecoder 按schema ID缓存编解码器
+type avroDecoder struct {
+	mu     sync.Mutex
+	codecs map[int]*goavro.Codec
+}
+
+func newAvroDecoder() *avroDecoder {
+	return &avroDecoder{codecs: make(map[int]*goavro.Codec)}
+}
+
+// codec 获取schema对应的编解码器
+func (d *avroDecoder) codec(schema Schema) (*goavro.Codec, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if codec, ok := d.codecs[schema.ID]; ok {
+		return codec, nil
+	}
+
+	codec, err := goavro.NewCodec(string(schema.Data))
+	if err != nil {
+		return nil, fmt.Errorf("解析Avro schema %d 失败: %w", schema.ID, err)
+	}
+	d.codecs[schema.ID] = codec
+	return codec, nil
+}
+
+func (d *avroDecoder) decodePayload(schema Schema, payload []byte, v interface{}) error {
+	codec, err := d.codec(schema)
+	if err != nil {
+		return err
+	}
+
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return fmt.Errorf("Avro解码失败: %w", err)
+	}
+
+	if ptr, ok := v.(*map[string]interface{}); ok {
+		if m, ok := native.(map[string]interface{}); ok {
+			*ptr = m
+			return nil
+		}
+	}
+
+	text, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return fmt.Errorf("Avro解码失败: %w", err)
+	}
+	return json.Unmarshal(text, v)
+}
@@ -0,0 +1,366 @@
+package serializer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// CloudEvents v1.0 Kafka协议绑定
+// 二进制模式：属性放在 ce_ 前缀的消息头，datacontenttype 对应 content-type 消息头，消息体为data
+// 结构化模式：消息体为JSON信封，content-type 为 application/cloudevents+json
+const (
+	CloudEventsSpecVersion = "1.0"
+
+	HeaderContentType     = "content-type"
+	cloudEventsHeaderPref = "ce_"
+	contentTypeStructured = "application/cloudevents+json; charset=UTF-8"
+
+	// PartitionKeyExtension 分区key扩展属性，消息没有设置Key时作为Kafka消息的Key
+	PartitionKeyExtension = "partitionkey"
+)
+
+// ContentMode CloudEvents在Kafka消息中的编码方式
+type ContentMode int
+
+const (
+	BinaryMode     ContentMode = iota // 属性在消息头中，消息体只有data
+	StructuredMode                    // 属性和data都在JSON消息体中
+)
+
+// ErrInvalidCloudEvent 缺少必需属性或格式不正确
+var ErrInvalidCloudEvent = errors.New("invalid cloudevent")
+
+// CloudEvent CloudEvents上下文属性，data保存在 Message.Data 中
+type CloudEvent struct {
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Extensions      map[string]string
+	Mode            ContentMode
+}
+
+// NewCloudEvent 创建CloudEvents消息，自动生成ID，时间为当前时间
+func NewCloudEvent(source, eventType string, data interface{}) Message {
+	return Message{
+		Data: data,
+		Event: &CloudEvent{
+			ID:     newEventID(),
+			Source: source,
+			Type:   eventType,
+			Time:   time.Now().UTC(),
+		},
+	}
+}
+
+// newEventID 随机的UUID v4
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (e *CloudEvent) validate() error {
+	switch {
+	case e.ID == "":
+		return fmt.Errorf("%w: 缺少id", ErrInvalidCloudEvent)
+	case e.Source == "":
+		return fmt.Errorf("%w: 缺少source", ErrInvalidCloudEvent)
+	case e.Type == "":
+		return fmt.Errorf("%w: 缺少type", ErrInvalidCloudEvent)
+	}
+	return nil
+}
+
+// attributes 返回除 datacontenttype 外的非空属性
+func (e *CloudEvent) attributes() map[string]string {
+	attrs := map[string]string{
+		"specversion": CloudEventsSpecVersion,
+		"id":          e.ID,
+		"source":      e.Source,
+		"type":        e.Type,
+	}
+	if e.Subject != "" {
+		attrs["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		attrs["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.DataSchema != "" {
+		attrs["dataschema"] = e.DataSchema
+	}
+	for k, v := range e.Extensions {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// setAttribute 按属性名设置，未知属性作为扩展属性
+func (e *CloudEvent) setAttribute(name, value string) error {
+	switch name {
+	case "specversion":
+		if value != CloudEventsSpecVersion {
+			return fmt.Errorf("%w: 不支持的specversion %s", ErrInvalidCloudEvent, value)
+		}
+	case "id":
+		e.ID = value
+	case "source":
+		e.Source = value
+	case "type":
+		e.Type = value
+	case "subject":
+		e.Subject = value
+	case "dataschema":
+		e.DataSchema = value
+	case "datacontenttype":
+		e.DataContentType = value
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("%w: 无效的time %s", ErrInvalidCloudEvent, value)
+		}
+		e.Time = t
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[name] = value
+	}
+	return nil
+}
+
+// IsCloudEvent 消息是否为CloudEvents
+func (m *Message) IsCloudEvent() bool {
+	return m.Event != nil
+}
+
+// ID CloudEvents的id，不是CloudEvents时返回空字符串
+func (m *Message) ID() string {
+	if m.Event == nil {
+		return ""
+	}
+	return m.Event.ID
+}
+
+// Source CloudEvents的source
+func (m *Message) Source() string {
+	if m.Event == nil {
+		return ""
+	}
+	return m.Event.Source
+}
+
+// Type CloudEvents的type
+func (m *Message) Type() string {
+	if m.Event == nil {
+		return ""
+	}
+	return m.Event.Type
+}
+
+// Subject CloudEvents的subject
+func (m *Message) Subject() string {
+	if m.Event == nil {
+		return ""
+	}
+	return m.Event.Subject
+}
+
+// Time CloudEvents的time，没有该属性时使用消息的 Timestamp
+func (m *Message) Time() time.Time {
+	if m.Event != nil && !m.Event.Time.IsZero() {
+		return m.Event.Time
+	}
+	if m.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(m.Timestamp)
+}
+
+// contentTypeOf 未设置 datacontenttype 时按序列化器推断
+func contentTypeOf(s Serializer) string {
+	switch s.(type) {
+	case *JSONSerializer:
+		return "application/json"
+	case *XMLSerializer:
+		return "application/xml"
+	case *StringSerializer:
+		return "text/plain"
+	}
+	return ""
+}
+
+// isJSONContentType application/json 和 +json 后缀的类型可以直接嵌入结构化信封
+func isJSONContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// encodeCloudEvent 按 msg.Event.Mode 编码
+func encodeCloudEvent(msg Message, serializer Serializer) (kafka.Message, error) {
+	event := msg.Event
+	if err := event.validate(); err != nil {
+		return kafka.Message{}, err
+	}
+
+	data, headers, err := SerializeWithHeaders(serializer, msg.Data, msg.Headers)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	contentType := event.DataContentType
+	if contentType == "" {
+		contentType = contentTypeOf(serializer)
+	}
+
+	key := msg.Key
+	if key == "" {
+		key = event.Extensions[PartitionKeyExtension]
+	}
+	kafkaMsg := kafka.Message{Key: []byte(key)}
+
+	// 序列化器写入的消息头（如加密、压缩）保留在Kafka消息头中
+	for k, v := range headers {
+		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	if event.Mode == StructuredMode {
+		envelope := make(map[string]interface{})
+		for k, v := range event.attributes() {
+			envelope[k] = v
+		}
+		if contentType != "" {
+			envelope["datacontenttype"] = contentType
+		}
+		// 序列化器改变了编码（如压缩、加密）时data不再是JSON，使用 data_base64
+		if isJSONContentType(contentType) && json.Valid(data) {
+			envelope["data"] = json.RawMessage(data)
+		} else if data != nil {
+			envelope["data_base64"] = base64.StdEncoding.EncodeToString(data)
+		}
+
+		value, err := json.Marshal(envelope)
+		if err != nil {
+			return kafka.Message{}, err
+		}
+		kafkaMsg.Value = value
+		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{Key: HeaderContentType, Value: []byte(contentTypeStructured)})
+		return kafkaMsg, nil
+	}
+
+	kafkaMsg.Value = data
+	for k, v := range event.attributes() {
+		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{Key: cloudEventsHeaderPref + k, Value: []byte(v)})
+	}
+	if contentType != "" {
+		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{Key: HeaderContentType, Value: []byte(contentType)})
+	}
+	return kafkaMsg, nil
+}
+
+// cloudEventMode 按消息头判断是否为CloudEvents及其编码方式
+func cloudEventMode(headers map[string]string) (ContentMode, bool) {
+	if strings.HasPrefix(headers[HeaderContentType], "application/cloudevents") {
+		return StructuredMode, true
+	}
+	if _, ok := headers[cloudEventsHeaderPref+"specversion"]; ok {
+		return BinaryMode, true
+	}
+	return 0, false
+}
+
+// decodeCloudEvent 解析CloudEvents属性并反序列化data，返回的消息头不包含CloudEvents属性
+func decodeCloudEvent(kafkaMsg kafka.Message, mode ContentMode, headers map[string]string, serializer Serializer, dest interface{}) (*Message, error) {
+	event := &CloudEvent{Mode: mode}
+	data := kafkaMsg.Value
+
+	if mode == StructuredMode {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(kafkaMsg.Value, &envelope); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCloudEvent, err)
+		}
+		data = nil
+		var rawData json.RawMessage
+		for name, raw := range envelope {
+			switch name {
+			case "data":
+				rawData = raw
+			case "data_base64":
+				var encoded string
+				if err := json.Unmarshal(raw, &encoded); err != nil {
+					return nil, fmt.Errorf("%w: 无效的data_base64", ErrInvalidCloudEvent)
+				}
+				decoded, err := base64.StdEncoding.DecodeString(encoded)
+				if err != nil {
+					return nil, fmt.Errorf("%w: 无效的data_base64", ErrInvalidCloudEvent)
+				}
+				data = decoded
+			default:
+				var value string
+				if err := json.Unmarshal(raw, &value); err != nil {
+					value = string(raw) // 非字符串的扩展属性保留JSON文本
+				}
+				if err := event.setAttribute(name, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		// datacontenttype 不是JSON时，其他实现按JSON格式规范把文本数据写为JSON字符串，解码前去掉引号
+		if rawData != nil {
+			data = rawData
+			if event.DataContentType != "" && !isJSONContentType(event.DataContentType) {
+				var text string
+				if err := json.Unmarshal(rawData, &text); err == nil {
+					data = []byte(text)
+				}
+			}
+		}
+		delete(headers, HeaderContentType)
+	} else {
+		for name, value := range headers {
+			if !strings.HasPrefix(name, cloudEventsHeaderPref) {
+				continue
+			}
+			if err := event.setAttribute(strings.TrimPrefix(name, cloudEventsHeaderPref), value); err != nil {
+				return nil, err
+			}
+			delete(headers, name)
+		}
+		if contentType, ok := headers[HeaderContentType]; ok {
+			event.DataContentType = contentType
+			delete(headers, HeaderContentType)
+		}
+	}
+
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if err := DeserializeWithHeaders(serializer, data, headers, dest); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{
+		Topic:     kafkaMsg.Topic,
+		Key:       string(kafkaMsg.Key),
+		Data:      dest,
+		Headers:   headers,
+		Timestamp: kafkaMsg.Time.UnixMilli(),
+		Event:     event,
+	}, nil
+}
@@ -0,0 +1,444 @@
+package serializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Compatibility schema兼容性模式，与 Confluent Schema Registry 的命名一致
+// 只与subject的最新版本比较
+type Compatibility string
+
+const (
+	CompatibilityNone     Compatibility = "NONE"     // 不检查
+	CompatibilityBackward Compatibility = "BACKWARD" // 新schema能读取旧schema写入的数据
+	CompatibilityForward  Compatibility = "FORWARD"  // 旧schema能读取新schema写入的数据
+	CompatibilityFull     Compatibility = "FULL"     // 同时满足 BACKWARD 和 FORWARD
+)
+
+func (c Compatibility) valid() bool {
+	switch c {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return true
+	}
+	return false
+}
+
+// validateSchema 检查schema类型和内容是否合法
+func validateSchema(schema Schema) error {
+	switch schema.Type {
+	case SchemaTypeAvro:
+		if _, err := goavro.NewCodec(string(schema.Data)); err != nil {
+			return fmt.Errorf("无效的Avro schema: %w", err)
+		}
+	case SchemaTypeJSON:
+		if len(schema.Data) > 0 {
+			if _, err := CompileJSONSchema(schema.Data); err != nil {
+				return err
+			}
+		}
+	case SchemaTypeProtobuf, SchemaTypeMsgPack:
+	default:
+		return fmt.Errorf("不支持的schema类型: %q", schema.Type)
+	}
+	return nil
+}
+
+// checkCompatibility 按兼容性模式检查新schema与旧schema
+// 只检查 Avro 和 JSON Schema，其他类型只要求类型不变
+func checkCompatibility(mode Compatibility, prev, next Schema) error {
+	if mode == CompatibilityNone {
+		return nil
+	}
+	if prev.Type != next.Type {
+		return fmt.Errorf("%w: schema类型从 %s 变为 %s", ErrIncompatibleSchema, prev.Type, next.Type)
+	}
+
+	var check func(reader, writer []byte) error
+	switch next.Type {
+	case SchemaTypeAvro:
+		check = avroCanRead
+	case SchemaTypeJSON:
+		check = jsonSchemaCanRead
+	default:
+		return nil
+	}
+
+	if mode == CompatibilityBackward || mode == CompatibilityFull {
+		if err := check(next.Data, prev.Data); err != nil {
+			return fmt.Errorf("%w: 新版本不能读取旧版本数据: %v", ErrIncompatibleSchema, err)
+		}
+	}
+	if mode == CompatibilityForward || mode == CompatibilityFull {
+		if err := check(prev.Data, next.Data); err != nil {
+			return fmt.Errorf("%w: 旧版本不能读取新版本数据: %v", ErrIncompatibleSchema, err)
+		}
+	}
+	return nil
+}
+
+// avroCanRead 按Avro的schema解析规则检查reader能否读取writer写入的数据
+func avroCanRead(readerData, writerData []byte) error {
+	var reader, writer interface{}
+	if err := json.Unmarshal(readerData, &reader); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(writerData, &writer); err != nil {
+		return err
+	}
+
+	c := &avroChecker{
+		readerNames: make(map[string]interface{}),
+		writerNames: make(map[string]interface{}),
+		seen:        make(map[[2]string]bool),
+	}
+	collectAvroNames(reader, "", c.readerNames)
+	collectAvroNames(writer, "", c.writerNames)
+	return c.check(reader, writer, "")
+}
+
+// avroChecker 保存两边的命名类型，用于解析类型引用
+type avroChecker struct {
+	readerNames map[string]interface{}
+	writerNames map[string]interface{}
+	seen        map[[2]string]bool // 已比较过的命名类型，避免递归类型死循环
+}
+
+// avroPromotions writer类型可以提升为的reader类型
+var avroPromotions = map[string][]string{
+	"int":    {"long", "float", "double"},
+	"long":   {"float", "double"},
+	"float":  {"double"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+func (c *avroChecker) check(reader, writer interface{}, path string) error {
+	reader = c.resolve(reader, c.readerNames)
+	writer = c.resolve(writer, c.writerNames)
+
+	// writer为union时，每个分支都必须能被读取
+	if branches, ok := writer.([]interface{}); ok {
+		for _, b := range branches {
+			if err := c.check(reader, b, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// reader为union时，至少一个分支能读取
+	if branches, ok := reader.([]interface{}); ok {
+		for _, b := range branches {
+			if c.check(b, writer, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: union中没有与 %s 匹配的类型", schemaPath(path), avroTypeName(writer))
+	}
+
+	rt, wt := avroTypeName(reader), avroTypeName(writer)
+	if rt != wt {
+		for _, p := range avroPromotions[wt] {
+			if p == rt {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: 类型 %s 不能读取为 %s", schemaPath(path), wt, rt)
+	}
+
+	r, _ := reader.(map[string]interface{})
+	w, _ := writer.(map[string]interface{})
+	switch rt {
+	case "record":
+		return c.checkRecord(r, w, path)
+	case "enum":
+		return checkAvroEnum(r, w, path)
+	case "array":
+		return c.check(r["items"], w["items"], path+"[]")
+	case "map":
+		return c.check(r["values"], w["values"], path+"{}")
+	case "fixed":
+		if r["name"] != w["name"] || r["size"] != w["size"] {
+			return fmt.Errorf("%s: fixed类型的名称或长度不一致", schemaPath(path))
+		}
+	}
+	return nil
+}
+
+func (c *avroChecker) checkRecord(r, w map[string]interface{}, path string) error {
+	if r["name"] != w["name"] {
+		return fmt.Errorf("%s: record名称 %v 与 %v 不一致", schemaPath(path), w["name"], r["name"])
+	}
+
+	key := [2]string{fmt.Sprint(r["name"]), fmt.Sprint(w["name"])}
+	if c.seen[key] {
+		return nil
+	}
+	c.seen[key] = true
+
+	writerFields := make(map[string]interface{})
+	for _, f := range avroFields(w) {
+		writerFields[fmt.Sprint(f["name"])] = f["type"]
+	}
+
+	for _, f := range avroFields(r) {
+		name := fmt.Sprint(f["name"])
+		fieldPath := strings.TrimPrefix(path+"."+name, ".")
+		wtype, ok := writerFields[name]
+		if !ok {
+			if _, hasDefault := f["default"]; !hasDefault {
+				return fmt.Errorf("%s: 字段在写入方不存在且没有默认值", fieldPath)
+			}
+			continue
+		}
+		if err := c.check(f["type"], wtype, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAvroEnum writer的所有符号都必须在reader中，reader有默认值时除外
+func checkAvroEnum(r, w map[string]interface{}, path string) error {
+	if r["name"] != w["name"] {
+		return fmt.Errorf("%s: enum名称 %v 与 %v 不一致", schemaPath(path), w["name"], r["name"])
+	}
+	if _, hasDefault := r["default"]; hasDefault {
+		return nil
+	}
+
+	symbols := make(map[interface{}]bool)
+	if list, ok := r["symbols"].([]interface{}); ok {
+		for _, s := range list {
+			symbols[s] = true
+		}
+	}
+	if list, ok := w["symbols"].([]interface{}); ok {
+		for _, s := range list {
+			if !symbols[s] {
+				return fmt.Errorf("%s: enum缺少符号 %v", schemaPath(path), s)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve 将类型引用替换为命名类型的定义，将 {"type": "int"} 简化为 "int"
+func (c *avroChecker) resolve(t interface{}, names map[string]interface{}) interface{} {
+	switch v := t.(type) {
+	case string:
+		if def, ok := names[v]; ok {
+			return def
+		}
+		for name, def := range names {
+			if strings.HasSuffix(name, "."+v) {
+				return def
+			}
+		}
+	case map[string]interface{}:
+		if inner, ok := v["type"].(string); ok {
+			switch inner {
+			case "record", "enum", "array", "map", "fixed", "error":
+			default:
+				return c.resolve(inner, names)
+			}
+		}
+	}
+	return t
+}
+
+// collectAvroNames 收集schema中定义的命名类型（record、enum、fixed）
+func collectAvroNames(t interface{}, namespace string, names map[string]interface{}) {
+	switch v := t.(type) {
+	case []interface{}:
+		for _, b := range v {
+			collectAvroNames(b, namespace, names)
+		}
+	case map[string]interface{}:
+		if ns, ok := v["namespace"].(string); ok {
+			namespace = ns
+		}
+		if name, ok := v["name"].(string); ok {
+			full := name
+			if namespace != "" && !strings.Contains(name, ".") {
+				full = namespace + "." + name
+			}
+			names[full] = v
+		}
+		for _, f := range avroFields(v) {
+			collectAvroNames(f["type"], namespace, names)
+		}
+		collectAvroNames(v["items"], namespace, names)
+		collectAvroNames(v["values"], namespace, names)
+	}
+}
+
+func avroFields(record map[string]interface{}) []map[string]interface{} {
+	list, _ := record["fields"].([]interface{})
+	fields := make([]map[string]interface{}, 0, len(list))
+	for _, f := range list {
+		if m, ok := f.(map[string]interface{}); ok {
+			fields = append(fields, m)
+		}
+	}
+	return fields
+}
+
+func avroTypeName(t interface{}) string {
+	switch v := t.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if name, ok := v["type"].(string); ok {
+			if name == "error" {
+				return "record"
+			}
+			return name
+		}
+	case []interface{}:
+		return "union"
+	}
+	return fmt.Sprint(t)
+}
+
+// schemaPath 错误信息中的字段路径
+func schemaPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
+
+// jsonSchemaCanRead 检查满足writer的数据是否都满足reader
+// 支持 type、properties、required、additionalProperties、enum 和 items，
+// 只出现在reader中的属性视为新增的可选属性
+func jsonSchemaCanRead(readerData, writerData []byte) error {
+	var reader, writer interface{}
+	if len(readerData) == 0 || len(writerData) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(readerData, &reader); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(writerData, &writer); err != nil {
+		return err
+	}
+	return checkJSONSchema(reader, writer, "")
+}
+
+func checkJSONSchema(reader, writer interface{}, path string) error {
+	r, _ := reader.(map[string]interface{})
+	w, _ := writer.(map[string]interface{})
+	if reader == false {
+		return fmt.Errorf("%s: 新schema不接受任何值", schemaPath(path))
+	}
+	if r == nil {
+		return nil // true 或 {} 接受任何值
+	}
+	if w == nil {
+		w = map[string]interface{}{}
+	}
+
+	if rtypes := jsonTypes(r["type"]); rtypes != nil {
+		wtypes := jsonTypes(w["type"])
+		if wtypes == nil {
+			return fmt.Errorf("%s: 新增了类型限制 %v", schemaPath(path), rtypes)
+		}
+		for _, t := range wtypes {
+			if !containsJSONType(rtypes, t) {
+				return fmt.Errorf("%s: 类型 %s 不再被接受", schemaPath(path), t)
+			}
+		}
+	}
+
+	if renum, ok := r["enum"].([]interface{}); ok {
+		wenum, ok := w["enum"].([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: 新增了enum限制", schemaPath(path))
+		}
+		for _, v := range wenum {
+			if !containsJSONValue(renum, v) {
+				return fmt.Errorf("%s: enum值 %v 不再被接受", schemaPath(path), v)
+			}
+		}
+	}
+
+	if err := checkJSONObject(r, w, path); err != nil {
+		return err
+	}
+
+	if ritems, ok := r["items"]; ok {
+		return checkJSONSchema(ritems, w["items"], path+"[]")
+	}
+	return nil
+}
+
+func checkJSONObject(r, w map[string]interface{}, path string) error {
+	writerRequired := make(map[string]bool)
+	if list, ok := w["required"].([]interface{}); ok {
+		for _, name := range list {
+			writerRequired[fmt.Sprint(name)] = true
+		}
+	}
+	if list, ok := r["required"].([]interface{}); ok {
+		for _, name := range list {
+			if !writerRequired[fmt.Sprint(name)] {
+				return fmt.Errorf("%s: 属性 %v 变为必填", schemaPath(path), name)
+			}
+		}
+	}
+
+	rprops, _ := r["properties"].(map[string]interface{})
+	wprops, _ := w["properties"].(map[string]interface{})
+	for name, wprop := range wprops {
+		propPath := strings.TrimPrefix(path+"."+name, ".")
+		rprop, ok := rprops[name]
+		if !ok {
+			if r["additionalProperties"] == false {
+				return fmt.Errorf("%s: 属性被删除且不允许额外属性", propPath)
+			}
+			continue
+		}
+		if err := checkJSONSchema(rprop, wprop, propPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonTypes(t interface{}) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		types := make([]string, 0, len(v))
+		for _, s := range v {
+			types = append(types, fmt.Sprint(s))
+		}
+		return types
+	}
+	return nil
+}
+
+// containsJSONType integer 是 number 的子集
+func containsJSONType(types []string, t string) bool {
+	for _, s := range types {
+		if s == t || (s == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsJSONValue(values []interface{}, v interface{}) bool {
+	text, _ := json.Marshal(v)
+	for _, candidate := range values {
+		if c, _ := json.Marshal(candidate); string(c) == string(text) {
+			return true
+		}
+	}
+	return false
+}
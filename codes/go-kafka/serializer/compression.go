@@ -0,0 +1,122 @@
+package serializer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HeaderContentEncoding 压缩算法消息头，值为 gzip、snappy、lz4 或 zstd
+const HeaderContentEncoding = "content-encoding"
+
+// CompressionSerializer 压缩包装器，payload不小于阈值时压缩并在消息头中记录算法
+// 与 producer.WithCompression 的批量压缩不同，压缩后的payload在Topic中保持压缩，适合很大的消息
+type CompressionSerializer struct {
+	inner     Serializer
+	algo      kafka.Compression
+	threshold int
+}
+
+var _ HeaderSerializer = (*CompressionSerializer)(nil)
+
+// CompressionOption 压缩包装器配置选项
+type CompressionOption func(*CompressionSerializer)
+
+// WithCompressionThreshold 设置压缩阈值，小于该字节数的payload不压缩，默认1KB
+func WithCompressionThreshold(n int) CompressionOption {
+	return func(s *CompressionSerializer) {
+		if n >= 0 {
+			s.threshold = n
+		}
+	}
+}
+
+// NewCompressionSerializer 创建压缩包装器，algo 为 kafka.Gzip、kafka.Snappy、kafka.Lz4 或 kafka.Zstd
+func NewCompressionSerializer(inner Serializer, algo kafka.Compression, options ...CompressionOption) (*CompressionSerializer, error) {
+	if algo.Codec() == nil {
+		return nil, fmt.Errorf("不支持的压缩算法: %v", algo)
+	}
+
+	s := &CompressionSerializer{
+		inner:     inner,
+		algo:      algo,
+		threshold: 1024,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Serialize 压缩后的payload需要消息头才能解码，返回 ErrHeadersRequired
+func (s *CompressionSerializer) Serialize(data interface{}) ([]byte, error) {
+	return nil, ErrHeadersRequired
+}
+
+// Deserialize 没有消息头时视为未压缩
+func (s *CompressionSerializer) Deserialize(data []byte, v interface{}) error {
+	return s.DeserializeWithHeaders(data, nil, v)
+}
+
+// SerializeWithHeaders 压缩后在headers中记录算法，headers 不能为nil
+func (s *CompressionSerializer) SerializeWithHeaders(data interface{}, headers map[string]string) ([]byte, error) {
+	if headers == nil {
+		return nil, ErrHeadersRequired
+	}
+	value, err := serializeInner(s.inner, data, headers)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < s.threshold {
+		return value, nil
+	}
+
+	codec := s.algo.Codec()
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("%s压缩失败: %w", codec.Name(), err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("%s压缩失败: %w", codec.Name(), err)
+	}
+
+	headers[HeaderContentEncoding] = codec.Name()
+	return buf.Bytes(), nil
+}
+
+// DeserializeWithHeaders 按消息头解压，可以解码任何支持的算法，不要求与写入时的配置相同
+func (s *CompressionSerializer) DeserializeWithHeaders(data []byte, headers map[string]string, v interface{}) error {
+	encoding, ok := headers[HeaderContentEncoding]
+	if !ok {
+		return DeserializeWithHeaders(s.inner, data, headers, v)
+	}
+
+	var algo kafka.Compression
+	if err := algo.UnmarshalText([]byte(encoding)); err != nil {
+		return fmt.Errorf("不支持的压缩算法: %s", encoding)
+	}
+	codec := algo.Codec()
+	if codec == nil {
+		return DeserializeWithHeaders(s.inner, data, headers, v)
+	}
+
+	r := codec.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("%s解压失败: %w", codec.Name(), err)
+	}
+	return DeserializeWithHeaders(s.inner, plain, headers, v)
+}
+
+// serializeInner 调用被包装的序列化器，消息头直接写入headers
+func serializeInner(inner Serializer, data interface{}, headers map[string]string) ([]byte, error) {
+	if hs, ok := inner.(HeaderSerializer); ok {
+		return hs.SerializeWithHeaders(data, headers)
+	}
+	return inner.Serialize(data)
+}
@@ -0,0 +1,144 @@
+package serializer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// 加密相关的消息头
+const (
+	HeaderEncryption      = "encryption"        // 加密算法，值为 AES-GCM
+	HeaderEncryptionKeyID = "encryption-key-id" // 加密数据密钥使用的KEK的ID
+	HeaderEncryptedKey    = "encryption-dek"    // 被KEK加密的数据密钥，base64编码
+)
+
+const encryptionAESGCM = "AES-GCM"
+
+// EncryptionSerializer 信封加密包装器
+// 每条消息生成随机的AES-256数据密钥（DEK）加密payload，DEK再由 KeyProvider 的当前密钥（KEK）加密后放入消息头，
+// 解密时按消息头中的KEK ID查找密钥，密钥轮换后只要旧KEK仍在 KeyProvider 中，历史消息就能解密
+// 与 CompressionSerializer 组合时应包在外层，先压缩再加密
+type EncryptionSerializer struct {
+	inner Serializer
+	keys  KeyProvider
+}
+
+var _ HeaderSerializer = (*EncryptionSerializer)(nil)
+
+// NewEncryptionSerializer 创建加密包装器
+func NewEncryptionSerializer(inner Serializer, keys KeyProvider) *EncryptionSerializer {
+	return &EncryptionSerializer{
+		inner: inner,
+		keys:  keys,
+	}
+}
+
+// Serialize 加密后的payload需要消息头才能解密，返回 ErrHeadersRequired
+func (s *EncryptionSerializer) Serialize(data interface{}) ([]byte, error) {
+	return nil, ErrHeadersRequired
+}
+
+// Deserialize 没有消息头时视为未加密
+func (s *EncryptionSerializer) Deserialize(data []byte, v interface{}) error {
+	return s.DeserializeWithHeaders(data, nil, v)
+}
+
+// SerializeWithHeaders 加密后在headers中记录算法、密钥ID和加密的数据密钥，headers 不能为nil
+func (s *EncryptionSerializer) SerializeWithHeaders(data interface{}, headers map[string]string) ([]byte, error) {
+	if headers == nil {
+		return nil, ErrHeadersRequired
+	}
+	plain, err := serializeInner(s.inner, data, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	kekID, kek, err := s.keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("获取加密密钥失败: %w", err)
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	// 密钥ID作为附加数据，篡改消息头会导致解密失败
+	wrapped, err := sealAESGCM(kek, dek, []byte(kekID))
+	if err != nil {
+		return nil, fmt.Errorf("加密数据密钥失败: %w", err)
+	}
+	ciphertext, err := sealAESGCM(dek, plain, nil)
+	if err != nil {
+		return nil, fmt.Errorf("加密失败: %w", err)
+	}
+
+	headers[HeaderEncryption] = encryptionAESGCM
+	headers[HeaderEncryptionKeyID] = kekID
+	headers[HeaderEncryptedKey] = base64.StdEncoding.EncodeToString(wrapped)
+	return ciphertext, nil
+}
+
+// DeserializeWithHeaders 按消息头解密，消息没有加密消息头时直接交给被包装的序列化器
+func (s *EncryptionSerializer) DeserializeWithHeaders(data []byte, headers map[string]string, v interface{}) error {
+	algo, ok := headers[HeaderEncryption]
+	if !ok {
+		return DeserializeWithHeaders(s.inner, data, headers, v)
+	}
+	if algo != encryptionAESGCM {
+		return fmt.Errorf("不支持的加密算法: %s", algo)
+	}
+
+	kekID := headers[HeaderEncryptionKeyID]
+	kek, err := s.keys.Key(kekID)
+	if err != nil {
+		return fmt.Errorf("获取解密密钥失败: %w", err)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(headers[HeaderEncryptedKey])
+	if err != nil {
+		return fmt.Errorf("无效的数据密钥: %w", err)
+	}
+	dek, err := openAESGCM(kek, wrapped, []byte(kekID))
+	if err != nil {
+		return fmt.Errorf("解密数据密钥失败: %w", err)
+	}
+	plain, err := openAESGCM(dek, data, nil)
+	if err != nil {
+		return fmt.Errorf("解密失败: %w", err)
+	}
+	return DeserializeWithHeaders(s.inner, plain, headers, v)
+}
+
+// sealAESGCM 加密结果为 nonce + 密文
+func sealAESGCM(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func openAESGCM(key, data, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("密文长度不足")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
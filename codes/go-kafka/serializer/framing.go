@@ -0,0 +1,102 @@
+package serializer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MagicByte 二进制格式消息的首字节，与 Confluent 线上格式一致
+const MagicByte byte = 0x0
+
+// frameHeaderSize 头部长度：1字节magic + 4字节大端schema ID
+const frameHeaderSize = 5
+
+// ErrInvalidFrame 消息不是 magic byte + schema ID 格式
+var ErrInvalidFrame = errors.New("invalid schema frame")
+
+// EncodeFrame 在payload前加上 magic byte 和 schema ID
+func EncodeFrame(schemaID int, payload []byte) []byte {
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	buf[0] = MagicByte
+	binary.BigEndian.PutUint32(buf[1:], uint32(schemaID))
+	return append(buf, payload...)
+}
+
+// DecodeFrame 解析 magic byte 和 schema ID，返回ID和payload
+func DecodeFrame(data []byte) (int, []byte, error) {
+	if len(data) < frameHeaderSize || data[0] != MagicByte {
+		return 0, nil, ErrInvalidFrame
+	}
+	return int(binary.BigEndian.Uint32(data[1:frameHeaderSize])), data[frameHeaderSize:], nil
+}
+
+// payloadDecoder 按schema解码payload，由各二进制格式的序列化器实现
+type payloadDecoder interface {
+	decodePayload(schema Schema, payload []byte, v interface{}) error
+}
+
+// decodeFramed 解析头部并校验schema类型后解码
+func decodeFramed(registry Registry, schemaType string, d payloadDecoder, data []byte, v interface{}) error {
+	id, payload, err := DecodeFrame(data)
+	if err != nil {
+		return err
+	}
+
+	schema, err := registry.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if schema.Type != schemaType {
+		return fmt.Errorf("schema %d 类型为 %s，期望 %s", id, schema.Type, schemaType)
+	}
+
+	return d.decodePayload(schema, payload, v)
+}
+
+// FramedSerializer 按消息头部的schema ID选择解码器，可消费混合了多种二进制格式的Topic
+// 序列化使用 writer，未设置时只能用于反序列化
+type FramedSerializer struct {
+	registry Registry
+	writer   Serializer
+	decoders map[string]payloadDecoder
+}
+
+// NewFramedSerializer 创建按schema ID分发的序列化器，默认支持 Avro、Protobuf 和 MessagePack
+func NewFramedSerializer(registry Registry, writer Serializer) *FramedSerializer {
+	return &FramedSerializer{
+		registry: registry,
+		writer:   writer,
+		decoders: map[string]payloadDecoder{
+			SchemaTypeAvro:     newAvroDecoder(),
+			SchemaTypeProtobuf: protobufDecoder{},
+			SchemaTypeMsgPack:  msgPackDecoder{},
+		},
+	}
+}
+
+func (s *FramedSerializer) Serialize(data interface{}) ([]byte, error) {
+	if s.writer == nil {
+		return nil, errors.New("FramedSerializer 未设置writer，不能序列化")
+	}
+	return s.writer.Serialize(data)
+}
+
+func (s *FramedSerializer) Deserialize(data []byte, v interface{}) error {
+	id, payload, err := DecodeFrame(data)
+	if err != nil {
+		return err
+	}
+
+	schema, err := s.registry.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	d, ok := s.decoders[schema.Type]
+	if !ok {
+		return fmt.Errorf("不支持的schema类型: %s", schema.Type)
+	}
+
+	return d.decodePayload(schema, payload, v)
+}
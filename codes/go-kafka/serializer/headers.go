@@ -0,0 +1,67 @@
+package serializer
+
+import (
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrHeadersRequired 序列化器需要通过消息头标记payload的编码方式，只能通过 SerializeWithHeaders 使用
+var ErrHeadersRequired = errors.New("serializer requires message headers")
+
+// ErrEncodedPayload payload已被压缩或加密，无法直接按schema校验，应使用 ValidatingSerializer 校验明文
+var ErrEncodedPayload = errors.New("payload is compressed or encrypted, validate it with serializer.ValidatingSerializer")
+
+// Encoded 消息头是否表明payload经过压缩或加密
+func Encoded(headers map[string]string) bool {
+	_, compressed := headers[HeaderContentEncoding]
+	_, encrypted := headers[HeaderEncryption]
+	return compressed || encrypted
+}
+
+// HeaderSerializer 需要读写消息头的序列化器
+// 压缩和加密包装器在消息头中记录算法和密钥ID，反序列化时按消息头自动解码
+type HeaderSerializer interface {
+	Serializer
+	// SerializeWithHeaders 序列化并将需要的消息头写入headers
+	SerializeWithHeaders(data interface{}, headers map[string]string) ([]byte, error)
+	// DeserializeWithHeaders 按消息头解码后反序列化
+	DeserializeWithHeaders(data []byte, headers map[string]string, v interface{}) error
+}
+
+// SerializeWithHeaders 使用s序列化，返回合并了s写入的消息头的新map，不修改传入的headers
+// s 未实现 HeaderSerializer 时等同于 s.Serialize，headers 原样返回
+func SerializeWithHeaders(s Serializer, data interface{}, headers map[string]string) ([]byte, map[string]string, error) {
+	hs, ok := s.(HeaderSerializer)
+	if !ok {
+		value, err := s.Serialize(data)
+		return value, headers, err
+	}
+
+	merged := make(map[string]string, len(headers)+4)
+	for k, v := range headers {
+		merged[k] = v
+	}
+	value, err := hs.SerializeWithHeaders(data, merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, merged, nil
+}
+
+// DeserializeWithHeaders 使用s反序列化，s 未实现 HeaderSerializer 时等同于 s.Deserialize
+func DeserializeWithHeaders(s Serializer, data []byte, headers map[string]string, v interface{}) error {
+	if hs, ok := s.(HeaderSerializer); ok {
+		return hs.DeserializeWithHeaders(data, headers, v)
+	}
+	return s.Deserialize(data, v)
+}
+
+// HeadersOf 将Kafka消息头转换为map，同名消息头取最后一个
+func HeadersOf(msg kafka.Message) map[string]string {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return headers
+}
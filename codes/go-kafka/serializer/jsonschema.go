@@ -0,0 +1,354 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation 一处校验失败，Path 为字段路径，如 data.items[2].price
+type Violation struct {
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return schemaPath(v.Path) + ": " + v.Message
+}
+
+// ValidationError payload不满足schema，包含所有校验失败的位置
+type ValidationError struct {
+	Subject    string
+	SchemaID   int
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("payload不满足schema %s (id %d): %s", e.Subject, e.SchemaID, e.Violations[0])
+	if n := len(e.Violations) - 1; n > 0 {
+		msg += fmt.Sprintf(" (另有%d处错误)", n)
+	}
+	return msg
+}
+
+// JSONSchema 编译后的JSON Schema
+// 支持 type、enum、const、properties、required、additionalProperties、items、
+// minItems/maxItems、minLength/maxLength、pattern、minimum/maximum、
+// exclusiveMinimum/exclusiveMaximum、format(date-time)、allOf/anyOf/oneOf/not 和本地 $ref
+type JSONSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// CompileJSONSchema 解析schema并预编译其中的正则表达式
+func CompileJSONSchema(data []byte) (*JSONSchema, error) {
+	root, err := decodeJSONNumber(data)
+	if err != nil {
+		return nil, fmt.Errorf("无效的JSON schema: %w", err)
+	}
+
+	s := &JSONSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONSchema) compilePatterns(node interface{}) error {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if p, ok := v["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("无效的pattern %q: %w", p, err)
+			}
+			s.patterns[p] = re
+		}
+		for _, child := range v {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate 校验JSON数据，返回所有校验失败的位置
+func (s *JSONSchema) Validate(data []byte) ([]Violation, error) {
+	value, err := decodeJSONNumber(data)
+	if err != nil {
+		return nil, fmt.Errorf("payload不是有效的JSON: %w", err)
+	}
+	var violations []Violation
+	s.validate(s.root, value, "", &violations)
+	return violations, nil
+}
+
+func (s *JSONSchema) validate(schema, value interface{}, path string, out *[]Violation) {
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// true 接受任何值，false 不接受任何值
+	sc, ok := schema.(map[string]interface{})
+	if !ok {
+		if schema == false {
+			fail("不允许任何值")
+		}
+		return
+	}
+
+	if ref, ok := sc["$ref"].(string); ok {
+		target, err := s.resolveRef(ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		s.validate(target, value, path, out)
+		return
+	}
+
+	if types := jsonTypes(sc["type"]); types != nil {
+		actual := jsonValueType(value)
+		if !containsJSONType(types, actual) {
+			fail("类型应为 %s，实际为 %s", strings.Join(types, "|"), actual)
+			return
+		}
+	}
+
+	if values, ok := sc["enum"].([]interface{}); ok && !containsJSONValue(values, value) {
+		fail("值 %s 不在enum中", jsonText(value))
+	}
+	if c, ok := sc["const"]; ok && !containsJSONValue([]interface{}{c}, value) {
+		fail("值应为 %s", jsonText(c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(sc, v, path, out)
+	case []interface{}:
+		s.validateArray(sc, v, path, out)
+	case string:
+		s.validateString(sc, v, fail)
+	case json.Number:
+		validateNumber(sc, v, fail)
+	}
+
+	if list, ok := sc["allOf"].([]interface{}); ok {
+		for _, sub := range list {
+			s.validate(sub, value, path, out)
+		}
+	}
+	if list, ok := sc["anyOf"].([]interface{}); ok {
+		if s.countMatches(list, value, path) == 0 {
+			fail("不满足anyOf中的任何schema")
+		}
+	}
+	if list, ok := sc["oneOf"].([]interface{}); ok {
+		if n := s.countMatches(list, value, path); n != 1 {
+			fail("应恰好满足oneOf中的一个schema，实际满足%d个", n)
+		}
+	}
+	if not, ok := sc["not"]; ok && s.countMatches([]interface{}{not}, value, path) == 1 {
+		fail("不应满足not中的schema")
+	}
+}
+
+func (s *JSONSchema) countMatches(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		var violations []Violation
+		s.validate(sub, value, path, &violations)
+		if len(violations) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *JSONSchema) validateObject(sc, obj map[string]interface{}, path string, out *[]Violation) {
+	if list, ok := sc["required"].([]interface{}); ok {
+		for _, name := range list {
+			key := fmt.Sprint(name)
+			if _, ok := obj[key]; !ok {
+				*out = append(*out, Violation{Path: joinPath(path, key), Message: "缺少必填属性"})
+			}
+		}
+	}
+
+	props, _ := sc["properties"].(map[string]interface{})
+	additional, hasAdditional := sc["additionalProperties"]
+
+	// 按属性名排序，错误顺序稳定
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if prop, ok := props[key]; ok {
+			s.validate(prop, obj[key], joinPath(path, key), out)
+			continue
+		}
+		if !hasAdditional {
+			continue
+		}
+		if additional == false {
+			*out = append(*out, Violation{Path: joinPath(path, key), Message: "不允许额外属性"})
+			continue
+		}
+		s.validate(additional, obj[key], joinPath(path, key), out)
+	}
+}
+
+func (s *JSONSchema) validateArray(sc map[string]interface{}, arr []interface{}, path string, out *[]Violation) {
+	if min, ok := schemaInt(sc["minItems"]); ok && len(arr) < min {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("元素个数 %d 小于 %d", len(arr), min)})
+	}
+	if max, ok := schemaInt(sc["maxItems"]); ok && len(arr) > max {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf("元素个数 %d 大于 %d", len(arr), max)})
+	}
+	if items, ok := sc["items"]; ok {
+		for i, item := range arr {
+			s.validate(items, item, path+"["+strconv.Itoa(i)+"]", out)
+		}
+	}
+}
+
+func (s *JSONSchema) validateString(sc map[string]interface{}, str string, fail func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if min, ok := schemaInt(sc["minLength"]); ok && length < min {
+		fail("长度 %d 小于 %d", length, min)
+	}
+	if max, ok := schemaInt(sc["maxLength"]); ok && length > max {
+		fail("长度 %d 大于 %d", length, max)
+	}
+	if p, ok := sc["pattern"].(string); ok && !s.patterns[p].MatchString(str) {
+		fail("不匹配 %s", p)
+	}
+	if sc["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			fail("不是有效的date-time")
+		}
+	}
+}
+
+func validateNumber(sc map[string]interface{}, num json.Number, fail func(string, ...interface{})) {
+	v, err := num.Float64()
+	if err != nil {
+		fail("无效的数字 %s", num)
+		return
+	}
+	if min, ok := schemaFloat(sc["minimum"]); ok && v < min {
+		fail("%s 小于最小值 %v", num, min)
+	}
+	if max, ok := schemaFloat(sc["maximum"]); ok && v > max {
+		fail("%s 大于最大值 %v", num, max)
+	}
+	if min, ok := schemaFloat(sc["exclusiveMinimum"]); ok && v <= min {
+		fail("%s 应大于 %v", num, min)
+	}
+	if max, ok := schemaFloat(sc["exclusiveMaximum"]); ok && v >= max {
+		fail("%s 应小于 %v", num, max)
+	}
+}
+
+// resolveRef 只支持指向同一文档的JSON Pointer，如 #/definitions/Item 或 #/$defs/Item
+func (s *JSONSchema) resolveRef(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("不支持的$ref: %s", ref)
+	}
+
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("无法解析$ref: %s", ref)
+		}
+		if node, ok = m[token]; !ok {
+			return nil, fmt.Errorf("无法解析$ref: %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// jsonValueType 返回值的JSON Schema类型，整数值返回 integer
+func jsonValueType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaFloat(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func schemaInt(v interface{}) (int, bool) {
+	f, ok := schemaFloat(v)
+	return int(f), ok
+}
+
+func jsonText(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// joinPath 拼接对象属性路径
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// decodeJSONNumber 解码JSON，数字保留为 json.Number 以区分整数和浮点数
+func decodeJSONNumber(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("JSON之后有多余的数据")
+	}
+	return v, nil
+}
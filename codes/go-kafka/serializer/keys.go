@@ -0,0 +1,183 @@
+package serializer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrKeyNotFound 密钥提供者中没有该ID的密钥
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// KeyProvider 密钥加密密钥（KEK）的提供者
+// 加密使用当前密钥，解密按消息头中的密钥ID查找，轮换后旧密钥需要保留才能解密历史消息
+type KeyProvider interface {
+	// CurrentKey 返回加密使用的密钥ID和密钥
+	CurrentKey() (string, []byte, error)
+	// Key 按ID返回密钥，不存在时返回 ErrKeyNotFound
+	Key(id string) ([]byte, error)
+}
+
+// checkKey AES密钥长度必须为16、24或32字节
+func checkKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("AES密钥长度必须为16、24或32字节，实际为%d", len(key))
+}
+
+// StaticKeyProvider 固定的单个密钥
+type StaticKeyProvider struct {
+	id  string
+	key []byte
+}
+
+// NewStaticKeyProvider 创建固定密钥提供者
+func NewStaticKeyProvider(id string, key []byte) (*StaticKeyProvider, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{id: id, key: key}, nil
+}
+
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.id, p.key, nil
+}
+
+func (p *StaticKeyProvider) Key(id string) ([]byte, error) {
+	if id != p.id {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return p.key, nil
+}
+
+// NewKeyFileProvider 从文件读取base64或hex编码的密钥，密钥ID为密钥SHA-256摘要的前16个hex字符
+// 替换密钥文件后ID随之改变，需要解密旧消息时使用 Keyring 同时保留新旧密钥
+func NewKeyFileProvider(path string) (*StaticKeyProvider, error) {
+	key, err := ReadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeyProvider(KeyFingerprint(key), key)
+}
+
+// ReadKeyFile 读取base64或hex编码的密钥文件
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+
+	text := strings.TrimSpace(string(data))
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return nil, fmt.Errorf("密钥文件 %s 不是base64或hex编码", path)
+		}
+	}
+	if err := checkKey(key); err != nil {
+		return nil, fmt.Errorf("密钥文件 %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// KeyFingerprint 密钥的SHA-256摘要前16个hex字符，用作不泄露密钥的ID
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Keyring 可轮换的密钥环，可在多个协程中并发使用
+// 轮换后新消息使用新密钥加密，旧密钥保留用于解密，直到调用 Remove
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewKeyring 创建空密钥环
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Add 添加密钥，密钥环为空时同时设为当前密钥
+func (k *Keyring) Add(id string, key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	if k.current == "" {
+		k.current = id
+	}
+	return nil
+}
+
+// SetCurrent 切换加密使用的密钥
+func (k *Keyring) SetCurrent(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	k.current = id
+	return nil
+}
+
+// Rotate 生成新的随机AES-256密钥并设为当前密钥，返回新密钥的ID
+func (k *Keyring) Rotate() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	id := KeyFingerprint(key)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	k.current = id
+	return id, nil
+}
+
+// Remove 删除不再需要的旧密钥，不能删除当前密钥
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if id == k.current {
+		return fmt.Errorf("不能删除当前密钥: %s", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *Keyring) CurrentKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.current == "" {
+		return "", nil, fmt.Errorf("%w: 密钥环为空", ErrKeyNotFound)
+	}
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *Keyring) Key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
@@ -0,0 +1,57 @@
+package serializer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPackSerializer MessagePack二进制序列化，消息头部携带schema ID
+// 结构体字段名使用json标签，与 JSONSerializer 的数据结构兼容
+type MsgPackSerializer struct {
+	registry Registry
+	schemaID int
+}
+
+// NewMsgPackSerializer 创建MessagePack序列化器，使用subject在注册中心的schema ID
+func NewMsgPackSerializer(registry Registry, subject string) (*MsgPackSerializer, error) {
+	schema, err := registry.Get(subject)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type != SchemaTypeMsgPack {
+		return nil, fmt.Errorf("subject %s 的schema类型为 %s，不是MessagePack", subject, schema.Type)
+	}
+
+	return &MsgPackSerializer{
+		registry: registry,
+		schemaID: schema.ID,
+	}, nil
+}
+
+func (s *MsgPackSerializer) Serialize(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(data); err != nil {
+		return nil, fmt.Errorf("MessagePack编码失败: %w", err)
+	}
+	return EncodeFrame(s.schemaID, buf.Bytes()), nil
+}
+
+func (s *MsgPackSerializer) Deserialize(data []byte, v interface{}) error {
+	return decodeFramed(s.registry, SchemaTypeMsgPack, msgPackDecoder{}, data, v)
+}
+
+// msgPackDecoder 解码MessagePack payload
+type msgPackDecoder struct{}
+
+func (msgPackDecoder) decodePayload(schema Schema, payload []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(payload))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("MessagePack解码失败: %w", err)
+	}
+	return nil
+}
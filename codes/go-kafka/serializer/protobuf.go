@@ -0,0 +1,78 @@
+package serializer
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufSerializer Protobuf二进制序列化，消息头部携带schema ID和消息索引
+// 消息索引与 Confluent 格式一致，只支持schema中的第一个消息类型（索引编码为单个0字节）
+type ProtobufSerializer struct {
+	registry Registry
+	schemaID int
+}
+
+// NewProtobufSerializer 创建Protobuf序列化器，使用subject在注册中心的schema ID
+func NewProtobufSerializer(registry Registry, subject string) (*ProtobufSerializer, error) {
+	schema, err := registry.Get(subject)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type != SchemaTypeProtobuf {
+		return nil, fmt.Errorf("subject %s 的schema类型为 %s，不是Protobuf", subject, schema.Type)
+	}
+
+	return &ProtobufSerializer{
+		registry: registry,
+		schemaID: schema.ID,
+	}, nil
+}
+
+func (s *ProtobufSerializer) Serialize(data interface{}) ([]byte, error) {
+	msg, ok := data.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("Protobuf序列化需要 proto.Message，得到 %T", data)
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("Protobuf编码失败: %w", err)
+	}
+
+	// 消息索引 [0] 编码为单个0字节
+	return EncodeFrame(s.schemaID, append([]byte{0}, payload...)), nil
+}
+
+func (s *ProtobufSerializer) Deserialize(data []byte, v interface{}) error {
+	return decodeFramed(s.registry, SchemaTypeProtobuf, protobufDecoder{}, data, v)
+}
+
+// protobufDecoder 解码Protobuf payload
+type protobufDecoder struct{}
+
+func (protobufDecoder) decodePayload(schema Schema, payload []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("Protobuf反序列化需要 proto.Message，得到 %T", v)
+	}
+
+	// 跳过消息索引：zigzag varint 数量，0表示 [0]
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 {
+		return ErrInvalidFrame
+	}
+	payload = payload[n:]
+	for i := int64(0); i < count; i++ {
+		if _, n = binary.Varint(payload); n <= 0 {
+			return ErrInvalidFrame
+		}
+		payload = payload[n:]
+	}
+
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Errorf("Protobuf解码失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Schema 类型
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeMsgPack  = "MSGPACK"
+	SchemaTypeJSON     = "JSON"
+)
+
+// Schema 注册中心中的schema，ID全局唯一，Version为subject内的版本号
+type Schema struct {
+	ID      int
+	Version int
+	Type    string
+	Data    []byte
+}
+
+var (
+	ErrSubjectNotFound    = errors.New("subject not found")
+	ErrVersionNotFound    = errors.New("version not found")
+	ErrSchemaNotFound     = errors.New("schema not found")
+	ErrIncompatibleSchema = errors.New("incompatible schema")
+)
+
+// Registry schema注册中心接口
+// 本地的 SchemaRegistry 和访问 Confluent REST API 的 RegistryClient 都实现该接口，可以互换使用
+type Registry interface {
+	// Register 注册schema，返回全局ID；与subject已有版本相同时不产生新版本
+	Register(subject string, schema Schema) (int, error)
+	// Get 获取subject的最新版本
+	Get(subject string) (Schema, error)
+	// GetVersion 获取subject的指定版本
+	GetVersion(subject string, version int) (Schema, error)
+	// GetByID 按全局ID获取schema
+	GetByID(id int) (Schema, error)
+	// Versions 返回subject的所有版本号
+	Versions(subject string) ([]int, error)
+	// SetCompatibility 设置subject的兼容性模式，subject为空时设置全局默认值
+	SetCompatibility(subject string, mode Compatibility) error
+	// CheckCompatibility 检查schema与subject的最新版本是否兼容
+	CheckCompatibility(subject string, schema Schema) error
+}
+
+// SchemaRegistry 本地schema注册中心，保存每个subject的所有版本并分配全局ID
+// 同一schema在不同subject下共享ID，注册新版本时按兼容性模式检查
+type SchemaRegistry struct {
+	mu            sync.RWMutex
+	subjects      map[string][]Schema
+	byID          map[int]Schema
+	nextID        int
+	compatibility Compatibility
+	subjectCompat map[string]Compatibility
+	path          string
+}
+
+// RegistryOption 注册中心配置选项
+type RegistryOption func(*SchemaRegistry)
+
+// WithDefaultCompatibility 设置全局默认兼容性模式，默认为 BACKWARD
+func WithDefaultCompatibility(mode Compatibility) RegistryOption {
+	return func(sr *SchemaRegistry) {
+		sr.compatibility = mode
+	}
+}
+
+// NewSchemaRegistry 创建内存中的注册中心
+func NewSchemaRegistry(options ...RegistryOption) *SchemaRegistry {
+	sr := &SchemaRegistry{
+		subjects:      make(map[string][]Schema),
+		byID:          make(map[int]Schema),
+		compatibility: CompatibilityBackward,
+		subjectCompat: make(map[string]Compatibility),
+	}
+	for _, opt := range options {
+		opt(sr)
+	}
+	return sr
+}
+
+// OpenSchemaRegistry 创建持久化到本地文件的注册中心，文件存在时加载已有数据
+// 每次修改后整体写入文件（先写临时文件再重命名）
+func OpenSchemaRegistry(path string, options ...RegistryOption) (*SchemaRegistry, error) {
+	sr := NewSchemaRegistry(options...)
+	sr.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sr, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取注册中心文件失败: %w", err)
+	}
+	if err := sr.load(data); err != nil {
+		return nil, fmt.Errorf("解析注册中心文件失败: %w", err)
+	}
+	return sr, nil
+}
+
+// Register 注册schema
+// 与subject已有版本内容相同时返回原ID；内容与其他subject的schema相同时复用其ID；
+// 指定ID时使用该ID（用于迁移），ID已被不同内容占用时返回错误
+func (sr *SchemaRegistry) Register(subject string, schema Schema) (int, error) {
+	if err := validateSchema(schema); err != nil {
+		return 0, err
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	versions := sr.subjects[subject]
+	for _, v := range versions {
+		if sameSchema(v, schema) {
+			return v.ID, nil
+		}
+	}
+
+	if len(versions) > 0 {
+		if err := checkCompatibility(sr.compatibilityLocked(subject), versions[len(versions)-1], schema); err != nil {
+			return 0, err
+		}
+	}
+
+	prevNextID := sr.nextID
+	id, err := sr.assignID(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	schema.ID = id
+	schema.Version = len(versions) + 1
+	if len(versions) > 0 {
+		schema.Version = versions[len(versions)-1].Version + 1
+	}
+	_, existed := sr.byID[id]
+	sr.subjects[subject] = append(versions, schema)
+	sr.byID[id] = Schema{ID: id, Type: schema.Type, Data: schema.Data}
+
+	if err := sr.saveLocked(); err != nil {
+		// 写入失败时回滚，内存与文件保持一致
+		if len(versions) == 0 {
+			delete(sr.subjects, subject)
+		} else {
+			sr.subjects[subject] = versions
+		}
+		if !existed {
+			delete(sr.byID, id)
+		}
+		sr.nextID = prevNextID
+		return 0, err
+	}
+	return id, nil
+}
+
+// assignID 查找内容相同的已有schema，或分配新的全局ID
+func (sr *SchemaRegistry) assignID(schema Schema) (int, error) {
+	if schema.ID != 0 {
+		if existing, ok := sr.byID[schema.ID]; ok && !sameSchema(existing, schema) {
+			return 0, fmt.Errorf("schema ID %d 已被其他schema使用", schema.ID)
+		}
+		if schema.ID > sr.nextID {
+			sr.nextID = schema.ID
+		}
+		return schema.ID, nil
+	}
+
+	for id, existing := range sr.byID {
+		if sameSchema(existing, schema) {
+			return id, nil
+		}
+	}
+	sr.nextID++
+	return sr.nextID, nil
+}
+
+// Get 获取subject的最新版本
+func (sr *SchemaRegistry) Get(subject string) (Schema, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	versions, ok := sr.subjects[subject]
+	if !ok || len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// GetVersion 获取subject的指定版本
+func (sr *SchemaRegistry) GetVersion(subject string, version int) (Schema, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	versions, ok := sr.subjects[subject]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: %s 版本 %d", ErrVersionNotFound, subject, version)
+}
+
+// GetByID 按全局ID获取schema，返回的Version为0
+func (sr *SchemaRegistry) GetByID(id int) (Schema, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	schema, ok := sr.byID[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+	}
+	return schema, nil
+}
+
+// Versions 返回subject的所有版本号
+func (sr *SchemaRegistry) Versions(subject string) ([]int, error) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	versions, ok := sr.subjects[subject]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	result := make([]int, len(versions))
+	for i, v := range versions {
+		result[i] = v.Version
+	}
+	return result, nil
+}
+
+// Subjects 返回所有subject，按名称排序
+func (sr *SchemaRegistry) Subjects() []string {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	subjects := make([]string, 0, len(sr.subjects))
+	for subject := range sr.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// SetCompatibility 设置subject的兼容性模式，subject为空时设置全局默认值
+func (sr *SchemaRegistry) SetCompatibility(subject string, mode Compatibility) error {
+	if !mode.valid() {
+		return fmt.Errorf("未知的兼容性模式: %s", mode)
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if subject == "" {
+		sr.compatibility = mode
+	} else {
+		sr.subjectCompat[subject] = mode
+	}
+	return sr.saveLocked()
+}
+
+// Compatibility 返回subject生效的兼容性模式，subject为空时返回全局默认值
+func (sr *SchemaRegistry) Compatibility(subject string) Compatibility {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	return sr.compatibilityLocked(subject)
+}
+
+func (sr *SchemaRegistry) compatibilityLocked(subject string) Compatibility {
+	if mode, ok := sr.subjectCompat[subject]; ok {
+		return mode
+	}
+	return sr.compatibility
+}
+
+// CheckCompatibility 检查schema与subject的最新版本是否兼容，subject不存在时总是兼容
+func (sr *SchemaRegistry) CheckCompatibility(subject string, schema Schema) error {
+	if err := validateSchema(schema); err != nil {
+		return err
+	}
+
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	versions := sr.subjects[subject]
+	if len(versions) == 0 {
+		return nil
+	}
+	return checkCompatibility(sr.compatibilityLocked(subject), versions[len(versions)-1], schema)
+}
+
+// sameSchema 比较类型和内容，JSON格式的schema忽略空白差异
+func sameSchema(a, b Schema) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	return bytes.Equal(compactSchema(a.Data), compactSchema(b.Data))
+}
+
+func compactSchema(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// registryFile 注册中心文件格式
+type registryFile struct {
+	NextID        int                      `json:"next_id"`
+	Compatibility Compatibility            `json:"compatibility"`
+	SubjectCompat map[string]Compatibility `json:"subject_compatibility,omitempty"`
+	Subjects      map[string][]Schema      `json:"subjects"`
+}
+
+func (sr *SchemaRegistry) load(data []byte) error {
+	var f registryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	sr.nextID = f.NextID
+	if f.Compatibility != "" {
+		sr.compatibility = f.Compatibility
+	}
+	for subject, mode := range f.SubjectCompat {
+		sr.subjectCompat[subject] = mode
+	}
+	for subject, versions := range f.Subjects {
+		sr.subjects[subject] = versions
+		for _, v := range versions {
+			sr.byID[v.ID] = Schema{ID: v.ID, Type: v.Type, Data: v.Data}
+			if v.ID > sr.nextID {
+				sr.nextID = v.ID
+			}
+		}
+	}
+	return nil
+}
+
+// saveLocked 持久化到文件，未设置文件时不做任何事
+func (sr *SchemaRegistry) saveLocked() error {
+	if sr.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(registryFile{
+		NextID:        sr.nextID,
+		Compatibility: sr.compatibility,
+		SubjectCompat: sr.subjectCompat,
+		Subjects:      sr.subjects,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sr.path), filepath.Base(sr.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("写入注册中心文件失败: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("写入注册中心文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("写入注册中心文件失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), sr.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("写入注册中心文件失败: %w", err)
+	}
+	return nil
+}
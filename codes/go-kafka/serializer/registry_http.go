@@ -0,0 +1,454 @@
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentTypeRegistry Confluent Schema Registry 使用的Content-Type
+const contentTypeRegistry = "application/vnd.schemaregistry.v1+json"
+
+// Confluent REST API 的错误码
+const (
+	errCodeSubjectNotFound    = 40401
+	errCodeVersionNotFound    = 40402
+	errCodeSchemaNotFound     = 40403
+	errCodeIncompatibleSchema = 409
+	errCodeInvalidSchema      = 42201
+	errCodeInvalidCompat      = 42203
+)
+
+// registryError REST API 的错误响应
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.ErrorCode, e.Message)
+}
+
+// Unwrap 将错误码映射为本包的错误，调用方可以用 errors.Is 判断
+func (e *registryError) Unwrap() error {
+	switch e.ErrorCode {
+	case errCodeSubjectNotFound:
+		return ErrSubjectNotFound
+	case errCodeVersionNotFound:
+		return ErrVersionNotFound
+	case errCodeSchemaNotFound:
+		return ErrSchemaNotFound
+	case errCodeIncompatibleSchema:
+		return ErrIncompatibleSchema
+	}
+	return nil
+}
+
+// schemaRequest 注册和检查schema的请求体
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+	ID         int    `json:"id,omitempty"`
+}
+
+// schemaResponse 查询schema的响应体
+type schemaResponse struct {
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	ID         int    `json:"id,omitempty"`
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// configRequest 兼容性配置，GET返回compatibilityLevel，PUT使用compatibility
+type configRequest struct {
+	Compatibility      Compatibility `json:"compatibility,omitempty"`
+	CompatibilityLevel Compatibility `json:"compatibilityLevel,omitempty"`
+}
+
+// Confluent 默认类型为Avro，请求和响应中省略schemaType
+func toSchemaType(t string) string {
+	if t == "" {
+		return SchemaTypeAvro
+	}
+	return t
+}
+
+func fromSchemaType(t string) string {
+	if t == SchemaTypeAvro {
+		return ""
+	}
+	return t
+}
+
+// RegistryHandler 以 Confluent Schema Registry 兼容的REST API 提供本地注册中心
+// 可以用 httptest 在进程内启动，替代真实的注册中心
+type RegistryHandler struct {
+	registry *SchemaRegistry
+}
+
+// NewRegistryHandler 创建REST API处理器
+func NewRegistryHandler(registry *SchemaRegistry) *RegistryHandler {
+	return &RegistryHandler{registry: registry}
+}
+
+// ServeHTTP 支持的接口：
+//
+//	GET  /subjects
+//	GET  /subjects/{subject}/versions
+//	POST /subjects/{subject}/versions
+//	GET  /subjects/{subject}/versions/{version|latest}
+//	GET  /schemas/ids/{id}
+//	POST /compatibility/subjects/{subject}/versions/{version|latest}
+//	GET|PUT /config, /config/{subject}
+func (h *RegistryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			parts[i] = unescaped
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "subjects" && r.Method == http.MethodGet:
+		writeRegistryJSON(w, http.StatusOK, h.registry.Subjects())
+	case len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions":
+		if r.Method == http.MethodPost {
+			h.register(w, r, parts[1])
+		} else {
+			h.versions(w, parts[1])
+		}
+	case len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions" && r.Method == http.MethodGet:
+		h.version(w, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "schemas" && parts[1] == "ids" && r.Method == http.MethodGet:
+		h.schemaByID(w, parts[2])
+	case len(parts) == 5 && parts[0] == "compatibility" && parts[1] == "subjects" && r.Method == http.MethodPost:
+		h.compatibility(w, r, parts[2], parts[4])
+	case parts[0] == "config" && len(parts) <= 2:
+		subject := ""
+		if len(parts) == 2 {
+			subject = parts[1]
+		}
+		h.config(w, r, subject)
+	default:
+		writeRegistryError(w, http.StatusNotFound, &registryError{ErrorCode: 404, Message: "HTTP 404 Not Found"})
+	}
+}
+
+func (h *RegistryHandler) register(w http.ResponseWriter, r *http.Request, subject string) {
+	schema, ok := readSchemaRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := h.registry.Register(subject, schema)
+	if err != nil {
+		writeRegistryErr(w, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, map[string]int{"id": id})
+}
+
+func (h *RegistryHandler) versions(w http.ResponseWriter, subject string) {
+	versions, err := h.registry.Versions(subject)
+	if err != nil {
+		writeRegistryErr(w, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, versions)
+}
+
+func (h *RegistryHandler) version(w http.ResponseWriter, subject, version string) {
+	schema, err := h.lookupVersion(subject, version)
+	if err != nil {
+		writeRegistryErr(w, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, schemaResponse{
+		Subject:    subject,
+		Version:    schema.Version,
+		ID:         schema.ID,
+		Schema:     string(schema.Data),
+		SchemaType: fromSchemaType(schema.Type),
+	})
+}
+
+func (h *RegistryHandler) lookupVersion(subject, version string) (Schema, error) {
+	if version == "latest" {
+		return h.registry.Get(subject)
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return Schema{}, fmt.Errorf("%w: %s", ErrVersionNotFound, version)
+	}
+	return h.registry.GetVersion(subject, v)
+}
+
+func (h *RegistryHandler) schemaByID(w http.ResponseWriter, idText string) {
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		writeRegistryErr(w, fmt.Errorf("%w: %s", ErrSchemaNotFound, idText))
+		return
+	}
+	schema, err := h.registry.GetByID(id)
+	if err != nil {
+		writeRegistryErr(w, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, schemaResponse{
+		Schema:     string(schema.Data),
+		SchemaType: fromSchemaType(schema.Type),
+	})
+}
+
+// compatibility 只支持与最新版本比较
+func (h *RegistryHandler) compatibility(w http.ResponseWriter, r *http.Request, subject, version string) {
+	schema, ok := readSchemaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.lookupVersion(subject, version); err != nil && !errors.Is(err, ErrSubjectNotFound) {
+		writeRegistryErr(w, err)
+		return
+	}
+
+	err := h.registry.CheckCompatibility(subject, schema)
+	if err != nil && !errors.Is(err, ErrIncompatibleSchema) {
+		writeRegistryErr(w, err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, map[string]bool{"is_compatible": err == nil})
+}
+
+func (h *RegistryHandler) config(w http.ResponseWriter, r *http.Request, subject string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeRegistryJSON(w, http.StatusOK, configRequest{CompatibilityLevel: h.registry.Compatibility(subject)})
+	case http.MethodPut:
+		var req configRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeRegistryError(w, http.StatusUnprocessableEntity, &registryError{ErrorCode: errCodeInvalidCompat, Message: err.Error()})
+			return
+		}
+		if err := h.registry.SetCompatibility(subject, req.Compatibility); err != nil {
+			writeRegistryError(w, http.StatusUnprocessableEntity, &registryError{ErrorCode: errCodeInvalidCompat, Message: err.Error()})
+			return
+		}
+		writeRegistryJSON(w, http.StatusOK, configRequest{Compatibility: req.Compatibility})
+	default:
+		writeRegistryError(w, http.StatusMethodNotAllowed, &registryError{ErrorCode: 405, Message: "HTTP 405 Method Not Allowed"})
+	}
+}
+
+func readSchemaRequest(w http.ResponseWriter, r *http.Request) (Schema, bool) {
+	var req schemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRegistryError(w, http.StatusUnprocessableEntity, &registryError{ErrorCode: errCodeInvalidSchema, Message: err.Error()})
+		return Schema{}, false
+	}
+	return Schema{ID: req.ID, Type: toSchemaType(req.SchemaType), Data: []byte(req.Schema)}, true
+}
+
+// writeRegistryErr 将本包的错误转换为对应的状态码和错误码
+func writeRegistryErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSubjectNotFound):
+		writeRegistryError(w, http.StatusNotFound, &registryError{ErrorCode: errCodeSubjectNotFound, Message: err.Error()})
+	case errors.Is(err, ErrVersionNotFound):
+		writeRegistryError(w, http.StatusNotFound, &registryError{ErrorCode: errCodeVersionNotFound, Message: err.Error()})
+	case errors.Is(err, ErrSchemaNotFound):
+		writeRegistryError(w, http.StatusNotFound, &registryError{ErrorCode: errCodeSchemaNotFound, Message: err.Error()})
+	case errors.Is(err, ErrIncompatibleSchema):
+		writeRegistryError(w, http.StatusConflict, &registryError{ErrorCode: errCodeIncompatibleSchema, Message: err.Error()})
+	default:
+		writeRegistryError(w, http.StatusUnprocessableEntity, &registryError{ErrorCode: errCodeInvalidSchema, Message: err.Error()})
+	}
+}
+
+func writeRegistryError(w http.ResponseWriter, status int, e *registryError) {
+	writeRegistryJSON(w, status, e)
+}
+
+func writeRegistryJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentTypeRegistry)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RegistryClient 通过 Confluent 兼容的REST API 访问注册中心
+// 按ID缓存schema（schema内容不可变），subject的最新版本每次都重新查询
+type RegistryClient struct {
+	baseURL string
+	client  *http.Client
+
+	mu    sync.RWMutex
+	byID  map[int]Schema
+	idsOf map[string]int // subject+内容 -> ID，避免重复注册请求
+}
+
+// RegistryClientOption 客户端配置选项
+type RegistryClientOption func(*RegistryClient)
+
+// WithHTTPClient 设置HTTP客户端，用于认证、TLS等配置
+func WithHTTPClient(client *http.Client) RegistryClientOption {
+	return func(c *RegistryClient) {
+		if client != nil {
+			c.client = client
+		}
+	}
+}
+
+// NewRegistryClient 创建注册中心客户端，baseURL 如 http://localhost:8081
+func NewRegistryClient(baseURL string, options ...RegistryClientOption) *RegistryClient {
+	c := &RegistryClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		byID:    make(map[int]Schema),
+		idsOf:   make(map[string]int),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+func (c *RegistryClient) Register(subject string, schema Schema) (int, error) {
+	key := subject + "\x00" + schema.Type + "\x00" + string(compactSchema(schema.Data))
+	c.mu.RLock()
+	id, ok := c.idsOf[key]
+	c.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	req := schemaRequest{Schema: string(schema.Data), SchemaType: fromSchemaType(schema.Type), ID: schema.ID}
+	if err := c.do(http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", req, &resp); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.idsOf[key] = resp.ID
+	c.byID[resp.ID] = Schema{ID: resp.ID, Type: toSchemaType(schema.Type), Data: schema.Data}
+	c.mu.Unlock()
+	return resp.ID, nil
+}
+
+func (c *RegistryClient) Get(subject string) (Schema, error) {
+	return c.getVersion(subject, "latest")
+}
+
+func (c *RegistryClient) GetVersion(subject string, version int) (Schema, error) {
+	return c.getVersion(subject, strconv.Itoa(version))
+}
+
+func (c *RegistryClient) getVersion(subject, version string) (Schema, error) {
+	var resp schemaResponse
+	if err := c.do(http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/"+version, nil, &resp); err != nil {
+		return Schema{}, err
+	}
+	return Schema{ID: resp.ID, Version: resp.Version, Type: toSchemaType(resp.SchemaType), Data: []byte(resp.Schema)}, nil
+}
+
+func (c *RegistryClient) GetByID(id int) (Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.byID[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	var resp schemaResponse
+	if err := c.do(http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &resp); err != nil {
+		return Schema{}, err
+	}
+	schema = Schema{ID: id, Type: toSchemaType(resp.SchemaType), Data: []byte(resp.Schema)}
+
+	c.mu.Lock()
+	c.byID[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+func (c *RegistryClient) Versions(subject string) ([]int, error) {
+	var versions []int
+	if err := c.do(http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions", nil, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (c *RegistryClient) SetCompatibility(subject string, mode Compatibility) error {
+	path := "/config"
+	if subject != "" {
+		path += "/" + url.PathEscape(subject)
+	}
+	return c.do(http.MethodPut, path, configRequest{Compatibility: mode}, nil)
+}
+
+func (c *RegistryClient) CheckCompatibility(subject string, schema Schema) error {
+	var resp struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	req := schemaRequest{Schema: string(schema.Data), SchemaType: fromSchemaType(schema.Type)}
+	err := c.do(http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", req, &resp)
+	if errors.Is(err, ErrSubjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.IsCompatible {
+		return fmt.Errorf("%w: subject %s", ErrIncompatibleSchema, subject)
+	}
+	return nil
+}
+
+// do 发送请求并解析响应，非2xx状态码返回 registryError
+func (c *RegistryClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentTypeRegistry)
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeRegistry)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求注册中心失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		e := &registryError{ErrorCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -59,17 +59,23 @@ func (s *StringSerializer) Deserialize(data []byte, v interface{}) error {
 }
 
 // Message 通用消息结构
+// Event 不为nil时按CloudEvents的Kafka协议绑定编码，使用 NewCloudEvent 创建
 type Message struct {
 	Topic     string            `json:"topic,omitempty"`
 	Key       string            `json:"key,omitempty"`
 	Data      interface{}       `json:"data"`
 	Headers   map[string]string `json:"headers,omitempty"`
 	Timestamp int64             `json:"timestamp"`
+	Event     *CloudEvent       `json:"-"`
 }
 
-// EncodeMessage 编码消息
+// EncodeMessage 编码消息，serializer 实现 HeaderSerializer 时写入它需要的消息头
 func EncodeMessage(msg Message, serializer Serializer) (kafka.Message, error) {
-	value, err := serializer.Serialize(msg.Data)
+	if msg.Event != nil {
+		return encodeCloudEvent(msg, serializer)
+	}
+
+	value, headers, err := SerializeWithHeaders(serializer, msg.Data, msg.Headers)
 	if err != nil {
 		return kafka.Message{}, err
 	}
@@ -80,7 +86,7 @@ func EncodeMessage(msg Message, serializer Serializer) (kafka.Message, error) {
 	}
 
 	// 添加消息头
-	for k, v := range msg.Headers {
+	for k, v := range headers {
 		kafkaMsg.Headers = append(kafkaMsg.Headers, kafka.Header{
 			Key:   k,
 			Value: []byte(v),
@@ -90,53 +96,21 @@ func EncodeMessage(msg Message, serializer Serializer) (kafka.Message, error) {
 	return kafkaMsg, nil
 }
 
-// DecodeMessage 解码消息
+// DecodeMessage 解码消息，CloudEvents消息（二进制或结构化模式）的属性解析到 Message.Event
 func DecodeMessage(kafkaMsg kafka.Message, serializer Serializer, dest interface{}) (*Message, error) {
-	if err := serializer.Deserialize(kafkaMsg.Value, dest); err != nil {
+	headers := HeadersOf(kafkaMsg)
+	if mode, ok := cloudEventMode(headers); ok {
+		return decodeCloudEvent(kafkaMsg, mode, headers, serializer, dest)
+	}
+	if err := DeserializeWithHeaders(serializer, kafkaMsg.Value, headers, dest); err != nil {
 		return nil, err
 	}
 
-	msg := &Message{
+	return &Message{
 		Topic:     kafkaMsg.Topic,
 		Key:       string(kafkaMsg.Key),
 		Data:      dest,
 		Timestamp: kafkaMsg.Time.UnixMilli(),
-		Headers:   make(map[string]string),
-	}
-
-	for _, h := range kafkaMsg.Headers {
-		msg.Headers[h.Key] = string(h.Value)
-	}
-
-	return msg, nil
-}
-
-// SchemaRegistry 模拟schema注册中心（实际项目中使用 Confluent Schema Registry）
-type SchemaRegistry struct {
-	schemas map[string]Schema
-}
-
-type Schema struct {
-	Version int
-	Type    string
-	Data    []byte
-}
-
-func NewSchemaRegistry() *SchemaRegistry {
-	return &SchemaRegistry{
-		schemas: make(map[string]Schema),
-	}
-}
-
-func (sr *SchemaRegistry) Register(subject string, schema Schema) error {
-	sr.schemas[subject] = schema
-	return nil
-}
-
-func (sr *SchemaRegistry) Get(subject string) (Schema, error) {
-	schema, ok := sr.schemas[subject]
-	if !ok {
-		return Schema{}, fmt.Errorf("schema not found: %s", subject)
-	}
-	return schema, nil
+		Headers:   headers,
+	}, nil
 }
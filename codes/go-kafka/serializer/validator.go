@@ -0,0 +1,158 @@
+package serializer
+
+import (
+	"sync"
+	"time"
+)
+
+// Validator 按注册中心中subject的最新JSON Schema校验payload
+// 编译后的schema按ID缓存；subject的最新版本按刷新间隔重新查询，注册新版本后最多延迟一个间隔生效
+type Validator struct {
+	registry Registry
+	refresh  time.Duration
+
+	mu       sync.Mutex
+	compiled map[int]*JSONSchema
+	latest   map[string]latestSchema
+}
+
+type latestSchema struct {
+	schema    Schema
+	fetchedAt time.Time
+}
+
+// ValidatorOption 校验器配置选项
+type ValidatorOption func(*Validator)
+
+// WithSchemaRefresh 设置subject最新版本的刷新间隔，默认1分钟，0表示每次都查询注册中心
+func WithSchemaRefresh(d time.Duration) ValidatorOption {
+	return func(v *Validator) {
+		if d >= 0 {
+			v.refresh = d
+		}
+	}
+}
+
+// NewValidator 创建校验器，实现 producer.Validator 和 middleware.PayloadValidator
+func NewValidator(registry Registry, options ...ValidatorOption) *Validator {
+	v := &Validator{
+		registry: registry,
+		refresh:  time.Minute,
+		compiled: make(map[int]*JSONSchema),
+		latest:   make(map[string]latestSchema),
+	}
+	for _, opt := range options {
+		opt(v)
+	}
+	return v
+}
+
+// Validate 校验payload，不满足schema时返回 *ValidationError
+// subject不存在时返回 ErrSubjectNotFound；非JSON类型的schema由对应的序列化器保证格式，直接通过
+func (v *Validator) Validate(subject string, payload []byte) error {
+	schema, err := v.lookup(subject)
+	if err != nil {
+		return err
+	}
+	if schema.Type != SchemaTypeJSON || len(schema.Data) == 0 {
+		return nil
+	}
+
+	compiled, err := v.compile(schema)
+	if err != nil {
+		return err
+	}
+	violations, err := compiled.Validate(payload)
+	if err != nil {
+		return &ValidationError{Subject: subject, SchemaID: schema.ID, Violations: []Violation{{Message: err.Error()}}}
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Subject: subject, SchemaID: schema.ID, Violations: violations}
+	}
+	return nil
+}
+
+func (v *Validator) lookup(subject string) (Schema, error) {
+	v.mu.Lock()
+	cached, ok := v.latest[subject]
+	v.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < v.refresh {
+		return cached.schema, nil
+	}
+
+	schema, err := v.registry.Get(subject)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	v.mu.Lock()
+	v.latest[subject] = latestSchema{schema: schema, fetchedAt: time.Now()}
+	v.mu.Unlock()
+	return schema, nil
+}
+
+func (v *Validator) compile(schema Schema) (*JSONSchema, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if compiled, ok := v.compiled[schema.ID]; ok {
+		return compiled, nil
+	}
+	compiled, err := CompileJSONSchema(schema.Data)
+	if err != nil {
+		return nil, err
+	}
+	v.compiled[schema.ID] = compiled
+	return compiled, nil
+}
+
+// ValidatingSerializer 序列化后、反序列化前按schema校验payload，不满足时返回 *ValidationError
+// 与 CompressionSerializer、EncryptionSerializer 组合时放在它们内层，校验的是压缩和加密前的明文
+type ValidatingSerializer struct {
+	inner     Serializer
+	validator *Validator
+	subject   string
+}
+
+var _ HeaderSerializer = (*ValidatingSerializer)(nil)
+
+// NewValidatingSerializer 创建校验序列化器，subject 通常为 "<topic>-value"
+func NewValidatingSerializer(inner Serializer, validator *Validator, subject string) *ValidatingSerializer {
+	return &ValidatingSerializer{inner: inner, validator: validator, subject: subject}
+}
+
+func (s *ValidatingSerializer) Serialize(data interface{}) ([]byte, error) {
+	value, err := s.inner.Serialize(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validator.Validate(s.subject, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *ValidatingSerializer) Deserialize(data []byte, v interface{}) error {
+	if err := s.validator.Validate(s.subject, data); err != nil {
+		return err
+	}
+	return s.inner.Deserialize(data, v)
+}
+
+func (s *ValidatingSerializer) SerializeWithHeaders(data interface{}, headers map[string]string) ([]byte, error) {
+	value, err := serializeInner(s.inner, data, headers)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validator.Validate(s.subject, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *ValidatingSerializer) DeserializeWithHeaders(data []byte, headers map[string]string, v interface{}) error {
+	if err := s.validator.Validate(s.subject, data); err != nil {
+		return err
+	}
+	return DeserializeWithHeaders(s.inner, data, headers, v)
+}
@@ -0,0 +1,591 @@
+// Package spool 生产者的本地磁盘缓存
+// 发送失败的批次按顺序追加到段文件，连接恢复后按写入顺序重放，进程重启后继续重放
+package spool
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go-kafka/config"
+)
+
+const (
+	defaultSegmentBytes = 64 << 20
+	defaultMaxBytes     = 1 << 30
+
+	segmentExt   = ".seg"
+	cursorFile   = "cursor"
+	headerSize   = 8       // 4字节长度 + 4字节CRC32C
+	maxRecordLen = 1 << 30 // 长度超过该值视为损坏
+)
+
+// ErrFull 缓存已达到 MaxBytes
+var ErrFull = errors.New("spool is full")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Stats 缓存状态
+type Stats struct {
+	Segments  int   // 段文件数
+	Bytes     int64 // 段文件占用的字节数
+	Batches   int64 // 未发送的批次数
+	Messages  int64 // 未发送的消息数
+	Expired   int64 // 因超过 MaxAge 丢弃的消息数
+	Corrupted int64 // 因校验失败丢弃的批次数
+}
+
+// position 下一个要重放的批次的位置
+type position struct {
+	segment uint64
+	offset  int64
+}
+
+type segment struct {
+	id       uint64
+	size     int64
+	modTime  time.Time
+	batches  int64 // 未重放的批次数
+	messages int64 // 未重放的消息数
+}
+
+// Spool 段文件组成的本地磁盘队列
+// 每个批次为一条记录：4字节长度、4字节CRC32C校验和、JSON编码的消息；重放位置保存在 cursor 文件中。
+// 崩溃时写了一半的记录在打开时截断；重放成功后才前移位置，重放过程中崩溃的批次会再次发送
+type Spool struct {
+	mu           sync.Mutex
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+	maxAge       time.Duration
+
+	segments []*segment // 按ID排序，最后一个为写入中的段
+	active   *os.File   // 最后一个段的写入句柄，没有时在下次写入时创建
+	cursor   position
+	reader   *os.File // cursor 所在段的读取句柄
+	readerID uint64
+
+	expired   int64
+	corrupted int64
+	closed    bool
+}
+
+// Open 打开或创建缓存目录，检查已有的段文件
+func Open(cfg config.SpoolConfig) (*Spool, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("缓存目录不能为空")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+
+	s := &Spool{
+		dir:          cfg.Dir,
+		segmentBytes: cfg.SegmentBytes,
+		maxBytes:     cfg.MaxBytes,
+		maxAge:       cfg.MaxAge.Std(),
+	}
+	if s.segmentBytes <= 0 {
+		s.segmentBytes = defaultSegmentBytes
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultMaxBytes
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 读取重放位置，删除已重放的段，统计每个段未重放的批次并截断末尾不完整的记录
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("读取缓存目录失败: %w", err)
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	s.cursor = s.readCursor()
+
+	for _, id := range ids {
+		if id < s.cursor.segment {
+			os.Remove(s.segmentPath(id))
+			continue
+		}
+		seg, err := s.scan(id)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	// 重放位置所在的段不存在时从第一个段开始
+	if len(s.segments) > 0 && s.segments[0].id != s.cursor.segment {
+		s.cursor = position{segment: s.segments[0].id}
+	}
+	return nil
+}
+
+// readCursor 读取保存的重放位置，文件不存在或损坏时从头开始
+func (s *Spool) readCursor() position {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if err != nil {
+		return position{}
+	}
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.segment, &pos.offset); err != nil {
+		return position{}
+	}
+	return pos
+}
+
+// writeCursor 保存重放位置，写入临时文件后替换
+func (s *Spool) writeCursor() error {
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d %d\n", s.cursor.segment, s.cursor.offset); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	return os.Rename(tmp, path)
+}
+
+// scan 统计段中重放位置之后的批次，遇到不完整或校验失败的记录时截断
+func (s *Spool) scan(id uint64) (*segment, error) {
+	path := s.segmentPath(id)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("打开段文件失败: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{id: id, size: info.Size(), modTime: info.ModTime()}
+
+	var offset int64
+	if id == s.cursor.segment {
+		offset = s.cursor.offset
+	}
+	for offset < seg.size {
+		msgs, n, err := readRecord(f, offset)
+		if err != nil {
+			// 崩溃时写了一半的记录或损坏的记录，之后的数据无法定位，截断
+			if err := f.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("截断段文件失败: %w", err)
+			}
+			if err != io.ErrUnexpectedEOF {
+				s.corrupted++
+			}
+			seg.size = offset
+			break
+		}
+		seg.batches++
+		seg.messages += int64(len(msgs))
+		offset += n
+	}
+	return seg, nil
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// Append 追加一个批次，返回时已同步到磁盘；超过 MaxBytes 时返回 ErrFull
+func (s *Spool) Append(msgs []kafka.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	record, err := encodeRecord(msgs)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("spool is closed")
+	}
+	if s.bytesLocked()+int64(len(record)) > s.maxBytes {
+		return ErrFull
+	}
+
+	seg, err := s.activeLocked(int64(len(record)))
+	if err != nil {
+		return err
+	}
+	if _, err := s.active.Write(record); err != nil {
+		// 写入失败的部分数据截断，保证文件以完整的记录结尾
+		s.active.Truncate(seg.size)
+		return fmt.Errorf("写入缓存失败: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("写入缓存失败: %w", err)
+	}
+
+	seg.size += int64(len(record))
+	seg.modTime = time.Now()
+	seg.batches++
+	seg.messages += int64(len(msgs))
+	return nil
+}
+
+// activeLocked 返回写入中的段，写入后超过 SegmentBytes 时创建新的段
+func (s *Spool) activeLocked(size int64) (*segment, error) {
+	if n := len(s.segments); n > 0 {
+		last := s.segments[n-1]
+		if last.size == 0 || last.size+size <= s.segmentBytes {
+			if s.active == nil {
+				f, err := os.OpenFile(s.segmentPath(last.id), os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					return nil, fmt.Errorf("打开段文件失败: %w", err)
+				}
+				s.active = f
+			}
+			return last, nil
+		}
+	}
+
+	id := s.cursor.segment
+	if n := len(s.segments); n > 0 {
+		id = s.segments[n-1].id + 1
+	}
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("创建段文件失败: %w", err)
+	}
+	syncDir(s.dir)
+
+	if s.active != nil {
+		s.active.Close()
+	}
+	s.active = f
+	seg := &segment{id: id, modTime: time.Now()}
+	s.segments = append(s.segments, seg)
+	if len(s.segments) == 1 {
+		s.cursor = position{segment: id}
+	}
+	return seg, nil
+}
+
+// Replay 按写入顺序把批次交给send，send 成功后删除该批次；send 返回错误时停止，该批次留待下次重放
+// 返回成功重放的消息数。Replay 不能并发调用
+func (s *Spool) Replay(ctx context.Context, send func(ctx context.Context, msgs []kafka.Message) error) (int, error) {
+	replayed := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+
+		msgs, next, err := s.next()
+		if err != nil || msgs == nil {
+			return replayed, err
+		}
+
+		if err := send(ctx, msgs); err != nil {
+			return replayed, err
+		}
+
+		if err := s.advance(next, int64(len(msgs))); err != nil {
+			return replayed, err
+		}
+		replayed += len(msgs)
+	}
+}
+
+// next 读取重放位置的批次，跳过过期和损坏的段，没有批次时返回nil
+func (s *Spool) next() ([]kafka.Message, position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+
+		if s.maxAge > 0 && seg.batches > 0 && time.Since(seg.modTime) > s.maxAge {
+			s.expired += seg.messages
+			if err := s.dropFirstLocked(); err != nil {
+				return nil, position{}, err
+			}
+			continue
+		}
+
+		if s.cursor.offset >= seg.size {
+			if len(s.segments) == 1 {
+				return nil, position{}, nil
+			}
+			if err := s.dropFirstLocked(); err != nil {
+				return nil, position{}, err
+			}
+			continue
+		}
+
+		if s.reader == nil || s.readerID != seg.id {
+			if s.reader != nil {
+				s.reader.Close()
+			}
+			f, err := os.Open(s.segmentPath(seg.id))
+			if err != nil {
+				return nil, position{}, fmt.Errorf("打开段文件失败: %w", err)
+			}
+			s.reader, s.readerID = f, seg.id
+		}
+
+		msgs, n, err := readRecord(s.reader, s.cursor.offset)
+		if err != nil {
+			// 打开后损坏的段无法继续定位记录，丢弃剩余部分
+			s.corrupted++
+			if err := s.dropFirstLocked(); err != nil {
+				return nil, position{}, err
+			}
+			continue
+		}
+		return msgs, position{segment: seg.id, offset: s.cursor.offset + n}, nil
+	}
+	return nil, position{}, nil
+}
+
+// advance 批次重放成功后前移重放位置，段全部重放后删除
+func (s *Spool) advance(next position, messages int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 || s.segments[0].id != next.segment {
+		return nil // 段已过期被删除
+	}
+	seg := s.segments[0]
+	seg.batches--
+	seg.messages -= messages
+	s.cursor = next
+
+	// 全部重放完后删除所有段，下次写入时创建新的段
+	if seg.batches == 0 && len(s.segments) == 1 {
+		s.closeActiveLocked()
+		return s.dropFirstLocked()
+	}
+	if s.cursor.offset >= seg.size && len(s.segments) > 1 {
+		return s.dropFirstLocked()
+	}
+	return s.writeCursor()
+}
+
+// dropFirstLocked 删除第一个段，重放位置移到下一个段的开头
+func (s *Spool) dropFirstLocked() error {
+	seg := s.segments[0]
+	if s.reader != nil && s.readerID == seg.id {
+		s.reader.Close()
+		s.reader = nil
+	}
+	if len(s.segments) == 1 && s.active != nil {
+		s.closeActiveLocked()
+	}
+	s.segments = s.segments[1:]
+
+	s.cursor = position{segment: seg.id + 1}
+	if len(s.segments) > 0 {
+		s.cursor.segment = s.segments[0].id
+	}
+	// 先保存位置再删除，崩溃时最多留下一个已重放的段，打开时按位置删除
+	if err := s.writeCursor(); err != nil {
+		return fmt.Errorf("保存重放位置失败: %w", err)
+	}
+	if err := os.Remove(s.segmentPath(seg.id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除段文件失败: %w", err)
+	}
+	return nil
+}
+
+func (s *Spool) closeActiveLocked() {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+}
+
+func (s *Spool) bytesLocked() int64 {
+	var n int64
+	for _, seg := range s.segments {
+		n += seg.size
+	}
+	return n
+}
+
+// Stats 获取缓存状态
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{
+		Segments:  len(s.segments),
+		Bytes:     s.bytesLocked(),
+		Expired:   s.expired,
+		Corrupted: s.corrupted,
+	}
+	for _, seg := range s.segments {
+		st.Batches += seg.batches
+		st.Messages += seg.messages
+	}
+	return st
+}
+
+// Pending 是否有未重放的批次
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seg := range s.segments {
+		if seg.batches > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 关闭段文件，未重放的批次在下次打开时继续重放
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.closeActiveLocked()
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+	return nil
+}
+
+// storedMessage 段文件中保存的消息
+type storedMessage struct {
+	Topic     string         `json:"t,omitempty"`
+	Partition int            `json:"p,omitempty"`
+	Key       []byte         `json:"k,omitempty"`
+	Value     []byte         `json:"v,omitempty"`
+	Headers   []storedHeader `json:"h,omitempty"`
+	Time      int64          `json:"ts,omitempty"`
+}
+
+type storedHeader struct {
+	Key   string `json:"k"`
+	Value []byte `json:"v"`
+}
+
+// encodeRecord 编码一个批次：4字节长度、4字节CRC32C、JSON
+func encodeRecord(msgs []kafka.Message) ([]byte, error) {
+	stored := make([]storedMessage, len(msgs))
+	for i, msg := range msgs {
+		stored[i] = storedMessage{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Key:       msg.Key,
+			Value:     msg.Value,
+		}
+		if !msg.Time.IsZero() {
+			stored[i].Time = msg.Time.UnixNano()
+		}
+		for _, h := range msg.Headers {
+			stored[i].Headers = append(stored[i].Headers, storedHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+
+	payload, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("编码缓存记录失败: %w", err)
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+	return record, nil
+}
+
+// readRecord 读取offset处的记录，返回消息和记录占用的字节数
+// 文件在记录中间结束时返回 io.ErrUnexpectedEOF，校验失败时返回其他错误
+func readRecord(f *os.File, offset int64) ([]kafka.Message, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordLen {
+		return nil, 0, fmt.Errorf("记录长度 %d 无效", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+headerSize); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("记录校验失败")
+	}
+
+	var stored []storedMessage
+	if err := json.Unmarshal(payload, &stored); err != nil {
+		return nil, 0, fmt.Errorf("解码缓存记录失败: %w", err)
+	}
+
+	msgs := make([]kafka.Message, len(stored))
+	for i, m := range stored {
+		msgs[i] = kafka.Message{
+			Topic:     m.Topic,
+			Partition: m.Partition,
+			Key:       m.Key,
+			Value:     m.Value,
+		}
+		if m.Time != 0 {
+			msgs[i].Time = time.Unix(0, m.Time)
+		}
+		for _, h := range m.Headers {
+			msgs[i].Headers = append(msgs[i].Headers, kafka.Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	return msgs, headerSize + int64(length), nil
+}
+
+// syncDir 确保目录中新建和删除的文件持久化
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
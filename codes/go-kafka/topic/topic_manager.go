@@ -20,7 +20,7 @@ type TopicManager struct {
 // NewTopicManager 创建Topic管理器
 func NewTopicManager(cfg *config.KafkaConfig) (*TopicManager, error) {
 	// 连接到Kafka集群（选择一个broker即可）
-	conn, err := kafka.Dial("tcp", cfg.Brokers[0])
+	conn, err := cfg.DialContext(context.Background(), "tcp", cfg.Brokers[0])
 	if err != nil {
 		return nil, fmt.Errorf("连接Kafka失败: %w", err)
 	}
@@ -36,6 +36,7 @@ func NewTopicManager(cfg *config.KafkaConfig) (*TopicManager, error) {
 // partitions: 分区数
 // replicationFactor: 副本因子
 // retentionMs: 消息保留时间（毫秒），-1表示使用默认
+// partitions 或 replicationFactor 不大于0时使用 admin.partitions 和 admin.replication_factor 配置
 func (tm *TopicManager) CreateTopic(
 	ctx context.Context,
 	topic string,
@@ -43,6 +44,13 @@ func (tm *TopicManager) CreateTopic(
 	replicationFactor int,
 	retentionMs int64,
 ) error {
+	if partitions <= 0 {
+		partitions = tm.config.Admin.Partitions
+	}
+	if replicationFactor <= 0 {
+		replicationFactor = tm.config.Admin.ReplicationFactor
+	}
+
 	// 配置Topic参数
 	configEntries := []kafka.ConfigEntry{
 		{
@@ -77,7 +85,7 @@ func (tm *TopicManager) CreateTopic(
 		return fmt.Errorf("获取控制器失败: %w", err)
 	}
 
-	controllerConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	controllerConn, err := tm.config.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
 	if err != nil {
 		return fmt.Errorf("连接控制器失败: %w", err)
 	}
@@ -110,7 +118,7 @@ func (tm *TopicManager) DeleteTopic(ctx context.Context, topics ...string) error
 		return fmt.Errorf("获取控制器失败: %w", err)
 	}
 
-	controllerConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	controllerConn, err := tm.config.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
 	if err != nil {
 		return fmt.Errorf("连接控制器失败: %w", err)
 	}
@@ -226,7 +234,7 @@ func (tm *TopicManager) UpdateTopicConfig(topic string, configs map[string]strin
 		return fmt.Errorf("获取控制器失败: %w", err)
 	}
 
-	controllerConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	controllerConn, err := tm.config.DialContext(context.Background(), "tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
 	if err != nil {
 		return fmt.Errorf("连接控制器失败: %w", err)
 	}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go-kafka/middleware"
 )
 
 // Tracer 消息追踪器
@@ -48,8 +49,11 @@ func NewTracer(serviceName string) *Tracer {
 
 // ExtractTraceContext 从消息中提取追踪上下文
 func (t *Tracer) ExtractTraceContext(msg kafka.Message) context.Context {
-	ctx := context.Background()
+	return t.Extract(context.Background(), msg)
+}
 
+// Extract 从消息中提取追踪上下文并附加到ctx，保留ctx的取消和超时
+func (t *Tracer) Extract(ctx context.Context, msg kafka.Message) context.Context {
 	for _, header := range msg.Headers {
 		switch header.Key {
 		case t.traceIDKey:
@@ -125,8 +129,12 @@ func (s *Span) LogEvent(event string, fields map[string]interface{}) {
 
 // Context 获取上下文
 func (s *Span) Context() context.Context {
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, "trace-id", s.TraceID)
+	return s.WithContext(context.Background())
+}
+
+// WithContext 将跨度附加到parent，下游可以继续创建子跨度
+func (s *Span) WithContext(parent context.Context) context.Context {
+	ctx := context.WithValue(parent, "trace-id", s.TraceID)
 	ctx = context.WithValue(ctx, "span-id", s.SpanID)
 	return ctx
 }
@@ -183,8 +191,9 @@ func NewTracedHandler(tracer *Tracer, handler func(ctx context.Context, msg *Tra
 	}
 }
 
-func (th *TracedHandler) Handle(msg kafka.Message) error {
-	ctx := th.tracer.ExtractTraceContext(msg)
+// Handle 处理消息，可直接作为 consumer.MessageHandler 使用
+func (th *TracedHandler) Handle(ctx context.Context, msg kafka.Message) error {
+	ctx = th.tracer.Extract(ctx, msg)
 	span := th.tracer.NewSpan(ctx, "consume")
 	defer span.Finish()
 
@@ -202,8 +211,39 @@ func (th *TracedHandler) Handle(msg kafka.Message) error {
 	span.SetTag("message.offset", fmt.Sprintf("%d", msg.Offset))
 	span.SetTag("message.partition", fmt.Sprintf("%d", msg.Partition))
 
-	ctx = span.Context()
-	return th.handler(ctx, &tracedMsg)
+	return th.handler(span.WithContext(ctx), &tracedMsg)
+}
+
+// Middleware 返回追踪中间件，从消息头提取追踪上下文并创建consume跨度，
+// 下游处理函数收到的ctx携带该跨度，完成后交给reporter（可为nil）
+func (t *Tracer) Middleware(reporter TraceReporter) middleware.Middleware {
+	return func(next middleware.HandlerFunc) middleware.HandlerFunc {
+		return func(ctx context.Context, msg kafka.Message) error {
+			span := t.NewSpan(t.Extract(ctx, msg), "consume")
+			span.SetTag("message.topic", msg.Topic)
+			span.SetTag("message.offset", fmt.Sprintf("%d", msg.Offset))
+			span.SetTag("message.partition", fmt.Sprintf("%d", msg.Partition))
+
+			// 处理函数panic时也结束并报告跨度
+			completed := false
+			defer func() {
+				if !completed {
+					span.SetTag("error", "panic")
+				}
+				span.Finish()
+				if reporter != nil {
+					reporter.Report(span)
+				}
+			}()
+
+			err := next(span.WithContext(ctx), msg)
+			completed = true
+			if err != nil {
+				span.SetTag("error", err.Error())
+			}
+			return err
+		}
+	}
 }
 
 // TraceReporter 追踪报告器接口
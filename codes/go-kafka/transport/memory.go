@@ -0,0 +1,624 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	errTopicBoth       = errors.New("kafka.(*Writer): Topic must not be specified for both Writer and Message")
+	errTopicMissing    = errors.New("kafka.(*Writer): Topic must be specified for Writer or Message")
+	errWithoutGroup    = errors.New("unavailable when GroupID is not set")
+	errWithGroup       = errors.New("unavailable when GroupID is set")
+	errPartitionBounds = errors.New("partition out of range")
+)
+
+// Broker 内存Broker，实现 Transport 接口
+// 支持Topic、分区、偏移量、消费者组提交、再平衡和事务，用于无Kafka环境下运行测试和示例
+type Broker struct {
+	mu                sync.Mutex
+	topics            map[string][][]kafka.Message
+	groups            map[string]*memGroup
+	notify            chan struct{}
+	defaultPartitions int
+	writeErr          error
+	txnWriters        map[string]*memWriter // transactionalID -> 当前的事务写入器
+	txnMarks          map[memOffset]*memTxn // 事务中写入的消息所属的事务
+}
+
+// BrokerOption 内存Broker配置选项
+type BrokerOption func(*Broker)
+
+// WithDefaultPartitions 设置自动创建Topic时的分区数
+func WithDefaultPartitions(n int) BrokerOption {
+	return func(b *Broker) {
+		if n > 0 {
+			b.defaultPartitions = n
+		}
+	}
+}
+
+// NewBroker 创建内存Broker
+func NewBroker(options ...BrokerOption) *Broker {
+	b := &Broker{
+		topics:            make(map[string][][]kafka.Message),
+		groups:            make(map[string]*memGroup),
+		notify:            make(chan struct{}),
+		txnWriters:        make(map[string]*memWriter),
+		txnMarks:          make(map[memOffset]*memTxn),
+		defaultPartitions: 1, // 与Kafka的 num.partitions 默认值一致
+	}
+
+	for _, opt := range options {
+		opt(b)
+	}
+
+	return b
+}
+
+// memGroup 消费者组状态
+type memGroup struct {
+	generation  int
+	members     []*memReader
+	assignments map[*memReader][]int
+	committed   map[string]map[int]int64 // topic -> partition -> 下一条待消费的偏移量
+}
+
+// CreateTopic 创建Topic，Topic已存在且分区数更大时会扩容分区并触发再平衡
+func (b *Broker) CreateTopic(topic string, partitions int) {
+	if partitions <= 0 {
+		partitions = b.defaultPartitions
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	logs, ok := b.topics[topic]
+	if ok && len(logs) >= partitions {
+		return
+	}
+
+	for len(logs) < partitions {
+		logs = append(logs, nil)
+	}
+	b.topics[topic] = logs
+
+	if ok {
+		for _, g := range b.groups {
+			if g.subscribes(topic) {
+				b.rebalanceLocked(g)
+			}
+		}
+	}
+	b.broadcastLocked()
+}
+
+// Partitions 返回Topic的分区数，Topic不存在时返回0
+func (b *Broker) Partitions(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic])
+}
+
+// LookupPartitions 返回Topic的分区列表，实现 Transport 接口
+func (b *Broker) LookupPartitions(ctx context.Context, brokers []string, topic string) ([]int, error) {
+	n := b.Partitions(topic)
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids, nil
+}
+
+// Messages 返回Topic中的全部消息，按分区、偏移量排序
+func (b *Broker) Messages(topic string) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var msgs []kafka.Message
+	for _, log := range b.topics[topic] {
+		msgs = append(msgs, log...)
+	}
+	return msgs
+}
+
+// CommittedOffset 返回消费者组在分区上已提交的偏移量，未提交时返回-1
+func (b *Broker) CommittedOffset(groupID, topic string, partition int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[groupID]
+	if !ok {
+		return -1
+	}
+	offset, ok := g.committed[topic][partition]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+// SetWriteError 设置写入错误，用于模拟Broker不可用，传入nil恢复正常
+func (b *Broker) SetWriteError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writeErr = err
+}
+
+// NewWriter 创建内存写入器，沿用 kafka.Writer 上的 Topic、Balancer 和 Completion 配置
+func (b *Broker) NewWriter(w *kafka.Writer) Writer {
+	return &memWriter{broker: b, writer: w}
+}
+
+// NewReader 创建内存读取器
+// 设置了 GroupID 时加入消费者组并参与分区分配，否则读取 Partition 指定的分区
+func (b *Broker) NewReader(cfg kafka.ReaderConfig) Reader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := &memReader{
+		broker:    b,
+		config:    cfg,
+		positions: make(map[int]int64),
+	}
+
+	if cfg.StartOffset == 0 {
+		r.config.StartOffset = kafka.FirstOffset
+	}
+
+	b.ensureTopicLocked(cfg.Topic)
+
+	if cfg.GroupID == "" {
+		r.assigned = []int{cfg.Partition}
+		r.positions[cfg.Partition] = 0
+		return r
+	}
+
+	g, ok := b.groups[cfg.GroupID]
+	if !ok {
+		g = &memGroup{
+			assignments: make(map[*memReader][]int),
+			committed:   make(map[string]map[int]int64),
+		}
+		b.groups[cfg.GroupID] = g
+	}
+	r.group = g
+	r.generation = -1
+	g.members = append(g.members, r)
+	b.rebalanceLocked(g)
+	b.broadcastLocked()
+
+	return r
+}
+
+// ensureTopicLocked 自动创建不存在的Topic
+func (b *Broker) ensureTopicLocked(topic string) [][]kafka.Message {
+	logs, ok := b.topics[topic]
+	if !ok {
+		logs = make([][]kafka.Message, b.defaultPartitions)
+		b.topics[topic] = logs
+	}
+	return logs
+}
+
+// rebalanceLocked 按范围分配策略重新分配分区，并递增代数
+func (b *Broker) rebalanceLocked(g *memGroup) {
+	g.generation++
+	g.assignments = make(map[*memReader][]int)
+
+	byTopic := make(map[string][]*memReader)
+	for _, m := range g.members {
+		byTopic[m.config.Topic] = append(byTopic[m.config.Topic], m)
+	}
+
+	for topic, members := range byTopic {
+		partitions := len(b.ensureTopicLocked(topic))
+		per := partitions / len(members)
+		extra := partitions % len(members)
+
+		next := 0
+		for i, m := range members {
+			count := per
+			if i < extra {
+				count++
+			}
+			for p := next; p < next+count; p++ {
+				g.assignments[m] = append(g.assignments[m], p)
+			}
+			next += count
+		}
+	}
+}
+
+// broadcastLocked 唤醒所有等待消息的读取器
+func (b *Broker) broadcastLocked() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// subscribes 组内是否有成员订阅了该Topic
+func (g *memGroup) subscribes(topic string) bool {
+	for _, m := range g.members {
+		if m.config.Topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// memWriter 内存写入器
+type memWriter struct {
+	broker *Broker
+	writer *kafka.Writer
+	closed bool
+	stats  kafka.WriterStats
+
+	// 事务写入器的状态，由 Broker.mu 保护
+	transactionalID string
+	fenced          bool
+	txn             *memTxn
+}
+
+// WriteMessages 写入消息，按 Balancer 选择分区并分配偏移量
+func (w *memWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b := w.broker
+	b.mu.Lock()
+
+	if w.closed {
+		b.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	if err := w.checkTxnLocked(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+
+	if b.writeErr != nil {
+		w.stats.Errors++
+		err := b.writeErr
+		b.mu.Unlock()
+		w.complete(msgs, err)
+		return err
+	}
+
+	balancer := w.writer.Balancer
+	if balancer == nil {
+		balancer = &kafka.RoundRobin{}
+	}
+
+	// 先确定每条消息的Topic和分区，校验通过后再统一追加，避免部分写入
+	written := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		switch {
+		case w.writer.Topic != "" && msg.Topic != "":
+			b.mu.Unlock()
+			return errTopicBoth
+		case w.writer.Topic == "" && msg.Topic == "":
+			b.mu.Unlock()
+			return errTopicMissing
+		case msg.Topic == "":
+			msg.Topic = w.writer.Topic
+		}
+
+		logs := b.ensureTopicLocked(msg.Topic)
+		partitions := make([]int, len(logs))
+		for p := range partitions {
+			partitions[p] = p
+		}
+
+		msg.Partition = balancer.Balance(msg, partitions...)
+		if msg.Partition < 0 || msg.Partition >= len(logs) {
+			b.mu.Unlock()
+			return errPartitionBounds
+		}
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		written[i] = msg
+	}
+
+	for i, msg := range written {
+		logs := b.topics[msg.Topic]
+		msg.Offset = int64(len(logs[msg.Partition]))
+		stored := msg
+		stored.WriterData = nil // 与Kafka一致，WriterData 只在 Completion 中可见
+		logs[msg.Partition] = append(logs[msg.Partition], stored)
+		if w.txn != nil {
+			b.txnMarks[memOffset{msg.Topic, msg.Partition, msg.Offset}] = w.txn
+		}
+
+		written[i] = msg
+		w.stats.Bytes += int64(len(msg.Key) + len(msg.Value))
+	}
+
+	w.stats.Writes++
+	w.stats.Messages += int64(len(msgs))
+	b.broadcastLocked()
+	b.mu.Unlock()
+
+	w.complete(written, nil)
+	return nil
+}
+
+// complete 触发 kafka.Writer 上配置的 Completion 回调
+func (w *memWriter) complete(msgs []kafka.Message, err error) {
+	if w.writer.Completion != nil {
+		w.writer.Completion(msgs, err)
+	}
+}
+
+// Stats 返回累计的写入统计
+func (w *memWriter) Stats() kafka.WriterStats {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+
+	stats := w.stats
+	stats.Topic = w.writer.Topic
+	return stats
+}
+
+// Close 关闭写入器
+func (w *memWriter) Close() error {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// memReader 内存读取器，所有状态由 Broker.mu 保护
+type memReader struct {
+	broker     *Broker
+	config     kafka.ReaderConfig
+	group      *memGroup
+	generation int
+	assigned   []int
+	positions  map[int]int64
+	next       int
+	closed     bool
+	stats      kafka.ReaderStats
+}
+
+// ReadMessage 读取消息，消费者组模式下自动提交偏移量
+func (r *memReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	msg, err := r.FetchMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+
+	if r.group != nil {
+		if err := r.CommitMessages(ctx, msg); err != nil {
+			return msg, err
+		}
+	}
+
+	return msg, nil
+}
+
+// FetchMessage 读取消息但不提交，没有新消息时阻塞直到ctx取消或读取器关闭
+func (r *memReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	b := r.broker
+
+	for {
+		b.mu.Lock()
+		if r.closed {
+			b.mu.Unlock()
+			return kafka.Message{}, io.EOF
+		}
+
+		if r.group != nil && r.generation != r.group.generation {
+			r.syncAssignmentLocked()
+		}
+
+		msg, ok := r.nextLocked()
+		wait := b.notify
+		r.stats.Fetches++
+		b.mu.Unlock()
+
+		if ok {
+			return msg, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// syncAssignmentLocked 再平衡后同步分区分配，未提交的消息会被重新投递
+func (r *memReader) syncAssignmentLocked() {
+	g := r.group
+	r.generation = g.generation
+	r.assigned = g.assignments[r]
+	r.positions = make(map[int]int64, len(r.assigned))
+	r.next = 0
+	r.stats.Rebalances++
+
+	logs := r.broker.topics[r.config.Topic]
+	for _, p := range r.assigned {
+		if offset, ok := g.committed[r.config.Topic][p]; ok {
+			r.positions[p] = offset
+		} else if r.config.StartOffset == kafka.LastOffset {
+			r.positions[p] = int64(len(logs[p]))
+		} else {
+			r.positions[p] = 0
+		}
+	}
+}
+
+// nextLocked 轮询已分配的分区，取出下一条消息
+func (r *memReader) nextLocked() (kafka.Message, bool) {
+	logs := r.broker.topics[r.config.Topic]
+
+	for i := 0; i < len(r.assigned); i++ {
+		idx := (r.next + i) % len(r.assigned)
+		p := r.assigned[idx]
+		if p < 0 || p >= len(logs) {
+			continue
+		}
+
+		pos, ok := r.visibleLocked(p, logs[p])
+		if !ok {
+			continue
+		}
+
+		msg := logs[p][pos]
+		r.positions[p] = pos + 1
+		r.next = (idx + 1) % len(r.assigned)
+
+		r.stats.Messages++
+		r.stats.Bytes += int64(len(msg.Key) + len(msg.Value))
+		r.stats.Offset = msg.Offset
+		return msg, true
+	}
+
+	return kafka.Message{}, false
+}
+
+// CommitMessages 提交偏移量，每个分区取批次中最大的偏移量
+func (r *memReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if r.group == nil {
+		return errWithoutGroup
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	latest := make(map[string]map[int]int64)
+	for _, msg := range msgs {
+		if latest[msg.Topic] == nil {
+			latest[msg.Topic] = make(map[int]int64)
+		}
+		if next, ok := latest[msg.Topic][msg.Partition]; !ok || msg.Offset+1 > next {
+			latest[msg.Topic][msg.Partition] = msg.Offset + 1
+		}
+	}
+
+	for topic, offsets := range latest {
+		if r.group.committed[topic] == nil {
+			r.group.committed[topic] = make(map[int]int64)
+		}
+		for p, offset := range offsets {
+			r.group.committed[topic][p] = offset
+		}
+	}
+
+	return nil
+}
+
+// SetOffset 设置读取位置，仅在未设置 GroupID 时可用
+func (r *memReader) SetOffset(offset int64) error {
+	if r.group != nil {
+		return errWithGroup
+	}
+
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := r.config.Partition
+	switch offset {
+	case kafka.FirstOffset:
+		offset = 0
+	case kafka.LastOffset:
+		if logs := b.topics[r.config.Topic]; p >= 0 && p < len(logs) {
+			offset = int64(len(logs[p]))
+		}
+	}
+	r.positions[p] = offset
+	b.broadcastLocked()
+	return nil
+}
+
+// ReadLag 返回已分配分区上尚未读取的消息数
+func (r *memReader) ReadLag(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return r.lagLocked(), nil
+}
+
+// lagLocked 计算消费延迟
+func (r *memReader) lagLocked() int64 {
+	if r.group != nil && r.generation != r.group.generation {
+		r.syncAssignmentLocked()
+	}
+
+	logs := r.broker.topics[r.config.Topic]
+
+	var lag int64
+	for _, p := range r.assigned {
+		if p < 0 || p >= len(logs) {
+			continue
+		}
+		if n := int64(len(logs[p])) - r.positions[p]; n > 0 {
+			lag += n
+		}
+	}
+	return lag
+}
+
+// Stats 返回读取统计
+func (r *memReader) Stats() kafka.ReaderStats {
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := r.stats
+	stats.Topic = r.config.Topic
+	stats.Lag = r.lagLocked()
+
+	if len(r.assigned) == 1 {
+		stats.Partition = strconv.Itoa(r.assigned[0])
+	}
+
+	return stats
+}
+
+// Close 关闭读取器，消费者组模式下离开组并触发再平衡
+func (r *memReader) Close() error {
+	b := r.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if g := r.group; g != nil {
+		for i, m := range g.members {
+			if m == r {
+				g.members = append(g.members[:i], g.members[i+1:]...)
+				break
+			}
+		}
+		b.rebalanceLocked(g)
+	}
+
+	b.broadcastLocked()
+	return nil
+}
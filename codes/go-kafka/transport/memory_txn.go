@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// memOffset 消息在日志中的位置
+type memOffset struct {
+	topic     string
+	partition int
+	offset    int64
+}
+
+type txnState int
+
+const (
+	txnOpen txnState = iota
+	txnCommitted
+	txnAborted
+)
+
+// memTxn 内存事务，消息写入时直接追加到日志，read_committed 的读取器按事务状态过滤
+type memTxn struct {
+	state   txnState
+	offsets map[string]map[string]map[int]int64 // groupID -> topic -> partition -> offset
+}
+
+// memTxnWriter 内存事务写入器
+type memTxnWriter struct {
+	*memWriter
+}
+
+// NewTxnWriter 创建事务写入器，实现 Transactional 接口
+func (b *Broker) NewTxnWriter(w *kafka.Writer, transactionalID string) (TxnWriter, error) {
+	if transactionalID == "" {
+		return nil, errors.New("transactional id is required")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if old, ok := b.txnWriters[transactionalID]; ok {
+		old.fenced = true
+		old.abortLocked()
+	}
+
+	mw := &memWriter{broker: b, writer: w, transactionalID: transactionalID}
+	b.txnWriters[transactionalID] = mw
+	return memTxnWriter{mw}, nil
+}
+
+// checkTxnLocked 事务写入器只能在事务中写入
+func (w *memWriter) checkTxnLocked() error {
+	if w.transactionalID == "" {
+		return nil
+	}
+	if w.fenced {
+		return ErrProducerFenced
+	}
+	if w.txn == nil {
+		return ErrNoTransaction
+	}
+	return nil
+}
+
+// abortLocked 中止进行中的事务
+func (w *memWriter) abortLocked() {
+	if w.txn == nil {
+		return
+	}
+	w.txn.state = txnAborted
+	w.txn = nil
+	w.broker.broadcastLocked()
+}
+
+// BeginTxn 开始事务
+func (w memTxnWriter) BeginTxn() error {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+
+	switch {
+	case w.closed:
+		return errors.New("writer closed")
+	case w.fenced:
+		return ErrProducerFenced
+	case w.txn != nil:
+		return ErrTransactionInProgress
+	}
+	w.txn = &memTxn{offsets: make(map[string]map[string]map[int]int64)}
+	return nil
+}
+
+// SendOffsets 将消费者组的偏移量加入当前事务
+func (w memTxnWriter) SendOffsets(ctx context.Context, groupID string, offsets map[string]map[int]int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+
+	if err := w.checkTxnLocked(); err != nil {
+		return err
+	}
+
+	group := w.txn.offsets[groupID]
+	if group == nil {
+		group = make(map[string]map[int]int64)
+		w.txn.offsets[groupID] = group
+	}
+	for topic, partitions := range offsets {
+		if group[topic] == nil {
+			group[topic] = make(map[int]int64)
+		}
+		for p, offset := range partitions {
+			group[topic][p] = offset
+		}
+	}
+	return nil
+}
+
+// CommitTxn 提交事务，同时提交事务中的消费偏移量
+func (w memTxnWriter) CommitTxn(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b := w.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := w.checkTxnLocked(); err != nil {
+		return err
+	}
+
+	for groupID, topics := range w.txn.offsets {
+		g, ok := b.groups[groupID]
+		if !ok {
+			g = &memGroup{
+				assignments: make(map[*memReader][]int),
+				committed:   make(map[string]map[int]int64),
+			}
+			b.groups[groupID] = g
+		}
+		for topic, partitions := range topics {
+			if g.committed[topic] == nil {
+				g.committed[topic] = make(map[int]int64)
+			}
+			for p, offset := range partitions {
+				g.committed[topic][p] = offset
+			}
+		}
+	}
+
+	w.txn.state = txnCommitted
+	w.txn = nil
+	b.broadcastLocked()
+	return nil
+}
+
+// AbortTxn 中止事务
+func (w memTxnWriter) AbortTxn(ctx context.Context) error {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+
+	if w.fenced {
+		return ErrProducerFenced
+	}
+	if w.txn == nil {
+		return ErrNoTransaction
+	}
+	w.abortLocked()
+	return nil
+}
+
+// Close 关闭写入器，中止进行中的事务
+func (w memTxnWriter) Close() error {
+	b := w.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w.abortLocked()
+	w.closed = true
+	if b.txnWriters[w.transactionalID] == w.memWriter {
+		delete(b.txnWriters, w.transactionalID)
+	}
+	return nil
+}
+
+// visibleLocked 返回分区上下一条可读消息的位置
+// read_committed 时跳过已中止事务的消息，遇到未完成的事务时停止，与Kafka的LSO（last stable offset）一致
+func (r *memReader) visibleLocked(p int, log []kafka.Message) (int64, bool) {
+	pos := r.positions[p]
+	for pos < int64(len(log)) {
+		if r.config.IsolationLevel != kafka.ReadCommitted {
+			return pos, true
+		}
+
+		txn, ok := r.broker.txnMarks[memOffset{r.config.Topic, p, pos}]
+		switch {
+		case !ok || txn.state == txnCommitted:
+			return pos, true
+		case txn.state == txnOpen:
+			return 0, false
+		}
+		pos++
+		r.positions[p] = pos
+	}
+	return 0, false
+}
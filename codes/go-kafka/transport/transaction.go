@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	// ErrTransactionsUnsupported 传输层不支持事务
+	// kafka-go 写入的记录批次不带生产者ID和epoch，KafkaTransport 无法参与Kafka事务
+	ErrTransactionsUnsupported = errors.New("transport does not support transactions")
+	// ErrProducerFenced 相同 transactionalID 的新写入器已创建，旧写入器不能再写入或提交
+	ErrProducerFenced = errors.New("producer fenced by a newer instance with the same transactional id")
+	// ErrNoTransaction 没有进行中的事务
+	ErrNoTransaction = errors.New("no transaction in progress")
+	// ErrTransactionInProgress 上一个事务尚未提交或中止
+	ErrTransactionInProgress = errors.New("transaction already in progress")
+)
+
+// TxnWriter 事务写入器，写入的消息和提交的消费偏移量在 CommitTxn 时一起生效
+type TxnWriter interface {
+	Writer
+	// BeginTxn 开始事务，事务外不能写入消息
+	BeginTxn() error
+	// SendOffsets 将消费者组的偏移量加入当前事务，offsets 为 Topic -> 分区 -> 下一条待消费的偏移量
+	SendOffsets(ctx context.Context, groupID string, offsets map[string]map[int]int64) error
+	// CommitTxn 提交事务，消息对 read_committed 的消费者可见，偏移量同时提交
+	CommitTxn(ctx context.Context) error
+	// AbortTxn 中止事务，已写入的消息被 read_committed 的消费者跳过，偏移量不提交
+	AbortTxn(ctx context.Context) error
+}
+
+// Transactional 支持事务的传输层，内存 Broker 实现了该接口
+type Transactional interface {
+	// NewTxnWriter 创建事务写入器，相同 transactionalID 的旧写入器被隔离，其进行中的事务被中止
+	NewTxnWriter(w *kafka.Writer, transactionalID string) (TxnWriter, error)
+}
+
+var _ Transactional = (*Broker)(nil)
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"context"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Writer 消息写入接口，*kafka.Writer 天然实现该接口
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Stats() kafka.WriterStats
+	Close() error
+}
+
+// Reader 消息读取接口，*kafka.Reader 天然实现该接口
+type Reader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	SetOffset(offset int64) error
+	ReadLag(ctx context.Context) (int64, error)
+	Stats() kafka.ReaderStats
+	Close() error
+}
+
+// Transport 传输层接口，生产者和消费者通过它创建底层的读写器
+// 默认使用真实的Kafka连接，测试时可以替换为内存Broker
+type Transport interface {
+	// NewWriter 根据 kafka.Writer 的配置创建写入器
+	NewWriter(w *kafka.Writer) Writer
+	// NewReader 根据 kafka.ReaderConfig 创建读取器
+	NewReader(cfg kafka.ReaderConfig) Reader
+	// LookupPartitions 查询Topic的分区列表
+	LookupPartitions(ctx context.Context, brokers []string, topic string) ([]int, error)
+}
+
+// KafkaTransport 基于 segmentio/kafka-go 的真实传输层
+type KafkaTransport struct {
+	// Dial 查询元数据时建立连接，为nil时使用 kafka.DialContext
+	// config.KafkaConfig.GetTransport 设置为带TLS和SASL的连接
+	Dial func(ctx context.Context, network, address string) (*kafka.Conn, error)
+}
+
+// NewWriter 直接返回 kafka.Writer
+func (KafkaTransport) NewWriter(w *kafka.Writer) Writer {
+	return w
+}
+
+// NewReader 创建 kafka.Reader
+func (KafkaTransport) NewReader(cfg kafka.ReaderConfig) Reader {
+	return kafka.NewReader(cfg)
+}
+
+// LookupPartitions 依次尝试各个broker，读取Topic的分区元数据
+func (t KafkaTransport) LookupPartitions(ctx context.Context, brokers []string, topic string) ([]int, error) {
+	dial := t.Dial
+	if dial == nil {
+		dial = kafka.DialContext
+	}
+
+	lastErr := errors.New("没有可用的broker")
+	for _, addr := range brokers {
+		conn, err := dial(ctx, "tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		partitions, err := conn.ReadPartitions(topic)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]int, len(partitions))
+		for i, p := range partitions {
+			ids[i] = p.ID
+		}
+		return ids, nil
+	}
+	return nil, lastErr
+}
+
+// Default 默认传输层
+var Default Transport = KafkaTransport{}
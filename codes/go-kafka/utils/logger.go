@@ -1,10 +1,33 @@
 package utils
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
+// 日志级别
+const (
+	LevelInfo  int32 = iota // 输出全部日志
+	LevelError              // 只输出错误
+)
+
+var level atomic.Int32
+
+// SetLevel 按名称设置全局日志级别，name 为 info 或 error
+func SetLevel(name string) error {
+	switch name {
+	case "info":
+		level.Store(LevelInfo)
+	case "error":
+		level.Store(LevelError)
+	default:
+		return fmt.Errorf("未知的日志级别: %s", name)
+	}
+	return nil
+}
+
 // Logger 简单的日志包装器
 type Logger struct {
 	*log.Logger
@@ -19,6 +42,9 @@ func NewLogger(prefix string) *Logger {
 
 // Info 信息日志
 func (l *Logger) Info(v ...interface{}) {
+	if level.Load() > LevelInfo {
+		return
+	}
 	l.Logger.Println("[INFO]", v)
 }
 